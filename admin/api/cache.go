@@ -0,0 +1,42 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/fabiolb/fabio/cache"
+)
+
+// CacheHandler provides the statistics of the response cache
+// and purges cached responses.
+type CacheHandler struct {
+	// Cache is the response cache. If nil cache.Default is used.
+	Cache *cache.Cache
+}
+
+type cachePurge struct {
+	Purged int `json:"purged"`
+}
+
+func (h *CacheHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c := h.Cache
+	if c == nil {
+		c = cache.Default
+	}
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, r, c.Stats())
+
+	case "DELETE":
+		// purge the responses of a host and a path prefix
+		// or all responses without parameters.
+		host, prefix := r.URL.Query().Get("host"), r.URL.Query().Get("prefix")
+		n := c.Purge(host, prefix)
+		log.Printf("[INFO] Purged %d cached responses for host %q and prefix %q", n, host, prefix)
+		writeJSON(w, r, cachePurge{Purged: n})
+
+	default:
+		http.Error(w, "not allowed", http.StatusMethodNotAllowed)
+	}
+}
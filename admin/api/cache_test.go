@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fabiolb/fabio/cache"
+)
+
+func TestCacheHandler(t *testing.T) {
+	c := cache.New(1<<20, 1<<10)
+	for _, u := range []struct{ host, path string }{
+		{"a.com", "/static/a.css"},
+		{"a.com", "/static/b.css"},
+		{"a.com", "/api"},
+		{"b.com", "/static/a.css"},
+	} {
+		c.Put(u.host+u.path+"?", u.host, u.path, http.Header{}, &cache.Entry{Status: 200, Header: http.Header{}, Body: []byte("x")})
+	}
+	h := &CacheHandler{Cache: c}
+
+	do := func(method, url string) string {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, url, nil))
+		if got, want := rec.Code, http.StatusOK; got != want {
+			t.Fatalf("%s %s: got code %d want %d", method, url, got, want)
+		}
+		return rec.Body.String()
+	}
+
+	if got, want := do("DELETE", "/api/cache?host=a.com&prefix=/static/"), `{"purged":2}`; got != want {
+		t.Fatalf("got %s want %s", got, want)
+	}
+	if got, want := c.Stats().Entries, 2; got != want {
+		t.Fatalf("got %d entries want %d", got, want)
+	}
+	if got, want := do("DELETE", "/api/cache"), `{"purged":2}`; got != want {
+		t.Fatalf("got %s want %s", got, want)
+	}
+	if got, want := do("GET", "/api/cache"), `{"entries":0,"size":0,"max_size":1048576}`; got != want {
+		t.Fatalf("got %s want %s", got, want)
+	}
+}
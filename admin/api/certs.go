@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/fabiolb/fabio/cert"
+)
+
+// CertsHandler returns the certificates held by the certificate
+// stores of all listeners.
+type CertsHandler struct{}
+
+type apiCert struct {
+	Listener        string    `json:"listener"`
+	Source          string    `json:"source"`
+	Subject         string    `json:"subject"`
+	DNSNames        []string  `json:"sans"`
+	Issuer          string    `json:"issuer"`
+	Serial          string    `json:"serial"`
+	NotBefore       time.Time `json:"notBefore"`
+	NotAfter        time.Time `json:"notAfter"`
+	DaysUntilExpiry int       `json:"daysUntilExpiry"`
+}
+
+func (h *CertsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	certs := []apiCert{}
+	for _, c := range cert.Inventory() {
+		certs = append(certs, apiCert{
+			Listener:        c.Listener,
+			Source:          c.Source,
+			Subject:         c.Subject,
+			DNSNames:        c.DNSNames,
+			Issuer:          c.Issuer,
+			Serial:          c.Serial,
+			NotBefore:       c.NotBefore,
+			NotAfter:        c.NotAfter,
+			DaysUntilExpiry: int(c.NotAfter.Sub(now).Hours() / 24),
+		})
+	}
+	writeJSON(w, r, certs)
+}
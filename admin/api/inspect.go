@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fabiolb/fabio/inspect"
+)
+
+// InspectHandler streams the recently proxied requests
+// which match the filter as server-sent events.
+type InspectHandler struct {
+	// Ring is the ring buffer of the requests. If nil
+	// inspect.Default is used.
+	Ring *inspect.Ring
+
+	// Interval is the polling interval of the ring buffer.
+	// If zero the requests are sent every 250ms.
+	Interval time.Duration
+}
+
+func (h *InspectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	f, err := inspect.ParseFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ring := h.Ring
+	if ring == nil {
+		ring = inspect.Default
+	}
+	interval := h.Interval
+	if interval <= 0 {
+		interval = 250 * time.Millisecond
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	c := ring.Watch()
+	defer c.Close()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+
+		reqs, lost := c.Next()
+		if lost > 0 {
+			fmt.Fprintf(w, "event: lost\ndata: %d\n\n", lost)
+		}
+		for i := range reqs {
+			if !f.Match(&reqs[i]) {
+				continue
+			}
+			b, err := json.Marshal(reqs[i])
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", b)
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/inspect"
+)
+
+func TestInspectHandler(t *testing.T) {
+	ring := inspect.NewRing(16)
+	srv := httptest.NewServer(&InspectHandler{Ring: ring, Interval: 10 * time.Millisecond})
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?status=5xx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got, want := resp.Header.Get("Content-Type"), "text/event-stream"; got != want {
+		t.Fatalf("got content type %q want %q", got, want)
+	}
+
+	// wait for the watcher to be registered
+	for i := 0; i < 100 && !ring.Watching(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	ring.Add(inspect.Request{Path: "/ok", Status: 200})
+	ring.Add(inspect.Request{Path: "/fail", Status: 502})
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(line, "data: ") || !strings.Contains(line, `"path":"/fail"`) {
+		t.Fatalf("got %q want event for /fail", line)
+	}
+}
+
+func TestInspectHandlerInvalidFilter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	(&InspectHandler{}).ServeHTTP(rec, httptest.NewRequest("GET", "/api/inspect?status=foo", nil))
+	if got, want := rec.Code, http.StatusBadRequest; got != want {
+		t.Fatalf("got code %d want %d", got, want)
+	}
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/logger"
+)
+
+// LogLevelHandler provides a fetch and update handler for the log level.
+type LogLevelHandler struct {
+	Writer *logger.LevelWriter
+
+	mu sync.Mutex
+
+	// saved contains the levels before the first update with a ttl.
+	// They are restored by revert when the ttl expires.
+	saved    *logLevel
+	revert   *time.Timer
+	revertAt time.Time
+
+	// gen is incremented on every update so that an expired
+	// revert does not restore the levels after a newer update.
+	gen int
+}
+
+type logLevel struct {
+	Level      string            `json:"level"`
+	Subsystems map[string]string `json:"subsystems"`
+
+	// TTL is the duration after which an update is reverted.
+	TTL string `json:"ttl,omitempty"`
+
+	// RevertAt is the time at which the levels are reverted.
+	RevertAt string `json:"revert_at,omitempty"`
+}
+
+func (h *LogLevelHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Writer == nil {
+		http.Error(w, "not available", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		h.mu.Lock()
+		v := h.current()
+		if h.revert != nil {
+			v.RevertAt = h.revertAt.UTC().Format(time.RFC3339)
+		}
+		h.mu.Unlock()
+		writeJSON(w, r, v)
+
+	case "PUT":
+		var v logLevel
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			log.Print("[ERROR] ", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		var ttl time.Duration
+		if v.TTL != "" {
+			d, err := time.ParseDuration(v.TTL)
+			if err != nil || d <= 0 {
+				http.Error(w, fmt.Sprintf("invalid ttl %q", v.TTL), http.StatusBadRequest)
+				return
+			}
+			ttl = d
+		}
+		if err := h.update(v, ttl); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+	default:
+		http.Error(w, "not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// update applies the levels of v. If ttl is positive the levels before
+// the update are restored after ttl. Otherwise, a pending revert is
+// cancelled and the levels are kept.
+func (h *LogLevelHandler) update(v logLevel, ttl time.Duration) error {
+	// validate on a scratch writer first so that
+	// an invalid update does not change anything.
+	tmp := logger.NewLevelWriter(nil, "INFO", "")
+	if v.Level != "" && !tmp.SetLevel(v.Level) {
+		return fmt.Errorf("invalid level %q", v.Level)
+	}
+	for sub, level := range v.Subsystems {
+		if !tmp.SetSubsystemLevel(sub, level) {
+			return fmt.Errorf("invalid level %q for subsystem %q", level, sub)
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.revert != nil {
+		h.revert.Stop()
+		h.revert = nil
+	}
+	h.gen++
+	if ttl > 0 {
+		// keep the levels from before the first of
+		// several consecutive updates with a ttl.
+		if h.saved == nil {
+			cur := h.current()
+			h.saved = &cur
+		}
+		h.revertAt = time.Now().Add(ttl)
+		gen := h.gen
+		h.revert = time.AfterFunc(ttl, func() { h.restore(gen) })
+	} else {
+		h.saved = nil
+	}
+
+	h.apply(v)
+	log.Printf("[INFO] Log level set to %s with subsystems %v", h.Writer.Level(), h.Writer.SubsystemLevels())
+	return nil
+}
+
+// restore restores the saved levels unless there
+// was another update since the revert was scheduled.
+func (h *LogLevelHandler) restore(gen int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.saved == nil || gen != h.gen {
+		return
+	}
+	for sub := range h.Writer.SubsystemLevels() {
+		h.Writer.SetSubsystemLevel(sub, "")
+	}
+	h.apply(*h.saved)
+	h.saved, h.revert = nil, nil
+	log.Printf("[INFO] Log level reverted to %s with subsystems %v", h.Writer.Level(), h.Writer.SubsystemLevels())
+}
+
+func (h *LogLevelHandler) apply(v logLevel) {
+	if v.Level != "" {
+		h.Writer.SetLevel(v.Level)
+	}
+	for sub, level := range v.Subsystems {
+		h.Writer.SetSubsystemLevel(sub, level)
+	}
+}
+
+func (h *LogLevelHandler) current() logLevel {
+	return logLevel{Level: h.Writer.Level(), Subsystems: h.Writer.SubsystemLevels()}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/logger"
+)
+
+func TestLogLevelHandler(t *testing.T) {
+	lw := logger.NewLevelWriter(ioutil.Discard, "INFO", "")
+	h := &LogLevelHandler{Writer: lw}
+
+	put := func(body string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("PUT", "/api/loglevel", strings.NewReader(body)))
+		return rec.Code
+	}
+
+	for _, body := range []string{
+		`{"level":"LOUD"}`,
+		`{"subsystems":{"foo":"DEBUG"}}`,
+		`{"level":"DEBUG","subsystems":{"registry":"LOUD"}}`,
+		`{"level":"DEBUG","ttl":"soon"}`,
+	} {
+		if got, want := put(body), http.StatusBadRequest; got != want {
+			t.Fatalf("%s: got code %d want %d", body, got, want)
+		}
+	}
+	if got, want := lw.Level(), "INFO"; got != want {
+		t.Fatalf("invalid update changed level to %s", got)
+	}
+
+	if got, want := put(`{"level":"WARN","subsystems":{"registry":"TRACE"}}`), http.StatusOK; got != want {
+		t.Fatalf("got code %d want %d", got, want)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/loglevel", nil))
+	if got, want := rec.Body.String(), `{"level":"WARN","subsystems":{"registry":"TRACE"}}`; got != want {
+		t.Fatalf("got %s want %s", got, want)
+	}
+
+	// two updates with a ttl revert to the
+	// levels from before the first update
+	put(`{"level":"DEBUG","ttl":"1h"}`)
+	put(`{"level":"TRACE","subsystems":{"registry":"","proxy":"ERROR"},"ttl":"10ms"}`)
+	if got, want := lw.Level(), "TRACE"; got != want {
+		t.Fatalf("got level %s want %s", got, want)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for lw.Level() != "WARN" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got, want := lw.Level(), "WARN"; got != want {
+		t.Fatalf("got level %s want %s", got, want)
+	}
+	h.mu.Lock()
+	subs := lw.SubsystemLevels()
+	h.mu.Unlock()
+	if len(subs) != 1 || subs["registry"] != "TRACE" {
+		t.Fatalf("got subsystems %v want registry:TRACE", subs)
+	}
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/fabiolb/fabio/auth"
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+)
+
+// LookupHandler explains how the current routing table
+// handles the request described by the query parameters:
+//
+//	url:        absolute url of the request
+//	header.<n>: value of the request header <n>
+//	remote:     client address, defaults to 127.0.0.1
+type LookupHandler struct {
+	Config      *config.Config
+	AuthSchemes map[string]auth.AuthScheme
+}
+
+func (h *LookupHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	header := http.Header{}
+	for k, vs := range q {
+		if strings.HasPrefix(k, "header.") {
+			for _, v := range vs {
+				header.Add(strings.TrimPrefix(k, "header."), v)
+			}
+		}
+	}
+
+	remote := q.Get("remote")
+	if remote == "" {
+		remote = "127.0.0.1"
+	}
+
+	req, err := route.NewExplainRequest(q.Get("url"), header, remote)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ex, err := route.GetTable().Explain(req, h.Config.Proxy.Strategy, h.Config.Proxy.Matcher, nil, h.Config.GlobMatchingDisabled, h.AuthSchemes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, r, ex)
+}
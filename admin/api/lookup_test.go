@@ -0,0 +1,46 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestLookupHandler(t *testing.T) {
+	tbl, err := route.NewTable(bytes.NewBufferString(`route add svc-a a.com/ http://127.0.0.1:3000/ opts "allow=header:X-Foo=bar"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer route.SetTable(route.GetTable())
+	route.SetTable(tbl)
+
+	cfg := &config.Config{Proxy: config.Proxy{Strategy: "rnd", Matcher: "prefix"}}
+	h := &LookupHandler{Config: cfg}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/lookup?url=http://a.com/foo&header.X-Foo=bar", nil))
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got code %d want %d", got, want)
+	}
+	var ex route.Explanation
+	if err := json.Unmarshal(rec.Body.Bytes(), &ex); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ex.Route, "a.com/"; got != want {
+		t.Fatalf("got route %q want %q", got, want)
+	}
+	if ex.Access == nil || !ex.Access.Allowed {
+		t.Fatalf("got access %v want allowed", ex.Access)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/lookup?url=/foo", nil))
+	if got, want := rec.Code, http.StatusBadRequest; got != want {
+		t.Fatalf("got code %d want %d", got, want)
+	}
+}
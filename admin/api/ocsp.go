@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/fabiolb/fabio/cert"
+)
+
+// OCSPHandler returns the OCSP status of the served certificates.
+type OCSPHandler struct{}
+
+type apiOCSP struct {
+	Subject    string    `json:"subject"`
+	Serial     string    `json:"serial"`
+	Responder  string    `json:"responder"`
+	Status     string    `json:"status"`
+	ThisUpdate time.Time `json:"thisUpdate"`
+	NextUpdate time.Time `json:"nextUpdate"`
+	RevokedAt  time.Time `json:"revokedAt"`
+	LastFetch  time.Time `json:"lastFetch"`
+	Error      string    `json:"error,omitempty"`
+}
+
+func (h *OCSPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	certs := []apiOCSP{}
+	if cert.DefaultStapler != nil {
+		for _, s := range cert.DefaultStapler.Status() {
+			certs = append(certs, apiOCSP{
+				Subject:    s.Subject,
+				Serial:     s.Serial,
+				Responder:  s.Responder,
+				Status:     s.Status,
+				ThisUpdate: s.ThisUpdate,
+				NextUpdate: s.NextUpdate,
+				RevokedAt:  s.RevokedAt,
+				LastFetch:  s.LastFetch,
+				Error:      s.Error,
+			})
+		}
+	}
+	writeJSON(w, r, certs)
+}
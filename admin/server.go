@@ -9,7 +9,10 @@ import (
 	"github.com/fabiolb/fabio/admin/api"
 	"github.com/fabiolb/fabio/admin/ui"
 	_ "github.com/fabiolb/fabio/admin/ui/statik"
+	"github.com/fabiolb/fabio/auth"
 	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/logger"
+	"github.com/fabiolb/fabio/metrics"
 	"github.com/fabiolb/fabio/proxy"
 	"github.com/rakyll/statik/fs"
 )
@@ -22,6 +25,14 @@ type Server struct {
 	Version  string
 	Commands string
 	Cfg      *config.Config
+
+	// LogLevel is the log writer whose level can be changed
+	// through the api in 'rw' mode.
+	LogLevel *logger.LevelWriter
+
+	// AuthSchemes are the auth schemes of the proxy. The route
+	// lookup api reports routes with an unknown auth scheme.
+	AuthSchemes map[string]auth.AuthScheme
 }
 
 // ListenAndServe starts the admin server.
@@ -35,6 +46,8 @@ func (s *Server) handler() http.Handler {
 	switch s.Access {
 	case "ro":
 		mux.HandleFunc("/api/paths", forbidden)
+		mux.HandleFunc("/api/loglevel", forbidden)
+		mux.HandleFunc("/api/cache", forbidden)
 		mux.HandleFunc("/api/manual", forbidden)
 		mux.HandleFunc("/api/manual/", forbidden)
 		mux.HandleFunc("/manual", forbidden)
@@ -44,6 +57,8 @@ func (s *Server) handler() http.Handler {
 		// but Consul treats all KV paths without a leading slash.
 		pathsPrefix := strings.TrimPrefix(s.Cfg.Registry.Consul.KVPath, "/")
 		mux.Handle("/api/paths", &api.ManualPathsHandler{Prefix: pathsPrefix})
+		mux.Handle("/api/loglevel", &api.LogLevelHandler{Writer: s.LogLevel})
+		mux.Handle("/api/cache", &api.CacheHandler{})
 		mux.Handle("/api/manual", &api.ManualHandler{BasePath: "/api/manual"})
 		mux.Handle("/api/manual/", &api.ManualHandler{BasePath: "/api/manual"})
 		mux.Handle("/manual", &ui.ManualHandler{
@@ -64,10 +79,26 @@ func (s *Server) handler() http.Handler {
 
 	mux.Handle("/api/config", &api.ConfigHandler{Config: s.Cfg})
 	mux.Handle("/api/routes", &api.RoutesHandler{})
+	// only evaluate the auth schemes with the credentials
+	// from the request in 'rw' mode.
+	lookup := &api.LookupHandler{Config: s.Cfg}
+	if s.Access == "rw" {
+		lookup.AuthSchemes = s.AuthSchemes
+	}
+	mux.Handle("/api/lookup", lookup)
+	mux.Handle("/api/ocsp", &api.OCSPHandler{})
+	mux.Handle("/api/certs", &api.CertsHandler{})
 	mux.Handle("/api/version", &api.VersionHandler{Version: s.Version})
+	mux.Handle("/api/inspect", &api.InspectHandler{})
 	mux.Handle("/routes", &ui.RoutesHandler{Color: s.Color, Title: s.Title, Version: s.Version})
+	mux.Handle("/certs", &ui.CertsHandler{Color: s.Color, Title: s.Title, Version: s.Version})
+	mux.Handle("/inspect", &ui.InspectHandler{Color: s.Color, Title: s.Title, Version: s.Version})
 	mux.HandleFunc("/health", handleHealth)
 
+	if m := s.Cfg.Metrics; m.Target == "prometheus" && m.Prometheus.Addr == "" {
+		mux.Handle(m.Prometheus.Path, metrics.PrometheusHandler())
+	}
+
 	statikFS, err := fs.New()
 	if err != nil {
 		// This error is always a result of invalid, generated zip data. That
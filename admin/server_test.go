@@ -1,11 +1,13 @@
 package admin
 
 import (
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/logger"
 )
 
 func TestAdminServerAccess(t *testing.T) {
@@ -16,8 +18,14 @@ func TestAdminServerAccess(t *testing.T) {
 
 	testAccess := func(access string, tests []test) {
 		srv := &Server{
-			Access: access,
+			Access:   access,
+			LogLevel: logger.NewLevelWriter(ioutil.Discard, "INFO", ""),
 			Cfg: &config.Config{
+				Proxy: config.Proxy{Strategy: "rnd", Matcher: "prefix"},
+				Metrics: config.Metrics{
+					Target:     "prometheus",
+					Prometheus: config.Prometheus{Path: "/metrics"},
+				},
 				Registry: config.Registry{
 					Consul: config.Consul{
 						KVPath: "/fabio/config",
@@ -49,12 +57,20 @@ func TestAdminServerAccess(t *testing.T) {
 	roTests := []test{
 		{"/api/manual", 403},
 		{"/api/paths", 403},
+		{"/api/loglevel", 403},
+		{"/api/cache", 403},
 		{"/api/config", 200},
 		{"/api/routes", 200},
+		{"/api/lookup?url=http://a.com/", 200},
+		{"/api/ocsp", 200},
+		{"/api/certs", 200},
 		{"/api/version", 200},
 		{"/manual", 403},
 		{"/routes", 200},
+		{"/certs", 200},
+		{"/inspect", 200},
 		{"/health", 200},
+		{"/metrics", 200},
 		{"/assets/logo.svg", 200},
 		{"/assets/logo.bw.svg", 200},
 		{"/", 303},
@@ -63,12 +79,20 @@ func TestAdminServerAccess(t *testing.T) {
 	rwTests := []test{
 		{"/api/manual", 200},
 		{"/api/paths", 200},
+		{"/api/loglevel", 200},
+		{"/api/cache", 200},
 		{"/api/config", 200},
 		{"/api/routes", 200},
+		{"/api/lookup?url=http://a.com/", 200},
+		{"/api/ocsp", 200},
+		{"/api/certs", 200},
 		{"/api/version", 200},
 		{"/manual", 200},
 		{"/routes", 200},
+		{"/certs", 200},
+		{"/inspect", 200},
 		{"/health", 200},
+		{"/metrics", 200},
 		{"/assets/logo.svg", 200},
 		{"/assets/logo.bw.svg", 200},
 		{"/", 303},
@@ -0,0 +1,127 @@
+package ui
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// CertsHandler provides the UI for the certificate inventory.
+type CertsHandler struct {
+	Color, Title, Version string
+}
+
+func (h *CertsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tmplCerts.ExecuteTemplate(w, "certs", h)
+}
+
+var tmplCerts = template.Must(template.New("certs").Parse(`
+<!doctype html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<title>fabio{{if .Title}} - {{.Title}}{{end}}</title>
+	<script type="text/javascript" src="/assets/code.jquery.com/jquery-3.3.1.min.js"></script>
+    <link href="/assets/fonts/material-icons.css" rel="stylesheet">
+    <link rel="stylesheet" href="/assets/cdnjs.cloudflare.com/ajax/libs/materialize/0.100.2/css/materialize.min.css">
+    <script src="/assets/cdnjs.cloudflare.com/ajax/libs/materialize/0.100.2/js/materialize.min.js"></script>
+	<meta name="viewport" content="width=device-width, initial-scale=1.0"/>
+
+	<style type="text/css">
+		td.sans { word-break: break-all; }
+		.footer { padding-top: 10px; }
+		.logo { height: 32px; margin: 0 auto; display: block; }
+	</style>
+</head>
+<body>
+
+<nav class="top-nav {{.Color}}">
+
+	<div class="container">
+		<div class="nav-wrapper">
+			<a href="/" class="brand-logo">fabio{{if .Title}} - {{.Title}}{{end}}</a>
+			<ul id="nav-mobile" class="right hide-on-med-and-down">
+				<li><a href="/routes">Routes</a></li>
+				<li><a href="/inspect">Requests</a></li>
+				<li><a href="https://github.com/fabiolb/fabio/blob/master/CHANGELOG.md">{{.Version}}</a></li>
+				<li><a href="https://github.com/fabiolb/fabio">Github</a></li>
+			</ul>
+		</div>
+	</div>
+
+</nav>
+
+<div class="container">
+
+	<div class="section">
+		<h5>Certificates</h5>
+		<p><input type="text" id="filter" placeholder="type to filter certificates"></p>
+		<table class="certs highlight"></table>
+	</div>
+
+	<div class="section footer">
+		<img class="logo" src="/assets/logo.svg">
+	</div>
+
+</div>
+
+<script>
+$(function(){
+	function renderCerts(certs) {
+		var $table = $('table.certs');
+
+		var thead = '<thead><tr>';
+		thead += '<th>Listener</th>';
+		thead += '<th>Source</th>';
+		thead += '<th>Subject</th>';
+		thead += '<th>SANs</th>';
+		thead += '<th>Issuer</th>';
+		thead += '<th>Serial</th>';
+		thead += '<th>Expires</th>';
+		thead += '<th>Days left</th>';
+		thead += '</tr></thead>';
+
+		var $tbody = $('<tbody />');
+
+		for (var i=0; i < certs.length; i++) {
+			var c = certs[i];
+
+			var $tr = $('<tr />');
+			if (c.daysUntilExpiry < 30) {
+				$tr.addClass('red lighten-4');
+			}
+
+			$tr.append($('<td />').text(c.listener));
+			$tr.append($('<td />').text(c.source));
+			$tr.append($('<td />').text(c.subject));
+			$tr.append($('<td class="sans" />').text((c.sans || []).join(', ')));
+			$tr.append($('<td />').text(c.issuer));
+			$tr.append($('<td />').text(c.serial));
+			$tr.append($('<td />').text(c.notAfter));
+			$tr.append($('<td />').text(c.daysUntilExpiry));
+
+			$tr.appendTo($tbody);
+		}
+
+		$table.empty().
+			append($(thead)).
+			append($tbody);
+	}
+
+	var $filter = $('#filter');
+	$filter.focus();
+	$filter.keyup(function() {
+		var v = $filter.val();
+		$("tr").show();
+		if (!v) return;
+		$("tbody tr:not(:contains('"+v+"'))").hide();
+	});
+
+	$.get("/api/certs", function(data) {
+		renderCerts(data);
+	});
+});
+</script>
+
+</body>
+</html>
+`))
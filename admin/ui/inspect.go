@@ -0,0 +1,156 @@
+package ui
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// InspectHandler provides the UI for watching the proxied requests.
+type InspectHandler struct {
+	Color, Title, Version string
+}
+
+func (h *InspectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tmplInspect.ExecuteTemplate(w, "inspect", h)
+}
+
+var tmplInspect = template.Must(template.New("inspect").Parse(`
+<!doctype html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<title>fabio{{if .Title}} - {{.Title}}{{end}}</title>
+	<script type="text/javascript" src="/assets/code.jquery.com/jquery-3.3.1.min.js"></script>
+    <link href="/assets/fonts/material-icons.css" rel="stylesheet">
+    <link rel="stylesheet" href="/assets/cdnjs.cloudflare.com/ajax/libs/materialize/0.100.2/css/materialize.min.css">
+    <script src="/assets/cdnjs.cloudflare.com/ajax/libs/materialize/0.100.2/js/materialize.min.js"></script>
+	<meta name="viewport" content="width=device-width, initial-scale=1.0"/>
+
+	<style type="text/css">
+		td.path { word-break: break-all; }
+		.footer { padding-top: 10px; }
+		.logo { height: 32px; margin: 0 auto; display: block; }
+	</style>
+</head>
+<body>
+
+<nav class="top-nav {{.Color}}">
+
+	<div class="container">
+		<div class="nav-wrapper">
+			<a href="/" class="brand-logo">fabio{{if .Title}} - {{.Title}}{{end}}</a>
+			<ul id="nav-mobile" class="right hide-on-med-and-down">
+				<li><a href="/routes">Routes</a></li>
+				<li><a href="/certs">Certificates</a></li>
+				<li><a href="https://github.com/fabiolb/fabio/blob/master/CHANGELOG.md">{{.Version}}</a></li>
+				<li><a href="https://github.com/fabiolb/fabio">Github</a></li>
+			</ul>
+		</div>
+	</div>
+
+</nav>
+
+<div class="container">
+
+	<div class="section">
+		<h5>Requests</h5>
+		<form id="filter">
+			<div class="row">
+				<div class="input-field col s3"><input type="text" name="host" id="host"><label for="host">Host</label></div>
+				<div class="input-field col s3"><input type="text" name="path" id="path"><label for="path">Path prefix</label></div>
+				<div class="input-field col s2"><input type="text" name="service" id="service"><label for="service">Service</label></div>
+				<div class="input-field col s2"><input type="text" name="status" id="status"><label for="status">Status (404, 5xx)</label></div>
+				<div class="input-field col s2"><input type="text" name="sample" id="sample"><label for="sample">Sample (0-1)</label></div>
+			</div>
+			<button class="btn" type="submit" id="start">Watch</button>
+			<button class="btn grey" type="button" id="stop" disabled>Stop</button>
+			<span id="state"></span>
+		</form>
+		<table class="requests highlight">
+			<thead><tr>
+				<th>Time</th>
+				<th>Method</th>
+				<th>Host</th>
+				<th>Path</th>
+				<th>Route</th>
+				<th>Service</th>
+				<th>Target</th>
+				<th>Status</th>
+				<th>Latency (ms)</th>
+			</tr></thead>
+			<tbody></tbody>
+		</table>
+	</div>
+
+	<div class="section footer">
+		<img class="logo" src="/assets/logo.svg">
+	</div>
+
+</div>
+
+<script>
+$(function(){
+	var maxRows = 500;
+	var source = null;
+	var lost = 0;
+
+	function renderRequest(r) {
+		var $tr = $('<tr />');
+		if (r.status >= 500) {
+			$tr.addClass('red lighten-4');
+		} else if (r.status >= 400) {
+			$tr.addClass('orange lighten-4');
+		}
+		$tr.append($('<td />').text(new Date(r.time).toLocaleTimeString()));
+		$tr.append($('<td />').text(r.method));
+		$tr.append($('<td />').text(r.host));
+		$tr.append($('<td class="path" />').text(r.path));
+		$tr.append($('<td />').text(r.route));
+		$tr.append($('<td />').text(r.service));
+		$tr.append($('<td />').text(r.target));
+		$tr.append($('<td />').text(r.status));
+		$tr.append($('<td />').text(r.duration_ms.toFixed(3)));
+
+		var $tbody = $('table.requests tbody');
+		$tbody.prepend($tr);
+		$tbody.find('tr').slice(maxRows).remove();
+	}
+
+	function stop() {
+		if (source) {
+			source.close();
+			source = null;
+		}
+		$('#start').prop('disabled', false);
+		$('#stop').prop('disabled', true);
+		$('#state').text('');
+	}
+
+	$('#filter').submit(function(e) {
+		e.preventDefault();
+		stop();
+		lost = 0;
+		var params = $(this).serializeArray().filter(function(p) { return p.value !== ''; });
+		source = new EventSource('/api/inspect?' + $.param(params));
+		source.onmessage = function(e) {
+			renderRequest(JSON.parse(e.data));
+		};
+		source.addEventListener('lost', function(e) {
+			lost += parseInt(e.data, 10);
+			$('#state').text('skipped ' + lost + ' requests');
+		});
+		source.onerror = function() {
+			stop();
+			$('#state').text('connection closed');
+		};
+		$('#start').prop('disabled', true);
+		$('#stop').prop('disabled', false);
+	});
+
+	$('#stop').click(stop);
+});
+</script>
+
+</body>
+</html>
+`))
@@ -67,6 +67,8 @@ var tmplManual = template.Must(template.New("manual").Funcs(funcs).Parse(`
 			<ul id="nav-mobile" class="right hide-on-med-and-down">
 				<li><a href="/routes">Routes</a></li>
                 <li><a class="dropdown-button" href="#!" data-activates="overrides">Overrides<i class="material-icons right">arrow_drop_down</i></a></li>
+				<li><a href="/certs">Certificates</a></li>
+				<li><a href="/inspect">Requests</a></li>
 				<li><a href="https://github.com/fabiolb/fabio/blob/master/CHANGELOG.md">{{.Version}}</a></li>
 				<li><a href="https://github.com/fabiolb/fabio">Github</a></li>
 			</ul>
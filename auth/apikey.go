@@ -0,0 +1,321 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/gobwas/glob"
+	"github.com/hashicorp/consul/api"
+	"golang.org/x/time/rate"
+)
+
+// apiKey is a single entry of the API key list.
+type apiKey struct {
+	name    string
+	routes  []glob.Glob
+	rate    string // rate option as configured, e.g. 10/s
+	limiter *rate.Limiter
+}
+
+// apiKeyAuth is an implementation of AuthScheme which validates API
+// keys from a request header or a query parameter against a list of
+// SHA-256 hashed keys. The list is loaded from a file or a key in the
+// consul KV store and is reloaded when it changes. Each key can be
+// restricted to a set of routes and a request rate.
+type apiKeyAuth struct {
+	header string
+	query  string
+
+	mu   sync.RWMutex
+	keys map[string]*apiKey // hex encoded sha256 hash -> key
+}
+
+func newAPIKeyAuth(cfg config.APIKeyAuth) (AuthScheme, error) {
+	a := &apiKeyAuth{header: cfg.Header, query: cfg.Query}
+
+	switch {
+	case cfg.File != "":
+		stat, err := os.Stat(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		b, err := ioutil.ReadFile(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.load(b); err != nil {
+			return nil, err
+		}
+		go a.watchFile(cfg.File, stat.ModTime(), cfg.Refresh)
+
+	case cfg.ConsulURL != "":
+		client, key, err := newConsulKVClient(cfg.ConsulURL)
+		if err != nil {
+			return nil, err
+		}
+		go a.watchConsul(client, key)
+	}
+
+	return a, nil
+}
+
+func (a *apiKeyAuth) Authorized(request *http.Request, response http.ResponseWriter) bool {
+	raw := ""
+	if a.header != "" {
+		raw = request.Header.Get(a.header)
+	}
+	if raw == "" && a.query != "" {
+		raw = request.URL.Query().Get(a.query)
+	}
+	if raw == "" {
+		return false
+	}
+
+	hash := sha256.Sum256([]byte(raw))
+	a.mu.RLock()
+	k := a.keys[hex.EncodeToString(hash[:])]
+	a.mu.RUnlock()
+	if k == nil {
+		return false
+	}
+
+	if !k.allowed(request) {
+		log.Printf("[INFO] auth: API key %q is not allowed for %s%s", k.name, request.Host, request.URL.Path)
+		http.Error(response, "forbidden", http.StatusForbidden)
+		return false
+	}
+
+	if k.limiter != nil && !k.limiter.Allow() {
+		response.Header().Set("Retry-After", "1")
+		http.Error(response, "rate limit exceeded", http.StatusTooManyRequests)
+		return false
+	}
+
+	// do not pass the key to the upstream
+	if a.header != "" {
+		request.Header.Del(a.header)
+	}
+	if a.query != "" {
+		request.URL.RawQuery = removeQueryParam(request.URL.RawQuery, a.query)
+	}
+	return true
+}
+
+// removeQueryParam removes all values of the parameter from the raw
+// query and keeps the order and encoding of the other parameters.
+func removeQueryParam(rawQuery, name string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	var params []string
+	for _, p := range strings.Split(rawQuery, "&") {
+		k := p
+		if i := strings.IndexByte(k, '='); i >= 0 {
+			k = k[:i]
+		}
+		if key, err := url.QueryUnescape(k); err == nil && key == name {
+			continue
+		}
+		params = append(params, p)
+	}
+	return strings.Join(params, "&")
+}
+
+// allowed returns true if the key has no route restrictions or one
+// of its patterns matches the request. Patterns starting with a slash
+// are matched against the path and all others against host and path.
+func (k *apiKey) allowed(r *http.Request) bool {
+	if len(k.routes) == 0 {
+		return true
+	}
+	for _, g := range k.routes {
+		if g.Match(r.URL.Path) || g.Match(r.Host+r.URL.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// load parses the key list and replaces the current keys. Rate
+// limiters of keys whose hash and rate have not changed are kept.
+func (a *apiKeyAuth) load(b []byte) error {
+	keys, err := parseAPIKeys(b)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for hash, k := range keys {
+		if old := a.keys[hash]; old != nil && old.rate == k.rate {
+			k.limiter = old.limiter
+		}
+	}
+	a.keys = keys
+	return nil
+}
+
+// parseAPIKeys parses a list of API keys in the format
+//
+//   # comment
+//   <name> sha256:<hex> [routes=<glob>,<glob>] [rate=<n>/<s|m|h>]
+func parseAPIKeys(b []byte) (map[string]*apiKey, error) {
+	keys := map[string]*apiKey{}
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.HasPrefix(fields[1], "sha256:") {
+			return nil, fmt.Errorf("line %d: must be '<name> sha256:<hash> [options]'", n)
+		}
+		hash := strings.ToLower(strings.TrimPrefix(fields[1], "sha256:"))
+		if h, err := hex.DecodeString(hash); err != nil || len(h) != sha256.Size {
+			return nil, fmt.Errorf("line %d: invalid sha256 hash", n)
+		}
+
+		k := &apiKey{name: fields[0]}
+		for _, opt := range fields[2:] {
+			p := strings.SplitN(opt, "=", 2)
+			if len(p) != 2 {
+				return nil, fmt.Errorf("line %d: invalid option %q", n, opt)
+			}
+			switch p[0] {
+			case "routes":
+				for _, s := range strings.Split(p[1], ",") {
+					g, err := glob.Compile(s)
+					if err != nil {
+						return nil, fmt.Errorf("line %d: invalid route pattern %q. %s", n, s, err)
+					}
+					k.routes = append(k.routes, g)
+				}
+			case "rate":
+				limit, burst, err := parseRate(p[1])
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %s", n, err)
+				}
+				k.rate = p[1]
+				k.limiter = rate.NewLimiter(limit, burst)
+			default:
+				return nil, fmt.Errorf("line %d: unknown option %q", n, p[0])
+			}
+		}
+		keys[hash] = k
+	}
+	return keys, sc.Err()
+}
+
+// parseRate parses a rate in the format <n>/<s|m|h> and returns the
+// limit and a burst of n.
+func parseRate(s string) (rate.Limit, int, error) {
+	p := strings.SplitN(s, "/", 2)
+	if len(p) != 2 {
+		return 0, 0, fmt.Errorf("invalid rate %q. Must be '<n>/<s|m|h>'", s)
+	}
+	n, err := strconv.Atoi(p[0])
+	if err != nil || n <= 0 {
+		return 0, 0, fmt.Errorf("invalid rate %q. Must be '<n>/<s|m|h>'", s)
+	}
+	var per time.Duration
+	switch p[1] {
+	case "s":
+		per = time.Second
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	default:
+		return 0, 0, fmt.Errorf("invalid rate %q. Must be '<n>/<s|m|h>'", s)
+	}
+	return rate.Limit(float64(n) / per.Seconds()), n, nil
+}
+
+// watchFile reloads the key list when the modification time of the
+// file changes. The current keys are kept if the file is invalid.
+func (a *apiKeyAuth) watchFile(path string, modTime time.Time, refresh time.Duration) {
+	for range time.NewTicker(refresh).C {
+		stat, err := os.Stat(path)
+		if err != nil {
+			log.Println("[WARN] auth: Error accessing API key file:", err)
+			continue
+		}
+		if stat.ModTime() == modTime {
+			continue
+		}
+		b, err := ioutil.ReadFile(path)
+		if err == nil {
+			err = a.load(b)
+		}
+		if err != nil {
+			log.Println("[WARN] auth: Error reloading API key file:", err)
+			continue
+		}
+		modTime = stat.ModTime()
+		log.Println("[INFO] auth: The API key file has been successfully reloaded")
+	}
+}
+
+// watchConsul reloads the key list when the key in the consul KV
+// store changes.
+func (a *apiKeyAuth) watchConsul(client *api.Client, key string) {
+	var lastIndex uint64
+	for {
+		kv, meta, err := client.KV().Get(key, &api.QueryOptions{RequireConsistent: true, WaitIndex: lastIndex})
+		if err != nil {
+			log.Printf("[WARN] auth: Error fetching API keys from %s. %s", key, err)
+			time.Sleep(time.Second)
+			continue
+		}
+		if meta.LastIndex == lastIndex {
+			continue
+		}
+		lastIndex = meta.LastIndex
+
+		var b []byte
+		if kv != nil {
+			b = kv.Value
+		}
+		if err := a.load(b); err != nil {
+			log.Printf("[WARN] auth: Error loading API keys from %s. %s", key, err)
+			continue
+		}
+		log.Printf("[INFO] auth: Loaded API keys from %s", key)
+	}
+}
+
+// newConsulKVClient creates a consul client for a KV store URL in the
+// format http[s]://host:port/v1/kv/<key>[?token=<token>].
+func newConsulKVClient(rawurl string) (*api.Client, string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, "", errors.New("invalid consul url " + rawurl)
+	}
+	const prefix = "/v1/kv/"
+	if !strings.HasPrefix(u.Path, prefix) {
+		return nil, "", errors.New("missing prefix: " + prefix)
+	}
+	client, err := api.NewClient(&api.Config{Address: u.Host, Scheme: u.Scheme, Token: u.Query().Get("token")})
+	if err != nil {
+		return nil, "", err
+	}
+	return client, u.Path[len(prefix):], nil
+}
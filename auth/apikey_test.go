@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+func keyHash(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(h[:])
+}
+
+func TestParseAPIKeys(t *testing.T) {
+	tests := []struct {
+		desc string
+		in   string
+		err  string
+	}{
+		{"valid", fmt.Sprintf("# comment\n\nci %s routes=/api/*,example.com/* rate=10/s\n", keyHash("a")), ""},
+		{"missing hash", "ci\n", "line 1: must be '<name> sha256:<hash> [options]'"},
+		{"invalid hash", "ci sha256:abc\n", "line 1: invalid sha256 hash"},
+		{"invalid rate", fmt.Sprintf("ci %s rate=10\n", keyHash("a")), `line 1: invalid rate "10". Must be '<n>/<s|m|h>'`},
+		{"unknown option", fmt.Sprintf("ci %s foo=bar\n", keyHash("a")), `line 1: unknown option "foo"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			_, err := parseAPIKeys([]byte(tt.in))
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tt.err {
+				t.Fatalf("got error %q want %q", got, tt.err)
+			}
+		})
+	}
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	keys := fmt.Sprintf("ci %s routes=/api/*\nlimited %s rate=2/h\nall %s\n", keyHash("ci-key"), keyHash("limited-key"), keyHash("all-key"))
+	filename, err := createBasicAuthFile(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+
+	a, err := newAPIKeyAuth(config.APIKeyAuth{File: filename, Refresh: 10 * time.Millisecond, Header: "X-Api-Key", Query: "api_key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authorized := func(url, key string) (bool, int) {
+		req := httptest.NewRequest("GET", url, nil)
+		if key != "" {
+			req.Header.Set("X-Api-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		return a.Authorized(req, rec), rec.Code
+	}
+
+	tests := []struct {
+		desc string
+		url  string
+		key  string
+		ok   bool
+		code int
+	}{
+		{"no key", "http://example.com/api/foo", "", false, 200},
+		{"unknown key", "http://example.com/api/foo", "foo", false, 200},
+		{"allowed route", "http://example.com/api/foo", "ci-key", true, 200},
+		{"forbidden route", "http://example.com/admin", "ci-key", false, http.StatusForbidden},
+		{"query parameter", "http://example.com/admin?api_key=all-key", "", true, 200},
+		{"rate limit 1", "http://example.com/", "limited-key", true, 200},
+		{"rate limit 2", "http://example.com/", "limited-key", true, 200},
+		{"rate limit exceeded", "http://example.com/", "limited-key", false, http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			ok, code := authorized(tt.url, tt.key)
+			if ok != tt.ok || code != tt.code {
+				t.Fatalf("got %v, %d want %v, %d", ok, code, tt.ok, tt.code)
+			}
+		})
+	}
+
+	t.Run("strip key", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com/admin?a=1&api_key=all-key&b=%20", nil)
+		req.Header.Set("X-Api-Key", "all-key")
+		if !a.Authorized(req, httptest.NewRecorder()) {
+			t.Fatal("request not authorized")
+		}
+		if got := req.Header.Get("X-Api-Key"); got != "" {
+			t.Fatalf("got X-Api-Key %q want none", got)
+		}
+		if got, want := req.URL.RawQuery, "a=1&b=%20"; got != want {
+			t.Fatalf("got query %q want %q", got, want)
+		}
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		keys := fmt.Sprintf("limited %s rate=2/h\nnew %s\n", keyHash("limited-key"), keyHash("new-key"))
+		if err := ioutil.WriteFile(filename, []byte(keys), 0666); err != nil {
+			t.Fatal(err)
+		}
+		future := time.Now().Add(time.Minute)
+		if err := os.Chtimes(filename, future, future); err != nil {
+			t.Fatal(err)
+		}
+
+		if !waitFor(time.Second, func() bool { ok, _ := authorized("http://example.com/", "new-key"); return ok }) {
+			t.Fatal("new key not authorized after reload")
+		}
+		if ok, _ := authorized("http://example.com/api/foo", "ci-key"); ok {
+			t.Fatal("removed key authorized after reload")
+		}
+		if ok, code := authorized("http://example.com/", "limited-key"); ok || code != http.StatusTooManyRequests {
+			t.Fatal("rate limit reset by reload")
+		}
+	})
+}
+
+func waitFor(timeout time.Duration, fn func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if fn() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
@@ -7,10 +7,23 @@ import (
 	"github.com/fabiolb/fabio/config"
 )
 
+// AuthScheme authorizes requests. If the request is not authorized
+// the scheme can either only set response headers, e.g. to request
+// credentials, or write a complete response, e.g. a redirect to a
+// login page. In the latter case the caller must not write another
+// response.
 type AuthScheme interface {
 	Authorized(request *http.Request, response http.ResponseWriter) bool
 }
 
+// ClaimsAuthScheme is an AuthScheme which can also check per route
+// requirements on the claims of the authenticated identity. Every
+// claim must match one of the comma separated values.
+type ClaimsAuthScheme interface {
+	AuthScheme
+	AuthorizedClaims(request *http.Request, response http.ResponseWriter, claims map[string]string) bool
+}
+
 func LoadAuthSchemes(cfg map[string]config.AuthScheme) (map[string]AuthScheme, error) {
 	auths := map[string]AuthScheme{}
 	for _, a := range cfg {
@@ -21,6 +34,30 @@ func LoadAuthSchemes(cfg map[string]config.AuthScheme) (map[string]AuthScheme, e
 				return nil, err
 			}
 			auths[a.Name] = b
+		case "jwt":
+			j, err := newJWTAuth(a.JWT)
+			if err != nil {
+				return nil, err
+			}
+			auths[a.Name] = j
+		case "oidc":
+			o, err := newOIDCAuth(a.OIDC)
+			if err != nil {
+				return nil, err
+			}
+			auths[a.Name] = o
+		case "external":
+			e, err := newExternalAuth(a.External)
+			if err != nil {
+				return nil, err
+			}
+			auths[a.Name] = e
+		case "apikey":
+			k, err := newAPIKeyAuth(a.APIKey)
+			if err != nil {
+				return nil, err
+			}
+			auths[a.Name] = k
 		default:
 			return nil, fmt.Errorf("unknown auth type '%s'", a.Type)
 		}
@@ -0,0 +1,249 @@
+package auth
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+// externalMaxBody is the maximum size of a response body of the
+// auth service which is passed through to the client.
+const externalMaxBody = 64 << 10
+
+// externalMaxCache is the maximum number of cached decisions.
+var externalMaxCache = 10000
+
+// hopHeaders are the headers which are not copied between the
+// request, the auth service and the client.
+var hopHeaders = []string{
+	"Connection",
+	"Content-Length",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// external is an implementation of AuthScheme which asks an external
+// auth service whether a request is authorized. The auth service
+// receives a GET request with the headers of the original request and
+// the X-Forwarded-Method, X-Forwarded-Proto, X-Forwarded-Host,
+// X-Forwarded-Uri and X-Forwarded-For headers. A 2xx response
+// authorizes the request. Any other response is passed through to the
+// client.
+//
+// Decisions are only cached when the request headers which identify
+// the client are configured since the auth service may use any of
+// the request headers.
+type external struct {
+	cfg    config.ExternalAuth
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]*list.Element
+	// order contains the cached decisions in the order they
+	// were stored which is also the order of expiry.
+	order *list.List
+}
+
+// externalDecision is the response of the auth service.
+type externalDecision struct {
+	key     [sha256.Size]byte
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+func newExternalAuth(cfg config.ExternalAuth) (AuthScheme, error) {
+	if cfg.CacheTTL > 0 && len(cfg.CacheKey) == 0 {
+		log.Printf("[WARN] auth: Not caching external auth decisions for %s without 'cachekey'", cfg.URL)
+		cfg.CacheTTL = 0
+	}
+	return &external{
+		cfg: cfg,
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		cache: map[[sha256.Size]byte]*list.Element{},
+		order: list.New(),
+	}, nil
+}
+
+func (e *external) Authorized(request *http.Request, response http.ResponseWriter) bool {
+	// never pass client supplied auth headers upstream
+	for _, h := range e.cfg.Headers {
+		request.Header.Del(h)
+	}
+
+	d, err := e.decide(request)
+	if err != nil {
+		log.Printf("[ERROR] auth: External auth request to %s failed. %s", e.cfg.URL, err)
+		if e.cfg.FailOpen {
+			return true
+		}
+		http.Error(response, "authorization service unavailable", http.StatusServiceUnavailable)
+		return false
+	}
+
+	if d.status >= 200 && d.status < 300 {
+		for _, h := range e.cfg.Headers {
+			if v, ok := d.header[http.CanonicalHeaderKey(h)]; ok {
+				request.Header[http.CanonicalHeaderKey(h)] = v
+			}
+		}
+		return true
+	}
+
+	copyHeader(response.Header(), d.header)
+	response.WriteHeader(d.status)
+	response.Write(d.body)
+	return false
+}
+
+// decide returns the cached decision for the request or asks the
+// auth service.
+func (e *external) decide(r *http.Request) (*externalDecision, error) {
+	var key [sha256.Size]byte
+	if e.cfg.CacheTTL > 0 {
+		key = externalCacheKey(r, e.cfg.CacheKey)
+		e.mu.Lock()
+		var d *externalDecision
+		if el := e.cache[key]; el != nil {
+			d = el.Value.(*externalDecision)
+		}
+		e.mu.Unlock()
+		if d != nil && time.Now().Before(d.expires) {
+			return d, nil
+		}
+	}
+
+	d, err := e.check(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.cfg.CacheTTL > 0 {
+		d.key = key
+		d.expires = time.Now().Add(e.cfg.CacheTTL)
+		e.mu.Lock()
+		if el := e.cache[key]; el != nil {
+			e.order.Remove(el)
+		}
+		e.prune()
+		e.cache[key] = e.order.PushBack(d)
+		e.mu.Unlock()
+	}
+	return d, nil
+}
+
+// check sends the subrequest to the auth service. Server errors are
+// treated as errors so that they are subject to the fail open setting.
+func (e *external) check(r *http.Request) (*externalDecision, error) {
+	req, err := http.NewRequest(http.MethodGet, e.cfg.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	copyHeader(req.Header, r.Header)
+	req.Header.Set("X-Forwarded-Method", r.Method)
+	req.Header.Set("X-Forwarded-Proto", requestScheme(r))
+	req.Header.Set("X-Forwarded-Host", r.Host)
+	req.Header.Set("X-Forwarded-Uri", r.URL.RequestURI())
+	if ip := clientIP(r); ip != "" {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			ip = xff + ", " + ip
+		}
+		req.Header.Set("X-Forwarded-For", ip)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, externalMaxBody))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 500 {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return &externalDecision{status: resp.StatusCode, header: resp.Header, body: body}, nil
+}
+
+// prune removes the expired decisions from the cache and the oldest
+// ones until there is room for a new decision. The caller must hold
+// the lock.
+func (e *external) prune() {
+	now := time.Now()
+	for el := e.order.Front(); el != nil; el = e.order.Front() {
+		d := el.Value.(*externalDecision)
+		if len(e.cache) < externalMaxCache && now.Before(d.expires) {
+			return
+		}
+		e.order.Remove(el)
+		delete(e.cache, d.key)
+	}
+}
+
+// externalCacheKey returns the cache key for the request which
+// consists of the method, host, URI, client IP and the values of
+// the given request headers.
+func externalCacheKey(r *http.Request, headers []string) [sha256.Size]byte {
+	h := sha256.New()
+	for _, s := range []string{r.Method, r.Host, r.URL.RequestURI(), clientIP(r)} {
+		io.WriteString(h, s)
+		h.Write([]byte{0})
+	}
+	for _, name := range headers {
+		io.WriteString(h, name)
+		h.Write([]byte{0})
+		for _, v := range r.Header[http.CanonicalHeaderKey(name)] {
+			io.WriteString(h, v)
+			h.Write([]byte{0})
+		}
+		h.Write([]byte{1})
+	}
+	var key [sha256.Size]byte
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+func copyHeader(dst, src http.Header) {
+	for k, v := range src {
+		dst[k] = append([]string(nil), v...)
+	}
+	for _, h := range hopHeaders {
+		dst.Del(h)
+	}
+}
+
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+	return ip
+}
+
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"container/list"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+func TestExternalAuth(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if got, want := r.Header.Get("X-Forwarded-Uri"), "/app?x=1"; got != want {
+			t.Errorf("got X-Forwarded-Uri %q want %q", got, want)
+		}
+		if got, want := r.Header.Get("X-Forwarded-For"), "1.2.3.4"; got != want {
+			t.Errorf("got X-Forwarded-For %q want %q", got, want)
+		}
+		switch r.Header.Get("Authorization") {
+		case "Bearer key":
+			if r.Header.Get("X-Api-Key") != "good" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Header().Set("X-User", "bob")
+		case "Bearer good":
+			w.Header().Set("X-User", "alice")
+			w.Header().Set("X-Internal", "secret")
+		case "Bearer bad":
+			w.Header().Set("WWW-Authenticate", `Bearer realm="app"`)
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("go away"))
+		case "Bearer login":
+			w.Header().Set("Location", "https://login/")
+			w.WriteHeader(http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	newAuth := func(cfg config.ExternalAuth) AuthScheme {
+		cfg.URL = srv.URL
+		if cfg.Timeout == 0 {
+			cfg.Timeout = time.Second
+		}
+		a, err := newExternalAuth(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+
+	request := func(token string) *http.Request {
+		req := httptest.NewRequest("POST", "http://app.com/app?x=1", nil)
+		req.RemoteAddr = "1.2.3.4:5678"
+		req.Header.Set("X-User", "mallory")
+		req.Header.Set("Authorization", "Bearer "+token)
+		return req
+	}
+
+	tests := []struct {
+		desc     string
+		failOpen bool
+		token    string
+		ok       bool
+		code     int
+		body     string
+		header   string
+		user     string
+	}{
+		{desc: "allowed", token: "good", ok: true, code: 200, user: "alice"},
+		{desc: "denied", token: "bad", code: 403, body: "go away", header: `Bearer realm="app"`},
+		{desc: "redirect", token: "login", code: 302},
+		{desc: "error fail closed", token: "error", code: 503, body: "authorization service unavailable\n"},
+		{desc: "error fail open", token: "error", failOpen: true, ok: true, code: 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			a := newAuth(config.ExternalAuth{FailOpen: tt.failOpen, Headers: []string{"X-User"}})
+			req, rec := request(tt.token), httptest.NewRecorder()
+			if got, want := a.Authorized(req, rec), tt.ok; got != want {
+				t.Fatalf("got %v want %v", got, want)
+			}
+			if got, want := rec.Code, tt.code; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := rec.Body.String(), tt.body; got != want {
+				t.Fatalf("got body %q want %q", got, want)
+			}
+			if got, want := rec.Header().Get("WWW-Authenticate"), tt.header; got != want {
+				t.Fatalf("got WWW-Authenticate %q want %q", got, want)
+			}
+			if got, want := req.Header.Get("X-User"), tt.user; got != want {
+				t.Fatalf("got X-User %q want %q", got, want)
+			}
+			if got := req.Header.Get("X-Internal"); got != "" {
+				t.Fatalf("got X-Internal %q want none", got)
+			}
+		})
+	}
+
+	t.Run("cache", func(t *testing.T) {
+		a := newAuth(config.ExternalAuth{CacheTTL: time.Hour, CacheKey: []string{"Authorization"}, Headers: []string{"X-User"}})
+		atomic.StoreInt32(&calls, 0)
+		for i := 0; i < 3; i++ {
+			req := request("good")
+			if !a.Authorized(req, httptest.NewRecorder()) {
+				t.Fatal("request not authorized")
+			}
+			if got, want := req.Header.Get("X-User"), "alice"; got != want {
+				t.Fatalf("got X-User %q want %q", got, want)
+			}
+		}
+		if a.Authorized(request("bad"), httptest.NewRecorder()) {
+			t.Fatal("request authorized")
+		}
+		if got, want := atomic.LoadInt32(&calls), int32(2); got != want {
+			t.Fatalf("got %d calls want %d", got, want)
+		}
+	})
+
+	t.Run("cache key", func(t *testing.T) {
+		a := newAuth(config.ExternalAuth{CacheTTL: time.Hour, CacheKey: []string{"Authorization", "X-Api-Key"}, Headers: []string{"X-User"}})
+		good := request("key")
+		good.Header.Set("X-Api-Key", "good")
+		if !a.Authorized(good, httptest.NewRecorder()) {
+			t.Fatal("request not authorized")
+		}
+		bad := request("key")
+		bad.Header.Set("X-Api-Key", "bad")
+		if a.Authorized(bad, httptest.NewRecorder()) {
+			t.Fatal("request with other api key authorized from cache")
+		}
+	})
+
+	t.Run("no cache without cache key", func(t *testing.T) {
+		a := newAuth(config.ExternalAuth{CacheTTL: time.Hour, Headers: []string{"X-User"}})
+		atomic.StoreInt32(&calls, 0)
+		for i := 0; i < 2; i++ {
+			if !a.Authorized(request("good"), httptest.NewRecorder()) {
+				t.Fatal("request not authorized")
+			}
+		}
+		if got, want := atomic.LoadInt32(&calls), int32(2); got != want {
+			t.Fatalf("got %d calls want %d", got, want)
+		}
+	})
+
+	t.Run("prune", func(t *testing.T) {
+		defer func(n int) { externalMaxCache = n }(externalMaxCache)
+		externalMaxCache = 2
+
+		a := newAuth(config.ExternalAuth{CacheTTL: time.Hour, CacheKey: []string{"Authorization"}}).(*external)
+		for _, token := range []string{"good", "bad", "login"} {
+			a.Authorized(request(token), httptest.NewRecorder())
+		}
+		if got, want := len(a.cache), 2; got != want {
+			t.Fatalf("got %d cached decisions want %d", got, want)
+		}
+		if got, want := a.cache[externalCacheKey(request("good"), a.cfg.CacheKey)], (*list.Element)(nil); got != want {
+			t.Fatal("oldest decision not evicted")
+		}
+		if a.cache[externalCacheKey(request("login"), a.cfg.CacheKey)] == nil {
+			t.Fatal("newest decision evicted")
+		}
+	})
+}
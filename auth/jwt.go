@@ -0,0 +1,381 @@
+package auth
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// jwksTimeout is the timeout for fetching the JWKS.
+var jwksTimeout = 10 * time.Second
+
+// jwksMinRefetch is the minimum interval between two fetches of
+// the JWKS for tokens with an unknown key id. This protects the
+// identity provider from clients which send random key ids.
+var jwksMinRefetch = 30 * time.Second
+
+// jwksMaxSize is the maximum size of a JWKS document.
+const jwksMaxSize = 1 << 20
+
+var (
+	errNoToken  = errors.New("no bearer token")
+	errNoKey    = errors.New("no matching key")
+	errNoExpiry = errors.New("token has no expiry")
+	errAudience = errors.New("invalid audience")
+)
+
+// jwtAuth is an implementation of AuthScheme which validates
+// bearer tokens in the Authorization header.
+type jwtAuth struct {
+	realm    string
+	headers  map[string]string
+	verifier *jwtVerifier
+}
+
+// jwtVerifier validates the signature and the standard claims of
+// a signed token.
+type jwtVerifier struct {
+	issuer   string
+	audience []string
+	leeway   time.Duration
+	keys     *jwtKeys
+}
+
+func newJWTAuth(cfg config.JWTAuth) (AuthScheme, error) {
+	keys := &jwtKeys{url: cfg.JWKSURL, client: &http.Client{Timeout: jwksTimeout}}
+
+	if cfg.KeyFile != "" {
+		set, err := readKeyFile(cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		keys.static = set
+	}
+
+	if cfg.JWKSURL != "" {
+		// the identity provider may not be reachable during startup.
+		// The keys are fetched again on the next refresh or when a
+		// token with an unknown key id is presented.
+		if err := keys.fetch(); err != nil {
+			log.Printf("[WARN] auth: Cannot fetch JWKS from %s. %s", cfg.JWKSURL, err)
+		}
+		if cfg.Refresh > 0 {
+			go func() {
+				for range time.NewTicker(cfg.Refresh).C {
+					if err := keys.fetch(); err != nil {
+						log.Printf("[WARN] auth: Cannot refresh JWKS from %s. %s", cfg.JWKSURL, err)
+					}
+				}
+			}()
+		}
+	}
+
+	return &jwtAuth{
+		realm:   cfg.Realm,
+		headers: cfg.Headers,
+		verifier: &jwtVerifier{
+			issuer:   cfg.Issuer,
+			audience: cfg.Audience,
+			leeway:   cfg.Leeway,
+			keys:     keys,
+		},
+	}, nil
+}
+
+func (j *jwtAuth) Authorized(request *http.Request, response http.ResponseWriter) bool {
+	return j.AuthorizedClaims(request, response, nil)
+}
+
+func (j *jwtAuth) AuthorizedClaims(request *http.Request, response http.ResponseWriter, required map[string]string) bool {
+	// never pass client supplied identity headers upstream
+	for _, h := range j.headers {
+		request.Header.Del(h)
+	}
+
+	claims, err := j.verifier.verify(bearerToken(request), time.Now())
+	switch {
+	case err == errNoToken:
+		response.Header().Set("WWW-Authenticate", "Bearer realm=\""+j.realm+"\"")
+		return false
+	case err != nil:
+		log.Printf("[DEBUG] auth: Invalid token for %s. %s", request.URL, err)
+		response.Header().Set("WWW-Authenticate", "Bearer realm=\""+j.realm+"\", error=\"invalid_token\"")
+		return false
+	}
+
+	if !matchClaims(claims, required) {
+		response.Header().Set("WWW-Authenticate", "Bearer realm=\""+j.realm+"\", error=\"insufficient_scope\"")
+		return false
+	}
+
+	setClaimHeaders(request, claims, j.headers)
+	return true
+}
+
+// verify validates the token and returns its claims.
+func (v *jwtVerifier) verify(raw string, now time.Time) (map[string]interface{}, error) {
+	if raw == "" {
+		return nil, errNoToken
+	}
+
+	tok, err := jwt.ParseSigned(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(tok.Headers) != 1 {
+		return nil, errors.New("token must have exactly one signature")
+	}
+	hdr := tok.Headers[0]
+
+	var std jwt.Claims
+	var claims map[string]interface{}
+	err = errNoKey
+	for _, k := range v.keys.lookup(hdr.KeyID) {
+		if k.Algorithm != "" && k.Algorithm != hdr.Algorithm {
+			continue
+		}
+		if err = tok.Claims(k.Key, &std, &claims); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if std.Expiry == nil {
+		return nil, errNoExpiry
+	}
+	if err := std.ValidateWithLeeway(jwt.Expected{Issuer: v.issuer, Time: now}, v.leeway); err != nil {
+		return nil, err
+	}
+	if len(v.audience) > 0 && !containsAny(std.Audience, v.audience) {
+		return nil, errAudience
+	}
+	return claims, nil
+}
+
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(h[7:])
+}
+
+func containsAny(aud jwt.Audience, want []string) bool {
+	for _, w := range want {
+		if aud.Contains(w) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchClaims returns true if all required claims match.
+func matchClaims(claims map[string]interface{}, required map[string]string) bool {
+	for name, want := range required {
+		if !matchClaim(name, claims[name], want) {
+			return false
+		}
+	}
+	return true
+}
+
+// setClaimHeaders sets the upstream headers for the claims in the
+// claim name to header mapping.
+func setClaimHeaders(r *http.Request, claims map[string]interface{}, headers map[string]string) {
+	for name, h := range headers {
+		if v, ok := claims[name]; ok {
+			r.Header.Set(h, claimString(v))
+		}
+	}
+}
+
+// matchClaim returns true if the claim value v matches one of the
+// comma separated values in want. Array claims match if one of their
+// elements matches. The OAuth2 'scope' claim is treated as a space
+// separated list.
+func matchClaim(name string, v interface{}, want string) bool {
+	var vals []string
+	switch x := v.(type) {
+	case nil:
+		return false
+	case []interface{}:
+		for _, e := range x {
+			vals = append(vals, claimString(e))
+		}
+	case string:
+		if name == "scope" {
+			vals = strings.Fields(x)
+		} else {
+			vals = []string{x}
+		}
+	default:
+		vals = []string{claimString(x)}
+	}
+
+	for _, w := range strings.Split(want, ",") {
+		for _, v := range vals {
+			if v == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// claimString returns the claim value as header value. Arrays are
+// joined with commas and objects are encoded as JSON.
+func claimString(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(x)
+	case []interface{}:
+		var s []string
+		for _, e := range x {
+			s = append(s, claimString(e))
+		}
+		return strings.Join(s, ",")
+	default:
+		b, _ := json.Marshal(x)
+		return string(b)
+	}
+}
+
+// jwtKeys holds the static keys and the keys from the JWKS URL.
+type jwtKeys struct {
+	static []jose.JSONWebKey
+	url    string
+	client *http.Client
+
+	mu        sync.RWMutex
+	remote    []jose.JSONWebKey
+	lastFetch time.Time
+}
+
+// lookup returns the keys for the given key id. All keys are returned
+// if the key id is empty. If no key matches the JWKS is fetched again
+// to pick up rotated keys.
+func (k *jwtKeys) lookup(kid string) []jose.JSONWebKey {
+	keys := k.find(kid)
+	if len(keys) > 0 || k.url == "" {
+		return keys
+	}
+
+	k.mu.RLock()
+	recent := time.Since(k.lastFetch) < jwksMinRefetch
+	k.mu.RUnlock()
+	if recent {
+		return nil
+	}
+	if err := k.fetch(); err != nil {
+		log.Printf("[WARN] auth: Cannot fetch JWKS from %s. %s", k.url, err)
+		return nil
+	}
+	return k.find(kid)
+}
+
+func (k *jwtKeys) find(kid string) []jose.JSONWebKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	var keys []jose.JSONWebKey
+	for _, set := range [][]jose.JSONWebKey{k.static, k.remote} {
+		for _, key := range set {
+			if key.Use != "" && key.Use != "sig" {
+				continue
+			}
+			if kid == "" || key.KeyID == kid {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// fetch loads the JWKS from the URL and replaces the remote keys.
+func (k *jwtKeys) fetch() error {
+	k.mu.Lock()
+	k.lastFetch = time.Now()
+	k.mu.Unlock()
+
+	resp, err := k.client.Get(k.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	var set jose.JSONWebKeySet
+	if err := json.NewDecoder(io.LimitReader(resp.Body, jwksMaxSize)).Decode(&set); err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	k.remote = set.Keys
+	k.mu.Unlock()
+	return nil
+}
+
+// readKeyFile reads a JWKS or a list of PEM encoded public keys and
+// certificates from a file.
+func readKeyFile(path string) ([]jose.JSONWebKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var set jose.JSONWebKeySet
+	if err := json.Unmarshal(b, &set); err == nil {
+		if len(set.Keys) == 0 {
+			return nil, fmt.Errorf("auth: no keys in %s", path)
+		}
+		return set.Keys, nil
+	}
+
+	var keys []jose.JSONWebKey
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "PUBLIC KEY":
+			pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, jose.JSONWebKey{Key: pub})
+		case "CERTIFICATE":
+			x, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, jose.JSONWebKey{Key: x.PublicKey})
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("auth: no keys in %s", path)
+	}
+	return keys, nil
+}
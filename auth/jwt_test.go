@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+type testKey struct {
+	kid  string
+	priv *rsa.PrivateKey
+}
+
+func newTestKey(t *testing.T, kid string) testKey {
+	t.Helper()
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{kid, priv}
+}
+
+func (k testKey) jwk() jose.JSONWebKey {
+	return jose.JSONWebKey{Key: &k.priv.PublicKey, KeyID: k.kid, Algorithm: "RS256", Use: "sig"}
+}
+
+func (k testKey) sign(t *testing.T, claims interface{}) string {
+	t.Helper()
+	sig, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: k.priv},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", k.kid),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := jwt.Signed(sig).Claims(claims).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// jwksServer serves the public keys of the current key set.
+type jwksServer struct {
+	mu      sync.Mutex
+	keys    []testKey
+	fetches int
+}
+
+func (s *jwksServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetches++
+	var set jose.JSONWebKeySet
+	for _, k := range s.keys {
+		set.Keys = append(set.Keys, k.jwk())
+	}
+	json.NewEncoder(w).Encode(set)
+}
+
+func (s *jwksServer) setKeys(keys ...testKey) {
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+}
+
+func validClaims() map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":   "https://idp",
+		"aud":   []string{"fabio"},
+		"sub":   "alice",
+		"exp":   now.Add(time.Hour).Unix(),
+		"nbf":   now.Add(-time.Minute).Unix(),
+		"role":  []string{"admin", "dev"},
+		"scope": "read write",
+	}
+}
+
+func withClaim(k string, v interface{}) map[string]interface{} {
+	c := validClaims()
+	if v == nil {
+		delete(c, k)
+	} else {
+		c[k] = v
+	}
+	return c
+}
+
+func TestJWTAuth(t *testing.T) {
+	k1 := newTestKey(t, "k1")
+	jwks := &jwksServer{keys: []testKey{k1}}
+	srv := httptest.NewServer(jwks)
+	defer srv.Close()
+
+	a, err := newJWTAuth(config.JWTAuth{
+		Realm:    "myrealm",
+		JWKSURL:  srv.URL,
+		Issuer:   "https://idp",
+		Audience: []string{"fabio", "other"},
+		Headers:  map[string]string{"sub": "X-User", "role": "X-Role"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := a.(*jwtAuth)
+
+	tests := []struct {
+		desc     string
+		token    string
+		required map[string]string
+		ok       bool
+		authHdr  string
+	}{
+		{"no token", "", nil, false, `Bearer realm="myrealm"`},
+		{"garbage", "foo", nil, false, `Bearer realm="myrealm", error="invalid_token"`},
+		{"valid", k1.sign(t, validClaims()), nil, true, ""},
+		{"expired", k1.sign(t, withClaim("exp", time.Now().Add(-time.Hour).Unix())), nil, false, `Bearer realm="myrealm", error="invalid_token"`},
+		{"no expiry", k1.sign(t, withClaim("exp", nil)), nil, false, `Bearer realm="myrealm", error="invalid_token"`},
+		{"not yet valid", k1.sign(t, withClaim("nbf", time.Now().Add(time.Hour).Unix())), nil, false, `Bearer realm="myrealm", error="invalid_token"`},
+		{"wrong issuer", k1.sign(t, withClaim("iss", "https://evil")), nil, false, `Bearer realm="myrealm", error="invalid_token"`},
+		{"wrong audience", k1.sign(t, withClaim("aud", "foo")), nil, false, `Bearer realm="myrealm", error="invalid_token"`},
+		{"unknown key", newTestKey(t, "k1").sign(t, validClaims()), nil, false, `Bearer realm="myrealm", error="invalid_token"`},
+		{"claim array match", k1.sign(t, validClaims()), map[string]string{"role": "admin"}, true, ""},
+		{"claim alternatives", k1.sign(t, validClaims()), map[string]string{"role": "ops,dev"}, true, ""},
+		{"claim string match", k1.sign(t, validClaims()), map[string]string{"sub": "alice"}, true, ""},
+		{"scope match", k1.sign(t, validClaims()), map[string]string{"scope": "write"}, true, ""},
+		{"claim mismatch", k1.sign(t, validClaims()), map[string]string{"role": "ops"}, false, `Bearer realm="myrealm", error="insufficient_scope"`},
+		{"claim missing", k1.sign(t, validClaims()), map[string]string{"group": "ops"}, false, `Bearer realm="myrealm", error="insufficient_scope"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req := &http.Request{Header: http.Header{"X-User": {"mallory"}}}
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rw := &responseWriter{}
+			if got, want := j.AuthorizedClaims(req, rw, tt.required), tt.ok; got != want {
+				t.Fatalf("got %v want %v", got, want)
+			}
+			if got, want := rw.Header().Get("WWW-Authenticate"), tt.authHdr; got != want {
+				t.Fatalf("got WWW-Authenticate %q want %q", got, want)
+			}
+			wantUser, wantRole := "", ""
+			if tt.ok {
+				wantUser, wantRole = "alice", "admin,dev"
+			}
+			if got := req.Header.Get("X-User"); got != wantUser {
+				t.Fatalf("got X-User %q want %q", got, wantUser)
+			}
+			if got := req.Header.Get("X-Role"); got != wantRole {
+				t.Fatalf("got X-Role %q want %q", got, wantRole)
+			}
+		})
+	}
+}
+
+func TestJWTAuthKeyRotation(t *testing.T) {
+	defer func(d time.Duration) { jwksMinRefetch = d }(jwksMinRefetch)
+	jwksMinRefetch = 0
+
+	k1, k2 := newTestKey(t, "k1"), newTestKey(t, "k2")
+	jwks := &jwksServer{keys: []testKey{k1}}
+	srv := httptest.NewServer(jwks)
+	defer srv.Close()
+
+	a, err := newJWTAuth(config.JWTAuth{JWKSURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authorized := func(token string) bool {
+		req := &http.Request{Header: http.Header{"Authorization": {"Bearer " + token}}}
+		return a.Authorized(req, &responseWriter{})
+	}
+
+	if !authorized(k1.sign(t, validClaims())) {
+		t.Fatal("token signed with k1 not authorized")
+	}
+	if got, want := jwks.fetches, 1; got != want {
+		t.Fatalf("got %d fetches want %d", got, want)
+	}
+
+	jwks.setKeys(k2)
+	if !authorized(k2.sign(t, validClaims())) {
+		t.Fatal("token signed with rotated key k2 not authorized")
+	}
+	if got, want := jwks.fetches, 2; got != want {
+		t.Fatalf("got %d fetches want %d", got, want)
+	}
+	if authorized(k1.sign(t, validClaims())) {
+		t.Fatal("token signed with removed key k1 authorized")
+	}
+}
+
+func TestJWTAuthKeyFile(t *testing.T) {
+	k1 := newTestKey(t, "k1")
+	b, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{k1.jwk()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename, err := createBasicAuthFile(string(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := newJWTAuth(config.JWTAuth{KeyFile: filename})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &http.Request{Header: http.Header{"Authorization": {"Bearer " + k1.sign(t, validClaims())}}}
+	if !a.Authorized(req, &responseWriter{}) {
+		t.Fatal("token not authorized")
+	}
+
+	if _, err := newJWTAuth(config.JWTAuth{KeyFile: "/some/non/existent/file"}); err == nil {
+		t.Fatal("want error for missing key file")
+	}
+}
@@ -0,0 +1,391 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+// oidcTimeout is the timeout for requests to the identity provider.
+var oidcTimeout = 10 * time.Second
+
+// oidcRetry is the minimum interval between two attempts to fetch
+// the configuration of the identity provider.
+var oidcRetry = 10 * time.Second
+
+// oidcLoginTimeout is the time a user has to complete the login at
+// the identity provider.
+var oidcLoginTimeout = 10 * time.Minute
+
+// oidcSkipClaims are the claims of the ID token which are not stored
+// in the session cookie to keep it small.
+var oidcSkipClaims = []string{"aud", "exp", "iat", "nbf", "auth_time", "nonce", "at_hash", "c_hash", "azp", "sid"}
+
+// oidcAuth is an implementation of AuthScheme which authenticates
+// browser requests with the OpenID Connect authorization code flow.
+// Unauthenticated requests are redirected to the identity provider
+// which redirects back to the callback path. The identity from the
+// ID token is stored in an encrypted session cookie.
+type oidcAuth struct {
+	cfg    config.OIDCAuth
+	aead   cipher.AEAD
+	client *http.Client
+
+	mu       sync.Mutex
+	provider *oidcProvider
+	lastTry  time.Time
+}
+
+// oidcProvider is the configuration of the identity provider from
+// the discovery document.
+type oidcProvider struct {
+	Issuer   string `json:"issuer"`
+	AuthURL  string `json:"authorization_endpoint"`
+	TokenURL string `json:"token_endpoint"`
+	JWKSURL  string `json:"jwks_uri"`
+
+	verifier *jwtVerifier
+}
+
+// oidcSession is the content of the session cookie.
+type oidcSession struct {
+	Expiry int64                  `json:"exp"`
+	Claims map[string]interface{} `json:"claims"`
+}
+
+// oidcState is the content of the state cookie during the login.
+type oidcState struct {
+	Expiry int64  `json:"exp"`
+	State  string `json:"state"`
+	Nonce  string `json:"nonce"`
+	URL    string `json:"url"`
+}
+
+func newOIDCAuth(cfg config.OIDCAuth) (AuthScheme, error) {
+	key := sha256.Sum256([]byte(cfg.CookieSecret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &oidcAuth{
+		cfg:    cfg,
+		aead:   aead,
+		client: &http.Client{Timeout: oidcTimeout},
+	}
+
+	// the identity provider may not be reachable during startup.
+	// The configuration is fetched again on the next login.
+	if _, err := o.discover(); err != nil {
+		log.Printf("[WARN] auth: Cannot fetch OpenID configuration from %s. %s", cfg.Issuer, err)
+	}
+	return o, nil
+}
+
+func (o *oidcAuth) Authorized(request *http.Request, response http.ResponseWriter) bool {
+	return o.AuthorizedClaims(request, response, nil)
+}
+
+func (o *oidcAuth) AuthorizedClaims(request *http.Request, response http.ResponseWriter, required map[string]string) bool {
+	// never pass client supplied identity headers upstream
+	for _, h := range o.cfg.Headers {
+		request.Header.Del(h)
+	}
+
+	if request.URL.Path == o.cfg.CallbackPath {
+		o.callback(response, request)
+		return false
+	}
+
+	var s oidcSession
+	if !o.readCookie(request, o.cfg.CookieName, &s) || time.Now().Unix() > s.Expiry {
+		o.login(response, request)
+		return false
+	}
+	if !matchClaims(s.Claims, required) {
+		return false
+	}
+
+	removeCookies(request, o.cfg.CookieName, o.stateCookieName())
+	setClaimHeaders(request, s.Claims, o.cfg.Headers)
+	return true
+}
+
+// login redirects browser requests to the identity provider. Other
+// requests are rejected.
+func (o *oidcAuth) login(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet || !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		return
+	}
+
+	p, err := o.discover()
+	if err != nil {
+		log.Printf("[ERROR] auth: Cannot fetch OpenID configuration from %s. %s", o.cfg.Issuer, err)
+		http.Error(w, "identity provider unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	st := oidcState{
+		Expiry: time.Now().Add(oidcLoginTimeout).Unix(),
+		State:  randomString(),
+		Nonce:  randomString(),
+		URL:    r.URL.RequestURI(),
+	}
+	if err := o.setCookie(w, r, o.stateCookieName(), st, oidcLoginTimeout); err != nil {
+		log.Printf("[ERROR] auth: Cannot create state cookie. %s", err)
+		http.Error(w, "cannot create state", http.StatusInternalServerError)
+		return
+	}
+
+	q := url.Values{
+		"response_type": {"code"},
+		"client_id":     {o.cfg.ClientID},
+		"redirect_uri":  {o.redirectURI(r)},
+		"scope":         {strings.Join(o.cfg.Scopes, " ")},
+		"state":         {st.State},
+		"nonce":         {st.Nonce},
+	}
+	sep := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, p.AuthURL+sep+q.Encode(), http.StatusFound)
+}
+
+// callback completes the login by exchanging the authorization code
+// for an ID token and redirects to the original URL with a new
+// session cookie.
+func (o *oidcAuth) callback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		log.Printf("[WARN] auth: Login failed. %s %s", e, q.Get("error_description"))
+		http.Error(w, "login failed", http.StatusUnauthorized)
+		return
+	}
+
+	var st oidcState
+	if !o.readCookie(r, o.stateCookieName(), &st) || time.Now().Unix() > st.Expiry || q.Get("state") != st.State {
+		http.Error(w, "invalid login state", http.StatusBadRequest)
+		return
+	}
+
+	p, err := o.discover()
+	if err != nil {
+		log.Printf("[ERROR] auth: Cannot fetch OpenID configuration from %s. %s", o.cfg.Issuer, err)
+		http.Error(w, "identity provider unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	claims, err := o.exchange(p, q.Get("code"), o.redirectURI(r))
+	if err == nil && claims["nonce"] != st.Nonce {
+		err = errors.New("invalid nonce")
+	}
+	if err != nil {
+		log.Printf("[WARN] auth: Login failed. %s", err)
+		http.Error(w, "login failed", http.StatusUnauthorized)
+		return
+	}
+
+	for _, c := range oidcSkipClaims {
+		delete(claims, c)
+	}
+	s := oidcSession{Expiry: time.Now().Add(o.cfg.Session).Unix(), Claims: claims}
+	if err := o.setCookie(w, r, o.cfg.CookieName, s, o.cfg.Session); err != nil {
+		log.Printf("[ERROR] auth: Cannot create session cookie. %s", err)
+		http.Error(w, "cannot create session", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: o.stateCookieName(), Path: "/", MaxAge: -1})
+
+	// only redirect to local paths
+	target := st.URL
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") {
+		target = "/"
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// exchange redeems the authorization code at the token endpoint and
+// returns the claims of the verified ID token.
+func (o *oidcAuth) exchange(p *oidcProvider, code, redirectURI string) (map[string]interface{}, error) {
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {redirectURI},
+	}
+	if o.cfg.ClientSecret == "" {
+		form.Set("client_id", o.cfg.ClientID)
+	}
+	req, err := http.NewRequest(http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if o.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(o.cfg.ClientID), url.QueryEscape(o.cfg.ClientSecret))
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
+	}
+	var tok struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, jwksMaxSize)).Decode(&tok); err != nil {
+		return nil, err
+	}
+	if tok.IDToken == "" {
+		return nil, errors.New("no id_token in token response")
+	}
+	return p.verifier.verify(tok.IDToken, time.Now())
+}
+
+// discover returns the configuration of the identity provider and
+// fetches it if necessary.
+func (o *oidcAuth) discover() (*oidcProvider, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.provider != nil {
+		return o.provider, nil
+	}
+	if time.Since(o.lastTry) < oidcRetry {
+		return nil, errors.New("waiting for retry")
+	}
+	o.lastTry = time.Now()
+
+	issuer := strings.TrimSuffix(o.cfg.Issuer, "/")
+	resp, err := o.client.Get(issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	p := &oidcProvider{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, jwksMaxSize)).Decode(p); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(p.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("issuer %q does not match %q", p.Issuer, o.cfg.Issuer)
+	}
+	if p.AuthURL == "" || p.TokenURL == "" || p.JWKSURL == "" {
+		return nil, errors.New("incomplete OpenID configuration")
+	}
+
+	keys := &jwtKeys{url: p.JWKSURL, client: o.client}
+	if err := keys.fetch(); err != nil {
+		return nil, err
+	}
+	p.verifier = &jwtVerifier{
+		issuer:   p.Issuer,
+		audience: []string{o.cfg.ClientID},
+		leeway:   time.Minute,
+		keys:     keys,
+	}
+	o.provider = p
+	return p, nil
+}
+
+func (o *oidcAuth) stateCookieName() string {
+	return o.cfg.CookieName + "-state"
+}
+
+func (o *oidcAuth) redirectURI(r *http.Request) string {
+	return requestScheme(r) + "://" + r.Host + o.cfg.CallbackPath
+}
+
+// setCookie stores v encrypted in the cookie with the given name.
+func (o *oidcAuth) setCookie(w http.ResponseWriter, r *http.Request, name string, v interface{}, maxAge time.Duration) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, o.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	value := base64.RawURLEncoding.EncodeToString(o.aead.Seal(nonce, nonce, b, []byte(name)))
+	if len(value) > 4000 {
+		log.Printf("[WARN] auth: Cookie %s has %d bytes and may be rejected by the browser", name, len(value))
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(maxAge.Seconds()),
+		Secure:   requestScheme(r) == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// readCookie decrypts the cookie with the given name into v.
+func (o *oidcAuth) readCookie(r *http.Request, name string, v interface{}) bool {
+	c, err := r.Cookie(name)
+	if err != nil {
+		return false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(c.Value)
+	if err != nil || len(b) < o.aead.NonceSize() {
+		return false
+	}
+	n := o.aead.NonceSize()
+	plain, err := o.aead.Open(nil, b[:n], b[n:], []byte(name))
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(plain, v) == nil
+}
+
+// removeCookies removes the cookies with the given names from the
+// request so that they are not sent upstream.
+func removeCookies(r *http.Request, names ...string) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, c := range cookies {
+		keep := true
+		for _, name := range names {
+			if c.Name == name {
+				keep = false
+			}
+		}
+		if keep {
+			r.AddCookie(c)
+		}
+	}
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
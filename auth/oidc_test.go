@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// mockOIDCProvider is a minimal OpenID Connect provider which logs in
+// every user as alice.
+type mockOIDCProvider struct {
+	*httptest.Server
+	t   *testing.T
+	key testKey
+
+	mu    sync.Mutex
+	codes map[string]string // code -> nonce
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	p := &mockOIDCProvider{t: t, key: newTestKey(t, "k1"), codes: map[string]string{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{p.key.jwk()}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("client_id") != "fabio" || q.Get("response_type") != "code" {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		code := randomString()
+		p.mu.Lock()
+		p.codes[code] = q.Get("nonce")
+		p.mu.Unlock()
+		http.Redirect(w, r, q.Get("redirect_uri")+"?code="+code+"&state="+q.Get("state"), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "fabio" || secret != "secret" {
+			http.Error(w, "invalid client", http.StatusUnauthorized)
+			return
+		}
+		p.mu.Lock()
+		nonce, ok := p.codes[r.FormValue("code")]
+		delete(p.codes, r.FormValue("code"))
+		p.mu.Unlock()
+		if !ok {
+			http.Error(w, "invalid code", http.StatusBadRequest)
+			return
+		}
+		claims := map[string]interface{}{
+			"iss":   p.URL,
+			"aud":   "fabio",
+			"sub":   "alice",
+			"email": "alice@example.com",
+			"role":  []string{"admin"},
+			"nonce": nonce,
+			"exp":   time.Now().Add(time.Hour).Unix(),
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": p.key.sign(t, claims)})
+	})
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+// browse sends a browser request through the auth scheme and returns
+// the response and whether the request was authorized.
+func browse(a AuthScheme, rawurl string, cookies []*http.Cookie, required map[string]string) (*http.Request, *httptest.ResponseRecorder, bool) {
+	req := httptest.NewRequest("GET", rawurl, nil)
+	req.Header.Set("Accept", "text/html")
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rec := httptest.NewRecorder()
+	ok := a.(ClaimsAuthScheme).AuthorizedClaims(req, rec, required)
+	return req, rec, ok
+}
+
+func TestOIDCAuth(t *testing.T) {
+	idp := newMockOIDCProvider(t)
+	defer idp.Close()
+
+	a, err := newOIDCAuth(config.OIDCAuth{
+		Issuer:       idp.URL,
+		ClientID:     "fabio",
+		ClientSecret: "secret",
+		Scopes:       []string{"openid", "email"},
+		CallbackPath: "/oauth2/callback",
+		CookieName:   "fabio-sso",
+		CookieSecret: "0123456789abcdef",
+		Session:      time.Hour,
+		Headers:      map[string]string{"sub": "X-Forwarded-User", "email": "X-Forwarded-Email"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// unauthenticated browser request is redirected to the provider
+	_, rec, ok := browse(a, "http://app.com/dash?x=1", nil, nil)
+	if ok {
+		t.Fatal("unauthenticated request authorized")
+	}
+	if got, want := rec.Code, http.StatusFound; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	loc := rec.Header().Get("Location")
+	if !strings.HasPrefix(loc, idp.URL+"/authorize?") {
+		t.Fatalf("got redirect to %q want provider", loc)
+	}
+	stateCookies := rec.Result().Cookies()
+
+	// provider redirects back to the callback
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback := resp.Header.Get("Location")
+	if !strings.HasPrefix(callback, "http://app.com/oauth2/callback?") {
+		t.Fatalf("got callback %q", callback)
+	}
+
+	// callback with an invalid state is rejected
+	u, _ := url.Parse(callback)
+	q := u.Query()
+	q.Set("state", "foo")
+	u.RawQuery = q.Encode()
+	if _, rec, _ := browse(a, u.String(), stateCookies, nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d for invalid state want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	// callback sets the session and redirects to the original URL
+	_, rec, ok = browse(a, callback, stateCookies, nil)
+	if ok {
+		t.Fatal("callback request authorized")
+	}
+	if got, want := rec.Code, http.StatusFound; got != want {
+		t.Fatalf("got status %d want %d: %s", got, want, rec.Body.String())
+	}
+	if got, want := rec.Header().Get("Location"), "/dash?x=1"; got != want {
+		t.Fatalf("got redirect to %q want %q", got, want)
+	}
+	var session []*http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "fabio-sso" {
+			session = append(session, c)
+		}
+	}
+	if len(session) != 1 {
+		t.Fatal("no session cookie")
+	}
+
+	// requests with a session are authorized and carry the identity
+	req, _, ok := browse(a, "http://app.com/dash?x=1", append(session, &http.Cookie{Name: "app", Value: "1"}), nil)
+	if !ok {
+		t.Fatal("request with session not authorized")
+	}
+	if got, want := req.Header.Get("X-Forwarded-User"), "alice"; got != want {
+		t.Fatalf("got user %q want %q", got, want)
+	}
+	if got, want := req.Header.Get("X-Forwarded-Email"), "alice@example.com"; got != want {
+		t.Fatalf("got email %q want %q", got, want)
+	}
+	if got, want := req.Header.Get("Cookie"), "app=1"; got != want {
+		t.Fatalf("got cookies %q want %q", got, want)
+	}
+
+	// claims requirements are checked against the session
+	if _, _, ok := browse(a, "http://app.com/", session, map[string]string{"role": "admin"}); !ok {
+		t.Fatal("request with matching claim not authorized")
+	}
+	if _, _, ok := browse(a, "http://app.com/", session, map[string]string{"role": "ops"}); ok {
+		t.Fatal("request with missing claim authorized")
+	}
+
+	// tampered sessions are rejected
+	tampered := &http.Cookie{Name: "fabio-sso", Value: session[0].Value[:len(session[0].Value)-2] + "AA"}
+	if _, _, ok := browse(a, "http://app.com/", []*http.Cookie{tampered}, nil); ok {
+		t.Fatal("request with tampered session authorized")
+	}
+
+	// spoofed identity headers are removed from non-browser requests
+	req = httptest.NewRequest("GET", "http://app.com/api", nil)
+	req.Header.Set("X-Forwarded-User", "mallory")
+	rec = httptest.NewRecorder()
+	if a.Authorized(req, rec) {
+		t.Fatal("api request without session authorized")
+	}
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Fatalf("got response %d %q for api request want none", rec.Code, rec.Body.String())
+	}
+	if got := req.Header.Get("X-Forwarded-User"); got != "" {
+		t.Fatalf("got spoofed user %q", got)
+	}
+}
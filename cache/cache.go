@@ -0,0 +1,276 @@
+// Package cache provides an in-memory cache for HTTP responses with
+// a global memory limit and least-recently-used eviction.
+package cache
+
+import (
+	"container/list"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default is the response cache of the HTTP proxy.
+var Default = New(64<<20, 1<<20)
+
+// Entry is a cached response.
+type Entry struct {
+	Status int
+	Header http.Header
+	Body   []byte
+
+	// Date is the time at which the response was generated by the
+	// upstream. It is used to calculate the Age header.
+	Date time.Time
+
+	// Expires is the time at which the response becomes stale.
+	Expires time.Time
+}
+
+// Fresh returns true if the response can be served
+// without revalidation at time now.
+func (e *Entry) Fresh(now time.Time) bool {
+	return now.Before(e.Expires)
+}
+
+func (e *Entry) size() int64 {
+	n := len(e.Body)
+	for k, vals := range e.Header {
+		for _, v := range vals {
+			n += len(k) + len(v)
+		}
+	}
+	return int64(n)
+}
+
+// Stats contains the statistics of the cache.
+type Stats struct {
+	Entries int   `json:"entries"`
+	Size    int64 `json:"size"`
+	MaxSize int64 `json:"max_size"`
+}
+
+// Cache is an in-memory response cache. Responses are stored per
+// key and per variant of the request headers which are listed in
+// the Vary header of the response. When the size of the entries
+// exceeds the maximum size the least recently used entries are
+// evicted.
+type Cache struct {
+	mu           sync.Mutex
+	maxSize      int64
+	maxEntrySize int64
+	size         int64
+	lru          *list.List // of *item, most recently used first
+	resources    map[string]*resource
+	inflight     map[string]chan struct{}
+}
+
+// resource contains the variants of the responses for a key.
+type resource struct {
+	host, path string
+	vary       []string
+	variants   map[string]*list.Element
+}
+
+type item struct {
+	key     string
+	variant string
+	entry   *Entry
+	size    int64
+}
+
+// New creates a cache with the maximum size of all entries
+// and of a single entry in bytes.
+func New(maxSize, maxEntrySize int64) *Cache {
+	return &Cache{
+		maxSize:      maxSize,
+		maxEntrySize: maxEntrySize,
+		lru:          list.New(),
+		resources:    map[string]*resource{},
+		inflight:     map[string]chan struct{}{},
+	}
+}
+
+// MaxEntrySize returns the maximum size of a single entry.
+func (c *Cache) MaxEntrySize() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.maxEntrySize
+}
+
+// Get returns the entry for the key which matches the request headers
+// listed in the Vary header of the response or nil.
+func (c *Cache) Get(key string, h http.Header) *Entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := c.resources[key]
+	if res == nil {
+		return nil
+	}
+	el := res.variants[variant(res.vary, h)]
+	if el == nil {
+		return nil
+	}
+	c.lru.MoveToFront(el)
+	return el.Value.(*item).entry
+}
+
+// Put stores the entry for the key and the request headers listed in
+// the Vary header of the entry. Entries which are larger than the
+// maximum entry size are not stored. Put returns true if the entry
+// was stored.
+func (c *Cache) Put(key, host, path string, h http.Header, e *Entry) bool {
+	vary := varyHeaders(e.Header)
+	it := &item{key: key, variant: variant(vary, h), entry: e, size: e.size() + int64(len(key))}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if it.size > c.maxEntrySize || it.size > c.maxSize {
+		return false
+	}
+
+	res := c.resources[key]
+	if res != nil && !equal(res.vary, vary) {
+		// the upstream changed the Vary header and
+		// the old variants cannot be matched anymore
+		c.removeResource(key, res)
+		res = nil
+	}
+	if res == nil {
+		res = &resource{host: host, path: path, vary: vary, variants: map[string]*list.Element{}}
+		c.resources[key] = res
+	}
+	if el := res.variants[it.variant]; el != nil {
+		c.size -= c.lru.Remove(el).(*item).size
+	}
+	res.variants[it.variant] = c.lru.PushFront(it)
+	c.size += it.size
+	c.evict()
+	return true
+}
+
+// Purge removes the entries whose host matches host and whose path
+// starts with prefix. An empty host matches all hosts. Purge returns
+// the number of removed entries.
+func (c *Cache) Purge(host, prefix string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for key, res := range c.resources {
+		if host != "" && !strings.EqualFold(res.host, host) {
+			continue
+		}
+		if !strings.HasPrefix(res.path, prefix) {
+			continue
+		}
+		n += len(res.variants)
+		c.removeResource(key, res)
+	}
+	return n
+}
+
+// SetMaxSize changes the maximum size of all entries and of a single
+// entry and evicts the least recently used entries if necessary.
+func (c *Cache) SetMaxSize(maxSize, maxEntrySize int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxSize, c.maxEntrySize = maxSize, maxEntrySize
+	c.evict()
+}
+
+// Stats returns the statistics of the cache.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{Entries: c.lru.Len(), Size: c.size, MaxSize: c.maxSize}
+}
+
+// acquire returns nil if there is no other request in flight for the
+// key. The caller must then call release when it is done. Otherwise,
+// acquire returns a channel which is closed when the other request
+// is done.
+func (c *Cache) acquire(key string) <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ch := c.inflight[key]; ch != nil {
+		return ch
+	}
+	c.inflight[key] = make(chan struct{})
+	return nil
+}
+
+func (c *Cache) release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	close(c.inflight[key])
+	delete(c.inflight, key)
+}
+
+func (c *Cache) evict() {
+	for c.size > c.maxSize {
+		el := c.lru.Back()
+		if el == nil {
+			return
+		}
+		c.remove(el)
+	}
+}
+
+// remove removes the element from the list and from its resource.
+func (c *Cache) remove(el *list.Element) {
+	it := c.lru.Remove(el).(*item)
+	c.size -= it.size
+	if res := c.resources[it.key]; res != nil && res.variants[it.variant] == el {
+		delete(res.variants, it.variant)
+		if len(res.variants) == 0 {
+			delete(c.resources, it.key)
+		}
+	}
+}
+
+func (c *Cache) removeResource(key string, res *resource) {
+	for _, el := range res.variants {
+		it := c.lru.Remove(el).(*item)
+		c.size -= it.size
+	}
+	delete(c.resources, key)
+}
+
+// varyHeaders returns the canonical names of the
+// headers in the Vary header of the response.
+func varyHeaders(h http.Header) []string {
+	var names []string
+	for _, v := range h["Vary"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
+
+// variant returns the key of the variant which
+// consists of the values of the vary headers.
+func variant(vary []string, h http.Header) string {
+	var b strings.Builder
+	for _, name := range vary {
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(strings.Join(h[name], ","))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+)
+
+func entry(body string, hdr ...string) *Entry {
+	h := http.Header{}
+	for i := 0; i < len(hdr); i += 2 {
+		h.Add(hdr[i], hdr[i+1])
+	}
+	return &Entry{Status: 200, Header: h, Body: []byte(body)}
+}
+
+func TestCacheLRU(t *testing.T) {
+	// each entry uses 10 bytes for the body and 3 bytes for the key
+	c := New(30, 20)
+	c.Put("/a?", "", "/a", nil, entry("aaaaaaaaaa"))
+	c.Put("/b?", "", "/b", nil, entry("bbbbbbbbbb"))
+
+	// use /a so that /b is evicted
+	if c.Get("/a?", nil) == nil {
+		t.Fatal("/a not cached")
+	}
+	c.Put("/c?", "", "/c", nil, entry("cccccccccc"))
+
+	if c.Get("/b?", nil) != nil {
+		t.Fatal("/b not evicted")
+	}
+	if c.Get("/a?", nil) == nil || c.Get("/c?", nil) == nil {
+		t.Fatal("/a or /c evicted")
+	}
+	if got, want := c.Stats(), (Stats{Entries: 2, Size: 26, MaxSize: 30}); got != want {
+		t.Fatalf("got %+v want %+v", got, want)
+	}
+
+	if c.Put("/d?", "", "/d", nil, entry("dddddddddddddddddddd")) {
+		t.Fatal("entry larger than max entry size stored")
+	}
+
+	c.SetMaxSize(13, 20)
+	if got, want := c.Stats().Entries, 1; got != want {
+		t.Fatalf("got %d entries want %d", got, want)
+	}
+	if c.Get("/c?", nil) == nil {
+		t.Fatal("most recently used entry evicted")
+	}
+}
+
+func TestCacheVary(t *testing.T) {
+	c := New(1<<20, 1<<20)
+	gz := http.Header{"Accept-Encoding": {"gzip"}}
+	br := http.Header{"Accept-Encoding": {"br"}}
+
+	c.Put("/a?", "", "/a", gz, entry("gzip", "Vary", "accept-encoding"))
+	c.Put("/a?", "", "/a", br, entry("br", "Vary", "accept-encoding"))
+	if e := c.Get("/a?", gz); e == nil || string(e.Body) != "gzip" {
+		t.Fatalf("got %v want gzip variant", e)
+	}
+	if e := c.Get("/a?", br); e == nil || string(e.Body) != "br" {
+		t.Fatalf("got %v want br variant", e)
+	}
+	if e := c.Get("/a?", http.Header{}); e != nil {
+		t.Fatalf("got %v want no variant", e)
+	}
+
+	// a different Vary header replaces all variants
+	c.Put("/a?", "", "/a", gz, entry("plain"))
+	if got, want := c.Stats().Entries, 1; got != want {
+		t.Fatalf("got %d entries want %d", got, want)
+	}
+	if e := c.Get("/a?", br); e == nil || string(e.Body) != "plain" {
+		t.Fatalf("got %v want plain", e)
+	}
+}
+
+func TestCachePurge(t *testing.T) {
+	c := New(1<<20, 1<<20)
+	c.Put("a.com/static/a.css?", "a.com", "/static/a.css", nil, entry("a"))
+	c.Put("a.com/api?", "a.com", "/api", nil, entry("b"))
+	c.Put("b.com/static/a.css?", "b.com", "/static/a.css", nil, entry("c"))
+
+	if got, want := c.Purge("A.com", "/static/"), 1; got != want {
+		t.Fatalf("got %d want %d", got, want)
+	}
+	if got, want := c.Purge("", "/static/"), 1; got != want {
+		t.Fatalf("got %d want %d", got, want)
+	}
+	if got, want := c.Purge("", ""), 1; got != want {
+		t.Fatalf("got %d want %d", got, want)
+	}
+	if got, want := c.Stats(), (Stats{MaxSize: 1 << 20}); got != want {
+		t.Fatalf("got %+v want %+v", got, want)
+	}
+}
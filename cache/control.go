@@ -0,0 +1,155 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheableStatus contains the status codes of
+// the responses which can be stored in the cache.
+var cacheableStatus = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// cacheControl contains the directives of a Cache-Control header.
+// Directives without a value have an empty value.
+type cacheControl map[string]string
+
+func parseCacheControl(h http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, v := range h["Cache-Control"] {
+		for _, d := range strings.Split(v, ",") {
+			d = strings.TrimSpace(d)
+			if d == "" {
+				continue
+			}
+			name, val := d, ""
+			if i := strings.IndexByte(d, '='); i >= 0 {
+				name, val = d[:i], strings.Trim(d[i+1:], `"`)
+			}
+			cc[strings.ToLower(name)] = val
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds returns the value of a delta-seconds directive.
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	v, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, true
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// isPublic returns true if the response may be stored by a shared
+// cache even if the request was authenticated.
+func isPublic(h http.Header) bool {
+	cc := parseCacheControl(h)
+	return cc.has("public") || cc.has("s-maxage")
+}
+
+// freshness returns the time at which the response was generated
+// and the time at which it becomes stale. The lifetime is taken from
+// the s-maxage and max-age directives and the Expires header in that
+// order. If none of them are present the default ttl is used.
+// freshness returns false if the response must not be stored.
+func freshness(status int, h http.Header, now time.Time, ttl time.Duration) (date, expires time.Time, ok bool) {
+	if !cacheableStatus[status] {
+		return date, expires, false
+	}
+	cc := parseCacheControl(h)
+	if cc.has("no-store") || cc.has("private") {
+		return date, expires, false
+	}
+	if h.Get("Set-Cookie") != "" {
+		return date, expires, false
+	}
+	for _, name := range varyHeaders(h) {
+		if name == "*" {
+			return date, expires, false
+		}
+	}
+
+	// the age of the response when it was received
+	date = now
+	if age, err := strconv.ParseInt(h.Get("Age"), 10, 64); err == nil && age > 0 {
+		date = now.Add(-time.Duration(age) * time.Second)
+	}
+
+	var lifetime time.Duration
+	if d, ok := cc.seconds("s-maxage"); ok {
+		lifetime = d
+	} else if d, ok := cc.seconds("max-age"); ok {
+		lifetime = d
+	} else if v := h.Get("Expires"); v != "" {
+		// an invalid date means that the response is already stale
+		if exp, err := http.ParseTime(v); err == nil {
+			origin := now
+			if d, err := http.ParseTime(h.Get("Date")); err == nil {
+				origin = d
+			}
+			lifetime = exp.Sub(origin)
+		}
+	} else {
+		lifetime = ttl
+	}
+	if cc.has("no-cache") {
+		lifetime = 0
+	}
+	expires = date.Add(lifetime)
+
+	// a stale response is only useful if it can be revalidated
+	if !expires.After(now) && !hasValidator(h) {
+		return date, expires, false
+	}
+	return date, expires, true
+}
+
+func hasValidator(h http.Header) bool {
+	return h.Get("ETag") != "" || h.Get("Last-Modified") != ""
+}
+
+// notModified returns true if the conditional headers of the
+// request match the cached response.
+func notModified(r *http.Request, h http.Header) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := h.Get("ETag")
+		if etag == "" {
+			return false
+		}
+		for _, v := range strings.Split(inm, ",") {
+			v = strings.TrimSpace(v)
+			if v == "*" || weakMatch(v, etag) {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		t, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		lm, err := http.ParseTime(h.Get("Last-Modified"))
+		return err == nil && !lm.After(t)
+	}
+	return false
+}
+
+// weakMatch compares two entity tags with the weak comparison.
+func weakMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
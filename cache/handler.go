@@ -0,0 +1,318 @@
+package cache
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Cache status of a request.
+const (
+	// Hit means that the response was served from the cache.
+	Hit = "HIT"
+
+	// Miss means that the response was fetched from the upstream.
+	Miss = "MISS"
+
+	// Revalidated means that a stale response was served from
+	// the cache after the upstream confirmed that it is unchanged.
+	Revalidated = "REVALIDATED"
+
+	// Bypass means that the request was not eligible for caching.
+	Bypass = "BYPASS"
+)
+
+// Handler serves the responses for GET and HEAD requests from the
+// cache and stores the cacheable responses of the next handler.
+// Concurrent requests for the same url which miss the cache wait for
+// the first request to complete and use its response if it was
+// stored. A Handler must only be used for a single request.
+type Handler struct {
+	// Cache is the response cache. If nil Default is used.
+	Cache *Cache
+
+	// URL is the url of the request before it was modified
+	// for the upstream. It determines the cache key.
+	URL *url.URL
+
+	// TTL is the lifetime of the responses without
+	// Cache-Control or Expires header.
+	TTL time.Duration
+
+	// Next is the handler which fetches the response from the upstream.
+	Next http.Handler
+
+	// Time returns the current time. If nil time.Now is used.
+	Time func() time.Time
+
+	// Authenticated is true if the requests are authenticated by an
+	// auth scheme of the route. Their responses are only stored if
+	// they are explicitly public. See isPublic.
+	Authenticated bool
+
+	// Status is the cache status of the request after ServeHTTP.
+	Status string
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c := h.Cache
+	if c == nil {
+		c = Default
+	}
+	now := h.Time
+	if now == nil {
+		now = time.Now
+	}
+
+	reqCC := parseCacheControl(r.Header)
+	if (r.Method != "GET" && r.Method != "HEAD") || reqCC.has("no-store") ||
+		r.Header.Get("Authorization") != "" || r.Header.Get("Range") != "" {
+		h.Status = Bypass
+		h.Next.ServeHTTP(w, r)
+		return
+	}
+
+	key := h.URL.Host + h.URL.Path + "?" + h.URL.RawQuery
+	revalidate := reqCC.has("no-cache") || reqCC["max-age"] == "0" || r.Header.Get("Pragma") == "no-cache"
+
+	e := c.Get(key, r.Header)
+	if e != nil && e.Fresh(now()) && !revalidate {
+		h.Status = Hit
+		serve(w, r, e, now(), Hit)
+		return
+	}
+
+	// only GET requests store responses and
+	// are coalesced with other requests.
+	if r.Method == "HEAD" {
+		h.Status = Miss
+		h.fetch(c, key, w, r, nil, now)
+		return
+	}
+
+	if wait := c.acquire(key); wait != nil {
+		select {
+		case <-wait:
+		case <-r.Context().Done():
+			return
+		}
+		e = c.Get(key, r.Header)
+		if e != nil && e.Fresh(now()) && !revalidate {
+			h.Status = Hit
+			serve(w, r, e, now(), Hit)
+			return
+		}
+		// fetch without coalescing since the other
+		// request did not produce a usable response.
+	} else {
+		defer c.release(key)
+	}
+
+	if e != nil && hasValidator(e.Header) {
+		h.revalidate(c, key, w, r, e, now)
+		return
+	}
+	h.Status = Miss
+	h.fetch(c, key, w, r, c, now)
+}
+
+// fetch forwards the request to the upstream and stores the response
+// in store unless it is nil.
+func (h *Handler) fetch(c *Cache, key string, w http.ResponseWriter, r *http.Request, store *Cache, now func() time.Time) {
+	rec := &recorder{w: w, max: c.MaxEntrySize(), status: Miss}
+	h.Next.ServeHTTP(rec, r)
+	if store != nil && rec.buffered() {
+		h.store(store, key, r, rec.code, rec.hdr, rec.body, now())
+	}
+}
+
+// revalidate sends a conditional request for the stale entry to the
+// upstream. If the upstream responds with 304 Not Modified the entry
+// is refreshed and served. Otherwise, the response of the upstream is
+// forwarded and stored.
+func (h *Handler) revalidate(c *Cache, key string, w http.ResponseWriter, r *http.Request, e *Entry, now func() time.Time) {
+	// replace the conditional headers of the client with the
+	// validators of the entry. The conditional headers of the
+	// client are evaluated when the entry is served.
+	ifNoneMatch, ifModifiedSince := r.Header["If-None-Match"], r.Header["If-Modified-Since"]
+	r.Header.Del("If-None-Match")
+	r.Header.Del("If-Modified-Since")
+	if v := e.Header.Get("ETag"); v != "" {
+		r.Header.Set("If-None-Match", v)
+	}
+	if v := e.Header.Get("Last-Modified"); v != "" {
+		r.Header.Set("If-Modified-Since", v)
+	}
+
+	rec := &recorder{w: w, max: c.MaxEntrySize(), status: Miss, intercept: http.StatusNotModified}
+	h.Next.ServeHTTP(rec, r)
+
+	restore(r.Header, "If-None-Match", ifNoneMatch)
+	restore(r.Header, "If-Modified-Since", ifModifiedSince)
+
+	if rec.code != http.StatusNotModified {
+		h.Status = Miss
+		if rec.buffered() {
+			h.store(c, key, r, rec.code, rec.hdr, rec.body, now())
+		}
+		return
+	}
+
+	// merge the headers of the 304 response into the entry
+	hdr := cloneHeader(e.Header)
+	for k, v := range rec.hdr {
+		hdr[k] = v
+	}
+	refreshed := &Entry{Status: e.Status, Header: hdr, Body: e.Body}
+	date, expires, ok := freshness(e.Status, hdr, now(), h.TTL)
+	if ok {
+		refreshed.Date, refreshed.Expires = date, expires
+		c.Put(key, h.URL.Host, h.URL.Path, r.Header, refreshed)
+	} else {
+		refreshed.Date, refreshed.Expires = now(), now()
+	}
+	h.Status = Revalidated
+	serve(w, r, refreshed, now(), Revalidated)
+}
+
+func (h *Handler) store(c *Cache, key string, r *http.Request, code int, hdr http.Header, body []byte, now time.Time) {
+	// the response may depend on the identity of the client
+	if (h.Authenticated || r.Header.Get("Cookie") != "") && !isPublic(hdr) {
+		return
+	}
+	date, expires, ok := freshness(code, hdr, now, h.TTL)
+	if !ok {
+		return
+	}
+	e := &Entry{Status: code, Header: hdr, Body: body, Date: date, Expires: expires}
+	c.Put(key, h.URL.Host, h.URL.Path, r.Header, e)
+}
+
+// serve writes the cached response. If the conditional headers of
+// the request match the response a 304 Not Modified is sent.
+func serve(w http.ResponseWriter, r *http.Request, e *Entry, now time.Time, status string) {
+	hdr := w.Header()
+	copyHeader(hdr, e.Header)
+	age := int64(now.Sub(e.Date) / time.Second)
+	if age < 0 {
+		age = 0
+	}
+	hdr.Set("Age", strconv.FormatInt(age, 10))
+	hdr.Set("X-Cache", status)
+
+	if notModified(r, e.Header) {
+		hdr.Del("Content-Length")
+		hdr.Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	hdr.Set("Content-Length", strconv.Itoa(len(e.Body)))
+	w.WriteHeader(e.Status)
+	if r.Method != "HEAD" {
+		w.Write(e.Body)
+	}
+}
+
+// copyHeader copies the header src into dst. The Vary header is
+// merged with the one in dst which was set by other handlers.
+func copyHeader(dst, src http.Header) {
+	for k, v := range src {
+		if k == "Vary" {
+			dst[k] = append(dst[k], v...)
+			continue
+		}
+		dst[k] = append([]string(nil), v...)
+	}
+}
+
+func restore(h http.Header, name string, vals []string) {
+	if vals == nil {
+		h.Del(name)
+		return
+	}
+	h[name] = vals
+}
+
+func cloneHeader(h http.Header) http.Header {
+	h2 := make(http.Header, len(h))
+	for k, v := range h {
+		h2[k] = append([]string(nil), v...)
+	}
+	return h2
+}
+
+// recorder forwards the response to the client and records the status
+// code, the header and the body up to max bytes. The next handler
+// writes the header into a separate map so that the recorded header
+// does not contain the headers which were set by the handlers between
+// the client and the recorder. A response with the intercept status
+// code is recorded but not forwarded.
+type recorder struct {
+	w         http.ResponseWriter
+	max       int64
+	status    string
+	intercept int
+
+	code        int
+	hdr         http.Header
+	body        []byte
+	overflow    bool
+	intercepted bool
+}
+
+func (r *recorder) Header() http.Header {
+	if r.code != 0 && !r.intercepted {
+		return r.w.Header()
+	}
+	if r.hdr == nil {
+		r.hdr = http.Header{}
+	}
+	return r.hdr
+}
+
+func (r *recorder) WriteHeader(code int) {
+	if r.code != 0 {
+		return
+	}
+	r.code = code
+	if r.hdr == nil {
+		r.hdr = http.Header{}
+	}
+	if code == r.intercept {
+		// keep the response away from the client
+		r.intercepted = true
+		return
+	}
+	copyHeader(r.w.Header(), r.hdr)
+	r.w.Header().Set("X-Cache", r.status)
+	r.w.WriteHeader(code)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.code == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	if r.intercepted {
+		return len(b), nil
+	}
+	if !r.overflow {
+		if int64(len(r.body)+len(b)) > r.max {
+			r.overflow, r.body = true, nil
+		} else {
+			r.body = append(r.body, b...)
+		}
+	}
+	return r.w.Write(b)
+}
+
+func (r *recorder) Flush() {
+	if f, ok := r.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// buffered returns true if the complete response was recorded.
+func (r *recorder) buffered() bool {
+	return r.code != 0 && !r.overflow
+}
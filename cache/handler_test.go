@@ -0,0 +1,374 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// upstream counts the requests and responds with the
+// headers and the body from the response function.
+type upstream struct {
+	calls    int32
+	response func(w http.ResponseWriter, r *http.Request)
+}
+
+func (u *upstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&u.calls, 1)
+	u.response(w, r)
+}
+
+func (u *upstream) Calls() int {
+	return int(atomic.LoadInt32(&u.calls))
+}
+
+type testClock struct{ t time.Time }
+
+func (c *testClock) Now() time.Time          { return c.t }
+func (c *testClock) Advance(d time.Duration) { c.t = c.t.Add(d) }
+func newClock() *testClock                   { return &testClock{t: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)} }
+func respond(h ...string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < len(h); i += 2 {
+			w.Header().Set(h[i], h[i+1])
+		}
+		w.Write([]byte("hello"))
+	}
+}
+
+func do(c *Cache, clock *testClock, ttl time.Duration, next http.Handler, r *http.Request) (*httptest.ResponseRecorder, string) {
+	h := &Handler{Cache: c, URL: &url.URL{Host: r.Host, Path: r.URL.Path}, TTL: ttl, Next: next, Time: clock.Now}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec, h.Status
+}
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		desc     string
+		ttl      time.Duration
+		response func(http.ResponseWriter, *http.Request)
+		req      func() *http.Request
+		advance  time.Duration
+		status   []string
+		calls    int
+	}{
+		{
+			desc:     "default ttl",
+			ttl:      time.Minute,
+			response: respond(),
+			advance:  30 * time.Second,
+			status:   []string{Miss, Hit},
+			calls:    1,
+		},
+		{
+			desc:     "default ttl expired",
+			ttl:      time.Minute,
+			response: respond(),
+			advance:  time.Minute,
+			status:   []string{Miss, Miss},
+			calls:    2,
+		},
+		{
+			desc:     "max-age overrides ttl",
+			ttl:      time.Minute,
+			response: respond("Cache-Control", "max-age=10"),
+			advance:  20 * time.Second,
+			status:   []string{Miss, Miss},
+			calls:    2,
+		},
+		{
+			desc:     "s-maxage overrides max-age",
+			response: respond("Cache-Control", "max-age=10, s-maxage=60"),
+			advance:  20 * time.Second,
+			status:   []string{Miss, Hit},
+			calls:    1,
+		},
+		{
+			desc:     "expires",
+			response: respond("Date", "Wed, 01 Jan 2020 00:00:00 GMT", "Expires", "Wed, 01 Jan 2020 00:01:00 GMT"),
+			advance:  30 * time.Second,
+			status:   []string{Miss, Hit},
+			calls:    1,
+		},
+		{
+			desc:     "no-store",
+			ttl:      time.Minute,
+			response: respond("Cache-Control", "no-store"),
+			status:   []string{Miss, Miss},
+			calls:    2,
+		},
+		{
+			desc:     "private",
+			ttl:      time.Minute,
+			response: respond("Cache-Control", "private, max-age=60"),
+			status:   []string{Miss, Miss},
+			calls:    2,
+		},
+		{
+			desc:     "set-cookie",
+			ttl:      time.Minute,
+			response: respond("Set-Cookie", "a=b"),
+			status:   []string{Miss, Miss},
+			calls:    2,
+		},
+		{
+			desc:     "vary star",
+			ttl:      time.Minute,
+			response: respond("Vary", "*"),
+			status:   []string{Miss, Miss},
+			calls:    2,
+		},
+		{
+			desc:     "uncacheable status",
+			ttl:      time.Minute,
+			response: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(500) },
+			status:   []string{Miss, Miss},
+			calls:    2,
+		},
+		{
+			desc:     "post",
+			ttl:      time.Minute,
+			response: respond(),
+			req:      func() *http.Request { return httptest.NewRequest("POST", "http://a.com/", nil) },
+			status:   []string{Bypass, Bypass},
+			calls:    2,
+		},
+		{
+			desc:     "authorization",
+			ttl:      time.Minute,
+			response: respond(),
+			req: func() *http.Request {
+				r := httptest.NewRequest("GET", "http://a.com/", nil)
+				r.Header.Set("Authorization", "Bearer x")
+				return r
+			},
+			status: []string{Bypass, Bypass},
+			calls:  2,
+		},
+		{
+			desc:     "cookie",
+			ttl:      time.Minute,
+			response: respond("Cache-Control", "max-age=60"),
+			req: func() *http.Request {
+				r := httptest.NewRequest("GET", "http://a.com/", nil)
+				r.Header.Set("Cookie", "session=x")
+				return r
+			},
+			status: []string{Miss, Miss},
+			calls:  2,
+		},
+		{
+			desc:     "cookie public",
+			ttl:      time.Minute,
+			response: respond("Cache-Control", "public, max-age=60"),
+			req: func() *http.Request {
+				r := httptest.NewRequest("GET", "http://a.com/", nil)
+				r.Header.Set("Cookie", "session=x")
+				return r
+			},
+			status: []string{Miss, Hit},
+			calls:  1,
+		},
+		{
+			desc:     "cookie s-maxage",
+			ttl:      time.Minute,
+			response: respond("Cache-Control", "s-maxage=60"),
+			req: func() *http.Request {
+				r := httptest.NewRequest("GET", "http://a.com/", nil)
+				r.Header.Set("Cookie", "session=x")
+				return r
+			},
+			status: []string{Miss, Hit},
+			calls:  1,
+		},
+		{
+			desc:     "request no-cache without validator",
+			ttl:      time.Minute,
+			response: respond(),
+			req: func() *http.Request {
+				r := httptest.NewRequest("GET", "http://a.com/", nil)
+				r.Header.Set("Cache-Control", "no-cache")
+				return r
+			},
+			status: []string{Miss, Miss},
+			calls:  2,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt // capture loop var
+		t.Run(tt.desc, func(t *testing.T) {
+			c, clock, up := New(1<<20, 1<<20), newClock(), &upstream{response: tt.response}
+			req := tt.req
+			if req == nil {
+				req = func() *http.Request { return httptest.NewRequest("GET", "http://a.com/", nil) }
+			}
+			for i, want := range tt.status {
+				if i > 0 {
+					clock.Advance(tt.advance)
+				}
+				if _, got := do(c, clock, tt.ttl, up, req()); got != want {
+					t.Fatalf("request %d: got status %s want %s", i+1, got, want)
+				}
+			}
+			if got, want := up.Calls(), tt.calls; got != want {
+				t.Fatalf("got %d upstream calls want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestHandlerAuthenticated(t *testing.T) {
+	tests := []struct {
+		desc   string
+		cc     string
+		status []string
+		calls  int
+	}{
+		{"not public", "max-age=60", []string{Miss, Miss}, 2},
+		{"public", "public, max-age=60", []string{Miss, Hit}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			c, clock, up := New(1<<20, 1<<20), newClock(), &upstream{response: respond("Cache-Control", tt.cc)}
+			for i, want := range tt.status {
+				r := httptest.NewRequest("GET", "http://a.com/", nil)
+				h := &Handler{Cache: c, URL: &url.URL{Host: r.Host, Path: r.URL.Path}, TTL: time.Minute, Next: up, Time: clock.Now, Authenticated: true}
+				h.ServeHTTP(httptest.NewRecorder(), r)
+				if got := h.Status; got != want {
+					t.Fatalf("request %d: got status %s want %s", i+1, got, want)
+				}
+			}
+			if got, want := up.Calls(), tt.calls; got != want {
+				t.Fatalf("got %d upstream calls want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestHandlerHit(t *testing.T) {
+	c, clock, up := New(1<<20, 1<<20), newClock(), &upstream{response: respond("Content-Type", "text/plain", "ETag", `"v1"`)}
+
+	rec, _ := do(c, clock, time.Minute, up, httptest.NewRequest("GET", "http://a.com/", nil))
+	if got, want := rec.Header().Get("X-Cache"), Miss; got != want {
+		t.Fatalf("got X-Cache %q want %q", got, want)
+	}
+
+	clock.Advance(5 * time.Second)
+	rec, _ = do(c, clock, time.Minute, up, httptest.NewRequest("GET", "http://a.com/", nil))
+	if got, want := rec.Body.String(), "hello"; got != want {
+		t.Fatalf("got body %q want %q", got, want)
+	}
+	for k, want := range map[string]string{"X-Cache": Hit, "Age": "5", "Content-Type": "text/plain", "Content-Length": "5"} {
+		if got := rec.Header().Get(k); got != want {
+			t.Fatalf("got %s %q want %q", k, got, want)
+		}
+	}
+
+	// conditional request of the client
+	r := httptest.NewRequest("GET", "http://a.com/", nil)
+	r.Header.Set("If-None-Match", `W/"v1"`)
+	rec, _ = do(c, clock, time.Minute, up, r)
+	if got, want := rec.Code, http.StatusNotModified; got != want {
+		t.Fatalf("got code %d want %d", got, want)
+	}
+
+	// HEAD request is served from the cache
+	rec, status := do(c, clock, time.Minute, up, httptest.NewRequest("HEAD", "http://a.com/", nil))
+	if status != Hit || rec.Body.Len() != 0 {
+		t.Fatalf("got status %s and body %q want HIT and no body", status, rec.Body.String())
+	}
+	if got, want := up.Calls(), 1; got != want {
+		t.Fatalf("got %d upstream calls want %d", got, want)
+	}
+}
+
+func TestHandlerRevalidate(t *testing.T) {
+	c, clock := New(1<<20, 1<<20), newClock()
+	etag := `"v1"`
+	up := &upstream{response: func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=10")
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("body " + etag))
+	}}
+
+	do(c, clock, 0, up, httptest.NewRequest("GET", "http://a.com/", nil))
+
+	// stale and unchanged
+	clock.Advance(20 * time.Second)
+	rec, status := do(c, clock, 0, up, httptest.NewRequest("GET", "http://a.com/", nil))
+	if status != Revalidated || rec.Code != 200 || rec.Body.String() != `body "v1"` {
+		t.Fatalf("got %s %d %q want REVALIDATED 200 %q", status, rec.Code, rec.Body.String(), `body "v1"`)
+	}
+
+	// fresh again after the revalidation
+	clock.Advance(5 * time.Second)
+	if _, status := do(c, clock, 0, up, httptest.NewRequest("GET", "http://a.com/", nil)); status != Hit {
+		t.Fatalf("got %s want HIT", status)
+	}
+
+	// stale and changed
+	clock.Advance(20 * time.Second)
+	etag = `"v2"`
+	rec, status = do(c, clock, 0, up, httptest.NewRequest("GET", "http://a.com/", nil))
+	if status != Miss || rec.Body.String() != `body "v2"` {
+		t.Fatalf("got %s %q want MISS %q", status, rec.Body.String(), `body "v2"`)
+	}
+	if _, status := do(c, clock, 0, up, httptest.NewRequest("GET", "http://a.com/", nil)); status != Hit {
+		t.Fatalf("got %s want HIT", status)
+	}
+	if got, want := up.Calls(), 3; got != want {
+		t.Fatalf("got %d upstream calls want %d", got, want)
+	}
+}
+
+func TestHandlerCoalesce(t *testing.T) {
+	c, clock := New(1<<20, 1<<20), newClock()
+	release := make(chan struct{})
+	up := &upstream{response: func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte("hello"))
+	}}
+
+	const n = 10
+	var wg sync.WaitGroup
+	status := make(chan string, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, s := do(c, clock, time.Minute, up, httptest.NewRequest("GET", "http://a.com/", nil))
+			status <- s
+		}()
+	}
+
+	// wait until the first request is in flight
+	for up.Calls() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(status)
+
+	counts := map[string]int{}
+	for s := range status {
+		counts[s]++
+	}
+	if counts[Miss] != 1 || counts[Hit] != n-1 {
+		t.Fatalf("got %v want 1 MISS and %d HIT", counts, n-1)
+	}
+	if got, want := up.Calls(), 1; got != want {
+		t.Fatalf("got %d upstream calls want %d", got, want)
+	}
+}
@@ -0,0 +1,184 @@
+package cert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+// clientSourceWait is the time ClientSources waits for the first
+// certificates of a newly started source. Afterwards, requests for a
+// source which has not been loaded fail immediately.
+var clientSourceWait = 3 * time.Second
+
+// ClientSources provides TLS configurations for upstream connections
+// which present a client certificate and verify the server certificate
+// with a CA bundle from a named certificate source. The sources are
+// started on first use and reloaded when they are updated. It is safe
+// for concurrent use.
+type ClientSources struct {
+	cfgs map[string]config.CertSource
+
+	mu      sync.Mutex
+	sources map[string]*clientSource
+}
+
+// NewClientSources creates a ClientSources for the given certificate
+// source configurations keyed by name.
+func NewClientSources(cfgs map[string]config.CertSource) *ClientSources {
+	return &ClientSources{
+		cfgs:    cfgs,
+		sources: make(map[string]*clientSource),
+	}
+}
+
+type clientSource struct {
+	name   string
+	gen    uint64
+	cert   atomic.Value // *tls.Certificate
+	pool   atomic.Value // *x509.CertPool
+	loaded chan struct{}
+
+	// deadline is the end of the wait for the first certificates.
+	deadline time.Time
+	timeout  sync.Once
+}
+
+// TLSConfig returns a TLS configuration which presents the first
+// certificate from the source certName and verifies the server
+// certificate with the CA bundle from the source caName. Either name
+// can be empty. The returned generation changes whenever one of the
+// sources is updated so that callers can replace cached connections.
+func (c *ClientSources) TLSConfig(certName, caName string, skipVerify bool) (cfg *tls.Config, gen uint64, err error) {
+	cfg = &tls.Config{InsecureSkipVerify: skipVerify}
+
+	if certName != "" {
+		src, err := c.source(certName)
+		if err != nil {
+			return nil, 0, err
+		}
+		gen += atomic.LoadUint64(&src.gen)
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if cert, ok := src.cert.Load().(*tls.Certificate); ok && cert != nil {
+				return cert, nil
+			}
+			// send no certificate and let the server decide
+			return &tls.Certificate{}, nil
+		}
+	}
+
+	if caName != "" {
+		src, err := c.source(caName)
+		if err != nil {
+			return nil, 0, err
+		}
+		gen += atomic.LoadUint64(&src.gen)
+		pool, _ := src.pool.Load().(*x509.CertPool)
+		if pool == nil {
+			return nil, 0, fmt.Errorf("cert: no CA certificates in cert source %q", caName)
+		}
+		cfg.RootCAs = pool
+	}
+
+	return cfg, gen, nil
+}
+
+// Generation returns the sum of the update counters of the given
+// sources. It returns 0 for sources which have not been started.
+func (c *ClientSources) Generation(names ...string) (gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, name := range names {
+		if src := c.sources[name]; src != nil {
+			gen += atomic.LoadUint64(&src.gen)
+		}
+	}
+	return gen
+}
+
+// source returns the started source with the given name and starts
+// it if necessary.
+func (c *ClientSources) source(name string) (*clientSource, error) {
+	c.mu.Lock()
+	src := c.sources[name]
+	if src == nil {
+		cfg, ok := c.cfgs[name]
+		if !ok {
+			c.mu.Unlock()
+			return nil, fmt.Errorf("cert: unknown cert source %q", name)
+		}
+		s, err := NewSource(cfg)
+		if err != nil {
+			c.mu.Unlock()
+			return nil, err
+		}
+		src = &clientSource{name: name, loaded: make(chan struct{}), deadline: time.Now().Add(clientSourceWait)}
+		c.sources[name] = src
+		go src.watch(s)
+	}
+	c.mu.Unlock()
+
+	if err := src.wait(); err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+// wait waits until the source has been loaded or the wait for the
+// first certificates is over.
+func (s *clientSource) wait() error {
+	select {
+	case <-s.loaded:
+		return nil
+	default:
+	}
+	if d := time.Until(s.deadline); d > 0 {
+		select {
+		case <-s.loaded:
+			return nil
+		case <-time.After(d):
+		}
+	}
+	s.timeout.Do(func() {
+		log.Printf("[WARN] cert: Timeout waiting for client cert source %q", s.name)
+	})
+	return fmt.Errorf("cert: cert source %q not loaded", s.name)
+}
+
+// watch updates the client certificate and the CA bundle whenever
+// the source provides new certificates. The CA bundle is the client
+// CA bundle of the source if configured and the certificates of the
+// source otherwise.
+func (s *clientSource) watch(src Source) {
+	var once sync.Once
+	for certs := range src.Certificates() {
+		pool, err := src.LoadClientCAs()
+		if err != nil {
+			log.Printf("[ERROR] cert: Cannot load CA certificates for cert source %q. %s", s.name, err)
+		}
+		if pool == nil {
+			pool = x509.NewCertPool()
+			for _, cert := range certs {
+				for _, der := range cert.Certificate {
+					if x, err := x509.ParseCertificate(der); err == nil {
+						pool.AddCert(x)
+					}
+				}
+			}
+		}
+		s.pool.Store(pool)
+
+		if len(certs) > 0 {
+			s.cert.Store(&certs[0])
+		}
+		atomic.AddUint64(&s.gen, 1)
+		once.Do(func() { close(s.loaded) })
+		log.Printf("[INFO] cert: Updated client certificates from cert source %q", s.name)
+	}
+}
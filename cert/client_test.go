@@ -0,0 +1,123 @@
+package cert
+
+import (
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+func TestClientSources(t *testing.T) {
+	dir := tempDir()
+	defer os.RemoveAll(dir)
+
+	srvCertPEM, srvKeyPEM := makePEM("localhost", time.Minute)
+	srvCertFile, srvKeyFile := saveCert(dir, "localhost", srvCertPEM, srvKeyPEM)
+	clientCertPEM, clientKeyPEM := makePEM("client", time.Minute)
+	clientCertFile, clientKeyFile := saveCert(dir, "client", clientCertPEM, clientKeyPEM)
+
+	srvCert, err := tls.X509KeyPair(srvCertPEM, srvKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the test certificates are only valid for server auth
+	// so the handler checks the client certificate.
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 || r.TLS.PeerCertificates[0].DNSNames[0] != "client" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("OK"))
+	}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{srvCert},
+		ClientAuth:   tls.RequireAnyClientCert,
+	}
+	srv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+
+	cs := NewClientSources(map[string]config.CertSource{
+		"client": {Name: "client", Type: "file", CertPath: clientCertFile, KeyPath: clientKeyFile},
+		"ca":     {Name: "ca", Type: "file", CertPath: srvCertFile, KeyPath: srvKeyFile},
+	})
+
+	get := func(certName, caName string) error {
+		cfg, _, err := cs.TLSConfig(certName, caName, false)
+		if err != nil {
+			return err
+		}
+		cfg.ServerName = "localhost"
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return errors.New(resp.Status)
+		}
+		return nil
+	}
+
+	t.Run("client cert and ca", func(t *testing.T) {
+		if err := get("client", "ca"); err != nil {
+			t.Fatalf("got %v want nil", err)
+		}
+	})
+
+	t.Run("no client cert", func(t *testing.T) {
+		if err := get("", "ca"); err == nil {
+			t.Fatal("got nil want error")
+		}
+	})
+
+	t.Run("no ca", func(t *testing.T) {
+		if err := get("client", ""); err == nil {
+			t.Fatal("got nil want error")
+		}
+	})
+
+	t.Run("unknown source", func(t *testing.T) {
+		if _, _, err := cs.TLSConfig("foo", "", false); err == nil {
+			t.Fatal("got nil want error")
+		}
+	})
+
+	t.Run("not loaded", func(t *testing.T) {
+		defer func(d time.Duration) { clientSourceWait = d }(clientSourceWait)
+		clientSourceWait = 100 * time.Millisecond
+
+		// the source retries the failing requests and never loads
+		down := httptest.NewServer(http.NotFoundHandler())
+		down.Close()
+		cs := NewClientSources(map[string]config.CertSource{
+			"missing": {Name: "missing", Type: "http", CertPath: down.URL + "/list"},
+		})
+		if _, _, err := cs.TLSConfig("missing", "", false); err == nil {
+			t.Fatal("got nil want error")
+		}
+
+		// fail fast after the first wait
+		start := time.Now()
+		if _, _, err := cs.TLSConfig("missing", "", false); err == nil {
+			t.Fatal("got nil want error")
+		}
+		if d := time.Since(start); d >= clientSourceWait {
+			t.Fatalf("got wait of %s want no wait", d)
+		}
+	})
+
+	t.Run("generation", func(t *testing.T) {
+		if got, want := cs.Generation("client", "ca"), uint64(2); got != want {
+			t.Fatalf("got %d want %d", got, want)
+		}
+	})
+}
@@ -0,0 +1,191 @@
+package cert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/metrics"
+)
+
+// CertInfo describes a certificate held by a certificate store.
+type CertInfo struct {
+	Listener  string
+	Source    string
+	Subject   string
+	DNSNames  []string
+	Issuer    string
+	Serial    string
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// registeredStore is a certificate store with the names of the
+// listener and the certificate source it serves.
+type registeredStore struct {
+	listener string
+	source   string
+	store    *Store
+}
+
+var stores struct {
+	sync.Mutex
+	list []registeredStore
+}
+
+// RegisterStore adds the store to the certificate inventory under
+// the given listener address and certificate source name.
+func RegisterStore(listener, source string, s *Store) {
+	stores.Lock()
+	defer stores.Unlock()
+	stores.list = append(stores.list, registeredStore{listener, source, s})
+}
+
+// Inventory returns the certificates of all registered stores
+// sorted by listener and subject.
+func Inventory() []CertInfo {
+	stores.Lock()
+	list := stores.list
+	stores.Unlock()
+
+	var certs []CertInfo
+	for _, rs := range list {
+		for _, x := range parseLeafs(rs.store.certstore().Certificates) {
+			certs = append(certs, certInfo(rs.listener, rs.source, x))
+		}
+	}
+	sort.SliceStable(certs, func(i, j int) bool {
+		if certs[i].Listener != certs[j].Listener {
+			return certs[i].Listener < certs[j].Listener
+		}
+		return certs[i].Subject < certs[j].Subject
+	})
+	return certs
+}
+
+// certInfo describes the certificate x. Certificates without a
+// common name are named after their first SAN.
+func certInfo(listener, source string, x *x509.Certificate) CertInfo {
+	subject := x.Subject.CommonName
+	if subject == "" && len(x.DNSNames) > 0 {
+		subject = x.DNSNames[0]
+	}
+	return CertInfo{
+		Listener:  listener,
+		Source:    source,
+		Subject:   subject,
+		DNSNames:  x.DNSNames,
+		Issuer:    x.Issuer.CommonName,
+		Serial:    hex.EncodeToString(x.SerialNumber.Bytes()),
+		NotBefore: x.NotBefore,
+		NotAfter:  x.NotAfter,
+	}
+}
+
+func parseLeafs(certs []tls.Certificate) []*x509.Certificate {
+	var leafs []*x509.Certificate
+	for _, c := range certs {
+		if len(c.Certificate) == 0 {
+			continue
+		}
+		x, err := x509.ParseCertificate(c.Certificate[0])
+		if err != nil {
+			continue
+		}
+		leafs = append(leafs, x)
+	}
+	return leafs
+}
+
+// DefaultExpiryMonitor checks the certificates of all stores when
+// they are updated. Expiry monitoring is disabled if it is nil.
+var DefaultExpiryMonitor *ExpiryMonitor
+
+// ExpiryMonitor reports the number of days until the certificates
+// in the inventory expire as metrics and logs a warning for
+// certificates which expire within the threshold.
+type ExpiryMonitor struct {
+	// Threshold is the remaining validity below which a
+	// warning is logged. Warnings are disabled if it is zero.
+	Threshold time.Duration
+
+	// Registry is the metrics registry for the expiry gauges.
+	Registry metrics.Registry
+
+	mu    sync.Mutex
+	names map[string]bool
+}
+
+// Run checks the certificates in the inventory every interval
+// until stop is closed.
+func (m *ExpiryMonitor) Run(interval time.Duration, stop <-chan struct{}) {
+	for {
+		m.CheckAll(time.Now())
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// CheckAll updates the expiry metrics for all certificates in the
+// inventory and removes the metrics of certificates which are no
+// longer served.
+func (m *ExpiryMonitor) CheckAll(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := map[string]bool{}
+	for _, c := range Inventory() {
+		name := ExpiryMetricName(c)
+		if names[name] {
+			continue
+		}
+		names[name] = true
+		m.check(name, c, now)
+	}
+	for name := range m.names {
+		if !names[name] {
+			m.Registry.Unregister(name)
+		}
+	}
+	m.names = names
+}
+
+// Check updates the expiry metrics for the given certificates.
+func (m *ExpiryMonitor) Check(certs []tls.Certificate, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.names == nil {
+		m.names = map[string]bool{}
+	}
+	for _, x := range parseLeafs(certs) {
+		c := certInfo("", "", x)
+		name := ExpiryMetricName(c)
+		m.names[name] = true
+		m.check(name, c, now)
+	}
+}
+
+func (m *ExpiryMonitor) check(name string, c CertInfo, now time.Time) {
+	left := c.NotAfter.Sub(now)
+	metrics.LabeledGauge(m.Registry, name, "cert_days_until_expiry", "subject", c.Subject, "serial", c.Serial).Update(left.Hours() / 24)
+	switch {
+	case left <= 0:
+		log.Printf("[WARN] cert: Certificate %q with serial %s has expired on %s", c.Subject, c.Serial, c.NotAfter.Format(time.RFC3339))
+	case m.Threshold > 0 && left < m.Threshold:
+		log.Printf("[WARN] cert: Certificate %q with serial %s expires in %d days on %s", c.Subject, c.Serial, int(left.Hours()/24), c.NotAfter.Format(time.RFC3339))
+	}
+}
+
+// ExpiryMetricName returns the name of the gauge which reports the
+// number of days until the certificate expires.
+func ExpiryMetricName(c CertInfo) string {
+	return "cert." + metrics.Clean(c.Subject) + "." + c.Serial + ".days_until_expiry"
+}
@@ -0,0 +1,96 @@
+package cert
+
+import (
+	"crypto/tls"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/metrics"
+)
+
+type gaugeRegistry struct {
+	metrics.NoopRegistry
+	mu     sync.Mutex
+	gauges map[string]float64
+}
+
+func (r *gaugeRegistry) Names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var names []string
+	for name := range r.gauges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *gaugeRegistry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.gauges, name)
+}
+
+func (r *gaugeRegistry) GetGauge(name string) metrics.Gauge {
+	return gaugeFunc(func(v float64) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.gauges[name] = v
+	})
+}
+
+type gaugeFunc func(float64)
+
+func (f gaugeFunc) Update(v float64) { f(v) }
+
+func TestInventory(t *testing.T) {
+	defer func(list []registeredStore) { stores.list = list }(stores.list)
+	stores.list = nil
+
+	a, b := NewStore(), NewStore()
+	a.SetCertificates([]tls.Certificate{makeCert("b.com", time.Hour), makeCert("a.com", time.Hour)})
+	b.SetCertificates([]tls.Certificate{makeCert("c.com", time.Hour)})
+	RegisterStore(":9999", "src2", b)
+	RegisterStore(":443", "src1", a)
+
+	var got []string
+	for _, c := range Inventory() {
+		got = append(got, c.Listener+" "+c.Source+" "+c.Subject)
+	}
+	want := []string{":443 src1 a.com", ":443 src1 b.com", ":9999 src2 c.com"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestExpiryMonitor(t *testing.T) {
+	defer func(list []registeredStore) { stores.list = list }(stores.list)
+	stores.list = nil
+
+	s := NewStore()
+	s.SetCertificates([]tls.Certificate{makeCert("a.com", 48*time.Hour)})
+	RegisterStore(":443", "src", s)
+	c := Inventory()[0]
+
+	r := &gaugeRegistry{gauges: map[string]float64{}}
+	m := &ExpiryMonitor{Threshold: 72 * time.Hour, Registry: r}
+	m.CheckAll(time.Now())
+
+	name := ExpiryMetricName(c)
+	if got, want := r.Names(), []string{name}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if got := r.gauges[name]; got < 1.9 || got > 2 {
+		t.Fatalf("got %v days want 2", got)
+	}
+
+	// replacing the certificate removes the old gauge
+	s.SetCertificates([]tls.Certificate{makeCert("b.com", 24*time.Hour)})
+	m.CheckAll(time.Now())
+	if got, want := r.Names(), []string{ExpiryMetricName(Inventory()[0])}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
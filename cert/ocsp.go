@@ -0,0 +1,368 @@
+package cert
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"golang.org/x/crypto/ocsp"
+)
+
+// DefaultStapler is the OCSP stapler used by the certificate stores
+// created by TLSConfig. OCSP stapling is disabled if it is nil.
+var DefaultStapler *OCSPStapler
+
+// ErrCertRevoked is returned by the certificate store when the OCSP
+// responder reports the selected certificate as revoked and the
+// stapler is configured to refuse revoked certificates.
+var ErrCertRevoked = errors.New("cert: certificate has been revoked")
+
+// minOCSPRefresh is the lower bound for the refresh interval of a
+// valid OCSP response to avoid hammering the responder.
+var minOCSPRefresh = time.Minute
+
+// OCSPStatus describes the OCSP state of a single certificate.
+type OCSPStatus struct {
+	Subject    string
+	Serial     string
+	Responder  string
+	Status     string
+	ThisUpdate time.Time
+	NextUpdate time.Time
+	RevokedAt  time.Time
+	LastFetch  time.Time
+	Error      string
+}
+
+// OCSPStapler fetches and caches OCSP responses for certificates
+// which provide an OCSP responder URL and refreshes them in the
+// background before they expire. It is safe for concurrent use.
+type OCSPStapler struct {
+	// RefuseRevoked causes the stapler to return ErrCertRevoked
+	// for certificates which have been revoked.
+	RefuseRevoked bool
+
+	// Timeout is the timeout for a single OCSP request.
+	Timeout time.Duration
+
+	// Retry is the interval after which a failed OCSP request
+	// is retried.
+	Retry time.Duration
+
+	client *http.Client
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]*ocspEntry
+}
+
+// NewOCSPStapler creates a new OCSP stapler from the configuration.
+func NewOCSPStapler(cfg config.OCSP) *OCSPStapler {
+	return &OCSPStapler{
+		RefuseRevoked: cfg.RefuseRevoked,
+		Timeout:       cfg.Timeout,
+		Retry:         cfg.Retry,
+		client:        &http.Client{Timeout: cfg.Timeout},
+		entries:       make(map[[sha256.Size]byte]*ocspEntry),
+	}
+}
+
+type ocspEntry struct {
+	leaf      *x509.Certificate
+	issuer    *x509.Certificate
+	responder string
+	refs      int
+	stop      chan struct{}
+
+	mu        sync.RWMutex
+	raw       []byte
+	resp      *ocsp.Response
+	lastFetch time.Time
+	err       error
+}
+
+// Track starts fetching OCSP responses for the given certificates.
+// Certificates without an issuer certificate in the chain or without
+// an OCSP responder URL are ignored. Every call to Track must be
+// matched by a call to Release with the same certificates.
+func (s *OCSPStapler) Track(certs []tls.Certificate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range certs {
+		key, ok := ocspKey(&certs[i])
+		if !ok {
+			continue
+		}
+		if e := s.entries[key]; e != nil {
+			e.refs++
+			continue
+		}
+		e, err := newOCSPEntry(&certs[i])
+		if err != nil {
+			log.Printf("[DEBUG] cert: Skipping OCSP stapling. %s", err)
+			continue
+		}
+		s.entries[key] = e
+		go s.refresh(e)
+	}
+}
+
+// TrackIssued starts fetching OCSP responses for a certificate which
+// was issued on demand unless it is already tracked. Issued
+// certificates are not released by a store and are removed once they
+// have expired.
+func (s *OCSPStapler) TrackIssued(cert *tls.Certificate) {
+	key, ok := ocspKey(cert)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.entries[key] != nil {
+		return
+	}
+	e, err := newOCSPEntry(cert)
+	if err != nil {
+		log.Printf("[DEBUG] cert: Skipping OCSP stapling. %s", err)
+		return
+	}
+	s.entries[key] = e
+	go s.refresh(e)
+}
+
+// Release stops fetching OCSP responses for the given certificates
+// once they are no longer used by any store.
+func (s *OCSPStapler) Release(certs []tls.Certificate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range certs {
+		key, ok := ocspKey(&certs[i])
+		if !ok {
+			continue
+		}
+		e := s.entries[key]
+		if e == nil {
+			continue
+		}
+		if e.refs--; e.refs <= 0 {
+			close(e.stop)
+			delete(s.entries, key)
+		}
+	}
+}
+
+// Staple returns a copy of the certificate with the current OCSP
+// response attached. If no valid response is available the original
+// certificate is returned. If the certificate has been revoked and
+// RefuseRevoked is set then ErrCertRevoked is returned.
+func (s *OCSPStapler) Staple(cert *tls.Certificate) (*tls.Certificate, error) {
+	key, ok := ocspKey(cert)
+	if !ok {
+		return cert, nil
+	}
+	s.mu.Lock()
+	e := s.entries[key]
+	s.mu.Unlock()
+	if e == nil {
+		return cert, nil
+	}
+
+	e.mu.RLock()
+	raw, resp := e.raw, e.resp
+	e.mu.RUnlock()
+
+	if resp == nil {
+		return cert, nil
+	}
+	if resp.Status == ocsp.Revoked && s.RefuseRevoked {
+		return nil, ErrCertRevoked
+	}
+	if resp.Status == ocsp.Unknown || (!resp.NextUpdate.IsZero() && time.Now().After(resp.NextUpdate)) {
+		return cert, nil
+	}
+	c := *cert
+	c.OCSPStaple = raw
+	return &c, nil
+}
+
+// Status returns the OCSP state of all tracked certificates sorted
+// by subject.
+func (s *OCSPStapler) Status() []OCSPStatus {
+	s.mu.Lock()
+	var entries []*ocspEntry
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	s.mu.Unlock()
+
+	var status []OCSPStatus
+	for _, e := range entries {
+		e.mu.RLock()
+		st := OCSPStatus{
+			Subject:   e.leaf.Subject.CommonName,
+			Serial:    e.leaf.SerialNumber.String(),
+			Responder: e.responder,
+			Status:    "pending",
+			LastFetch: e.lastFetch,
+		}
+		if e.resp != nil {
+			st.Status = ocspStatusName(e.resp.Status)
+			st.ThisUpdate = e.resp.ThisUpdate
+			st.NextUpdate = e.resp.NextUpdate
+			st.RevokedAt = e.resp.RevokedAt
+		}
+		if e.err != nil {
+			if e.resp == nil {
+				st.Status = "error"
+			}
+			st.Error = e.err.Error()
+		}
+		e.mu.RUnlock()
+		status = append(status, st)
+	}
+	sort.Slice(status, func(i, j int) bool {
+		if status[i].Subject != status[j].Subject {
+			return status[i].Subject < status[j].Subject
+		}
+		return status[i].Serial < status[j].Serial
+	})
+	return status
+}
+
+// refresh fetches the OCSP response for the entry until the entry
+// is released or the certificate has expired.
+func (s *OCSPStapler) refresh(e *ocspEntry) {
+	for {
+		if time.Now().After(e.leaf.NotAfter) {
+			s.remove(e)
+			return
+		}
+
+		next := s.Retry
+		raw, resp, err := s.fetch(e)
+
+		e.mu.Lock()
+		e.lastFetch = time.Now()
+		e.err = err
+		if err == nil {
+			e.raw, e.resp = raw, resp
+		}
+		e.mu.Unlock()
+
+		switch {
+		case err != nil:
+			log.Printf("[WARN] cert: OCSP request for %q to %s failed. %s", e.leaf.Subject.CommonName, e.responder, err)
+		default:
+			if resp.Status == ocsp.Revoked {
+				log.Printf("[WARN] cert: Certificate %q has been revoked at %s", e.leaf.Subject.CommonName, resp.RevokedAt)
+			}
+			next = ocspRefreshInterval(resp, time.Now())
+		}
+
+		select {
+		case <-e.stop:
+			return
+		case <-time.After(next):
+		}
+	}
+}
+
+// remove deletes the entry unless it has been replaced.
+func (s *OCSPStapler) remove(e *ocspEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := sha256.Sum256(e.leaf.Raw)
+	if s.entries[key] == e {
+		delete(s.entries, key)
+	}
+}
+
+func (s *OCSPStapler) fetch(e *ocspEntry) ([]byte, *ocsp.Response, error) {
+	req, err := ocsp.CreateRequest(e.leaf, e.issuer, &ocsp.RequestOptions{Hash: crypto.SHA1})
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := s.client.Post(e.responder, "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	raw, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxSize))
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := ocsp.ParseResponseForCert(raw, e.leaf, e.issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+	return raw, r, nil
+}
+
+// ocspRefreshInterval returns the time until the next refresh of a
+// response which is half way between now and the next update.
+func ocspRefreshInterval(resp *ocsp.Response, now time.Time) time.Duration {
+	if resp.NextUpdate.IsZero() {
+		return time.Hour
+	}
+	d := resp.NextUpdate.Sub(now) / 2
+	if d < minOCSPRefresh {
+		d = minOCSPRefresh
+	}
+	return d
+}
+
+func newOCSPEntry(cert *tls.Certificate) (*ocspEntry, error) {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(leaf.OCSPServer) == 0 {
+		return nil, fmt.Errorf("certificate %q has no OCSP responder", leaf.Subject.CommonName)
+	}
+	if len(cert.Certificate) < 2 {
+		return nil, fmt.Errorf("certificate %q has no issuer certificate", leaf.Subject.CommonName)
+	}
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return nil, err
+	}
+	return &ocspEntry{
+		leaf:      leaf,
+		issuer:    issuer,
+		responder: leaf.OCSPServer[0],
+		refs:      1,
+		stop:      make(chan struct{}),
+	}, nil
+}
+
+func ocspKey(cert *tls.Certificate) (key [sha256.Size]byte, ok bool) {
+	if len(cert.Certificate) == 0 {
+		return key, false
+	}
+	return sha256.Sum256(cert.Certificate[0]), true
+}
+
+func ocspStatusName(status int) string {
+	switch status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	default:
+		return "unknown"
+	}
+}
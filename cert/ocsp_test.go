@@ -0,0 +1,208 @@
+package cert
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"golang.org/x/crypto/ocsp"
+)
+
+// makeOCSPCert creates a CA and a leaf certificate signed by the CA
+// which points to an OCSP responder that reports the given status.
+func makeOCSPCert(t *testing.T, status int) (tls.Certificate, *httptest.Server) {
+	t.Helper()
+
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leafKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var leaf *x509.Certificate
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if _, err := ocsp.ParseRequest(body); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		resp, err := ocsp.CreateResponse(ca, ca, ocsp.Response{
+			Status:       status,
+			SerialNumber: leaf.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+			RevokedAt:    time.Now().Add(-time.Minute),
+		}, caKey)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(resp)
+	}))
+
+	leafTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		OCSPServer:   []string{srv.URL},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTmpl, ca, &leafKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf, err = x509.ParseCertificate(leafDER); err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{leafDER, caDER},
+		PrivateKey:  leafKey,
+	}, srv
+}
+
+func newTestStapler(refuseRevoked bool) *OCSPStapler {
+	return NewOCSPStapler(config.OCSP{
+		Stapling:      true,
+		RefuseRevoked: refuseRevoked,
+		Timeout:       time.Second,
+		Retry:         time.Second,
+	})
+}
+
+func TestOCSPStapler(t *testing.T) {
+	t.Run("good", func(t *testing.T) {
+		c, srv := makeOCSPCert(t, ocsp.Good)
+		defer srv.Close()
+
+		s := newTestStapler(true)
+		s.Track([]tls.Certificate{c})
+		defer s.Release([]tls.Certificate{c})
+
+		if !waitFor(time.Second, func() bool {
+			st := s.Status()
+			return len(st) == 1 && st[0].Status == "good"
+		}) {
+			t.Fatalf("got %v want status good", s.Status())
+		}
+
+		got, err := s.Staple(&c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.OCSPStaple) == 0 {
+			t.Fatal("OCSP response not stapled")
+		}
+		if len(c.OCSPStaple) != 0 {
+			t.Fatal("original certificate modified")
+		}
+	})
+
+	t.Run("revoked", func(t *testing.T) {
+		c, srv := makeOCSPCert(t, ocsp.Revoked)
+		defer srv.Close()
+
+		s := newTestStapler(true)
+		s.Track([]tls.Certificate{c})
+		defer s.Release([]tls.Certificate{c})
+
+		if !waitFor(time.Second, func() bool {
+			st := s.Status()
+			return len(st) == 1 && st[0].Status == "revoked"
+		}) {
+			t.Fatalf("got %v want status revoked", s.Status())
+		}
+
+		if _, err := s.Staple(&c); err != ErrCertRevoked {
+			t.Fatalf("got %v want %v", err, ErrCertRevoked)
+		}
+	})
+
+	t.Run("release", func(t *testing.T) {
+		c, srv := makeOCSPCert(t, ocsp.Good)
+		defer srv.Close()
+
+		s := newTestStapler(false)
+		s.Track([]tls.Certificate{c})
+		s.Track([]tls.Certificate{c})
+		s.Release([]tls.Certificate{c})
+		if got, want := len(s.Status()), 1; got != want {
+			t.Fatalf("got %d entries want %d", got, want)
+		}
+		s.Release([]tls.Certificate{c})
+		if got, want := len(s.Status()), 0; got != want {
+			t.Fatalf("got %d entries want %d", got, want)
+		}
+	})
+
+	t.Run("issued", func(t *testing.T) {
+		c, srv := makeOCSPCert(t, ocsp.Good)
+		defer srv.Close()
+
+		s := newTestStapler(false)
+		s.TrackIssued(&c)
+		s.TrackIssued(&c)
+		if got, want := len(s.Status()), 1; got != want {
+			t.Fatalf("got %d entries want %d", got, want)
+		}
+
+		if !waitFor(time.Second, func() bool {
+			got, err := s.Staple(&c)
+			return err == nil && len(got.OCSPStaple) > 0
+		}) {
+			t.Fatal("OCSP response not stapled")
+		}
+		s.Release([]tls.Certificate{c})
+	})
+
+	t.Run("no responder", func(t *testing.T) {
+		c := makeCert("example.com", time.Hour)
+
+		s := newTestStapler(false)
+		s.Track([]tls.Certificate{c})
+		if got, want := len(s.Status()), 0; got != want {
+			t.Fatalf("got %d entries want %d", got, want)
+		}
+		got, err := s.Staple(&c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != &c {
+			t.Fatal("got a copy of the certificate want the original")
+		}
+	})
+}
@@ -92,14 +92,23 @@ func NewSource(cfg config.CertSource) (Source, error) {
 //
 // It also sets the ClientCAs field if src.LoadClientCAs returns a non-nil
 // value and sets ClientAuth to RequireAndVerifyClientCert.
+//
+// If DefaultStapler is set the certificates from the store are
+// served with a stapled OCSP response.
 func TLSConfig(src Source, strictMatch bool, minVersion, maxVersion uint16, cipherSuites []uint16) (*tls.Config, error) {
+	return NewStore().TLSConfig(src, strictMatch, minVersion, maxVersion, cipherSuites)
+}
+
+// TLSConfig works like the TLSConfig function but uses the given
+// store instead of creating a new one.
+func (store *Store) TLSConfig(src Source, strictMatch bool, minVersion, maxVersion uint16, cipherSuites []uint16) (*tls.Config, error) {
 	clientCAs, err := src.LoadClientCAs()
 	if err != nil {
 		return nil, err
 	}
 
 	sf := &singleflight.Group{}
-	store := NewStore()
+	store.stapler = DefaultStapler
 	x := &tls.Config{
 		MinVersion:   minVersion,
 		MaxVersion:   maxVersion,
@@ -108,6 +117,9 @@ func TLSConfig(src Source, strictMatch bool, minVersion, maxVersion uint16, ciph
 		GetCertificate: func(clientHello *tls.ClientHelloInfo) (cert *tls.Certificate, err error) {
 			cert, err = getCertificate(store.certstore(), clientHello, strictMatch)
 			if cert != nil {
+				if store.stapler != nil {
+					return store.stapler.Staple(cert)
+				}
 				return
 			}
 
@@ -132,7 +144,12 @@ func TLSConfig(src Source, strictMatch bool, minVersion, maxVersion uint16, ciph
 				return cert, err
 			}
 
-			return x.(*tls.Certificate), nil
+			cert = x.(*tls.Certificate)
+			if store.stapler != nil {
+				store.stapler.TrackIssued(cert)
+				return store.stapler.Staple(cert)
+			}
+			return cert, nil
 		},
 	}
 
@@ -7,12 +7,17 @@ import (
 	"log"
 	"strings"
 	"sync/atomic"
+	"time"
 )
 
 // Store provides a dynamic certificate store which can be updated at
 // runtime and is safe for concurrent use.
 type Store struct {
 	cs atomic.Value
+
+	// stapler fetches OCSP responses for the stored certificates
+	// if it is not nil.
+	stapler *OCSPStapler
 }
 
 // NewStore creates an empty certificate store.
@@ -26,7 +31,14 @@ func NewStore() *Store {
 func (s *Store) SetCertificates(certs []tls.Certificate) {
 	cs := certstore{Certificates: certs}
 	cs.BuildNameToCertificate()
+	if s.stapler != nil {
+		s.stapler.Track(certs)
+		s.stapler.Release(s.certstore().Certificates)
+	}
 	s.cs.Store(cs)
+	if m := DefaultExpiryMonitor; m != nil {
+		m.Check(certs, time.Now())
+	}
 	var names []string
 	for name := range cs.NameToCertificate {
 		names = append(names, name)
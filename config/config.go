@@ -20,6 +20,16 @@ type Config struct {
 	Insecure             bool
 	GlobMatchingDisabled bool
 	GlobCacheSize        int
+	Explain              Explain
+}
+
+// Explain configures the offline explanation of the
+// route lookup for a single request.
+type Explain struct {
+	URL        string
+	Routes     string
+	Header     []string
+	RemoteAddr string
 }
 
 type CertSource struct {
@@ -48,6 +58,7 @@ type Listen struct {
 	ProxyProto         bool
 	ProxyHeaderTimeout time.Duration
 	Refresh            time.Duration
+	ClientAuth         string
 }
 
 type UI struct {
@@ -73,9 +84,52 @@ type Proxy struct {
 	TLSHeader             string
 	TLSHeaderValue        string
 	GZIPContentTypes      *regexp.Regexp
+	Compress              Compress
+	Cache                 Cache
+	WebSocket             WebSocket
 	RequestID             string
 	STSHeader             STSHeader
+	ClientCertHeader      ClientCertHeader
 	AuthSchemes           map[string]AuthScheme
+	CertSources           map[string]CertSource
+	OCSP                  OCSP
+	CertExpiryWarning     time.Duration
+	AccessRules           AccessRules
+}
+
+type Compress struct {
+	Encodings []string
+	MinSize   int
+	Level     int
+}
+
+type Cache struct {
+	Size         int
+	MaxEntrySize int
+}
+
+type WebSocket struct {
+	IdleTimeout  time.Duration
+	MaxLifetime  time.Duration
+	MaxFrameSize int
+}
+
+type AccessRules struct {
+	IPGroups       map[string][]string
+	TrustedProxies []string
+	GeoIPDB        string
+}
+
+type OCSP struct {
+	Stapling      bool
+	RefuseRevoked bool
+	Timeout       time.Duration
+	Retry         time.Duration
+}
+
+type ClientCertHeader struct {
+	Name   string
+	Fields []string
 }
 
 type STSHeader struct {
@@ -101,10 +155,28 @@ type Circonus struct {
 type Log struct {
 	AccessFormat string
 	AccessTarget string
+	AccessSample string
+	AccessBuffer int
+	AccessSyslog AccessSyslog
+	AccessFile   AccessFile
 	RoutesFormat string
 	Level        string
 }
 
+type AccessSyslog struct {
+	Addr     string
+	Facility string
+	Tag      string
+}
+
+type AccessFile struct {
+	Path       string
+	MaxSize    int
+	Interval   time.Duration
+	MaxBackups int
+	Compress   bool
+}
+
 type Metrics struct {
 	Target       string
 	Prefix       string
@@ -115,6 +187,21 @@ type Metrics struct {
 	GraphiteAddr string
 	StatsDAddr   string
 	Circonus     Circonus
+	Prometheus   Prometheus
+	OTLP         OTLP
+}
+
+type Prometheus struct {
+	Path    string
+	Addr    string
+	Buckets []float64
+}
+
+type OTLP struct {
+	Addr        string
+	Protocol    string
+	Headers     map[string]string
+	Temporality string
 }
 
 type Registry struct {
@@ -174,18 +261,21 @@ type Tracing struct {
 	TracingEnabled bool
 	CollectorType  string
 	ConnectString  string
+	Headers        map[string]string
 	ServiceName    string
-	Topic          string
+	Sampler        string
 	SamplerRate    float64
-	SpanHost       string
 	SpanName       string
-	TraceID128Bit  bool
 }
 
 type AuthScheme struct {
-	Name  string
-	Type  string
-	Basic BasicAuth
+	Name     string
+	Type     string
+	Basic    BasicAuth
+	JWT      JWTAuth
+	OIDC     OIDCAuth
+	External ExternalAuth
+	APIKey   APIKeyAuth
 }
 
 type BasicAuth struct {
@@ -195,6 +285,46 @@ type BasicAuth struct {
 	ModTime time.Time // the htpasswd file last modification time
 }
 
+type JWTAuth struct {
+	Realm    string
+	KeyFile  string
+	JWKSURL  string
+	Refresh  time.Duration
+	Issuer   string
+	Audience []string
+	Leeway   time.Duration
+	Headers  map[string]string // claim name -> upstream header
+}
+
+type APIKeyAuth struct {
+	File      string
+	ConsulURL string
+	Refresh   time.Duration
+	Header    string
+	Query     string
+}
+
+type ExternalAuth struct {
+	URL      string
+	Timeout  time.Duration
+	CacheTTL time.Duration
+	CacheKey []string // request headers which identify the client for the cache
+	FailOpen bool
+	Headers  []string // response headers copied to the upstream request
+}
+
+type OIDCAuth struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	CallbackPath string
+	CookieName   string
+	CookieSecret string
+	Session      time.Duration
+	Headers      map[string]string // claim name -> upstream header
+}
+
 type ConsulTlS struct {
 	KeyFile            string
 	CertFile           string
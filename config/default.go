@@ -15,15 +15,35 @@ var defaultValues = struct {
 	IdleTimeout           time.Duration
 	UIListenerValue       string
 	GZIPContentTypesValue string
+	CompressEncodings     string
+	ClientCertFieldsValue string
+	IPGroupsValue         string
+	TrustedProxiesValue   string
+	PrometheusBuckets     string
+	TracingHeadersValue   string
+	OTLPHeadersValue      string
 }{
-	ListenerValue:   ":9999",
-	UIListenerValue: ":9998",
+	ListenerValue:         ":9999",
+	UIListenerValue:       ":9998",
+	ClientCertFieldsValue: "Hash,Subject,URI,DNS",
+	CompressEncodings:     "gzip",
 }
 
 var defaultConfig = &Config{
 	ProfilePath: os.TempDir(),
 	Log: Log{
 		AccessFormat: "common",
+		AccessBuffer: 1024,
+		AccessSyslog: AccessSyslog{
+			Addr:     "udp://127.0.0.1:514",
+			Facility: "local0",
+			Tag:      "fabio",
+		},
+		AccessFile: AccessFile{
+			MaxSize:    100,
+			Interval:   24 * time.Hour,
+			MaxBackups: 7,
+		},
 		RoutesFormat: "delta",
 		Level:        "INFO",
 	},
@@ -36,6 +56,15 @@ var defaultConfig = &Config{
 		Circonus: Circonus{
 			APIApp: "fabio",
 		},
+		Prometheus: Prometheus{
+			Path: "/metrics",
+		},
+		OTLP: OTLP{
+			Addr:        "http://localhost:4317",
+			Protocol:    "grpc",
+			Headers:     map[string]string{},
+			Temporality: "cumulative",
+		},
 	},
 	Proxy: Proxy{
 		MaxConn:             10000,
@@ -47,6 +76,26 @@ var defaultConfig = &Config{
 		GlobalFlushInterval: 0,
 		LocalIP:             LocalIPString(),
 		AuthSchemes:         map[string]AuthScheme{},
+		CertSources:         map[string]CertSource{},
+		ClientCertHeader: ClientCertHeader{
+			Fields: []string{"Hash", "Subject", "URI", "DNS"},
+		},
+		Compress: Compress{
+			Encodings: []string{"gzip"},
+			MinSize:   0,
+		},
+		Cache: Cache{
+			Size:         64,
+			MaxEntrySize: 1024,
+		},
+		OCSP: OCSP{
+			Timeout: 10 * time.Second,
+			Retry:   time.Minute,
+		},
+		CertExpiryWarning: 30 * 24 * time.Hour,
+		AccessRules: AccessRules{
+			IPGroups: map[string][]string{},
+		},
 	},
 	Registry: Registry{
 		Backend: "consul",
@@ -96,14 +145,16 @@ var defaultConfig = &Config{
 	Tracing: Tracing{
 		TracingEnabled: false,
 		CollectorType:  "http",
-		ConnectString:  "http://localhost:9411/api/v1/spans",
+		ConnectString:  "http://localhost:9411/api/v2/spans",
 		ServiceName:    "Fabiolb",
-		Topic:          "Fabiolb-Kafka-Topic",
+		Headers:        map[string]string{},
+		Sampler:        "parentbased_traceidratio",
 		SamplerRate:    -1,
-		SpanHost:       "localhost:9998",
 		SpanName:       "",
-		TraceID128Bit:  true,
 	},
 
 	GlobCacheSize: 1000,
+	Explain: Explain{
+		RemoteAddr: "127.0.0.1",
+	},
 }
@@ -6,6 +6,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"regexp"
 	"runtime"
@@ -117,8 +118,15 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 	var authSchemesValue string
 	var readTimeout, writeTimeout time.Duration
 	var gzipContentTypesValue string
+	var clientCertFieldsValue string
+	var compressEncodingsValue string
+	var ipGroupsValue, trustedProxiesValue string
+	var prometheusBucketsValue, otlpHeadersValue string
+
+	var tracingHeadersValue string
 
 	var obsoleteStr string
+	var obsoleteBool bool
 
 	f.BoolVar(&cfg.Insecure, "insecure", defaultConfig.Insecure, "allow fabio to run as root when set to true")
 	f.IntVar(&cfg.Proxy.MaxConn, "proxy.maxconn", defaultConfig.Proxy.MaxConn, "maximum number of cached connections")
@@ -136,8 +144,18 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 	f.StringVar(&cfg.Proxy.RequestID, "proxy.header.requestid", defaultConfig.Proxy.RequestID, "header for reqest id")
 	f.IntVar(&cfg.Proxy.STSHeader.MaxAge, "proxy.header.sts.maxage", defaultConfig.Proxy.STSHeader.MaxAge, "enable and set the max-age value for HSTS")
 	f.BoolVar(&cfg.Proxy.STSHeader.Subdomains, "proxy.header.sts.subdomains", defaultConfig.Proxy.STSHeader.Subdomains, "direct HSTS to include subdomains")
+	f.StringVar(&cfg.Proxy.ClientCertHeader.Name, "proxy.header.clientcert", defaultConfig.Proxy.ClientCertHeader.Name, "header for the verified client certificate identity")
+	f.StringVar(&clientCertFieldsValue, "proxy.header.clientcert.fields", defaultValues.ClientCertFieldsValue, "fields of the client certificate header")
 	f.BoolVar(&cfg.Proxy.STSHeader.Preload, "proxy.header.sts.preload", defaultConfig.Proxy.STSHeader.Preload, "direct HSTS to pass the preload directive")
 	f.StringVar(&gzipContentTypesValue, "proxy.gzip.contenttype", defaultValues.GZIPContentTypesValue, "regexp of content types to compress")
+	f.StringVar(&compressEncodingsValue, "proxy.compress.encodings", defaultValues.CompressEncodings, "encodings for compressed responses in the order of preference")
+	f.IntVar(&cfg.Proxy.Compress.MinSize, "proxy.compress.minsize", defaultConfig.Proxy.Compress.MinSize, "minimum size of compressed responses in bytes")
+	f.IntVar(&cfg.Proxy.Compress.Level, "proxy.compress.level", defaultConfig.Proxy.Compress.Level, "compression level. 0 uses the default level of the encoding")
+	f.IntVar(&cfg.Proxy.Cache.Size, "proxy.cache.size", defaultConfig.Proxy.Cache.Size, "max size of the response cache in MB")
+	f.IntVar(&cfg.Proxy.Cache.MaxEntrySize, "proxy.cache.maxentrysize", defaultConfig.Proxy.Cache.MaxEntrySize, "max size of a cached response in KB")
+	f.DurationVar(&cfg.Proxy.WebSocket.IdleTimeout, "proxy.ws.idletimeout", defaultConfig.Proxy.WebSocket.IdleTimeout, "idle timeout for websocket connections")
+	f.DurationVar(&cfg.Proxy.WebSocket.MaxLifetime, "proxy.ws.maxlifetime", defaultConfig.Proxy.WebSocket.MaxLifetime, "max lifetime of websocket connections")
+	f.IntVar(&cfg.Proxy.WebSocket.MaxFrameSize, "proxy.ws.maxframesize", defaultConfig.Proxy.WebSocket.MaxFrameSize, "max payload size of websocket frames in bytes")
 	f.StringVar(&listenerValue, "proxy.addr", defaultValues.ListenerValue, "listener config")
 	f.StringVar(&certSourcesValue, "proxy.cs", defaultValues.CertSourcesValue, "certificate sources")
 	f.DurationVar(&readTimeout, "proxy.readtimeout", defaultValues.ReadTimeout, "read timeout for incoming requests")
@@ -145,8 +163,26 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 	f.DurationVar(&cfg.Proxy.FlushInterval, "proxy.flushinterval", defaultConfig.Proxy.FlushInterval, "flush interval for streaming responses")
 	f.DurationVar(&cfg.Proxy.GlobalFlushInterval, "proxy.globalflushinterval", defaultConfig.Proxy.GlobalFlushInterval, "flush interval for non-streaming responses")
 	f.StringVar(&authSchemesValue, "proxy.auth", defaultValues.AuthSchemesValue, "auth schemes")
+	f.BoolVar(&cfg.Proxy.OCSP.Stapling, "proxy.ocsp.stapling", defaultConfig.Proxy.OCSP.Stapling, "enable OCSP stapling for served certificates")
+	f.BoolVar(&cfg.Proxy.OCSP.RefuseRevoked, "proxy.ocsp.refuserevoked", defaultConfig.Proxy.OCSP.RefuseRevoked, "refuse to serve certificates which are revoked")
+	f.DurationVar(&cfg.Proxy.OCSP.Timeout, "proxy.ocsp.timeout", defaultConfig.Proxy.OCSP.Timeout, "timeout for OCSP requests")
+	f.DurationVar(&cfg.Proxy.OCSP.Retry, "proxy.ocsp.retry", defaultConfig.Proxy.OCSP.Retry, "retry interval for failed OCSP requests")
+	f.StringVar(&ipGroupsValue, "proxy.access.ipgroups", defaultValues.IPGroupsValue, "named groups of networks for access rules")
+	f.StringVar(&trustedProxiesValue, "proxy.access.trustedproxies", defaultValues.TrustedProxiesValue, "networks of proxies trusted to set X-Forwarded-For")
+	f.StringVar(&cfg.Proxy.AccessRules.GeoIPDB, "proxy.access.geoipdb", defaultConfig.Proxy.AccessRules.GeoIPDB, "path to a MaxMind DB file for country access rules")
+	f.DurationVar(&cfg.Proxy.CertExpiryWarning, "proxy.cert.expirywarning", defaultConfig.Proxy.CertExpiryWarning, "log a warning for certificates which expire within this duration")
 	f.StringVar(&cfg.Log.AccessFormat, "log.access.format", defaultConfig.Log.AccessFormat, "access log format")
-	f.StringVar(&cfg.Log.AccessTarget, "log.access.target", defaultConfig.Log.AccessTarget, "access log target")
+	f.StringVar(&cfg.Log.AccessTarget, "log.access.target", defaultConfig.Log.AccessTarget, "access log target, one of [stdout, syslog, file]")
+	f.StringVar(&cfg.Log.AccessSample, "log.access.sample", defaultConfig.Log.AccessSample, "default sample rules of the access log, e.g. 5xx:1,2xx:1%")
+	f.IntVar(&cfg.Log.AccessBuffer, "log.access.buffer", defaultConfig.Log.AccessBuffer, "number of buffered access log events. 0 writes synchronously")
+	f.StringVar(&cfg.Log.AccessSyslog.Addr, "log.access.syslog.addr", defaultConfig.Log.AccessSyslog.Addr, "syslog server address, e.g. udp://host:514, tcp://host:514 or unix:///dev/log")
+	f.StringVar(&cfg.Log.AccessSyslog.Facility, "log.access.syslog.facility", defaultConfig.Log.AccessSyslog.Facility, "syslog facility")
+	f.StringVar(&cfg.Log.AccessSyslog.Tag, "log.access.syslog.tag", defaultConfig.Log.AccessSyslog.Tag, "syslog app name")
+	f.StringVar(&cfg.Log.AccessFile.Path, "log.access.file.path", defaultConfig.Log.AccessFile.Path, "path of the access log file")
+	f.IntVar(&cfg.Log.AccessFile.MaxSize, "log.access.file.maxsize", defaultConfig.Log.AccessFile.MaxSize, "max size of the access log file in MB before it is rotated. 0 disables size based rotation")
+	f.DurationVar(&cfg.Log.AccessFile.Interval, "log.access.file.interval", defaultConfig.Log.AccessFile.Interval, "rotation interval of the access log file. 0 disables time based rotation")
+	f.IntVar(&cfg.Log.AccessFile.MaxBackups, "log.access.file.maxbackups", defaultConfig.Log.AccessFile.MaxBackups, "number of rotated access log files to keep. 0 keeps all")
+	f.BoolVar(&cfg.Log.AccessFile.Compress, "log.access.file.compress", defaultConfig.Log.AccessFile.Compress, "compress rotated access log files with gzip")
 	f.StringVar(&cfg.Log.RoutesFormat, "log.routes.format", defaultConfig.Log.RoutesFormat, "log format of routing table updates")
 	f.StringVar(&cfg.Log.Level, "log.level", defaultConfig.Log.Level, "log level: TRACE, DEBUG, INFO, WARN, ERROR, FATAL")
 	f.StringVar(&cfg.Metrics.Target, "metrics.target", defaultConfig.Metrics.Target, "metrics backend")
@@ -163,6 +199,13 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 	f.StringVar(&cfg.Metrics.Circonus.BrokerID, "metrics.circonus.brokerid", defaultConfig.Metrics.Circonus.BrokerID, "Circonus Broker ID")
 	f.StringVar(&cfg.Metrics.Circonus.CheckID, "metrics.circonus.checkid", defaultConfig.Metrics.Circonus.CheckID, "Circonus Check ID")
 	f.StringVar(&cfg.Metrics.Circonus.SubmissionURL, "metrics.circonus.submissionurl", defaultConfig.Metrics.Circonus.SubmissionURL, "Circonus Check SubmissionURL")
+	f.StringVar(&cfg.Metrics.Prometheus.Path, "metrics.prometheus.path", defaultConfig.Metrics.Prometheus.Path, "path of the Prometheus endpoint")
+	f.StringVar(&cfg.Metrics.Prometheus.Addr, "metrics.prometheus.addr", defaultConfig.Metrics.Prometheus.Addr, "dedicated listen address of the Prometheus endpoint")
+	f.StringVar(&prometheusBucketsValue, "metrics.prometheus.buckets", defaultValues.PrometheusBuckets, "histogram buckets in seconds for Prometheus timers")
+	f.StringVar(&cfg.Metrics.OTLP.Addr, "metrics.otlp.addr", defaultConfig.Metrics.OTLP.Addr, "URL of the OTLP metrics collector")
+	f.StringVar(&cfg.Metrics.OTLP.Protocol, "metrics.otlp.protocol", defaultConfig.Metrics.OTLP.Protocol, "OTLP protocol, one of [grpc, http]")
+	f.StringVar(&otlpHeadersValue, "metrics.otlp.headers", defaultValues.OTLPHeadersValue, "Headers sent to the OTLP metrics collector")
+	f.StringVar(&cfg.Metrics.OTLP.Temporality, "metrics.otlp.temporality", defaultConfig.Metrics.OTLP.Temporality, "OTLP aggregation temporality, one of [cumulative, delta]")
 	f.StringVar(&cfg.Registry.Backend, "registry.backend", defaultConfig.Registry.Backend, "registry backend")
 	f.DurationVar(&cfg.Registry.Timeout, "registry.timeout", defaultConfig.Registry.Timeout, "timeout for registry to become available")
 	f.DurationVar(&cfg.Registry.Retry, "registry.retry", defaultConfig.Registry.Retry, "retry interval during startup")
@@ -200,17 +243,23 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 	f.StringVar(&cfg.UI.Title, "ui.title", defaultConfig.UI.Title, "optional title for the UI")
 	f.StringVar(&cfg.ProfileMode, "profile.mode", defaultConfig.ProfileMode, "enable profiling mode, one of [cpu, mem, mutex, block, trace]")
 	f.StringVar(&cfg.ProfilePath, "profile.path", defaultConfig.ProfilePath, "path to profile dump file")
-	f.BoolVar(&cfg.Tracing.TracingEnabled, "tracing.TracingEnabled", defaultConfig.Tracing.TracingEnabled, "Enable/Disable OpenTrace, one of [true, false]")
-	f.StringVar(&cfg.Tracing.CollectorType, "tracing.CollectorType", defaultConfig.Tracing.CollectorType, "OpenTrace Collector Type, one of [http, kafka]")
-	f.StringVar(&cfg.Tracing.ConnectString, "tracing.ConnectString", defaultConfig.Tracing.ConnectString, "OpenTrace Collector host:port")
-	f.StringVar(&cfg.Tracing.ServiceName, "tracing.ServiceName", defaultConfig.Tracing.ServiceName, "Service name to embed in OpenTrace span")
-	f.StringVar(&cfg.Tracing.SpanName, "tracing.SpanName", defaultConfig.Tracing.SpanName, "Span name template used to embed in OpenTrace span")
-	f.StringVar(&cfg.Tracing.Topic, "tracing.Topic", defaultConfig.Tracing.Topic, "OpenTrace Collector Kafka Topic")
-	f.Float64Var(&cfg.Tracing.SamplerRate, "tracing.SamplerRate", defaultConfig.Tracing.SamplerRate, "OpenTrace sample rate percentage in decimal form")
-	f.StringVar(&cfg.Tracing.SpanHost, "tracing.SpanHost", defaultConfig.Tracing.SpanHost, "Host:Port info to add to spans")
-	f.BoolVar(&cfg.Tracing.TraceID128Bit, "tracing.TraceID128Bit", defaultConfig.Tracing.TraceID128Bit, "Generate 128 bit trace IDs")
+	f.BoolVar(&cfg.Tracing.TracingEnabled, "tracing.TracingEnabled", defaultConfig.Tracing.TracingEnabled, "Enable/Disable tracing, one of [true, false]")
+	f.StringVar(&cfg.Tracing.CollectorType, "tracing.CollectorType", defaultConfig.Tracing.CollectorType, "Trace exporter type, one of [otlp, otlp-http, http]")
+	f.StringVar(&cfg.Tracing.ConnectString, "tracing.ConnectString", defaultConfig.Tracing.ConnectString, "Trace collector URL")
+	f.StringVar(&tracingHeadersValue, "tracing.Headers", defaultValues.TracingHeadersValue, "Headers sent to the OTLP trace collector")
+	f.StringVar(&cfg.Tracing.ServiceName, "tracing.ServiceName", defaultConfig.Tracing.ServiceName, "Service name to embed in trace spans")
+	f.StringVar(&cfg.Tracing.SpanName, "tracing.SpanName", defaultConfig.Tracing.SpanName, "Span name template used to embed in trace spans")
+	f.StringVar(&cfg.Tracing.Sampler, "tracing.Sampler", defaultConfig.Tracing.Sampler, "Trace sampler, one of [always_on, always_off, traceidratio, parentbased_always_on, parentbased_always_off, parentbased_traceidratio]")
+	f.Float64Var(&cfg.Tracing.SamplerRate, "tracing.SamplerRate", defaultConfig.Tracing.SamplerRate, "Trace sample rate percentage in decimal form")
+	f.StringVar(&obsoleteStr, "tracing.Topic", "", "This option is deprecated and has no effect.")
+	f.StringVar(&obsoleteStr, "tracing.SpanHost", "", "This option is deprecated and has no effect.")
+	f.BoolVar(&obsoleteBool, "tracing.TraceID128Bit", true, "This option is deprecated and has no effect.")
 	f.BoolVar(&cfg.GlobMatchingDisabled, "glob.matching.disabled", defaultConfig.GlobMatchingDisabled, "Disable Glob Matching on routes, one of [true, false]")
 	f.IntVar(&cfg.GlobCacheSize, "glob.cache.size", defaultConfig.GlobCacheSize, "sets the size of the glob cache")
+	f.StringVar(&cfg.Explain.URL, "explain", defaultConfig.Explain.URL, "explain the route lookup for the url and exit")
+	f.StringVar(&cfg.Explain.Routes, "explain.routes", defaultConfig.Explain.Routes, "path to the routes file for explain")
+	f.StringSliceVar(&cfg.Explain.Header, "explain.header", defaultConfig.Explain.Header, "request headers for explain as 'name: value'")
+	f.StringVar(&cfg.Explain.RemoteAddr, "explain.remoteaddr", defaultConfig.Explain.RemoteAddr, "client address for explain")
 
 	f.StringVar(&cfg.Registry.Custom.Host, "registry.custom.host", defaultConfig.Registry.Custom.Host, "custom back end hostname/port")
 	f.StringVar(&cfg.Registry.Custom.Scheme, "registry.custom.scheme", defaultConfig.Registry.Custom.Scheme, "custom back end scheme - http/https")
@@ -245,6 +294,8 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 		return nil, err
 	}
 
+	cfg.Proxy.CertSources = certSources
+
 	authSchemes, err := parseAuthSchemes(authSchemesValue)
 
 	if err != nil {
@@ -253,6 +304,93 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 
 	cfg.Proxy.AuthSchemes = authSchemes
 
+	for _, v := range splitTrim(prometheusBucketsValue) {
+		b, err := strconv.ParseFloat(v, 64)
+		if err != nil || (len(cfg.Metrics.Prometheus.Buckets) > 0 && b <= cfg.Metrics.Prometheus.Buckets[len(cfg.Metrics.Prometheus.Buckets)-1]) {
+			return nil, fmt.Errorf("invalid bucket %q in metrics.prometheus.buckets. Buckets must be increasing numbers", v)
+		}
+		cfg.Metrics.Prometheus.Buckets = append(cfg.Metrics.Prometheus.Buckets, b)
+	}
+
+	if cfg.Metrics.Prometheus.Path == "" || cfg.Metrics.Prometheus.Path[0] != '/' {
+		return nil, fmt.Errorf("metrics.prometheus.path must start with a '/'")
+	}
+
+	if cfg.Log.AccessBuffer < 0 {
+		return nil, fmt.Errorf("log.access.buffer must not be negative")
+	}
+	if cfg.Log.AccessFile.MaxSize < 0 {
+		return nil, fmt.Errorf("log.access.file.maxsize must not be negative")
+	}
+	if cfg.Log.AccessFile.MaxBackups < 0 {
+		return nil, fmt.Errorf("log.access.file.maxbackups must not be negative")
+	}
+
+	switch cfg.Metrics.OTLP.Protocol {
+	case "grpc", "http":
+	default:
+		return nil, fmt.Errorf("invalid metrics.otlp.protocol %q", cfg.Metrics.OTLP.Protocol)
+	}
+
+	switch cfg.Metrics.OTLP.Temporality {
+	case "cumulative", "delta":
+	default:
+		return nil, fmt.Errorf("invalid metrics.otlp.temporality %q", cfg.Metrics.OTLP.Temporality)
+	}
+
+	cfg.Metrics.OTLP.Headers, err = parseHeaders("metrics.otlp.headers", otlpHeadersValue)
+	if err != nil {
+		return nil, err
+	}
+
+	// the default connect string is the url of the zipkin collector
+	if otlpURL, ok := otlpDefaultURL[cfg.Tracing.CollectorType]; ok && !f.IsSet("tracing.ConnectString") {
+		cfg.Tracing.ConnectString = otlpURL
+	}
+
+	// existing configurations with disabled tracing must still work
+	var tracingErr error
+	switch cfg.Tracing.CollectorType {
+	case "otlp", "otlp-http":
+	case "http":
+		if strings.Contains(cfg.Tracing.ConnectString, "/api/v1/") {
+			tracingErr = fmt.Errorf("tracing.ConnectString %q uses the Zipkin v1 API which is no longer supported. Use /api/v2/spans", cfg.Tracing.ConnectString)
+		}
+	case "kafka":
+		tracingErr = fmt.Errorf("tracing.CollectorType kafka is no longer supported")
+	default:
+		tracingErr = fmt.Errorf("invalid tracing.CollectorType %q", cfg.Tracing.CollectorType)
+	}
+	if tracingErr != nil {
+		if cfg.Tracing.TracingEnabled {
+			return nil, tracingErr
+		}
+		log.Print("[WARN] ", tracingErr)
+	}
+
+	switch cfg.Tracing.Sampler {
+	case "always_on", "always_off", "traceidratio", "parentbased_always_on", "parentbased_always_off", "parentbased_traceidratio":
+	default:
+		return nil, fmt.Errorf("invalid tracing.Sampler %q", cfg.Tracing.Sampler)
+	}
+
+	cfg.Tracing.Headers, err = parseHeaders("tracing.Headers", tracingHeadersValue)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.Proxy.AccessRules.IPGroups, err = parseIPGroups(ipGroupsValue)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.Proxy.AccessRules.TrustedProxies = splitTrim(trustedProxiesValue)
+	for _, n := range cfg.Proxy.AccessRules.TrustedProxies {
+		if !isIPOrCIDR(n) {
+			return nil, fmt.Errorf("invalid network %q in proxy.access.trustedproxies", n)
+		}
+	}
+
 	if uiListenerValue != "" {
 		kvs, err := parseKVSlice(uiListenerValue)
 		if err != nil {
@@ -305,6 +443,57 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 		}
 	}
 
+	cfg.Proxy.Compress.Encodings = nil
+	for _, enc := range strings.Split(compressEncodingsValue, ",") {
+		enc = strings.TrimSpace(enc)
+		switch enc {
+		case "":
+			continue
+		case "br", "zstd", "gzip":
+			cfg.Proxy.Compress.Encodings = append(cfg.Proxy.Compress.Encodings, enc)
+		default:
+			return nil, fmt.Errorf("invalid encoding %q in proxy.compress.encodings", enc)
+		}
+	}
+
+	if cfg.Proxy.OCSP.Retry <= 0 {
+		return nil, fmt.Errorf("invalid proxy.ocsp.retry: %s", cfg.Proxy.OCSP.Retry)
+	}
+
+	if cfg.Proxy.Compress.MinSize < 0 {
+		return nil, fmt.Errorf("invalid proxy.compress.minsize: %d", cfg.Proxy.Compress.MinSize)
+	}
+
+	if cfg.Proxy.Cache.Size <= 0 {
+		return nil, fmt.Errorf("invalid proxy.cache.size: %d", cfg.Proxy.Cache.Size)
+	}
+	if cfg.Proxy.Cache.MaxEntrySize <= 0 {
+		return nil, fmt.Errorf("invalid proxy.cache.maxentrysize: %d", cfg.Proxy.Cache.MaxEntrySize)
+	}
+
+	if cfg.Proxy.WebSocket.IdleTimeout < 0 {
+		return nil, fmt.Errorf("invalid proxy.ws.idletimeout: %s", cfg.Proxy.WebSocket.IdleTimeout)
+	}
+	if cfg.Proxy.WebSocket.MaxLifetime < 0 {
+		return nil, fmt.Errorf("invalid proxy.ws.maxlifetime: %s", cfg.Proxy.WebSocket.MaxLifetime)
+	}
+	if cfg.Proxy.WebSocket.MaxFrameSize < 0 {
+		return nil, fmt.Errorf("invalid proxy.ws.maxframesize: %d", cfg.Proxy.WebSocket.MaxFrameSize)
+	}
+
+	cfg.Proxy.ClientCertHeader.Fields = nil
+	for _, field := range strings.Split(clientCertFieldsValue, ",") {
+		field = strings.TrimSpace(field)
+		switch field {
+		case "":
+			continue
+		case "Hash", "Cert", "Subject", "URI", "DNS":
+			cfg.Proxy.ClientCertHeader.Fields = append(cfg.Proxy.ClientCertHeader.Fields, field)
+		default:
+			return nil, fmt.Errorf("invalid field %q in proxy.header.clientcert.fields", field)
+		}
+	}
+
 	if cfg.Proxy.Strategy != "rr" && cfg.Proxy.Strategy != "rnd" {
 		return nil, fmt.Errorf("invalid proxy.strategy: %s", cfg.Proxy.Strategy)
 	}
@@ -452,6 +641,13 @@ func parseListen(cfg map[string]string, cs map[string]CertSource, readTimeout, w
 				return Listen{}, err
 			}
 			l.Refresh = d
+		case "clientauth":
+			switch v {
+			case "require", "request":
+				l.ClientAuth = v
+			default:
+				return Listen{}, fmt.Errorf("invalid clientauth %q. Must be 'require' or 'request'", v)
+			}
 		}
 	}
 
@@ -484,6 +680,13 @@ func parseListen(cfg map[string]string, cs map[string]CertSource, readTimeout, w
 	return
 }
 
+// otlpDefaultURL contains the default collector urls
+// for the otlp collector types.
+var otlpDefaultURL = map[string]string{
+	"otlp":      "http://localhost:4317",
+	"otlp-http": "http://localhost:4318",
+}
+
 var tlsver = map[string]uint16{
 	"ssl30": tls.VersionSSL30,
 	"tls10": tls.VersionTLS10,
@@ -690,9 +893,252 @@ func parseAuthScheme(cfg map[string]string) (a AuthScheme, err error) {
 			a.Basic.Refresh = d
 		}
 
+	case "jwt":
+		a.JWT = JWTAuth{
+			Realm:   cfg["realm"],
+			KeyFile: cfg["keys"],
+			JWKSURL: cfg["jwks"],
+			Refresh: time.Hour,
+			Issuer:  cfg["iss"],
+			Leeway:  time.Minute,
+		}
+
+		if a.JWT.KeyFile == "" && a.JWT.JWKSURL == "" {
+			return AuthScheme{}, fmt.Errorf("missing 'keys' or 'jwks' in auth '%s'", a.Name)
+		}
+		if a.JWT.Realm == "" {
+			a.JWT.Realm = a.Name
+		}
+		if cfg["aud"] != "" {
+			a.JWT.Audience = splitTrim(cfg["aud"])
+		}
+
+		for _, k := range []string{"refresh", "leeway"} {
+			if cfg[k] == "" {
+				continue
+			}
+			d, err := time.ParseDuration(cfg[k])
+			if err != nil {
+				return AuthScheme{}, err
+			}
+			switch k {
+			case "refresh":
+				if d < time.Second {
+					d = time.Second
+				}
+				a.JWT.Refresh = d
+			case "leeway":
+				a.JWT.Leeway = d
+			}
+		}
+
+		if cfg["headers"] != "" {
+			if a.JWT.Headers, err = parseClaimHeaders(a.Name, cfg["headers"]); err != nil {
+				return AuthScheme{}, err
+			}
+		}
+
+	case "oidc":
+		a.OIDC = OIDCAuth{
+			Issuer:       cfg["issuer"],
+			ClientID:     cfg["clientid"],
+			ClientSecret: cfg["clientsecret"],
+			Scopes:       []string{"openid", "profile", "email"},
+			CallbackPath: "/oauth2/callback",
+			CookieName:   "fabio-" + a.Name,
+			CookieSecret: cfg["cookiesecret"],
+			Session:      8 * time.Hour,
+			Headers:      map[string]string{"sub": "X-Forwarded-User", "email": "X-Forwarded-Email"},
+		}
+
+		for _, k := range []string{"issuer", "clientid", "cookiesecret"} {
+			if cfg[k] == "" {
+				return AuthScheme{}, fmt.Errorf("missing '%s' in auth '%s'", k, a.Name)
+			}
+		}
+		if len(a.OIDC.CookieSecret) < 16 {
+			return AuthScheme{}, fmt.Errorf("'cookiesecret' in auth '%s' must have at least 16 characters", a.Name)
+		}
+		if cfg["scope"] != "" {
+			a.OIDC.Scopes = splitTrim(cfg["scope"])
+		}
+		if cfg["callback"] != "" {
+			a.OIDC.CallbackPath = cfg["callback"]
+		}
+		if cfg["cookie"] != "" {
+			a.OIDC.CookieName = cfg["cookie"]
+		}
+		if cfg["session"] != "" {
+			d, err := time.ParseDuration(cfg["session"])
+			if err != nil {
+				return AuthScheme{}, err
+			}
+			a.OIDC.Session = d
+		}
+		if cfg["headers"] != "" {
+			if a.OIDC.Headers, err = parseClaimHeaders(a.Name, cfg["headers"]); err != nil {
+				return AuthScheme{}, err
+			}
+		}
+
+	case "external":
+		a.External = ExternalAuth{
+			URL:     cfg["url"],
+			Timeout: 5 * time.Second,
+		}
+
+		if a.External.URL == "" {
+			return AuthScheme{}, fmt.Errorf("missing 'url' in auth '%s'", a.Name)
+		}
+		for _, k := range []string{"timeout", "cache"} {
+			if cfg[k] == "" {
+				continue
+			}
+			d, err := time.ParseDuration(cfg[k])
+			if err != nil {
+				return AuthScheme{}, err
+			}
+			switch k {
+			case "timeout":
+				a.External.Timeout = d
+			case "cache":
+				a.External.CacheTTL = d
+			}
+		}
+		switch cfg["onerror"] {
+		case "", "deny":
+		case "allow":
+			a.External.FailOpen = true
+		default:
+			return AuthScheme{}, fmt.Errorf("invalid onerror '%s' in auth '%s'. Must be 'allow' or 'deny'", cfg["onerror"], a.Name)
+		}
+		if cfg["headers"] != "" {
+			a.External.Headers = splitTrim(cfg["headers"])
+		}
+		if cfg["cachekey"] != "" {
+			a.External.CacheKey = splitTrim(cfg["cachekey"])
+		}
+
+	case "apikey":
+		a.APIKey = APIKeyAuth{
+			File:      cfg["file"],
+			ConsulURL: cfg["consul"],
+			Refresh:   30 * time.Second,
+			Header:    "X-Api-Key",
+			Query:     cfg["query"],
+		}
+
+		if a.APIKey.File == "" && a.APIKey.ConsulURL == "" {
+			return AuthScheme{}, fmt.Errorf("missing 'file' or 'consul' in auth '%s'", a.Name)
+		}
+		if a.APIKey.File != "" && a.APIKey.ConsulURL != "" {
+			return AuthScheme{}, fmt.Errorf("'file' and 'consul' are mutually exclusive in auth '%s'", a.Name)
+		}
+		if v, ok := cfg["header"]; ok {
+			a.APIKey.Header = v
+		}
+		if a.APIKey.Header == "" && a.APIKey.Query == "" {
+			return AuthScheme{}, fmt.Errorf("missing 'header' or 'query' in auth '%s'", a.Name)
+		}
+		if cfg["refresh"] != "" {
+			d, err := time.ParseDuration(cfg["refresh"])
+			if err != nil {
+				return AuthScheme{}, err
+			}
+			if d < time.Second {
+				d = time.Second
+			}
+			a.APIKey.Refresh = d
+		}
+
 	default:
 		return AuthScheme{}, fmt.Errorf("unknown auth type '%s'", a.Type)
 	}
 
 	return
 }
+
+// parseClaimHeaders parses a comma separated list of 'claim:header'
+// mappings.
+func parseClaimHeaders(name, s string) (map[string]string, error) {
+	headers := map[string]string{}
+	for _, v := range splitTrim(s) {
+		p := strings.SplitN(v, ":", 2)
+		if len(p) != 2 || p[0] == "" || p[1] == "" {
+			return nil, fmt.Errorf("invalid header mapping '%s' in auth '%s'. Must be 'claim:header'", v, name)
+		}
+		headers[p[0]] = p[1]
+	}
+	return headers, nil
+}
+
+// parseHeaders parses a list of headers in the form
+//
+//	name=value;name="value"
+func parseHeaders(opt, s string) (map[string]string, error) {
+	headers := map[string]string{}
+	kvs, err := parseKVSlice(s)
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range kvs {
+		for name, val := range kv {
+			if name == "" {
+				return nil, fmt.Errorf("header %q without name in %s", val, opt)
+			}
+			headers[name] = val
+		}
+	}
+	return headers, nil
+}
+
+// parseIPGroups parses named groups of networks in the form
+//
+//	name="cidr,cidr,...";name=cidr
+func parseIPGroups(cfgs string) (map[string][]string, error) {
+	groups := map[string][]string{}
+	kvs, err := parseKVSlice(cfgs)
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range kvs {
+		for name, val := range kv {
+			if name == "" {
+				return nil, fmt.Errorf("ip group %q without name", val)
+			}
+			nets := splitTrim(val)
+			if len(nets) == 0 {
+				return nil, fmt.Errorf("ip group %q is empty", name)
+			}
+			for _, n := range nets {
+				if !isIPOrCIDR(n) {
+					return nil, fmt.Errorf("invalid network %q in ip group %q", n, name)
+				}
+			}
+			groups[name] = append(groups[name], nets...)
+		}
+	}
+	return groups, nil
+}
+
+// isIPOrCIDR returns true if s is an IP address or a network in CIDR
+// notation.
+func isIPOrCIDR(s string) bool {
+	if strings.Contains(s, "/") {
+		_, _, err := net.ParseCIDR(s)
+		return err == nil
+	}
+	return net.ParseIP(s) != nil
+}
+
+// splitTrim splits the comma separated list s and removes
+// surrounding whitespace and empty values.
+func splitTrim(s string) []string {
+	var vals []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			vals = append(vals, v)
+		}
+	}
+	return vals
+}
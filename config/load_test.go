@@ -156,6 +156,7 @@ func TestLoad(t *testing.T) {
 			cfg: func(cfg *Config) *Config {
 				cfg.Listen = []Listen{{Addr: ":5555", Proto: "https"}}
 				cfg.Listen[0].CertSource = CertSource{Name: "name", Type: "file", CertPath: "value"}
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -165,6 +166,7 @@ func TestLoad(t *testing.T) {
 			cfg: func(cfg *Config) *Config {
 				cfg.Listen = []Listen{{Addr: ":5555", Proto: "https"}}
 				cfg.Listen[0].CertSource = CertSource{Name: "name", Type: "path", CertPath: "value", Refresh: 3 * time.Second}
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -174,6 +176,7 @@ func TestLoad(t *testing.T) {
 			cfg: func(cfg *Config) *Config {
 				cfg.Listen = []Listen{{Addr: ":5555", Proto: "https"}}
 				cfg.Listen[0].CertSource = CertSource{Name: "name", Type: "http", CertPath: "value", Refresh: 3 * time.Second}
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -183,6 +186,7 @@ func TestLoad(t *testing.T) {
 			cfg: func(cfg *Config) *Config {
 				cfg.Listen = []Listen{{Addr: ":5555", Proto: "https"}}
 				cfg.Listen[0].CertSource = CertSource{Name: "name", Type: "consul", CertPath: "value"}
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -192,6 +196,7 @@ func TestLoad(t *testing.T) {
 			cfg: func(cfg *Config) *Config {
 				cfg.Listen = []Listen{{Addr: ":5555", Proto: "https"}}
 				cfg.Listen[0].CertSource = CertSource{Name: "name", Type: "vault", CertPath: "value", Refresh: 3 * time.Second}
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -205,6 +210,7 @@ func TestLoad(t *testing.T) {
 				cfg.Listen = []Listen{{Addr: ":5555", Proto: "https"}}
 				cfg.Listen[0].CertSource = CertSource{Name: "name", Type: "vault-pki", CertPath: "pki/issue/value", Refresh: 3 * time.Second}
 				cfg.Listen[0].StrictMatch = true // implicit
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -218,6 +224,7 @@ func TestLoad(t *testing.T) {
 				cfg.Listen = []Listen{{Addr: ":5555", Proto: "https"}}
 				cfg.Listen[0].CertSource = CertSource{Name: "name", Type: "vault-pki", CertPath: "pki/issue/value", Refresh: 3 * time.Second}
 				cfg.Listen[0].StrictMatch = true // implicit
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -241,6 +248,7 @@ func TestLoad(t *testing.T) {
 						},
 					},
 				}
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -264,9 +272,103 @@ func TestLoad(t *testing.T) {
 						},
 					},
 				}
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
+		{
+			desc: "-proxy.ocsp.stapling",
+			args: []string{"-proxy.ocsp.stapling=true", "-proxy.ocsp.refuserevoked=true", "-proxy.ocsp.timeout=5s", "-proxy.ocsp.retry=30s"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.OCSP = OCSP{
+					Stapling:      true,
+					RefuseRevoked: true,
+					Timeout:       5 * time.Second,
+					Retry:         30 * time.Second,
+				}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.cert.expirywarning",
+			args: []string{"-proxy.cert.expirywarning", "168h"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.CertExpiryWarning = 7 * 24 * time.Hour
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.access.ipgroups",
+			args: []string{"-proxy.access.ipgroups", `office="10.0.0.0/8, 192.168.1.0/24";vpn=172.16.0.1`},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.AccessRules.IPGroups = map[string][]string{
+					"office": {"10.0.0.0/8", "192.168.1.0/24"},
+					"vpn":    {"172.16.0.1"},
+				}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.access.ipgroups invalid network",
+			args: []string{"-proxy.access.ipgroups", "office=10.0.0.0/33"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid network "10.0.0.0/33" in ip group "office"`),
+		},
+		{
+			desc: "-proxy.access.trustedproxies",
+			args: []string{"-proxy.access.trustedproxies", "10.0.0.0/8, ::1"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.AccessRules.TrustedProxies = []string{"10.0.0.0/8", "::1"}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.access.trustedproxies invalid network",
+			args: []string{"-proxy.access.trustedproxies", "10.0.0.0/8,foo"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid network "foo" in proxy.access.trustedproxies`),
+		},
+		{
+			desc: "-proxy.access.geoipdb",
+			args: []string{"-proxy.access.geoipdb", "/var/lib/GeoLite2-Country.mmdb"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.AccessRules.GeoIPDB = "/var/lib/GeoLite2-Country.mmdb"
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.header.clientcert",
+			args: []string{"-proxy.header.clientcert", "X-Forwarded-Client-Cert", "-proxy.header.clientcert.fields", "Subject, DNS"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.ClientCertHeader = ClientCertHeader{
+					Name:   "X-Forwarded-Client-Cert",
+					Fields: []string{"Subject", "DNS"},
+				}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.header.clientcert.fields invalid",
+			args: []string{"-proxy.header.clientcert.fields", "Subject,Foo"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid field "Foo" in proxy.header.clientcert.fields`),
+		},
+		{
+			desc: "-proxy.addr with clientauth",
+			args: []string{"-proxy.addr", ":5555;cs=name;clientauth=request", "-proxy.cs", "cs=name;type=file;cert=value;clientca=ca"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":5555", Proto: "https", ClientAuth: "request"}}
+				cfg.Listen[0].CertSource = CertSource{Name: "name", Type: "file", CertPath: "value", ClientCAPath: "ca"}
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.addr with invalid clientauth",
+			args: []string{"-proxy.addr", ":5555;cs=name;clientauth=foo", "-proxy.cs", "cs=name;type=file;cert=value"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid clientauth "foo". Must be 'require' or 'request'`),
+		},
 		{
 			desc: "-proxy.auth with source basic",
 			args: []string{"-proxy.auth", "name=foo;type=basic;file=/some/file/on/disk;realm=realm"},
@@ -301,6 +403,151 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			desc: "-proxy.auth with source jwt",
+			args: []string{"-proxy.auth", `name=foo;type=jwt;jwks=https://idp/jwks.json;iss=https://idp;aud="a, b";refresh=5m;leeway=10s;headers="sub:X-User,role:X-Role"`},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.AuthSchemes = map[string]AuthScheme{
+					"foo": {
+						Name: "foo",
+						Type: "jwt",
+						JWT: JWTAuth{
+							Realm:    "foo",
+							JWKSURL:  "https://idp/jwks.json",
+							Refresh:  5 * time.Minute,
+							Issuer:   "https://idp",
+							Audience: []string{"a", "b"},
+							Leeway:   10 * time.Second,
+							Headers:  map[string]string{"sub": "X-User", "role": "X-Role"},
+						},
+					},
+				}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.auth with source jwt and key file",
+			args: []string{"-proxy.auth", "name=foo;type=jwt;keys=/some/jwks.json;realm=realm"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.AuthSchemes = map[string]AuthScheme{
+					"foo": {
+						Name: "foo",
+						Type: "jwt",
+						JWT: JWTAuth{
+							Realm:   "realm",
+							KeyFile: "/some/jwks.json",
+							Refresh: time.Hour,
+							Leeway:  time.Minute,
+						},
+					},
+				}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.auth with source oidc",
+			args: []string{"-proxy.auth", "name=sso;type=oidc;issuer=https://idp;clientid=fabio;clientsecret=secret;cookiesecret=0123456789abcdef"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.AuthSchemes = map[string]AuthScheme{
+					"sso": {
+						Name: "sso",
+						Type: "oidc",
+						OIDC: OIDCAuth{
+							Issuer:       "https://idp",
+							ClientID:     "fabio",
+							ClientSecret: "secret",
+							Scopes:       []string{"openid", "profile", "email"},
+							CallbackPath: "/oauth2/callback",
+							CookieName:   "fabio-sso",
+							CookieSecret: "0123456789abcdef",
+							Session:      8 * time.Hour,
+							Headers:      map[string]string{"sub": "X-Forwarded-User", "email": "X-Forwarded-Email"},
+						},
+					},
+				}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.auth with source oidc and options",
+			args: []string{"-proxy.auth", `name=sso;type=oidc;issuer=https://idp;clientid=fabio;cookiesecret=0123456789abcdef;scope="openid,groups";callback=/_sso;cookie=sess;session=1h;headers="name:X-Name"`},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.AuthSchemes = map[string]AuthScheme{
+					"sso": {
+						Name: "sso",
+						Type: "oidc",
+						OIDC: OIDCAuth{
+							Issuer:       "https://idp",
+							ClientID:     "fabio",
+							Scopes:       []string{"openid", "groups"},
+							CallbackPath: "/_sso",
+							CookieName:   "sess",
+							CookieSecret: "0123456789abcdef",
+							Session:      time.Hour,
+							Headers:      map[string]string{"name": "X-Name"},
+						},
+					},
+				}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.auth with source external",
+			args: []string{"-proxy.auth", `name=ext;type=external;url=http://auth/check;timeout=1s;cache=10s;cachekey="Authorization, X-Api-Key";onerror=allow;headers="X-User, X-Roles"`},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.AuthSchemes = map[string]AuthScheme{
+					"ext": {
+						Name: "ext",
+						Type: "external",
+						External: ExternalAuth{
+							URL:      "http://auth/check",
+							Timeout:  time.Second,
+							CacheTTL: 10 * time.Second,
+							CacheKey: []string{"Authorization", "X-Api-Key"},
+							FailOpen: true,
+							Headers:  []string{"X-User", "X-Roles"},
+						},
+					},
+				}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.auth with source apikey and file",
+			args: []string{"-proxy.auth", "name=keys;type=apikey;file=/some/keys;refresh=1m"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.AuthSchemes = map[string]AuthScheme{
+					"keys": {
+						Name: "keys",
+						Type: "apikey",
+						APIKey: APIKeyAuth{
+							File:    "/some/keys",
+							Refresh: time.Minute,
+							Header:  "X-Api-Key",
+						},
+					},
+				}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.auth with source apikey and consul",
+			args: []string{"-proxy.auth", "name=keys;type=apikey;consul=http://localhost:8500/v1/kv/fabio/apikeys;header=;query=api_key"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.AuthSchemes = map[string]AuthScheme{
+					"keys": {
+						Name: "keys",
+						Type: "apikey",
+						APIKey: APIKeyAuth{
+							ConsulURL: "http://localhost:8500/v1/kv/fabio/apikeys",
+							Refresh:   30 * time.Second,
+							Query:     "api_key",
+						},
+					},
+				}
+				return cfg
+			},
+		},
 		{
 			desc: "issue 305",
 			args: []string{
@@ -318,6 +565,7 @@ func TestLoad(t *testing.T) {
 					Type:     "consul",
 					CertPath: "http://localhost:8500/v1/kv/ssl?token=token",
 				}
+				cfg.Proxy.CertSources = map[string]CertSource{"consul-cs": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -496,6 +744,51 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-proxy.compress.encodings", "gzip, br", "-proxy.compress.minsize", "256", "-proxy.compress.level", "5"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Compress = Compress{Encodings: []string{"gzip", "br"}, MinSize: 256, Level: 5}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.cache.size", "128", "-proxy.cache.maxentrysize", "512"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Cache = Cache{Size: 128, MaxEntrySize: 512}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.ws.idletimeout", "5m", "-proxy.ws.maxlifetime", "24h", "-proxy.ws.maxframesize", "65536"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.WebSocket = WebSocket{IdleTimeout: 5 * time.Minute, MaxLifetime: 24 * time.Hour, MaxFrameSize: 65536}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.ws.maxframesize invalid",
+			args: []string{"-proxy.ws.maxframesize", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid proxy.ws.maxframesize: -1`),
+		},
+		{
+			desc: "-proxy.ocsp.retry invalid",
+			args: []string{"-proxy.ocsp.retry", "0s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid proxy.ocsp.retry: 0s`),
+		},
+		{
+			desc: "-proxy.cache.size invalid",
+			args: []string{"-proxy.cache.size", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid proxy.cache.size: 0`),
+		},
+		{
+			desc: "-proxy.compress.encodings invalid",
+			args: []string{"-proxy.compress.encodings", "gzip,deflate"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid encoding "deflate" in proxy.compress.encodings`),
+		},
 		{
 			args: []string{"-proxy.log.routes", "foobar"},
 			cfg: func(cfg *Config) *Config {
@@ -745,6 +1038,55 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-log.access.sample", "5xx:1,2xx:1%"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Log.AccessSample = "5xx:1,2xx:1%"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-log.access.buffer", "0"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Log.AccessBuffer = 0
+				return cfg
+			},
+		},
+		{
+			args: []string{"-log.access.buffer", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("log.access.buffer must not be negative"),
+		},
+		{
+			args: []string{"-log.access.syslog.addr", "tcp://1.2.3.4:601", "-log.access.syslog.facility", "daemon", "-log.access.syslog.tag", "lb"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Log.AccessSyslog = AccessSyslog{Addr: "tcp://1.2.3.4:601", Facility: "daemon", Tag: "lb"}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-log.access.file.path", "/var/log/fabio/access.log", "-log.access.file.maxsize", "10", "-log.access.file.interval", "1h", "-log.access.file.maxbackups", "3", "-log.access.file.compress"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Log.AccessFile = AccessFile{
+					Path:       "/var/log/fabio/access.log",
+					MaxSize:    10,
+					Interval:   time.Hour,
+					MaxBackups: 3,
+					Compress:   true,
+				}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-log.access.file.maxsize", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("log.access.file.maxsize must not be negative"),
+		},
+		{
+			args: []string{"-log.access.file.maxbackups", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("log.access.file.maxbackups must not be negative"),
+		},
 		{
 			args: []string{"-log.routes.format", "foobar"},
 			cfg: func(cfg *Config) *Config {
@@ -857,6 +1199,131 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-metrics.prometheus.path", "/prom", "-metrics.prometheus.addr", ":9100"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.Prometheus.Path = "/prom"
+				cfg.Metrics.Prometheus.Addr = ":9100"
+				return cfg
+			},
+		},
+		{
+			desc: "-metrics.prometheus.path without slash",
+			args: []string{"-metrics.prometheus.path", "prom"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("metrics.prometheus.path must start with a '/'"),
+		},
+		{
+			args: []string{"-metrics.prometheus.buckets", "0.01, 0.1,1,10"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.Prometheus.Buckets = []float64{0.01, 0.1, 1, 10}
+				return cfg
+			},
+		},
+		{
+			desc: "-metrics.prometheus.buckets not increasing",
+			args: []string{"-metrics.prometheus.buckets", "0.1,1,0.5"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid bucket "0.5" in metrics.prometheus.buckets. Buckets must be increasing numbers`),
+		},
+		{
+			args: []string{"-metrics.target", "otlp", "-metrics.otlp.addr", "https://collector:4318/v1/metrics", "-metrics.otlp.protocol", "http", "-metrics.otlp.temporality", "delta"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.Target = "otlp"
+				cfg.Metrics.OTLP.Addr = "https://collector:4318/v1/metrics"
+				cfg.Metrics.OTLP.Protocol = "http"
+				cfg.Metrics.OTLP.Temporality = "delta"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.otlp.headers", "api-key=secret"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.OTLP.Headers = map[string]string{"api-key": "secret"}
+				return cfg
+			},
+		},
+		{
+			desc: "-metrics.otlp.protocol invalid",
+			args: []string{"-metrics.otlp.protocol", "udp"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid metrics.otlp.protocol "udp"`),
+		},
+		{
+			desc: "-metrics.otlp.temporality invalid",
+			args: []string{"-metrics.otlp.temporality", "both"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid metrics.otlp.temporality "both"`),
+		},
+		{
+			args: []string{"-tracing.CollectorType", "otlp-http", "-tracing.ConnectString", "https://collector:4318/v1/traces"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Tracing.CollectorType = "otlp-http"
+				cfg.Tracing.ConnectString = "https://collector:4318/v1/traces"
+				return cfg
+			},
+		},
+		{
+			desc: "-tracing.CollectorType otlp",
+			args: []string{"-tracing.CollectorType", "otlp"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Tracing.CollectorType = "otlp"
+				cfg.Tracing.ConnectString = "http://localhost:4317"
+				return cfg
+			},
+		},
+		{
+			desc: "-tracing.ConnectString zipkin v1",
+			args: []string{"-tracing.TracingEnabled=true", "-tracing.CollectorType", "http", "-tracing.ConnectString", "http://zipkin:9411/api/v1/spans"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`tracing.ConnectString "http://zipkin:9411/api/v1/spans" uses the Zipkin v1 API which is no longer supported. Use /api/v2/spans`),
+		},
+		{
+			desc: "-tracing.CollectorType kafka",
+			args: []string{"-tracing.TracingEnabled=true", "-tracing.CollectorType", "kafka"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("tracing.CollectorType kafka is no longer supported"),
+		},
+		{
+			desc: "-tracing.CollectorType kafka with tracing disabled",
+			args: []string{"-tracing.TracingEnabled=false", "-tracing.CollectorType", "kafka"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Tracing.CollectorType = "kafka"
+				return cfg
+			},
+		},
+		{
+			desc: "-tracing.CollectorType invalid",
+			args: []string{"-tracing.TracingEnabled=true", "-tracing.CollectorType", "foo"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid tracing.CollectorType "foo"`),
+		},
+		{
+			args: []string{"-tracing.Headers", `api-key=secret;x-tenant="a;b"`},
+			cfg: func(cfg *Config) *Config {
+				cfg.Tracing.Headers = map[string]string{"api-key": "secret", "x-tenant": "a;b"}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-tracing.Sampler", "traceidratio", "-tracing.SamplerRate", "0.25"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Tracing.Sampler = "traceidratio"
+				cfg.Tracing.SamplerRate = 0.25
+				return cfg
+			},
+		},
+		{
+			desc: "-tracing.Sampler invalid",
+			args: []string{"-tracing.Sampler", "sometimes"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid tracing.Sampler "sometimes"`),
+		},
+		{
+			desc: "-tracing.Topic is obsolete",
+			args: []string{"-tracing.Topic", "foo", "-tracing.TraceID128Bit=false"},
+			cfg:  func(cfg *Config) *Config { return cfg },
+		},
 		{
 			args: []string{"-runtime.gogc", "555"},
 			cfg: func(cfg *Config) *Config {
@@ -904,6 +1371,7 @@ func TestLoad(t *testing.T) {
 				cfg.UI.Listen.CertSource.CertPath = "value"
 				cfg.Registry.Consul.CheckScheme = "https"
 				cfg.Registry.Consul.ServiceAddr = ":9998"
+				cfg.Proxy.CertSources = map[string]CertSource{"ui": cfg.UI.Listen.CertSource}
 				return cfg
 			},
 		},
@@ -1090,6 +1558,54 @@ func TestLoad(t *testing.T) {
 			cfg:  func(cfg *Config) *Config { return nil },
 			err:  errors.New("missing 'name' in auth"),
 		},
+		{
+			desc: "-proxy.auth jwt with missing keys",
+			args: []string{"-proxy.auth", "name=foo;type=jwt;iss=foo"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("missing 'keys' or 'jwks' in auth 'foo'"),
+		},
+		{
+			desc: "-proxy.auth jwt with invalid header mapping",
+			args: []string{"-proxy.auth", "name=foo;type=jwt;keys=/some/file;headers=sub"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid header mapping 'sub' in auth 'foo'. Must be 'claim:header'"),
+		},
+		{
+			desc: "-proxy.auth oidc with missing issuer",
+			args: []string{"-proxy.auth", "name=foo;type=oidc;clientid=fabio;cookiesecret=0123456789abcdef"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("missing 'issuer' in auth 'foo'"),
+		},
+		{
+			desc: "-proxy.auth oidc with short cookie secret",
+			args: []string{"-proxy.auth", "name=foo;type=oidc;issuer=https://idp;clientid=fabio;cookiesecret=short"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("'cookiesecret' in auth 'foo' must have at least 16 characters"),
+		},
+		{
+			desc: "-proxy.auth external with missing url",
+			args: []string{"-proxy.auth", "name=foo;type=external"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("missing 'url' in auth 'foo'"),
+		},
+		{
+			desc: "-proxy.auth external with invalid onerror",
+			args: []string{"-proxy.auth", "name=foo;type=external;url=http://auth;onerror=maybe"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid onerror 'maybe' in auth 'foo'. Must be 'allow' or 'deny'"),
+		},
+		{
+			desc: "-proxy.auth apikey with missing key list",
+			args: []string{"-proxy.auth", "name=foo;type=apikey"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("missing 'file' or 'consul' in auth 'foo'"),
+		},
+		{
+			desc: "-proxy.auth apikey with file and consul",
+			args: []string{"-proxy.auth", "name=foo;type=apikey;file=/some/keys;consul=http://localhost:8500/v1/kv/keys"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("'file' and 'consul' are mutually exclusive in auth 'foo'"),
+		},
 		{
 			desc: "-proxy.auth basic with missing file",
 			args: []string{"-proxy.auth", "name=foo;type=basic;realm=realm"},
@@ -1103,6 +1619,18 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-explain", "https://example.com/foo", "-explain.routes", "routes.txt", "-explain.header", "X-Foo: a,Cookie: b=c", "-explain.remoteaddr", "1.2.3.4"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Explain = Explain{
+					URL:        "https://example.com/foo",
+					Routes:     "routes.txt",
+					Header:     []string{"X-Foo: a", "Cookie: b=c"},
+					RemoteAddr: "1.2.3.4",
+				}
+				return cfg
+			},
+		},
 		{
 			args: []string{"-cfg"},
 			cfg:  func(cfg *Config) *Config { return nil },
@@ -0,0 +1,84 @@
+package inspect
+
+import (
+	"fmt"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Filter selects the requests of a feed.
+type Filter struct {
+	// Host matches the host of the request case-insensitively.
+	Host string
+
+	// Path matches requests whose path starts with Path.
+	Path string
+
+	// Service matches the name of the upstream service.
+	Service string
+
+	// Status matches either the status code, e.g. 404, or
+	// the status class, e.g. 5 for '5xx'.
+	Status, Class int
+
+	// Sample is the fraction of the matching requests which
+	// are returned. Zero returns all requests.
+	Sample float64
+}
+
+// ParseFilter creates a filter from the 'host', 'path', 'service',
+// 'status' and 'sample' query parameters.
+func ParseFilter(q url.Values) (Filter, error) {
+	f := Filter{
+		Host:    q.Get("host"),
+		Path:    q.Get("path"),
+		Service: q.Get("service"),
+	}
+
+	if s := q.Get("status"); s != "" {
+		switch {
+		case len(s) == 3 && strings.HasSuffix(s, "xx") && s[0] >= '1' && s[0] <= '5':
+			f.Class = int(s[0] - '0')
+		default:
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 100 || n > 599 {
+				return Filter{}, fmt.Errorf("invalid status %q", s)
+			}
+			f.Status = n
+		}
+	}
+
+	if s := q.Get("sample"); s != "" {
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil || n <= 0 || n > 1 {
+			return Filter{}, fmt.Errorf("invalid sample %q", s)
+		}
+		f.Sample = n
+	}
+	return f, nil
+}
+
+// randFloat returns a random number in [0, 1).
+var randFloat = rand.Float64
+
+// Match returns true if the request matches the filter and
+// is selected by the sample rate.
+func (f Filter) Match(r *Request) bool {
+	switch {
+	case f.Host != "" && !strings.EqualFold(f.Host, r.Host):
+		return false
+	case f.Path != "" && !strings.HasPrefix(r.Path, f.Path):
+		return false
+	case f.Service != "" && f.Service != r.Service:
+		return false
+	case f.Status != 0 && f.Status != r.Status:
+		return false
+	case f.Class != 0 && f.Class != r.Status/100:
+		return false
+	case f.Sample > 0 && f.Sample < 1 && randFloat() >= f.Sample:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package inspect
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		query string
+		req   Request
+		match bool
+		err   bool
+	}{
+		{"", Request{Host: "a.com"}, true, false},
+		{"host=A.com", Request{Host: "a.com"}, true, false},
+		{"host=b.com", Request{Host: "a.com"}, false, false},
+		{"path=/foo", Request{Path: "/foo/bar"}, true, false},
+		{"path=/foo", Request{Path: "/bar"}, false, false},
+		{"service=svc-a", Request{Service: "svc-a"}, true, false},
+		{"service=svc-a", Request{Service: "svc-b"}, false, false},
+		{"status=404", Request{Status: 404}, true, false},
+		{"status=404", Request{Status: 400}, false, false},
+		{"status=5xx", Request{Status: 503}, true, false},
+		{"status=5xx", Request{Status: 404}, false, false},
+		{"status=6xx", Request{}, false, true},
+		{"status=foo", Request{}, false, true},
+		{"sample=0.5", Request{}, true, false},
+		{"sample=0.1", Request{}, false, false},
+		{"sample=2", Request{}, false, true},
+	}
+
+	defer func(f func() float64) { randFloat = f }(randFloat)
+	randFloat = func() float64 { return 0.3 }
+
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		f, err := ParseFilter(q)
+		if got, want := err != nil, tt.err; got != want {
+			t.Fatalf("%s: got error %v want %v", tt.query, err, want)
+		}
+		if err != nil {
+			continue
+		}
+		if got, want := f.Match(&tt.req), tt.match; got != want {
+			t.Errorf("%s: got match %v want %v", tt.query, got, want)
+		}
+	}
+}
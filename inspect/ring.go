@@ -0,0 +1,112 @@
+// Package inspect provides a feed of the recently proxied
+// requests for debugging the routing.
+package inspect
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// Request contains the summary of a proxied request.
+type Request struct {
+	Time     time.Time `json:"time"`
+	Method   string    `json:"method"`
+	Host     string    `json:"host"`
+	Path     string    `json:"path"`
+	Route    string    `json:"route"`
+	Service  string    `json:"service"`
+	Target   string    `json:"target"`
+	Status   int       `json:"status"`
+	Duration float64   `json:"duration_ms"`
+}
+
+// Default is the ring buffer for the requests of the HTTP proxy.
+var Default = NewRing(1024)
+
+// Ring is a lock-free ring buffer of requests. Requests are only
+// recorded while there is at least one watcher. Watchers read the
+// requests with their own cursor and skip the requests which have
+// been overwritten before they could be read.
+type Ring struct {
+	slots    []unsafe.Pointer // *entry
+	next     uint64
+	watchers int32
+}
+
+type entry struct {
+	seq uint64
+	req Request
+}
+
+// NewRing creates a ring buffer for size requests.
+func NewRing(size int) *Ring {
+	if size <= 0 {
+		size = 1
+	}
+	return &Ring{slots: make([]unsafe.Pointer, size)}
+}
+
+// Watching returns true if there is at least one watcher. Callers
+// should check this before building the request to avoid any cost
+// when nobody is watching.
+func (r *Ring) Watching() bool {
+	return atomic.LoadInt32(&r.watchers) > 0
+}
+
+// Add records the request if there is at least one watcher.
+func (r *Ring) Add(req Request) {
+	if !r.Watching() {
+		return
+	}
+	seq := atomic.AddUint64(&r.next, 1) - 1
+	e := &entry{seq: seq, req: req}
+	atomic.StorePointer(&r.slots[seq%uint64(len(r.slots))], unsafe.Pointer(e))
+}
+
+// Watch registers a watcher and returns a cursor which reads the
+// requests which are added from now on. The cursor must be closed.
+func (r *Ring) Watch() *Cursor {
+	atomic.AddInt32(&r.watchers, 1)
+	return &Cursor{r: r, pos: atomic.LoadUint64(&r.next)}
+}
+
+// Cursor reads the requests of a ring buffer.
+type Cursor struct {
+	r    *Ring
+	pos  uint64
+	once sync.Once
+}
+
+// Next returns the requests which were added since the last call and
+// the number of requests which were overwritten before they could be
+// read.
+func (c *Cursor) Next() (reqs []Request, lost int) {
+	slots := c.r.slots
+	size := uint64(len(slots))
+	end := atomic.LoadUint64(&c.r.next)
+	if end-c.pos > size {
+		lost += int(end - size - c.pos)
+		c.pos = end - size
+	}
+	for ; c.pos < end; c.pos++ {
+		e := (*entry)(atomic.LoadPointer(&slots[c.pos%size]))
+		switch {
+		case e == nil || e.seq < c.pos:
+			// the writer has not stored the request yet.
+			// try again on the next call.
+			return reqs, lost
+		case e.seq > c.pos:
+			lost++
+		default:
+			reqs = append(reqs, e.req)
+		}
+	}
+	return reqs, lost
+}
+
+// Close unregisters the watcher.
+func (c *Cursor) Close() {
+	c.once.Do(func() { atomic.AddInt32(&c.r.watchers, -1) })
+}
@@ -0,0 +1,87 @@
+package inspect
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func paths(reqs []Request) []string {
+	var p []string
+	for _, r := range reqs {
+		p = append(p, r.Path)
+	}
+	return p
+}
+
+func TestRing(t *testing.T) {
+	r := NewRing(3)
+
+	// nobody is watching
+	r.Add(Request{Path: "/a"})
+	if r.Watching() {
+		t.Fatal("got watching want not watching")
+	}
+
+	c := r.Watch()
+	if reqs, lost := c.Next(); len(reqs) != 0 || lost != 0 {
+		t.Fatalf("got %v, %d want nothing", reqs, lost)
+	}
+
+	r.Add(Request{Path: "/b"})
+	r.Add(Request{Path: "/c"})
+	reqs, lost := c.Next()
+	if got, want := paths(reqs), []string{"/b", "/c"}; !reflect.DeepEqual(got, want) || lost != 0 {
+		t.Fatalf("got %v, %d want %v, 0", got, lost, want)
+	}
+
+	// overflow the buffer
+	for _, p := range []string{"/d", "/e", "/f", "/g", "/h"} {
+		r.Add(Request{Path: p})
+	}
+	reqs, lost = c.Next()
+	if got, want := paths(reqs), []string{"/f", "/g", "/h"}; !reflect.DeepEqual(got, want) || lost != 2 {
+		t.Fatalf("got %v, %d want %v, 2", got, lost, want)
+	}
+
+	c.Close()
+	c.Close()
+	if r.Watching() {
+		t.Fatal("got watching after close")
+	}
+}
+
+func TestRingConcurrent(t *testing.T) {
+	r := NewRing(64)
+	c := r.Watch()
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				r.Add(Request{Path: "/"})
+			}
+		}()
+	}
+
+	total := 0
+	done := make(chan struct{})
+	go func() { wg.Wait(); close(done) }()
+	for {
+		select {
+		case <-done:
+			reqs, lost := c.Next()
+			total += len(reqs) + lost
+			if total != 4000 {
+				t.Fatalf("got %d requests want 4000", total)
+			}
+			return
+		default:
+			reqs, lost := c.Next()
+			total += len(reqs) + lost
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package logger
+
+import (
+	"io"
+	"sync"
+)
+
+// Counter counts events. It is satisfied by metrics.Counter.
+type Counter interface {
+	Inc(n int64)
+}
+
+// AsyncWriter writes to an underlying writer from a separate go routine
+// through a bounded buffer so that a slow writer does not block the
+// caller. Writes are dropped and counted when the buffer is full.
+type AsyncWriter struct {
+	w       io.Writer
+	dropped Counter
+
+	mu     sync.RWMutex
+	closed bool
+	ch     chan []byte
+	done   chan struct{}
+}
+
+// NewAsyncWriter creates a writer which buffers up to size writes for w.
+// Dropped writes are counted in dropped if it is not nil.
+func NewAsyncWriter(w io.Writer, size int, dropped Counter) *AsyncWriter {
+	aw := &AsyncWriter{
+		w:       w,
+		dropped: dropped,
+		ch:      make(chan []byte, size),
+		done:    make(chan struct{}),
+	}
+	go aw.run()
+	return aw
+}
+
+func (aw *AsyncWriter) run() {
+	defer close(aw.done)
+	for p := range aw.ch {
+		aw.w.Write(p)
+	}
+}
+
+// Write queues a copy of p for writing. It never blocks and
+// always reports success since the caller may re-use p.
+func (aw *AsyncWriter) Write(p []byte) (int, error) {
+	aw.mu.RLock()
+	defer aw.mu.RUnlock()
+	if aw.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	b := make([]byte, len(p))
+	copy(b, p)
+	select {
+	case aw.ch <- b:
+	default:
+		if aw.dropped != nil {
+			aw.dropped.Inc(1)
+		}
+	}
+	return len(p), nil
+}
+
+// Close stops accepting new writes and waits until
+// the buffered writes have been written.
+func (aw *AsyncWriter) Close() error {
+	aw.mu.Lock()
+	if !aw.closed {
+		aw.closed = true
+		close(aw.ch)
+	}
+	aw.mu.Unlock()
+	<-aw.done
+	return nil
+}
@@ -0,0 +1,63 @@
+package logger
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+type count int64
+
+func (c *count) Inc(n int64) { atomic.AddInt64((*int64)(c), n) }
+
+// blockingWriter blocks all writes until it is released.
+type blockingWriter struct {
+	release chan struct{}
+	mu      sync.Mutex
+	b       bytes.Buffer
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.b.Write(p)
+}
+
+func TestAsyncWriter(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	var dropped count
+	aw := NewAsyncWriter(w, 2, &dropped)
+
+	// the first write is taken by the writer go routine which blocks.
+	// Fill the buffer and then overflow it.
+	buf := []byte("a\n")
+	for i := 0; i < 10; i++ {
+		if n, err := aw.Write(buf); err != nil || n != len(buf) {
+			t.Fatalf("got %d, %v want %d, nil", n, err, len(buf))
+		}
+		// the caller may re-use the buffer
+		buf[0]++
+	}
+
+	close(w.release)
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := bytes.Count(w.b.Bytes(), []byte("\n"))
+	if got, want := int64(lines)+int64(dropped), int64(10); got != want {
+		t.Fatalf("got %d written + %d dropped want %d", lines, dropped, want)
+	}
+	if lines < 2 || lines > 3 {
+		t.Fatalf("got %d lines want 2 or 3", lines)
+	}
+	if !bytes.HasPrefix(w.b.Bytes(), []byte("a\n")) {
+		t.Fatalf("got %q want prefix %q", w.b.String(), "a\n")
+	}
+
+	if _, err := aw.Write(buf); err == nil {
+		t.Fatal("write after close: got nil want error")
+	}
+}
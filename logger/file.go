@@ -0,0 +1,238 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the timestamp format of the rotated files.
+const backupTimeFormat = "20060102T150405.000"
+
+// RotatingFile is a file writer which rotates the file when it exceeds
+// a maximum size or after a fixed interval. Rotated files are renamed to
+// <path>.<timestamp> and optionally compressed with gzip. Only the newest
+// MaxBackups rotated files are kept.
+type RotatingFile struct {
+	// Path is the path of the log file.
+	Path string
+
+	// MaxSize is the maximum size of the file in bytes before it is
+	// rotated. Zero disables size based rotation.
+	MaxSize int64
+
+	// Interval is the rotation interval. The file is rotated at
+	// multiples of the interval, e.g. at midnight UTC for 24h.
+	// Zero disables time based rotation.
+	Interval time.Duration
+
+	// MaxBackups is the number of rotated files to keep.
+	// Zero keeps all files.
+	MaxBackups int
+
+	// Compress enables gzip compression of rotated files.
+	Compress bool
+
+	now func() time.Time
+
+	mu         sync.Mutex
+	f          *os.File
+	size       int64
+	nextRotate time.Time
+
+	// cleanup serializes the compression and removal of
+	// rotated files in the background and wg tracks the
+	// pending cleanups.
+	cleanup sync.Mutex
+	wg      sync.WaitGroup
+}
+
+// NewRotatingFile opens or creates the log file and appends to it.
+func NewRotatingFile(path string, maxSize int64, interval time.Duration, maxBackups int, compress bool) (*RotatingFile, error) {
+	if path == "" {
+		return nil, fmt.Errorf("file: missing path")
+	}
+	r := &RotatingFile{
+		Path:       path,
+		MaxSize:    maxSize,
+		Interval:   interval,
+		MaxBackups: maxBackups,
+		Compress:   compress,
+		now:        time.Now,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(r.Path), 0755); err != nil {
+		return fmt.Errorf("file: %s", err)
+	}
+	f, err := os.OpenFile(r.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("file: %s", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("file: %s", err)
+	}
+	r.f, r.size = f, fi.Size()
+	if r.Interval > 0 {
+		r.nextRotate = r.now().Truncate(r.Interval).Add(r.Interval)
+	}
+	return nil
+}
+
+// Write appends p to the file and rotates the file before if necessary.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+
+	sizeExceeded := r.MaxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.MaxSize
+	intervalPassed := r.Interval > 0 && !r.now().Before(r.nextRotate)
+	if sizeExceeded || intervalPassed {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current file and opens a new one.
+func (r *RotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return fmt.Errorf("file: %s", err)
+	}
+	r.f = nil
+
+	backup := r.Path + "." + r.now().UTC().Format(backupTimeFormat)
+	if err := os.Rename(r.Path, backup); err != nil {
+		return fmt.Errorf("file: %s", err)
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+
+	r.wg.Add(1)
+	go r.cleanupBackups(backup)
+	return nil
+}
+
+// cleanupBackups compresses the rotated file and removes
+// the oldest backups.
+func (r *RotatingFile) cleanupBackups(backup string) {
+	defer r.wg.Done()
+	r.cleanup.Lock()
+	defer r.cleanup.Unlock()
+
+	if r.Compress {
+		if err := compressFile(backup); err != nil {
+			log.Printf("[WARN] file: cannot compress %s. %s", backup, err)
+		}
+	}
+
+	if r.MaxBackups <= 0 {
+		return
+	}
+	backups, err := r.backups()
+	if err != nil {
+		log.Printf("[WARN] file: cannot list backups of %s. %s", r.Path, err)
+		return
+	}
+	for len(backups) > r.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			log.Printf("[WARN] file: cannot remove %s. %s", backups[0], err)
+		}
+		backups = backups[1:]
+	}
+}
+
+// backups returns the rotated files from oldest to newest.
+func (r *RotatingFile) backups() ([]string, error) {
+	dir, base := filepath.Split(r.Path)
+	if dir == "" {
+		dir = "."
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []string
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasPrefix(name, base+".") {
+			continue
+		}
+		ts := strings.TrimSuffix(strings.TrimPrefix(name, base+"."), ".gz")
+		if _, err := time.Parse(backupTimeFormat, ts); err != nil {
+			continue
+		}
+		backups = append(backups, filepath.Join(dir, name))
+	}
+	// the timestamps sort lexically
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// compressFile replaces path with a gzip compressed path.gz.
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Close closes the log file and waits for a pending
+// cleanup of rotated files.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	r.wg.Wait()
+	return err
+}
@@ -0,0 +1,121 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fabio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ls := func() []string {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name())
+		}
+		sort.Strings(names)
+		return names
+	}
+	read := func(name string) string {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	t.Run("size", func(t *testing.T) {
+		path := filepath.Join(dir, "size", "access.log")
+		r, err := NewRotatingFile(path, 10, 0, 2, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tm := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+		r.now = func() time.Time {
+			tm = tm.Add(time.Second)
+			return tm
+		}
+
+		for _, s := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n", "eeee\n", "ffff\n", "gggg\n"} {
+			if _, err := r.Write([]byte(s)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		dir := filepath.Dir(path)
+		files, _ := ioutil.ReadDir(dir)
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name())
+		}
+		want := []string{"access.log", "access.log.20160101T000002.000", "access.log.20160101T000003.000"}
+		if got := names; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got files %v want %v", got, want)
+		}
+		b, _ := ioutil.ReadFile(filepath.Join(dir, "access.log.20160101T000003.000"))
+		if got, want := string(b), "eeee\nffff\n"; got != want {
+			t.Fatalf("got %q want %q", got, want)
+		}
+		b, _ = ioutil.ReadFile(path)
+		if got, want := string(b), "gggg\n"; got != want {
+			t.Fatalf("got %q want %q", got, want)
+		}
+	})
+
+	t.Run("interval and compress", func(t *testing.T) {
+		path := filepath.Join(dir, "access.log")
+		tm := time.Date(2016, 1, 1, 23, 59, 0, 0, time.UTC)
+		r := &RotatingFile{Path: path, Interval: 24 * time.Hour, Compress: true, now: func() time.Time { return tm }}
+		if err := r.open(); err != nil {
+			t.Fatal(err)
+		}
+
+		r.Write([]byte("day 1\n"))
+		tm = tm.Add(2 * time.Minute)
+		r.Write([]byte("day 2\n"))
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := ls(), []string{"access.log", "access.log.20160102T000100.000.gz", "size"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got files %v want %v", got, want)
+		}
+		if got, want := read("access.log"), "day 2\n"; got != want {
+			t.Fatalf("got %q want %q", got, want)
+		}
+
+		f, err := os.Open(filepath.Join(dir, "access.log.20160102T000100.000.gz"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(gz)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(b), "day 1\n"; got != want {
+			t.Fatalf("got %q want %q", got, want)
+		}
+	})
+}
@@ -3,17 +3,34 @@ package logger
 import (
 	"fmt"
 	"io"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"sync/atomic"
 )
 
+// Subsystems contains the names of the subsystems which can have their
+// own log level. The subsystem of a log line is determined by the
+// top-level package of the code which writes it.
+var Subsystems = []string{"cert", "proxy", "registry", "route"}
+
+// modulePrefix is the prefix of the function names of the fabio packages.
+const modulePrefix = "github.com/fabiolb/fabio/"
+
 // LevelWriter implements a simplistic levelled log writer which supports
 // TRACE, DEBUG, INFO, WARN, ERROR and FATAL. The log level can be changed at
-// runtime.
+// runtime for all log lines or per subsystem.
 type LevelWriter struct {
 	w         io.Writer
 	level     atomic.Value // string
 	prefixLen int
+
+	// subsystems contains the levels of the subsystems which
+	// override the default level. It is replaced on every
+	// change and must not be modified.
+	subsystems atomic.Value // map[string]string
+	mu         sync.Mutex   // serializes subsystem updates
 }
 
 // NewLevelWriter creates a new leveled writer for the given output and a
@@ -23,6 +40,7 @@ type LevelWriter struct {
 // format and the spaces are relevant but not the date and time itself.
 func NewLevelWriter(w io.Writer, level, prefix string) *LevelWriter {
 	lw := &LevelWriter{w: w, prefixLen: len(prefix)}
+	lw.subsystems.Store(map[string]string{})
 	if !lw.SetLevel(level) {
 		panic(fmt.Sprintf("invalid log level %s", level))
 	}
@@ -40,8 +58,16 @@ func (w *LevelWriter) Write(b []byte) (int, error) {
 	level := rune(b[w.prefixLen+1]) // T, D, I, W, E, or F
 
 	// w.level contains the characters of all the allowed levels so we can just
-	// check whether the level character is in that set.
-	if strings.ContainsRune(w.level.Load().(string), level) {
+	// check whether the level character is in that set. Only look up the
+	// subsystem of the caller if there are subsystem levels since this
+	// requires walking the stack.
+	levels := w.level.Load().(string)
+	if m := w.subsystems.Load().(map[string]string); len(m) > 0 {
+		if l, ok := m[callerSubsystem()]; ok {
+			levels = l
+		}
+	}
+	if strings.ContainsRune(levels, level) {
 		return w.w.Write(b)
 	}
 	return 0, nil
@@ -50,35 +76,118 @@ func (w *LevelWriter) Write(b []byte) (int, error) {
 // SetLevel sets the log level to the new value and returns true
 // if that was successful.
 func (w *LevelWriter) SetLevel(s string) bool {
+	l, ok := parseLevel(s)
+	if ok {
+		w.level.Store(l)
+	}
+	return ok
+}
+
+// parseLevel returns the first characters of the allowed levels
+// for the level s.
+func parseLevel(s string) (string, bool) {
 	// levels contains the first character of the levels in descending order
 	const levels = "TDIWEF"
 	switch strings.ToUpper(s) {
 	case "TRACE":
-		w.level.Store(levels[0:])
-		return true
+		return levels[0:], true
 	case "DEBUG":
-		w.level.Store(levels[1:])
-		return true
+		return levels[1:], true
 	case "INFO":
-		w.level.Store(levels[2:])
-		return true
+		return levels[2:], true
 	case "WARN":
-		w.level.Store(levels[3:])
-		return true
+		return levels[3:], true
 	case "ERROR":
-		w.level.Store(levels[4:])
-		return true
+		return levels[4:], true
 	case "FATAL":
-		w.level.Store(levels[5:])
-		return true
+		return levels[5:], true
 	default:
-		return false
+		return "", false
 	}
 }
 
 // Level returns the current log level.
 func (w *LevelWriter) Level() string {
-	l := w.level.Load().(string)
+	return levelName(w.level.Load().(string))
+}
+
+// SetSubsystemLevel sets the log level of a subsystem and returns true
+// if that was successful. An empty level removes the subsystem level
+// and the subsystem uses the default level again.
+func (w *LevelWriter) SetSubsystemLevel(subsystem, s string) bool {
+	if !IsSubsystem(subsystem) {
+		return false
+	}
+	var l string
+	if s != "" {
+		var ok bool
+		if l, ok = parseLevel(s); !ok {
+			return false
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	m := map[string]string{}
+	for k, v := range w.subsystems.Load().(map[string]string) {
+		m[k] = v
+	}
+	if l == "" {
+		delete(m, subsystem)
+	} else {
+		m[subsystem] = l
+	}
+	w.subsystems.Store(m)
+	return true
+}
+
+// SubsystemLevels returns the levels of the subsystems
+// which have their own log level.
+func (w *LevelWriter) SubsystemLevels() map[string]string {
+	m := map[string]string{}
+	for k, v := range w.subsystems.Load().(map[string]string) {
+		m[k] = levelName(v)
+	}
+	return m
+}
+
+// IsSubsystem returns true if s is a valid subsystem name.
+func IsSubsystem(s string) bool {
+	i := sort.SearchStrings(Subsystems, s)
+	return i < len(Subsystems) && Subsystems[i] == s
+}
+
+// callerSubsystem returns the subsystem of the first caller outside
+// of the log and logger packages or an empty string.
+func callerSubsystem() string {
+	pcs := make([]uintptr, 16)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		f, more := frames.Next()
+		if !strings.HasPrefix(f.Function, "log.") && !strings.HasPrefix(f.Function, modulePrefix+"logger.") {
+			return subsystem(f.Function)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// subsystem returns the top-level fabio package of the
+// fully qualified function name fn or an empty string.
+func subsystem(fn string) string {
+	if !strings.HasPrefix(fn, modulePrefix) {
+		return ""
+	}
+	pkg := fn[len(modulePrefix):]
+	if i := strings.IndexAny(pkg, "/."); i >= 0 {
+		pkg = pkg[:i]
+	}
+	return pkg
+}
+
+func levelName(l string) string {
 	switch l[0] {
 	case 'T':
 		return "TRACE"
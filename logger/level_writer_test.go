@@ -44,3 +44,52 @@ func TestLevelWriter(t *testing.T) {
 		})
 	}
 }
+
+func TestLevelWriterSubsystems(t *testing.T) {
+	var b bytes.Buffer
+	w := NewLevelWriter(&b, "INFO", "2017/01/01 00:00:00 ")
+
+	if w.SetSubsystemLevel("foo", "DEBUG") {
+		t.Fatal("SetSubsystemLevel accepted invalid subsystem")
+	}
+	if w.SetSubsystemLevel("registry", "LOUD") {
+		t.Fatal("SetSubsystemLevel accepted invalid level")
+	}
+	if !w.SetSubsystemLevel("registry", "trace") || !w.SetSubsystemLevel("proxy", "ERROR") {
+		t.Fatal("SetSubsystemLevel failed")
+	}
+	if got, want := w.SubsystemLevels(), map[string]string{"registry": "TRACE", "proxy": "ERROR"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if !w.SetSubsystemLevel("proxy", "") {
+		t.Fatal("SetSubsystemLevel failed")
+	}
+	if got, want := w.SubsystemLevels(), map[string]string{"registry": "TRACE"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	// log lines from outside of a subsystem use the default level
+	w.Write([]byte("2017/01/01 00:00:00 [DEBUG] a\n"))
+	w.Write([]byte("2017/01/01 00:00:00 [INFO] b\n"))
+	if got, want := b.String(), "2017/01/01 00:00:00 [INFO] b\n"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
+func TestSubsystem(t *testing.T) {
+	tests := []struct {
+		fn, sub string
+	}{
+		{"github.com/fabiolb/fabio/registry/consul.(*be).WatchServices", "registry"},
+		{"github.com/fabiolb/fabio/proxy/tcp.(*Proxy).ServeTCP", "proxy"},
+		{"github.com/fabiolb/fabio/cert.NewSource", "cert"},
+		{"github.com/fabiolb/fabio/route.(*Route).addTarget", "route"},
+		{"main.main", ""},
+		{"net/http.(*Server).Serve", ""},
+	}
+	for _, tt := range tests {
+		if got, want := subsystem(tt.fn), tt.sub; got != want {
+			t.Errorf("%s: got %q want %q", tt.fn, got, want)
+		}
+	}
+}
@@ -3,39 +3,60 @@
 // The access log format is defined through a format string which expands to a
 // log line per request. The values are taken as is and no quoting or escaping
 // takes place. Text between two fields is printed verbatim. See the common
-// log file formats for an example.
+// log file formats for an example. The 'json' and 'logfmt' formats write the
+// fields with proper escaping as JSON object or as logfmt key/value pairs.
+// See JSONFormat for details.
 //
-//   $header.<name>           - request http header (name: [a-zA-Z0-9-]+)
-//   $remote_addr             - host:port of remote client
-//   $remote_host             - host of remote client
-//   $remote_port             - port of remote client
-//   $request                 - request <method> <uri> <proto>
-//   $request_args            - request query parameters
-//   $request_host            - request host header (aka server name)
-//   $request_method          - request method
-//   $request_scheme          - request scheme
-//   $request_uri             - request URI
-//   $request_url             - request URL
-//   $request_proto           - request protocol
-//   $response_body_size      - response body size in bytes
-//   $response_status         - response status code
-//   $response_time_ms        - response time in S.sss format
-//   $response_time_us        - response time in S.ssssss format
-//   $response_time_ns        - response time in S.sssssssss format
-//   $time_rfc3339            - log timestamp in YYYY-MM-DDTHH:MM:SSZ format
-//   $time_rfc3339_ms         - log timestamp in YYYY-MM-DDTHH:MM:SS.sssZ format
-//   $time_rfc3339_us         - log timestamp in YYYY-MM-DDTHH:MM:SS.ssssssZ format
-//   $time_rfc3339_ns         - log timestamp in YYYY-MM-DDTHH:MM:SS.sssssssssZ format
-//   $time_unix_ms            - log timestamp in unix epoch ms
-//   $time_unix_us            - log timestamp in unix epoch us
-//   $time_unix_ns            - log timestamp in unix epoch ns
-//   $time_common             - log timestamp in DD/MMM/YYYY:HH:MM:SS -ZZZZ
-//   $upstream_addr           - host:port of upstream server
-//   $upstream_host           - host of upstream server
-//   $upstream_port           - port of upstream server
-//   $upstream_request_scheme - upstream request scheme
-//   $upstream_request_uri    - upstream request URI
-//   $upstream_request_url    - upstream request URL
+//   $bytes_received            - bytes received from the client
+//   $bytes_sent                - bytes sent to the client
+//   $cache_status              - response cache status, e.g. HIT, MISS
+//   $close_reason              - reason why a TCP connection was closed or an HTTP request was aborted
+//   $grpc_method               - full method name of a gRPC call
+//   $grpc_status               - status code of a gRPC call, e.g. OK
+//   $header.<name>             - request http header (name: [a-zA-Z0-9-]+)
+//   $proto                     - protocol of the listener, e.g. http, tcp+sni, grpc
+//   $remote_addr               - host:port of remote client
+//   $remote_host               - host of remote client
+//   $remote_port               - port of remote client
+//   $request                   - request <method> <uri> <proto>
+//   $request_args              - request query parameters
+//   $request_body_size         - request body size in bytes
+//   $request_host              - request host header (aka server name)
+//   $request_id                - request id (see proxy.header.requestid)
+//   $request_method            - request method
+//   $request_scheme            - request scheme
+//   $request_uri               - request URI
+//   $request_url               - request URL
+//   $request_proto             - request protocol
+//   $response_body_size        - response body size in bytes
+//   $response_status           - response status code
+//   $response_time_ms          - total response time in S.sss format
+//   $response_time_us          - total response time in S.ssssss format
+//   $response_time_ns          - total response time in S.sssssssss format
+//   $route                     - host and path of the matched route
+//   $target_weight             - weight of the selected target
+//   $tls_cipher                - TLS cipher suite of the client connection
+//   $tls_client_subject        - subject of the client certificate
+//   $tls_sni                   - TLS server name (SNI) sent by the client
+//   $tls_version               - TLS version of the client connection (e.g. TLSv1.3)
+//   $time_rfc3339              - log timestamp in YYYY-MM-DDTHH:MM:SSZ format
+//   $time_rfc3339_ms           - log timestamp in YYYY-MM-DDTHH:MM:SS.sssZ format
+//   $time_rfc3339_us           - log timestamp in YYYY-MM-DDTHH:MM:SS.ssssssZ format
+//   $time_rfc3339_ns           - log timestamp in YYYY-MM-DDTHH:MM:SS.sssssssssZ format
+//   $time_unix_ms              - log timestamp in unix epoch ms
+//   $time_unix_us              - log timestamp in unix epoch us
+//   $time_unix_ns              - log timestamp in unix epoch ns
+//   $time_common               - log timestamp in DD/MMM/YYYY:HH:MM:SS -ZZZZ
+//   $upstream_addr             - host:port of upstream server
+//   $upstream_host             - host of upstream server
+//   $upstream_port             - port of upstream server
+//   $upstream_request_scheme   - upstream request scheme
+//   $upstream_request_uri      - upstream request URI
+//   $upstream_request_url      - upstream request URL
+//   $upstream_response_time_ms - upstream response time in S.sss format
+//   $upstream_response_time_us - upstream response time in S.ssssss format
+//   $upstream_response_time_ns - upstream response time in S.sssssssss format
+//   $upstream_service          - name of the upstream service
 //
 package logger
 
@@ -86,6 +107,58 @@ type Event struct {
 	// UpstreamURL is the URL which was sent to the upstream server.
 	// It should only be set for HTTP log events.
 	UpstreamURL *url.URL
+
+	// UpstreamTime is the time the upstream server took to respond.
+	UpstreamTime time.Duration
+
+	// RequestID is the value of the request id header.
+	RequestID string
+
+	// RequestBodySize is the number of bytes read from the request body.
+	RequestBodySize int64
+
+	// Route is the host and path of the matched route, e.g. "example.com/foo".
+	Route string
+
+	// TargetWeight is the weight of the upstream target between 0 and 1.
+	TargetWeight float64
+
+	// Proto is the protocol of the listener, e.g. "http", "tcp+sni"
+	// or "grpc".
+	Proto string
+
+	// RemoteAddr is the address of the client. It is only used
+	// when Request is nil, e.g. for TCP and gRPC log events.
+	RemoteAddr string
+
+	// ServerName is the server name from the TLS client hello
+	// of a TCP+SNI connection.
+	ServerName string
+
+	// BytesReceived is the number of bytes received from the client.
+	BytesReceived int64
+
+	// BytesSent is the number of bytes sent to the client.
+	BytesSent int64
+
+	// CloseReason describes why a TCP connection was closed or
+	// why an HTTP request was aborted.
+	CloseReason string
+
+	// GRPCMethod is the full method name of a gRPC call.
+	GRPCMethod string
+
+	// GRPCStatus is the status code of a gRPC call, e.g. "OK".
+	GRPCStatus string
+
+	// CacheStatus is the status of the response cache for the
+	// request, e.g. "HIT" or "MISS". It is empty if the route
+	// has no cache.
+	CacheStatus string
+
+	// SampleRules are the sample rules of the route. If nil
+	// the default rules of the sampler are used.
+	SampleRules SampleRules
 }
 
 // Logger logs an event.
@@ -100,10 +173,16 @@ func New(w io.Writer, format string) (Logger, error) {
 	if w == nil {
 		return &noopLogger{}, nil
 	}
-	p, err := parse(format, fields)
+	p, ok, err := parseStructured(format, fields)
 	if err != nil {
 		return nil, err
 	}
+	if !ok {
+		p, err = parse(format, fields)
+		if err != nil {
+			return nil, err
+		}
+	}
 	if len(p) == 0 {
 		return nil, errors.New("empty log format")
 	}
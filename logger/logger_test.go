@@ -2,6 +2,9 @@ package logger
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -70,6 +73,14 @@ func TestLog(t *testing.T) {
 			URL:        rurl,
 			Method:     "GET",
 			Proto:      "HTTP/1.1",
+			TLS: &tls.ConnectionState{
+				Version:     tls.VersionTLS12,
+				CipherSuite: tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+				ServerName:  "foo.com",
+				PeerCertificates: []*x509.Certificate{
+					{Subject: pkix.Name{CommonName: "client", Organization: []string{"acme"}}},
+				},
+			},
 		},
 		Response: &http.Response{
 			StatusCode:    200,
@@ -83,12 +94,19 @@ func TestLog(t *testing.T) {
 		UpstreamAddr:    uurl.Host,
 		UpstreamService: "svc-a",
 		UpstreamURL:     uurl,
+		UpstreamTime:    23456789 * time.Nanosecond,
+		RequestID:       "req-1",
+		RequestBodySize: 42,
+		Route:           "foo.com/",
+		TargetWeight:    0.25,
+		CacheStatus:     "HIT",
 	}
 
 	tests := []struct {
 		format string
 		out    string
 	}{
+		{"$cache_status", "HIT\n"},
 		{"$header.Referer", "http://foo.com/\n"},
 		{"$header.X-Forwarded-For", "3.3.3.3\n"},
 		{"$header.user-agent", "Mozilla Firefox\n"},
@@ -97,7 +115,9 @@ func TestLog(t *testing.T) {
 		{"$remote_port", "666\n"},
 		{"$request", "GET /?q=x HTTP/1.1\n"},
 		{"$request_args", "q=x\n"},
+		{"$request_body_size", "42\n"},
 		{"$request_host", "foo.com\n"}, // TODO(fs): is this correct?
+		{"$request_id", "req-1\n"},
 		{"$request_method", "GET\n"},
 		{"$request_proto", "HTTP/1.1\n"},
 		{"$request_scheme", "http\n"},
@@ -108,6 +128,8 @@ func TestLog(t *testing.T) {
 		{"$response_time_ms", "0.123\n"},       // TODO(fs): is this correct?
 		{"$response_time_ns", "0.123456789\n"}, // TODO(fs): is this correct?
 		{"$response_time_us", "0.123456\n"},    // TODO(fs): is this correct?
+		{"$route", "foo.com/\n"},
+		{"$target_weight", "0.25\n"},
 		{"$time_common", "01/Jan/2016:00:00:00 +0000\n"},
 		{"$time_rfc3339", "2016-01-01T00:00:00Z\n"},
 		{"$time_rfc3339_ms", "2016-01-01T00:00:00.123Z\n"},
@@ -116,12 +138,19 @@ func TestLog(t *testing.T) {
 		{"$time_unix_ms", "1451606400123\n"},
 		{"$time_unix_ns", "1451606400123456789\n"},
 		{"$time_unix_us", "1451606400123456\n"},
+		{"$tls_cipher", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256\n"},
+		{"$tls_client_subject", "CN=client,O=acme\n"},
+		{"$tls_sni", "foo.com\n"},
+		{"$tls_version", "TLSv1.2\n"},
 		{"$upstream_addr", "7.8.9.0:5678\n"},
 		{"$upstream_host", "7.8.9.0\n"},
 		{"$upstream_port", "5678\n"},
 		{"$upstream_request_scheme", "http\n"},
 		{"$upstream_request_uri", "/foo?q=x\n"},
 		{"$upstream_request_url", "http://7.8.9.0:5678/foo?q=x\n"},
+		{"$upstream_response_time_ms", "0.023\n"},
+		{"$upstream_response_time_ns", "0.023456789\n"},
+		{"$upstream_response_time_us", "0.023456\n"},
 		{"$upstream_service", "svc-a\n"},
 	}
 
@@ -142,6 +171,170 @@ func TestLog(t *testing.T) {
 	}
 }
 
+func TestLogConn(t *testing.T) {
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	e := &Event{
+		Start:           start,
+		End:             start.Add(1500 * time.Millisecond),
+		Proto:           "tcp+sni",
+		RemoteAddr:      "2.2.2.2:666",
+		ServerName:      "foo.com",
+		BytesReceived:   123,
+		BytesSent:       4567,
+		CloseReason:     "client closed",
+		UpstreamAddr:    "7.8.9.0:5678",
+		UpstreamService: "svc-a",
+		GRPCMethod:      "/foo.Bar/Baz",
+		GRPCStatus:      "NotFound",
+	}
+
+	tests := []struct {
+		format string
+		out    string
+	}{
+		{"$proto", "tcp+sni\n"},
+		{"$remote_addr", "2.2.2.2:666\n"},
+		{"$remote_host", "2.2.2.2\n"},
+		{"$remote_port", "666\n"},
+		{"$tls_sni", "foo.com\n"},
+		{"$bytes_received", "123\n"},
+		{"$bytes_sent", "4567\n"},
+		{"$close_reason", "client closed\n"},
+		{"$grpc_method", "/foo.Bar/Baz\n"},
+		{"$grpc_status", "NotFound\n"},
+		{"$response_time_ms", "1.500\n"},
+		{"$request $response_status", " \n"},
+		{
+			"json:proto,remote_addr,tls_sni,bytes_received,bytes_sent,close_reason",
+			`{"proto":"tcp+sni","remote_addr":"2.2.2.2:666","tls_sni":"foo.com","bytes_received":123,"bytes_sent":4567,"close_reason":"client closed"}` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			b := new(bytes.Buffer)
+			l, err := New(b, tt.format)
+			if err != nil {
+				t.Fatalf("got %v want nil", err)
+			}
+			l.Log(e)
+			if got, want := b.String(), tt.out; got != want {
+				t.Errorf("got %q want %q", got, want)
+			}
+		})
+	}
+}
+
+// TestLogEmptyEvent verifies that all fields can handle events
+// without request and response, e.g. for TCP connections.
+func TestLogEmptyEvent(t *testing.T) {
+	for _, f := range append(Fields, "$header.X-Foo", "json", "logfmt") {
+		l, err := New(ioutil.Discard, f)
+		if err != nil {
+			t.Fatalf("%s: got %v want nil", f, err)
+		}
+		l.Log(&Event{})
+	}
+}
+
+func TestStructured(t *testing.T) {
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	e := &Event{
+		Start: start,
+		End:   start.Add(123456789 * time.Nanosecond),
+		Request: &http.Request{
+			Header: http.Header{
+				"User-Agent": {`Mozilla "Firefox"`},
+			},
+			RemoteAddr: "2.2.2.2:666",
+			Host:       "foo.com",
+			Method:     "GET",
+			RequestURI: "/a\tb",
+			URL:        mustParse("http://foo.com/"),
+		},
+		Response: &http.Response{
+			StatusCode:    200,
+			ContentLength: 1234,
+		},
+		TargetWeight: 0.5,
+	}
+
+	tests := []struct {
+		format string
+		out    string
+		err    string
+	}{
+		{
+			format: "json:request_method,request_host,response_status,response_time_ms,target_weight,request_body_size,header.User-Agent",
+			out:    `{"request_method":"GET","request_host":"foo.com","response_status":200,"response_time_ms":0.123,"target_weight":0.5,"request_body_size":0,"header.User-Agent":"Mozilla \"Firefox\""}` + "\n",
+		},
+		{
+			format: "json:$request_id,upstream_response_time_ms",
+			out:    `{"request_id":"","upstream_response_time_ms":0.000}` + "\n",
+		},
+		{
+			format: "json:request_uri",
+			out:    `{"request_uri":"/a\tb"}` + "\n",
+		},
+		{
+			format: "logfmt:request_method, response_status, request_id, header.User-Agent",
+			out:    `request_method=GET response_status=200 request_id="" header.User-Agent="Mozilla \"Firefox\""` + "\n",
+		},
+		{format: "json:", out: ""},
+		{format: "json:foo", err: `invalid field "foo"`},
+		{format: "logfmt:header.", err: `invalid field "header."`},
+		{format: "json:a b", err: `invalid field "a b"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			b := new(bytes.Buffer)
+			l, err := New(b, tt.format)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got %v want nil", err)
+			}
+			l.Log(e)
+			if tt.out == "" {
+				// default fields
+				if got := b.String(); !strings.HasPrefix(got, `{"time_rfc3339_ms":"2016-01-01T00:00:00.123Z","proto":"","remote_addr":"2.2.2.2:666"`) {
+					t.Fatalf("got %q", got)
+				}
+				return
+			}
+			if got, want := b.String(), tt.out; got != want {
+				t.Errorf("got %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestJSONEscape(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"", ""},
+		{"abc", "abc"},
+		{`a"b\c`, `a\"b\\c`},
+		{"a\nb\rc\td", `a\nb\rc\td`},
+		{"\x01\x7f", `\u0001\u007f`},
+		{"äöü", "äöü"},
+		{"a\xffb", `a\ufffdb`},
+	}
+	for _, tt := range tests {
+		var b bytes.Buffer
+		jsonEscape(&b, []byte(tt.in))
+		if got, want := b.String(), tt.out; got != want {
+			t.Errorf("%q: got %q want %q", tt.in, got, want)
+		}
+	}
+}
+
 func TestAtoi(t *testing.T) {
 	tests := []struct {
 		i   int64
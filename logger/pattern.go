@@ -2,8 +2,10 @@ package logger
 
 import (
 	"bytes"
+	"crypto/tls"
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -41,25 +43,37 @@ type field func(b *bytes.Buffer, e *Event)
 // alloc.
 var fields = map[string]field{
 	"$remote_addr": func(b *bytes.Buffer, e *Event) {
-		if e.Request == nil {
-			return
-		}
-		b.WriteString(e.Request.RemoteAddr)
+		b.WriteString(remoteAddr(e))
 	},
 	"$remote_host": func(b *bytes.Buffer, e *Event) {
-		if e.Request == nil {
-			return
-		}
-		host, _ := hostport(e.Request.RemoteAddr)
+		host, _ := hostport(remoteAddr(e))
 		b.WriteString(host)
 	},
 	"$remote_port": func(b *bytes.Buffer, e *Event) {
-		if e.Request == nil {
-			return
-		}
-		_, port := hostport(e.Request.RemoteAddr)
+		_, port := hostport(remoteAddr(e))
 		b.WriteString(port)
 	},
+	"$proto": func(b *bytes.Buffer, e *Event) {
+		b.WriteString(e.Proto)
+	},
+	"$bytes_received": func(b *bytes.Buffer, e *Event) {
+		atoi(b, e.BytesReceived, 0)
+	},
+	"$bytes_sent": func(b *bytes.Buffer, e *Event) {
+		atoi(b, e.BytesSent, 0)
+	},
+	"$cache_status": func(b *bytes.Buffer, e *Event) {
+		b.WriteString(e.CacheStatus)
+	},
+	"$close_reason": func(b *bytes.Buffer, e *Event) {
+		b.WriteString(e.CloseReason)
+	},
+	"$grpc_method": func(b *bytes.Buffer, e *Event) {
+		b.WriteString(e.GRPCMethod)
+	},
+	"$grpc_status": func(b *bytes.Buffer, e *Event) {
+		b.WriteString(e.GRPCStatus)
+	},
 	"$request": func(b *bytes.Buffer, e *Event) {
 		if e.Request == nil {
 			return
@@ -116,31 +130,75 @@ var fields = map[string]field{
 		b.WriteString(e.Request.Proto)
 	},
 	"$response_body_size": func(b *bytes.Buffer, e *Event) {
+		if e.Response == nil {
+			return
+		}
 		atoi(b, e.Response.ContentLength, 0)
 	},
 	"$response_status": func(b *bytes.Buffer, e *Event) {
+		if e.Response == nil {
+			return
+		}
 		atoi(b, int64(e.Response.StatusCode), 0)
 	},
 	"$response_time_ms": func(b *bytes.Buffer, e *Event) {
-		d := e.End.Sub(e.Start).Nanoseconds()
-		s, µs := d/int64(time.Second), d%int64(time.Second)/int64(time.Millisecond)
-		atoi(b, s, 0)
-		b.WriteRune('.')
-		atoi(b, µs, 3)
+		duration(b, e.End.Sub(e.Start), time.Millisecond, 3)
 	},
 	"$response_time_us": func(b *bytes.Buffer, e *Event) {
-		d := e.End.Sub(e.Start).Nanoseconds()
-		s, µs := d/int64(time.Second), d%int64(time.Second)/int64(time.Microsecond)
-		atoi(b, s, 0)
-		b.WriteRune('.')
-		atoi(b, µs, 6)
+		duration(b, e.End.Sub(e.Start), time.Microsecond, 6)
 	},
 	"$response_time_ns": func(b *bytes.Buffer, e *Event) {
-		d := e.End.Sub(e.Start).Nanoseconds()
-		s, ns := d/int64(time.Second), d%int64(time.Second)/int64(time.Nanosecond)
-		atoi(b, s, 0)
-		b.WriteRune('.')
-		atoi(b, ns, 9)
+		duration(b, e.End.Sub(e.Start), time.Nanosecond, 9)
+	},
+	"$upstream_response_time_ms": func(b *bytes.Buffer, e *Event) {
+		duration(b, e.UpstreamTime, time.Millisecond, 3)
+	},
+	"$upstream_response_time_us": func(b *bytes.Buffer, e *Event) {
+		duration(b, e.UpstreamTime, time.Microsecond, 6)
+	},
+	"$upstream_response_time_ns": func(b *bytes.Buffer, e *Event) {
+		duration(b, e.UpstreamTime, time.Nanosecond, 9)
+	},
+	"$request_id": func(b *bytes.Buffer, e *Event) {
+		b.WriteString(e.RequestID)
+	},
+	"$request_body_size": func(b *bytes.Buffer, e *Event) {
+		atoi(b, e.RequestBodySize, 0)
+	},
+	"$route": func(b *bytes.Buffer, e *Event) {
+		b.WriteString(e.Route)
+	},
+	"$target_weight": func(b *bytes.Buffer, e *Event) {
+		var d [32]byte
+		b.Write(strconv.AppendFloat(d[:0], e.TargetWeight, 'f', -1, 64))
+	},
+	"$tls_version": func(b *bytes.Buffer, e *Event) {
+		if e.Request == nil || e.Request.TLS == nil {
+			return
+		}
+		b.WriteString(tlsVersions[e.Request.TLS.Version])
+	},
+	"$tls_cipher": func(b *bytes.Buffer, e *Event) {
+		if e.Request == nil || e.Request.TLS == nil {
+			return
+		}
+		b.WriteString(tls.CipherSuiteName(e.Request.TLS.CipherSuite))
+	},
+	"$tls_sni": func(b *bytes.Buffer, e *Event) {
+		if e.ServerName != "" {
+			b.WriteString(e.ServerName)
+			return
+		}
+		if e.Request == nil || e.Request.TLS == nil {
+			return
+		}
+		b.WriteString(e.Request.TLS.ServerName)
+	},
+	"$tls_client_subject": func(b *bytes.Buffer, e *Event) {
+		if e.Request == nil || e.Request.TLS == nil || len(e.Request.TLS.PeerCertificates) == 0 {
+			return
+		}
+		b.WriteString(e.Request.TLS.PeerCertificates[0].Subject.String())
 	},
 	"$time_unix_ms": func(b *bytes.Buffer, e *Event) {
 		atoi(b, e.End.UnixNano()/int64(time.Millisecond), 0)
@@ -261,6 +319,13 @@ var fields = map[string]field{
 	},
 }
 
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLSv1.0",
+	tls.VersionTLS11: "TLSv1.1",
+	tls.VersionTLS12: "TLSv1.2",
+	tls.VersionTLS13: "TLSv1.3",
+}
+
 var shortMonthNames = []string{
 	"---",
 	"Jan",
@@ -289,6 +354,24 @@ func hostport(s string) (host, port string) {
 	return s[:n], s[n+1:]
 }
 
+// remoteAddr returns the address of the client from the
+// request or the connection.
+func remoteAddr(e *Event) string {
+	if e.Request != nil {
+		return e.Request.RemoteAddr
+	}
+	return e.RemoteAddr
+}
+
+// duration writes d in seconds with 'digits' fractional digits
+// of the given unit.
+func duration(b *bytes.Buffer, d, unit time.Duration, digits int) {
+	ns := d.Nanoseconds()
+	atoi(b, ns/int64(time.Second), 0)
+	b.WriteRune('.')
+	atoi(b, ns%int64(time.Second)/int64(unit), digits)
+}
+
 // atoi is a replacement for strconv.Atoi/strconv.FormatInt
 // which does not alloc.
 func atoi(b *bytes.Buffer, i int64, pad int) {
@@ -338,16 +421,6 @@ func parse(format string, fields map[string]field) (p pattern, err error) {
 		}
 	}
 
-	// header is a helper to add an HTTP header to the log output.
-	header := func(name string) field {
-		return func(b *bytes.Buffer, e *Event) {
-			if e.Request == nil || e.Request.Header == nil {
-				return
-			}
-			b.WriteString(e.Request.Header.Get(name))
-		}
-	}
-
 	s := []rune(format)
 	for {
 		if len(s) == 0 {
@@ -372,6 +445,16 @@ func parse(format string, fields map[string]field) (p pattern, err error) {
 	return p, nil
 }
 
+// header returns a field which renders the HTTP request header 'name'.
+func header(name string) field {
+	return func(b *bytes.Buffer, e *Event) {
+		if e.Request == nil || e.Request.Header == nil {
+			return
+		}
+		b.WriteString(e.Request.Header.Get(name))
+	}
+}
+
 type itemType int
 
 const (
@@ -403,11 +486,12 @@ const (
 	stateHeader
 )
 
-func lex(s []rune) (typ itemType, n int) {
-	isIDChar := func(r rune) bool {
-		return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '_' || r == '-'
-	}
+// isIDChar returns true if r is valid in a field name.
+func isIDChar(r rune) bool {
+	return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '_' || r == '-'
+}
 
+func lex(s []rune) (typ itemType, n int) {
 	state := stateStart
 	for i, r := range s {
 		switch state {
@@ -0,0 +1,174 @@
+package logger
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// SampleRules define which fraction of the log events is written
+// depending on the response status code. The rules are written as a
+// comma separated list of <status>:<rate> pairs where <status> is either
+// a status code like '404', a status class like '5xx' or '*' for all
+// other events. The rate is a number between 0 and 1 or a percentage,
+// e.g. '0.01' or '1%'. Exact codes take precedence over status classes
+// which take precedence over '*'. Events which do not match any rule
+// are always written. The value 'off' disables logging.
+//
+// Examples:
+//
+//	5xx:1,*:0       only log server errors
+//	2xx:1%          log 1% of the successful requests and all others
+//	off             log nothing
+//
+// gRPC calls and TCP connections have no HTTP status code. They match
+// the rules for 200 if the call returned OK or the connection was
+// closed by one of the peers and the rules for 500 otherwise.
+type SampleRules []SampleRule
+
+// SampleRule defines the sampling rate for a status code or class.
+type SampleRule struct {
+	// Code is the exact status code or zero.
+	Code int
+
+	// Class is the status class (1-5) or zero.
+	Class int
+
+	// Rate is the fraction of events which are written.
+	Rate float64
+}
+
+// ParseSampleRules parses the sample rules from s. An empty string
+// returns nil rules which write all events.
+func ParseSampleRules(s string) (SampleRules, error) {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "":
+		return nil, nil
+	case "off":
+		return SampleRules{{Rate: 0}}, nil
+	}
+
+	var rules SampleRules
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		i := strings.IndexByte(p, ':')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid sample rule %q", p)
+		}
+		status, rate := p[:i], p[i+1:]
+
+		var r SampleRule
+		switch {
+		case status == "*":
+		case len(status) == 3 && strings.HasSuffix(status, "xx") && status[0] >= '1' && status[0] <= '5':
+			r.Class = int(status[0] - '0')
+		default:
+			code, err := strconv.Atoi(status)
+			if err != nil || code < 100 || code > 599 {
+				return nil, fmt.Errorf("invalid status %q in sample rule %q", status, p)
+			}
+			r.Code = code
+		}
+
+		pct := strings.HasSuffix(rate, "%")
+		f, err := strconv.ParseFloat(strings.TrimSuffix(rate, "%"), 64)
+		if pct {
+			f /= 100
+		}
+		if err != nil || f < 0 || f > 1 {
+			return nil, fmt.Errorf("invalid rate %q in sample rule %q", rate, p)
+		}
+		r.Rate = f
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// Close reasons of TCP connections which were closed by one of the
+// peers.
+const (
+	CloseReasonClientClosed   = "client closed"
+	CloseReasonUpstreamClosed = "upstream closed"
+)
+
+// sampleStatus returns the status code of the event which is matched
+// against the sample rules. See SampleRules.
+func sampleStatus(e *Event) int {
+	switch {
+	case e.Response != nil:
+		return e.Response.StatusCode
+	case e.GRPCStatus != "":
+		if e.GRPCStatus == "OK" {
+			return 200
+		}
+		return 500
+	case e.CloseReason != "":
+		if e.CloseReason == CloseReasonClientClosed || e.CloseReason == CloseReasonUpstreamClosed {
+			return 200
+		}
+		return 500
+	default:
+		return 0
+	}
+}
+
+// randFloat returns a random number in [0, 1).
+var randFloat = rand.Float64
+
+// Keep returns true if an event with the given status code should be
+// written. The status code is zero for events without a response.
+func (rules SampleRules) Keep(status int) bool {
+	rate, prio := 1.0, -1
+	for _, r := range rules {
+		var p int
+		switch {
+		case r.Code != 0 && r.Code == status:
+			p = 2
+		case r.Class != 0 && r.Class == status/100:
+			p = 1
+		case r.Code == 0 && r.Class == 0:
+			p = 0
+		default:
+			continue
+		}
+		if p > prio {
+			rate, prio = r.Rate, p
+		}
+	}
+	switch {
+	case rate >= 1:
+		return true
+	case rate <= 0:
+		return false
+	default:
+		return randFloat() < rate
+	}
+}
+
+// NewSampler returns a logger which writes the events to l according
+// to the sample rules of the event. If the event has no sample rules
+// the default rules are used.
+func NewSampler(l Logger, rules SampleRules) Logger {
+	return &sampler{l: l, rules: rules}
+}
+
+type sampler struct {
+	l     Logger
+	rules SampleRules
+}
+
+func (s *sampler) Log(e *Event) {
+	rules := e.SampleRules
+	if rules == nil {
+		rules = s.rules
+	}
+	if !rules.Keep(sampleStatus(e)) {
+		return
+	}
+	s.l.Log(e)
+}
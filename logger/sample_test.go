@@ -0,0 +1,124 @@
+package logger
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseSampleRules(t *testing.T) {
+	tests := []struct {
+		in    string
+		rules SampleRules
+		err   string
+	}{
+		{"", nil, ""},
+		{"off", SampleRules{{Rate: 0}}, ""},
+		{"5xx:1,*:0", SampleRules{{Class: 5, Rate: 1}, {Rate: 0}}, ""},
+		{"2xx:1%, 404:0.5", SampleRules{{Class: 2, Rate: 0.01}, {Code: 404, Rate: 0.5}}, ""},
+		{"5xx", nil, `invalid sample rule "5xx"`},
+		{"6xx:1", nil, `invalid status "6xx" in sample rule "6xx:1"`},
+		{"99:1", nil, `invalid status "99" in sample rule "99:1"`},
+		{"2xx:2", nil, `invalid rate "2" in sample rule "2xx:2"`},
+		{"2xx:-1%", nil, `invalid rate "-1%" in sample rule "2xx:-1%"`},
+		{"2xx:abc", nil, `invalid rate "abc" in sample rule "2xx:abc"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			rules, err := ParseSampleRules(tt.in)
+			var gotErr string
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if got, want := gotErr, tt.err; got != want {
+				t.Fatalf("got error %q want %q", got, want)
+			}
+			if got, want := rules, tt.rules; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %#v want %#v", got, want)
+			}
+		})
+	}
+}
+
+func TestSampleRulesKeep(t *testing.T) {
+	defer func(f func() float64) { randFloat = f }(randFloat)
+	randFloat = func() float64 { return 0.5 }
+
+	tests := []struct {
+		rules  string
+		status int
+		keep   bool
+	}{
+		{"", 200, true},
+		{"", 0, true},
+		{"off", 500, false},
+		{"off", 0, false},
+		{"5xx:1,*:0", 503, true},
+		{"5xx:1,*:0", 200, false},
+		{"5xx:1,*:0", 0, false},
+		{"2xx:1%", 200, false},
+		{"2xx:1%", 404, true},
+		{"2xx:60%", 200, true},
+		{"2xx:40%", 200, false},
+		{"4xx:0,404:1", 404, true},
+		{"4xx:0,404:1", 403, false},
+		{"*:0,5xx:1", 500, true},
+	}
+
+	for _, tt := range tests {
+		rules, err := ParseSampleRules(tt.rules)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := rules.Keep(tt.status), tt.keep; got != want {
+			t.Errorf("%q: %d: got %v want %v", tt.rules, tt.status, got, want)
+		}
+	}
+}
+
+func TestSampler(t *testing.T) {
+	var n int
+	l := NewSampler(loggerFunc(func(*Event) { n++ }), SampleRules{{Class: 5, Rate: 1}, {Rate: 0}})
+
+	l.Log(&Event{Response: &http.Response{StatusCode: 500}})
+	l.Log(&Event{Response: &http.Response{StatusCode: 200}})
+	l.Log(&Event{})
+	if got, want := n, 1; got != want {
+		t.Fatalf("got %d events want %d", got, want)
+	}
+
+	// route rules override the default rules
+	l.Log(&Event{Response: &http.Response{StatusCode: 200}, SampleRules: SampleRules{{Rate: 1}}})
+	if got, want := n, 2; got != want {
+		t.Fatalf("got %d events want %d", got, want)
+	}
+}
+
+func TestSampleStatus(t *testing.T) {
+	tests := []struct {
+		desc   string
+		e      *Event
+		status int
+	}{
+		{"http", &Event{Response: &http.Response{StatusCode: 404}}, 404},
+		{"grpc ok", &Event{GRPCStatus: "OK"}, 200},
+		{"grpc error", &Event{GRPCStatus: "Unavailable"}, 500},
+		{"tcp client closed", &Event{CloseReason: CloseReasonClientClosed}, 200},
+		{"tcp upstream closed", &Event{CloseReason: CloseReasonUpstreamClosed}, 200},
+		{"tcp error", &Event{CloseReason: "upstream connect failed"}, 500},
+		{"no status", &Event{}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got, want := sampleStatus(tt.e), tt.status; got != want {
+				t.Fatalf("got %d want %d", got, want)
+			}
+		})
+	}
+}
+
+type loggerFunc func(*Event)
+
+func (f loggerFunc) Log(e *Event) { f(e) }
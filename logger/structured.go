@@ -0,0 +1,231 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Structured log formats. The format name can be followed by a colon and
+// a comma separated list of field names without the leading '$', e.g.
+// 'json:remote_addr,request,response_status,header.User-Agent'. If no
+// fields are given the DefaultStructuredFields are logged.
+const (
+	JSONFormat   = "json"
+	LogfmtFormat = "logfmt"
+)
+
+// DefaultStructuredFields contains the fields of the structured log
+// formats if no fields are given.
+var DefaultStructuredFields = []string{
+	"time_rfc3339_ms",
+	"proto",
+	"remote_addr",
+	"request_id",
+	"request_method",
+	"request_host",
+	"request_uri",
+	"request_proto",
+	"request_body_size",
+	"response_status",
+	"response_body_size",
+	"bytes_received",
+	"bytes_sent",
+	"response_time_ms",
+	"upstream_response_time_ms",
+	"upstream_addr",
+	"upstream_service",
+	"route",
+	"target_weight",
+	"tls_version",
+	"tls_cipher",
+	"tls_sni",
+	"tls_client_subject",
+	"grpc_method",
+	"grpc_status",
+	"close_reason",
+	"header.Referer",
+	"header.User-Agent",
+}
+
+// numericFields contains the fields whose values are written as
+// numbers in the JSON format.
+var numericFields = map[string]bool{
+	"bytes_received":            true,
+	"bytes_sent":                true,
+	"request_body_size":         true,
+	"response_body_size":        true,
+	"response_status":           true,
+	"response_time_ms":          true,
+	"response_time_us":          true,
+	"response_time_ns":          true,
+	"target_weight":             true,
+	"time_unix_ms":              true,
+	"time_unix_us":              true,
+	"time_unix_ns":              true,
+	"upstream_response_time_ms": true,
+	"upstream_response_time_us": true,
+	"upstream_response_time_ns": true,
+}
+
+// parseStructured parses a structured log format into a pattern. It
+// returns false if the format is not a structured format.
+func parseStructured(format string, fields map[string]field) (p pattern, ok bool, err error) {
+	enc, list := format, ""
+	if i := strings.IndexByte(format, ':'); i >= 0 {
+		enc, list = format[:i], format[i+1:]
+	}
+
+	var write func(b *bytes.Buffer, first bool, name string, numeric bool, val []byte)
+	switch enc {
+	case JSONFormat:
+		write = writeJSON
+	case LogfmtFormat:
+		write = writeLogfmt
+	default:
+		return nil, false, nil
+	}
+
+	names := DefaultStructuredFields
+	if list != "" {
+		names = nil
+		for _, s := range strings.Split(list, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				names = append(names, strings.TrimPrefix(s, "$"))
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil, true, fmt.Errorf("no fields for %s format", enc)
+	}
+
+	type namedField struct {
+		name    string
+		numeric bool
+		f       field
+	}
+	var nfs []namedField
+	for _, name := range names {
+		for _, r := range name {
+			if !isIDChar(r) && r != '.' {
+				return nil, true, fmt.Errorf("invalid field %q", name)
+			}
+		}
+		var f field
+		if strings.HasPrefix(name, "header.") && len(name) > len("header.") {
+			f = header(name[len("header."):])
+		} else {
+			f = fields["$"+name]
+		}
+		if f == nil {
+			return nil, true, fmt.Errorf("invalid field %q", name)
+		}
+		nfs = append(nfs, namedField{name, numericFields[name], f})
+	}
+
+	p = pattern{func(b *bytes.Buffer, e *Event) {
+		val := pool.Get().(*bytes.Buffer)
+		if enc == JSONFormat {
+			b.WriteByte('{')
+		}
+		for i, nf := range nfs {
+			val.Reset()
+			nf.f(val, e)
+			write(b, i == 0, nf.name, nf.numeric, val.Bytes())
+		}
+		if enc == JSONFormat {
+			b.WriteByte('}')
+		}
+		pool.Put(val)
+	}}
+	return p, true, nil
+}
+
+// writeJSON writes the field as a JSON object member. Numeric fields
+// without a value are written as null.
+func writeJSON(b *bytes.Buffer, first bool, name string, numeric bool, val []byte) {
+	if !first {
+		b.WriteByte(',')
+	}
+	b.WriteByte('"')
+	b.WriteString(name)
+	b.WriteString(`":`)
+	switch {
+	case numeric && len(val) == 0:
+		b.WriteString("null")
+	case numeric:
+		b.Write(val)
+	default:
+		b.WriteByte('"')
+		jsonEscape(b, val)
+		b.WriteByte('"')
+	}
+}
+
+const hex = "0123456789abcdef"
+
+// jsonEscape writes s as the content of a JSON string. Invalid UTF-8
+// sequences are replaced with the unicode replacement character.
+func jsonEscape(b *bytes.Buffer, s []byte) {
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			switch {
+			case c == '"' || c == '\\':
+				b.WriteByte('\\')
+				b.WriteByte(c)
+			case c == '\n':
+				b.WriteString(`\n`)
+			case c == '\r':
+				b.WriteString(`\r`)
+			case c == '\t':
+				b.WriteString(`\t`)
+			case c < 0x20 || c == 0x7f:
+				b.WriteString(`\u00`)
+				b.WriteByte(hex[c>>4])
+				b.WriteByte(hex[c&0xf])
+			default:
+				b.WriteByte(c)
+			}
+			i++
+			continue
+		}
+		r, n := utf8.DecodeRune(s[i:])
+		if r == utf8.RuneError && n == 1 {
+			b.WriteString(`\ufffd`)
+		} else {
+			b.Write(s[i : i+n])
+		}
+		i += n
+	}
+}
+
+// writeLogfmt writes the field as a logfmt key/value pair. Values
+// which contain spaces, quotes, '=' or control characters are quoted.
+func writeLogfmt(b *bytes.Buffer, first bool, name string, numeric bool, val []byte) {
+	if !first {
+		b.WriteByte(' ')
+	}
+	b.WriteString(name)
+	b.WriteByte('=')
+	if !needsQuoting(val) {
+		b.Write(val)
+		return
+	}
+	b.WriteByte('"')
+	jsonEscape(b, val)
+	b.WriteByte('"')
+}
+
+func needsQuoting(s []byte) bool {
+	if len(s) == 0 {
+		return true
+	}
+	for _, c := range s {
+		if c <= ' ' || c == '=' || c == '"' || c == '\\' || c == 0x7f || c >= utf8.RuneSelf {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// facilities maps the syslog facility names to their codes.
+var facilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// severityInfo is the syslog severity of the access log messages.
+const severityInfo = 6
+
+// SyslogWriter writes every Write as a single RFC5424 syslog message
+// with severity 'info'. Messages sent over stream connections (tcp and
+// unix stream sockets) are framed with octet counting as defined in
+// RFC6587. The connection is re-established on the next write after
+// a write error.
+type SyslogWriter struct {
+	network, addr string
+	header        string // "<PRI>1 " prefix without the timestamp
+	trailer       string // " HOSTNAME APP-NAME PROCID MSGID SD " suffix
+	now           func() time.Time
+
+	mu     sync.Mutex
+	conn   net.Conn
+	stream bool
+}
+
+// NewSyslogWriter creates a syslog writer for the given address which
+// has the form 'udp://host:port', 'tcp://host:port' or 'unix:///path'.
+func NewSyslogWriter(addr, facility, tag string) (*SyslogWriter, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("syslog: invalid address %q: %s", addr, err)
+	}
+
+	w := &SyslogWriter{network: u.Scheme, now: time.Now}
+	switch u.Scheme {
+	case "udp", "tcp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("syslog: missing host in address %q", addr)
+		}
+		w.addr = u.Host
+	case "unix":
+		if u.Path == "" {
+			return nil, fmt.Errorf("syslog: missing path in address %q", addr)
+		}
+		w.addr = u.Path
+	default:
+		return nil, fmt.Errorf("syslog: invalid network %q in address %q", u.Scheme, addr)
+	}
+
+	code, ok := facilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("syslog: invalid facility %q", facility)
+	}
+
+	hostname, _ := os.Hostname()
+	w.header = "<" + strconv.Itoa(code*8+severityInfo) + ">1 "
+	w.trailer = " " + nilValue(hostname) + " " + nilValue(tag) + " " + strconv.Itoa(os.Getpid()) + " - - "
+
+	if err := w.connect(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// nilValue returns the RFC5424 NILVALUE for empty header fields.
+func nilValue(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func (w *SyslogWriter) connect() error {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+
+	var err error
+	switch w.network {
+	case "unix":
+		// prefer datagram sockets like /dev/log and fall
+		// back to stream sockets.
+		w.conn, err = net.Dial("unixgram", w.addr)
+		w.stream = false
+		if err != nil {
+			w.conn, err = net.Dial("unix", w.addr)
+			w.stream = true
+		}
+	default:
+		w.conn, err = net.Dial(w.network, w.addr)
+		w.stream = w.network == "tcp"
+	}
+	if err != nil {
+		return fmt.Errorf("syslog: cannot connect to %s://%s: %s", w.network, w.addr, err)
+	}
+	return nil
+}
+
+// Write sends p as a single syslog message. A trailing
+// newline is removed.
+func (w *SyslogWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	msg := w.format(bytes.TrimSuffix(p, []byte{'\n'}))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn == nil {
+		if err := w.connect(); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := w.write(msg); err != nil {
+		// reconnect once and retry
+		if err := w.connect(); err != nil {
+			return 0, err
+		}
+		if _, err := w.write(msg); err != nil {
+			w.conn.Close()
+			w.conn = nil
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+func (w *SyslogWriter) format(p []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString(w.header)
+	b.WriteString(w.now().UTC().Format("2006-01-02T15:04:05.000000Z07:00"))
+	b.WriteString(w.trailer)
+	b.Write(p)
+	return b.Bytes()
+}
+
+func (w *SyslogWriter) write(msg []byte) (int, error) {
+	if !w.stream {
+		return w.conn.Write(msg)
+	}
+	frame := make([]byte, 0, len(msg)+8)
+	frame = strconv.AppendInt(frame, int64(len(msg)), 10)
+	frame = append(frame, ' ')
+	frame = append(frame, msg...)
+	return w.conn.Write(frame)
+}
+
+// Close closes the connection to the syslog server.
+func (w *SyslogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
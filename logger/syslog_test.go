@@ -0,0 +1,111 @@
+package logger
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogWriter(t *testing.T) {
+	now := time.Date(2016, 1, 1, 0, 0, 0, 123456000, time.UTC)
+	hostname, _ := os.Hostname()
+	want := "<134>1 2016-01-01T00:00:00.123456Z " + hostname + " fabio " + strconv.Itoa(os.Getpid()) + " - - foo bar"
+
+	t.Run("udp", func(t *testing.T) {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pc.Close()
+
+		w, err := NewSyslogWriter("udp://"+pc.LocalAddr().String(), "local0", "fabio")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+		w.now = func() time.Time { return now }
+
+		if _, err := w.Write([]byte("foo bar\n")); err != nil {
+			t.Fatal(err)
+		}
+		pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 1024)
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(buf[:n]); got != want {
+			t.Fatalf("got %q want %q", got, want)
+		}
+	})
+
+	t.Run("tcp", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+
+		w, err := NewSyslogWriter("tcp://"+l.Addr().String(), "local0", "fabio")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+		w.now = func() time.Time { return now }
+
+		c, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		for i := 0; i < 2; i++ {
+			if _, err := w.Write([]byte("foo bar\n")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// octet counting framing
+		c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		r := bufio.NewReader(c)
+		for i := 0; i < 2; i++ {
+			size, err := r.ReadString(' ')
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(size))
+			if got, want := n, len(want); got != want {
+				t.Fatalf("got frame size %d want %d", got, want)
+			}
+			msg := make([]byte, n)
+			if _, err := io.ReadFull(r, msg); err != nil {
+				t.Fatal(err)
+			}
+			if got := string(msg); got != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+		}
+	})
+}
+
+func TestNewSyslogWriter_Invalid(t *testing.T) {
+	tests := []struct {
+		addr, facility, err string
+	}{
+		{"foo://bar", "local0", `syslog: invalid network "foo" in address "foo://bar"`},
+		{"udp://", "local0", `syslog: missing host in address "udp://"`},
+		{"unix://", "local0", `syslog: missing path in address "unix://"`},
+		{"udp://127.0.0.1:514", "foo", `syslog: invalid facility "foo"`},
+	}
+	for _, tt := range tests {
+		_, err := NewSyslogWriter(tt.addr, tt.facility, "fabio")
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%s: got %v want %q", tt.addr, err, tt.err)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
@@ -20,6 +21,7 @@ import (
 
 	"github.com/fabiolb/fabio/admin"
 	"github.com/fabiolb/fabio/auth"
+	"github.com/fabiolb/fabio/cache"
 	"github.com/fabiolb/fabio/cert"
 	"github.com/fabiolb/fabio/config"
 	"github.com/fabiolb/fabio/exit"
@@ -54,6 +56,20 @@ var version = "1.5.14"
 
 var shuttingDown int32
 
+// upstreamTLS provides the transports for routes which use client
+// certificates or CA bundles for the upstream connection.
+var upstreamTLS *proxy.UpstreamTLS
+
+// accessLogger writes the access log for all proxies.
+var accessLogger logger.Logger
+
+// accessLogWriter buffers the access log writes and
+// is flushed on shutdown.
+var accessLogWriter io.Closer
+
+// authSchemes contains the auth schemes of the HTTP proxy.
+var authSchemes map[string]auth.AuthScheme
+
 func main() {
 	logOutput := logger.NewLevelWriter(os.Stderr, "INFO", "2017/01/01 00:00:00 ")
 	log.SetOutput(logOutput)
@@ -72,6 +88,11 @@ func main() {
 		log.Printf("[INFO] Cannot set log level to %s", cfg.Log.Level)
 	}
 
+	if cfg.Explain.URL != "" {
+		explain(cfg)
+		return
+	}
+
 	log.Printf("[INFO] Runtime config\n" + toJSON(cfg))
 	log.Printf("[INFO] Version %s starting", version)
 	log.Printf("[INFO] Go runtime is %s", runtime.Version())
@@ -111,6 +132,11 @@ func main() {
 	exit.Listen(func(s os.Signal) {
 		atomic.StoreInt32(&shuttingDown, 1)
 		proxy.Shutdown(cfg.Proxy.ShutdownWait)
+		if accessLogWriter != nil {
+			accessLogWriter.Close()
+		}
+		trace.Shutdown(5 * time.Second)
+		metrics.ShutdownOTLP(5 * time.Second)
 		if prof != nil {
 			prof.Stop()
 		}
@@ -124,12 +150,42 @@ func main() {
 	// that are used by other parts of the code.
 	initMetrics(cfg)
 	initRuntime(cfg)
+	initAccessRules(cfg)
 	initBackend(cfg)
 
-	// init OpenTracing, if enabled
-	trace.InitializeTracer(&cfg.Tracing)
+	// init tracing, if enabled
+	if err := trace.InitializeTracer(&cfg.Tracing); err != nil {
+		exit.Fatal("[FATAL] ", err)
+	}
 
-	startAdmin(cfg)
+	if cfg.Proxy.OCSP.Stapling {
+		cert.DefaultStapler = cert.NewOCSPStapler(cfg.Proxy.OCSP)
+		log.Printf("[INFO] OCSP stapling enabled")
+	}
+
+	cert.DefaultExpiryMonitor = &cert.ExpiryMonitor{
+		Threshold: cfg.Proxy.CertExpiryWarning,
+		Registry:  metrics.DefaultRegistry,
+	}
+	go cert.DefaultExpiryMonitor.Run(time.Hour, nil)
+
+	upstreamTLS = &proxy.UpstreamTLS{
+		Sources: cert.NewClientSources(cfg.Proxy.CertSources),
+		NewTransport: func(tlscfg *tls.Config) *http.Transport {
+			return newTransport(cfg, tlscfg)
+		},
+	}
+
+	accessLogger = newAccessLogger(cfg)
+
+	cache.Default.SetMaxSize(int64(cfg.Proxy.Cache.Size)<<20, int64(cfg.Proxy.Cache.MaxEntrySize)<<10)
+
+	authSchemes, err = auth.LoadAuthSchemes(cfg.Proxy.AuthSchemes)
+	if err != nil {
+		exit.Fatal("[FATAL] ", err)
+	}
+
+	startAdmin(cfg, logOutput)
 
 	go watchNoRouteHTML(cfg)
 
@@ -163,9 +219,10 @@ func newGrpcProxy(cfg *config.Config, tlscfg *tls.Config) []grpc.ServerOption {
 		Config:       cfg,
 		StatsHandler: statsHandler,
 		GlobCache:    globCache,
+		Logger:       accessLogger,
 	}
 
-	handler := grpc_proxy.TransparentHandler(proxy.GetGRPCDirector(tlscfg))
+	handler := grpc_proxy.TransparentHandler(proxy.GetGRPCDirector(tlscfg, upstreamTLS))
 
 	return []grpc.ServerOption{
 		grpc.CustomCodec(grpc_proxy.Codec()),
@@ -175,22 +232,41 @@ func newGrpcProxy(cfg *config.Config, tlscfg *tls.Config) []grpc.ServerOption {
 	}
 }
 
-func newHTTPProxy(cfg *config.Config) http.Handler {
+// newAccessLogger creates the access logger for all proxies.
+func newAccessLogger(cfg *config.Config) logger.Logger {
 	var w io.Writer
-
-	//Init Glob Cache
-	globCache := route.NewGlobCache(cfg.GlobCacheSize)
-
 	switch cfg.Log.AccessTarget {
 	case "":
 		log.Printf("[INFO] Access logging disabled")
 	case "stdout":
 		log.Printf("[INFO] Writing access log to stdout")
 		w = os.Stdout
+	case "syslog":
+		sc := cfg.Log.AccessSyslog
+		sw, err := logger.NewSyslogWriter(sc.Addr, sc.Facility, sc.Tag)
+		if err != nil {
+			exit.Fatal("[FATAL] ", err)
+		}
+		log.Printf("[INFO] Writing access log to syslog at %s", sc.Addr)
+		w = sw
+	case "file":
+		fc := cfg.Log.AccessFile
+		fw, err := logger.NewRotatingFile(fc.Path, int64(fc.MaxSize)<<20, fc.Interval, fc.MaxBackups, fc.Compress)
+		if err != nil {
+			exit.Fatal("[FATAL] ", err)
+		}
+		log.Printf("[INFO] Writing access log to %s", fc.Path)
+		w = fw
 	default:
 		exit.Fatal("[FATAL] Invalid access log target ", cfg.Log.AccessTarget)
 	}
 
+	if w != nil && cfg.Log.AccessBuffer > 0 {
+		aw := logger.NewAsyncWriter(w, cfg.Log.AccessBuffer, metrics.DefaultRegistry.GetCounter("accesslog.dropped"))
+		accessLogWriter = aw
+		w = aw
+	}
+
 	format := cfg.Log.AccessFormat
 	switch format {
 	case "common":
@@ -204,34 +280,43 @@ func newHTTPProxy(cfg *config.Config) http.Handler {
 		exit.Fatal("[FATAL] Invalid log format: ", err)
 	}
 
+	rules, err := logger.ParseSampleRules(cfg.Log.AccessSample)
+	if err != nil {
+		exit.Fatal("[FATAL] Invalid log.access.sample: ", err)
+	}
+	if w == nil {
+		return l
+	}
+	return logger.NewSampler(l, rules)
+}
+
+func newHTTPProxy(cfg *config.Config) http.Handler {
+	//Init Glob Cache
+	globCache := route.NewGlobCache(cfg.GlobCacheSize)
+
 	pick := route.Picker[cfg.Proxy.Strategy]
 	match := route.Matcher[cfg.Proxy.Matcher]
 	notFound := metrics.DefaultRegistry.GetCounter("notfound")
 	log.Printf("[INFO] Using routing strategy %q", cfg.Proxy.Strategy)
 	log.Printf("[INFO] Using route matching %q", cfg.Proxy.Matcher)
 
-	newTransport := func(tlscfg *tls.Config) *http.Transport {
-		return &http.Transport{
-			ResponseHeaderTimeout: cfg.Proxy.ResponseHeaderTimeout,
-			MaxIdleConnsPerHost:   cfg.Proxy.MaxConn,
-			Dial: (&net.Dialer{
-				Timeout:   cfg.Proxy.DialTimeout,
-				KeepAlive: cfg.Proxy.KeepAliveTimeout,
-			}).Dial,
-			TLSClientConfig: tlscfg,
-		}
+	h2Transport, err := proxy.NewH2Transport(newTransport(cfg, nil))
+	if err != nil {
+		exit.Fatal("[FATAL] Cannot create HTTP/2 transport. ", err)
 	}
-
-	authSchemes, err := auth.LoadAuthSchemes(cfg.Proxy.AuthSchemes)
-
+	insecureH2Transport, err := proxy.NewH2Transport(newTransport(cfg, &tls.Config{InsecureSkipVerify: true}))
 	if err != nil {
-		exit.Fatal("[FATAL] ", err)
+		exit.Fatal("[FATAL] Cannot create HTTP/2 transport. ", err)
 	}
 
 	return &proxy.HTTPProxy{
-		Config:            cfg.Proxy,
-		Transport:         newTransport(nil),
-		InsecureTransport: newTransport(&tls.Config{InsecureSkipVerify: true}),
+		Config:              cfg.Proxy,
+		Transport:           newTransport(cfg, nil),
+		InsecureTransport:   newTransport(cfg, &tls.Config{InsecureSkipVerify: true}),
+		H2Transport:         h2Transport,
+		InsecureH2Transport: insecureH2Transport,
+		H2CTransport:        proxy.NewH2CTransport(newDialer(cfg).Dial),
+		UpstreamTLS:         upstreamTLS,
 		Lookup: func(r *http.Request) *route.Target {
 			t := route.GetTable().Lookup(r, r.Header.Get("trace"), pick, match, globCache, cfg.GlobMatchingDisabled)
 			if t == nil {
@@ -242,12 +327,39 @@ func newHTTPProxy(cfg *config.Config) http.Handler {
 		},
 		Requests:    metrics.DefaultRegistry.GetTimer("requests"),
 		Noroute:     metrics.DefaultRegistry.GetCounter("notfound"),
-		Logger:      l,
+		Logger:      accessLogger,
 		TracerCfg:   cfg.Tracing,
 		AuthSchemes: authSchemes,
+		Cache:       cache.Default,
+	}
+}
+
+func newTransport(cfg *config.Config, tlscfg *tls.Config) *http.Transport {
+	return &http.Transport{
+		ResponseHeaderTimeout: cfg.Proxy.ResponseHeaderTimeout,
+		MaxIdleConnsPerHost:   cfg.Proxy.MaxConn,
+		Dial:                  newDialer(cfg).Dial,
+		TLSClientConfig:       tlscfg,
 	}
 }
 
+func newDialer(cfg *config.Config) *net.Dialer {
+	return &net.Dialer{
+		Timeout:   cfg.Proxy.DialTimeout,
+		KeepAlive: cfg.Proxy.KeepAliveTimeout,
+	}
+}
+
+// upstreamTLSConfig returns the TLS configuration for TCP upstream
+// connections of targets with the 'tlsclientcert' or 'tlsca' option.
+func upstreamTLSConfig(t *route.Target) (*tls.Config, error) {
+	if !proxy.UsesUpstreamTLS(t) {
+		return nil, nil
+	}
+	tlscfg, _, err := upstreamTLS.TLSConfig(t)
+	return tlscfg, err
+}
+
 func lookupHostFn(cfg *config.Config) func(string) *route.Target {
 	pick := route.Picker[cfg.Proxy.Strategy]
 	notFound := metrics.DefaultRegistry.GetCounter("notfound")
@@ -291,14 +403,21 @@ func makeTLSConfig(l config.Listen) (*tls.Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create cert source %s. %s", l.CertSource.Name, err)
 	}
-	tlscfg, err := cert.TLSConfig(src, l.StrictMatch, l.TLSMinVersion, l.TLSMaxVersion, l.TLSCiphers)
+	store := cert.NewStore()
+	cert.RegisterStore(l.Addr, l.CertSource.Name, store)
+	tlscfg, err := store.TLSConfig(src, l.StrictMatch, l.TLSMinVersion, l.TLSMaxVersion, l.TLSCiphers)
 	if err != nil {
 		return nil, fmt.Errorf("[FATAL] Failed to create TLS config for cert source %s. %s", l.CertSource.Name, err)
 	}
+	// request but do not require client certificates and
+	// let the routes decide with the 'clientcert' options.
+	if l.ClientAuth == "request" && tlscfg.ClientAuth == tls.RequireAndVerifyClientCert {
+		tlscfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
 	return tlscfg, nil
 }
 
-func startAdmin(cfg *config.Config) {
+func startAdmin(cfg *config.Config, logOutput *logger.LevelWriter) {
 	log.Printf("[INFO] Admin server access mode %q", cfg.UI.Access)
 	log.Printf("[INFO] Admin server listening on %q", cfg.UI.Listen.Addr)
 	go func() {
@@ -308,12 +427,14 @@ func startAdmin(cfg *config.Config) {
 			exit.Fatal("[FATAL] ", err)
 		}
 		srv := &admin.Server{
-			Access:   cfg.UI.Access,
-			Color:    cfg.UI.Color,
-			Title:    cfg.UI.Title,
-			Version:  version,
-			Commands: route.Commands,
-			Cfg:      cfg,
+			Access:      cfg.UI.Access,
+			Color:       cfg.UI.Color,
+			Title:       cfg.UI.Title,
+			Version:     version,
+			Commands:    route.Commands,
+			Cfg:         cfg,
+			LogLevel:    logOutput,
+			AuthSchemes: authSchemes,
 		}
 		if err := srv.ListenAndServe(l, tlscfg); err != nil {
 			exit.Fatal("[FATAL] ui: ", err)
@@ -333,6 +454,9 @@ func startServers(cfg *config.Config) {
 		if tlscfg != nil && tlscfg.ClientAuth == tls.RequireAndVerifyClientCert {
 			log.Printf("[INFO] Client certificate authentication enabled on %s", l.Addr)
 		}
+		if tlscfg != nil && tlscfg.ClientAuth == tls.VerifyClientCertIfGiven {
+			log.Printf("[INFO] Client certificate authentication per route enabled on %s", l.Addr)
+		}
 
 		switch l.Proto {
 		case "http", "https":
@@ -354,9 +478,11 @@ func startServers(cfg *config.Config) {
 				h := &tcp.Proxy{
 					DialTimeout: cfg.Proxy.DialTimeout,
 					Lookup:      lookupHostFn(cfg),
-					Conn:        metrics.DefaultRegistry.GetCounter("tcp.conn"),
-					ConnFail:    metrics.DefaultRegistry.GetCounter("tcp.connfail"),
-					Noroute:     metrics.DefaultRegistry.GetCounter("tcp.noroute"),
+					Conn:        listenerCounter("tcp.conn", l.Addr),
+					ConnFail:    listenerCounter("tcp.connfail", l.Addr),
+					Noroute:     listenerCounter("tcp.noroute", l.Addr),
+					Logger:      accessLogger,
+					TLSConfig:   upstreamTLSConfig,
 				}
 				if err := proxy.ListenAndServeTCP(l, h, tlscfg); err != nil {
 					exit.Fatal("[FATAL] ", err)
@@ -367,9 +493,10 @@ func startServers(cfg *config.Config) {
 				h := &tcp.SNIProxy{
 					DialTimeout: cfg.Proxy.DialTimeout,
 					Lookup:      lookupHostFn(cfg),
-					Conn:        metrics.DefaultRegistry.GetCounter("tcp_sni.conn"),
-					ConnFail:    metrics.DefaultRegistry.GetCounter("tcp_sni.connfail"),
-					Noroute:     metrics.DefaultRegistry.GetCounter("tcp_sni.noroute"),
+					Conn:        listenerCounter("tcp_sni.conn", l.Addr),
+					ConnFail:    listenerCounter("tcp_sni.connfail", l.Addr),
+					Noroute:     listenerCounter("tcp_sni.noroute", l.Addr),
+					Logger:      accessLogger,
 				}
 				if err := proxy.ListenAndServeTCP(l, h, tlscfg); err != nil {
 					exit.Fatal("[FATAL] ", err)
@@ -409,9 +536,11 @@ func startServers(cfg *config.Config) {
 							h := &tcp.DynamicProxy{
 								DialTimeout: cfg.Proxy.DialTimeout,
 								Lookup:      lookupHostFn(cfg),
-								Conn:        metrics.DefaultRegistry.GetCounter("tcp.conn"),
-								ConnFail:    metrics.DefaultRegistry.GetCounter("tcp.connfail"),
-								Noroute:     metrics.DefaultRegistry.GetCounter("tcp.noroute"),
+								Conn:        listenerCounter("tcp.conn", port),
+								ConnFail:    listenerCounter("tcp.connfail", port),
+								Noroute:     listenerCounter("tcp.noroute", port),
+								Logger:      accessLogger,
+								TLSConfig:   upstreamTLSConfig,
 							}
 							l.Addr = port
 							if err := proxy.ListenAndServeTCP(l, h, tlscfg); err != nil {
@@ -427,9 +556,10 @@ func startServers(cfg *config.Config) {
 				tp := &tcp.SNIProxy{
 					DialTimeout: cfg.Proxy.DialTimeout,
 					Lookup:      lookupHostFn(cfg),
-					Conn:        metrics.DefaultRegistry.GetCounter("tcp_sni.conn"),
-					ConnFail:    metrics.DefaultRegistry.GetCounter("tcp_sni.connfail"),
-					Noroute:     metrics.DefaultRegistry.GetCounter("tcp_sni.noroute"),
+					Conn:        listenerCounter("tcp_sni.conn", l.Addr),
+					ConnFail:    listenerCounter("tcp_sni.connfail", l.Addr),
+					Noroute:     listenerCounter("tcp_sni.noroute", l.Addr),
+					Logger:      accessLogger,
 				}
 				if err := proxy.ListenAndServeHTTPSTCPSNI(l, hp, tp, tlscfg, lookupHostMatcher(cfg)); err != nil {
 					exit.Fatal("[FATAL] ", err)
@@ -441,12 +571,24 @@ func startServers(cfg *config.Config) {
 	}
 }
 
+// listenerCounter returns the counter with the given name for
+// the TCP listener on addr.
+func listenerCounter(name, addr string) metrics.Counter {
+	return metrics.LabeledCounter(metrics.DefaultRegistry, name, strings.Replace(name, ".", "_", -1), "listener", addr)
+}
+
 func initMetrics(cfg *config.Config) {
 	if cfg.Metrics.Target == "" {
 		log.Printf("[INFO] Metrics disabled")
 		return
 	}
 
+	var listeners []string
+	for _, l := range cfg.Listen {
+		listeners = append(listeners, l.Proto+"://"+l.Addr)
+	}
+	metrics.ResourceLabels = []string{"service.version", version, "fabio.listeners", strings.Join(listeners, ",")}
+
 	var deadline = time.Now().Add(cfg.Metrics.Timeout)
 	var err error
 	for {
@@ -455,6 +597,7 @@ func initMetrics(cfg *config.Config) {
 			route.ServiceRegistry, err = metrics.NewRegistry(cfg.Metrics)
 		}
 		if err == nil {
+			startPrometheus(cfg)
 			return
 		}
 		if time.Now().After(deadline) {
@@ -468,6 +611,27 @@ func initMetrics(cfg *config.Config) {
 	}
 }
 
+// startPrometheus starts the dedicated listener for the Prometheus
+// endpoint. Otherwise, the endpoint is served by the admin server.
+func startPrometheus(cfg *config.Config) {
+	m := cfg.Metrics
+	if m.Target != "prometheus" {
+		return
+	}
+	if m.Prometheus.Addr == "" {
+		log.Printf("[INFO] Serving Prometheus metrics on the admin server at %s", m.Prometheus.Path)
+		return
+	}
+	log.Printf("[INFO] Serving Prometheus metrics on %s%s", m.Prometheus.Addr, m.Prometheus.Path)
+	go func() {
+		mux := http.NewServeMux()
+		mux.Handle(m.Prometheus.Path, metrics.PrometheusHandler())
+		if err := http.ListenAndServe(m.Prometheus.Addr, mux); err != nil {
+			exit.Fatal("[FATAL] metrics: ", err)
+		}
+	}()
+}
+
 func initRuntime(cfg *config.Config) {
 	if os.Getenv("GOGC") == "" {
 		log.Print("[INFO] Setting GOGC=", cfg.Runtime.GOGC)
@@ -484,6 +648,35 @@ func initRuntime(cfg *config.Config) {
 	}
 }
 
+func initAccessRules(cfg *config.Config) {
+	for name, nets := range cfg.Proxy.AccessRules.IPGroups {
+		for _, n := range nets {
+			ipnet, err := route.ParseIPNet(n)
+			if err != nil {
+				exit.Fatalf("[FATAL] Invalid network %q in ip group %q. %s", n, name, err)
+			}
+			route.IPGroups[name] = append(route.IPGroups[name], ipnet)
+		}
+	}
+
+	for _, n := range cfg.Proxy.AccessRules.TrustedProxies {
+		ipnet, err := route.ParseIPNet(n)
+		if err != nil {
+			exit.Fatalf("[FATAL] Invalid trusted proxy network %q. %s", n, err)
+		}
+		route.TrustedProxies = append(route.TrustedProxies, ipnet)
+	}
+
+	if cfg.Proxy.AccessRules.GeoIPDB != "" {
+		db, err := route.OpenGeoIPDB(cfg.Proxy.AccessRules.GeoIPDB)
+		if err != nil {
+			exit.Fatalf("[FATAL] Cannot open GeoIP database. %s", err)
+		}
+		route.GeoIP = db
+		log.Printf("[INFO] Using GeoIP database %s", cfg.Proxy.AccessRules.GeoIPDB)
+	}
+}
+
 func initBackend(cfg *config.Config) {
 	var deadline = time.Now().Add(cfg.Registry.Timeout)
 	var err error
@@ -635,6 +828,45 @@ func logRoutes(t route.Table, last, next, format string) {
 	}
 }
 
+// explain prints how the routes from the explain.routes file handle
+// the request for the explain url and exits. The auth schemes are not
+// evaluated.
+func explain(cfg *config.Config) {
+	initAccessRules(cfg)
+
+	path := cfg.Explain.Routes
+	if path == "" {
+		path = cfg.Registry.File.RoutesPath
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		exit.Fatal("[FATAL] explain: ", err)
+	}
+	t, err := route.NewTable(bytes.NewBuffer(b))
+	if err != nil {
+		exit.Fatal("[FATAL] explain: ", err)
+	}
+
+	header := http.Header{}
+	for _, h := range cfg.Explain.Header {
+		p := strings.SplitN(h, ":", 2)
+		if len(p) != 2 {
+			exit.Fatalf("[FATAL] explain: invalid header %q", h)
+		}
+		header.Add(strings.TrimSpace(p[0]), strings.TrimSpace(p[1]))
+	}
+
+	req, err := route.NewExplainRequest(cfg.Explain.URL, header, cfg.Explain.RemoteAddr)
+	if err != nil {
+		exit.Fatal("[FATAL] explain: ", err)
+	}
+	ex, err := t.Explain(req, cfg.Proxy.Strategy, cfg.Proxy.Matcher, nil, cfg.GlobMatchingDisabled, nil)
+	if err != nil {
+		exit.Fatal("[FATAL] explain: ", err)
+	}
+	fmt.Println(toJSON(ex))
+}
+
 func toJSON(v interface{}) string {
 	data, err := json.MarshalIndent(v, "", "    ")
 	if err != nil {
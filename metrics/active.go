@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"sync"
+)
+
+// active counts the active connections per flat metric name. The
+// counters are kept outside of the route targets since the targets
+// are re-created on every routing table update while the connections
+// stay open. A counter is removed when it drops to zero.
+var (
+	activeMu sync.Mutex
+	active   = map[string]int64{}
+)
+
+// addActive adds delta to the counter with the given name and
+// updates the gauge while holding the lock so that the updates
+// are not reordered.
+func addActive(g Gauge, flat string, delta int64) {
+	activeMu.Lock()
+	defer activeMu.Unlock()
+	n := active[flat] + delta
+	if n == 0 {
+		delete(active, flat)
+	} else {
+		active[flat] = n
+	}
+	g.Update(float64(n))
+}
+
+// TrackActive increments the gauge with the given name and labels
+// which counts the active connections and returns a function which
+// decrements it when the connection is closed. See LabeledGauge.
+func TrackActive(r Registry, flat, name string, labels ...string) func() {
+	g := LabeledGauge(r, flat, name, labels...)
+	addActive(g, flat, 1)
+	return func() {
+		addActive(g, flat, -1)
+	}
+}
@@ -0,0 +1,18 @@
+package metrics
+
+import (
+	"testing"
+)
+
+func TestTrackActive(t *testing.T) {
+	done1 := TrackActive(NoopRegistry{}, "a.conn.active", "route_active_connections")
+	done2 := TrackActive(NoopRegistry{}, "a.conn.active", "route_active_connections")
+	if got, want := active["a.conn.active"], int64(2); got != want {
+		t.Fatalf("got %d active want %d", got, want)
+	}
+	done1()
+	done2()
+	if _, ok := active["a.conn.active"]; ok {
+		t.Fatal("counter not removed")
+	}
+}
@@ -96,6 +96,12 @@ func (m *cgmRegistry) GetTimer(name string) Timer {
 	return &cgmTimer{m.metrics, metricName}
 }
 
+// GetGauge returns a gauge for the given metric name.
+func (m *cgmRegistry) GetGauge(name string) Gauge {
+	metricName := fmt.Sprintf("%s`%s", m.prefix, name)
+	return &cgmGauge{m.metrics, metricName}
+}
+
 type cgmCounter struct {
 	metrics *cgm.CirconusMetrics
 	name    string
@@ -106,6 +112,16 @@ func (c *cgmCounter) Inc(n int64) {
 	c.metrics.IncrementByValue(c.name, uint64(n))
 }
 
+type cgmGauge struct {
+	metrics *cgm.CirconusMetrics
+	name    string
+}
+
+// Update sets the gauge to v.
+func (g *cgmGauge) Update(v float64) {
+	g.metrics.SetGauge(g.name, v)
+}
+
 type cgmTimer struct {
 	metrics *cgm.CirconusMetrics
 	name    string
@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"net/url"
+)
+
+// LabeledRegistry defines an interface for metrics libraries which
+// support labels instead of encoding them in the metric name.
+//
+// Labels are passed as a list of label names and values in the form
+// name1, value1, name2, value2, ... and the metrics with the same name
+// must always use the same label names.
+//
+// A labeled metric is also registered under a flat name which
+// is used by Names() and Unregister(). This allows the callers to
+// manage the metrics with the same names for all registries.
+type LabeledRegistry interface {
+	Registry
+
+	// GetLabeledCounter returns a counter metric for the given
+	// name and labels which is registered as 'flat'.
+	GetLabeledCounter(flat, name string, labels ...string) Counter
+
+	// GetLabeledTimer returns a timer metric for the given
+	// name and labels which is registered as 'flat'.
+	GetLabeledTimer(flat, name string, labels ...string) Timer
+
+	// GetLabeledGauge returns a gauge metric for the given
+	// name and labels which is registered as 'flat'.
+	GetLabeledGauge(flat, name string, labels ...string) Gauge
+}
+
+// LabeledCounter returns a counter with the given name and labels if
+// the registry supports labels. Otherwise, it returns the counter
+// with the flat name.
+func LabeledCounter(r Registry, flat, name string, labels ...string) Counter {
+	if lr, ok := r.(LabeledRegistry); ok {
+		return lr.GetLabeledCounter(flat, name, labels...)
+	}
+	return r.GetCounter(flat)
+}
+
+// LabeledTimer returns a timer with the given name and labels if
+// the registry supports labels. Otherwise, it returns the timer
+// with the flat name.
+func LabeledTimer(r Registry, flat, name string, labels ...string) Timer {
+	if lr, ok := r.(LabeledRegistry); ok {
+		return lr.GetLabeledTimer(flat, name, labels...)
+	}
+	return r.GetTimer(flat)
+}
+
+// LabeledGauge returns a gauge with the given name and labels if
+// the registry supports labels. Otherwise, it returns the gauge
+// with the flat name.
+func LabeledGauge(r Registry, flat, name string, labels ...string) Gauge {
+	if lr, ok := r.(LabeledRegistry); ok {
+		return lr.GetLabeledGauge(flat, name, labels...)
+	}
+	return r.GetGauge(flat)
+}
+
+// TargetLabels returns the labels of the route metrics for a target.
+func TargetLabels(service, host, path string, targetURL *url.URL) []string {
+	var target string
+	if targetURL != nil {
+		target = targetURL.Host
+	}
+	return []string{"service", service, "host", host, "path", path, "target", target}
+}
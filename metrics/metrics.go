@@ -69,6 +69,12 @@ func NewRegistry(cfg config.Metrics) (r Registry, err error) {
 	case "circonus":
 		return circonusRegistry(prefix, cfg.Circonus, cfg.Interval)
 
+	case "prometheus":
+		return prometheusRegistry(cfg.Prometheus)
+
+	case "otlp":
+		return openTelemetryRegistry(cfg)
+
 	default:
 		exit.Fatal("[FATAL] Invalid metrics target ", cfg.Target)
 	}
@@ -82,7 +88,7 @@ func parsePrefix(tmpl string) (string, error) {
 		tmpl = DefaultPrefix
 	}
 	funcMap := template.FuncMap{
-		"clean": clean,
+		"clean": Clean,
 	}
 	t, err := template.New("prefix").Funcs(funcMap).Parse(tmpl)
 	if err != nil {
@@ -105,7 +111,7 @@ func parsePrefix(tmpl string) (string, error) {
 // parseNames parses the route metric name template.
 func parseNames(tmpl string) (*template.Template, error) {
 	funcMap := template.FuncMap{
-		"clean": clean,
+		"clean": Clean,
 	}
 	t, err := template.New("names").Funcs(funcMap).Parse(tmpl)
 	if err != nil {
@@ -141,10 +147,10 @@ func TargetName(service, host, path string, targetURL *url.URL) (string, error)
 	return name.String(), nil
 }
 
-// clean creates safe names for graphite reporting by replacing
+// Clean creates safe names for graphite reporting by replacing
 // some characters with underscores.
 // TODO(fs): This may need updating for other metrics backends.
-func clean(s string) string {
+func Clean(s string) string {
 	if s == "" {
 		return "_"
 	}
@@ -0,0 +1,449 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/prometheus/client_golang/prometheus"
+	gm "github.com/rcrowley/go-metrics"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlphttp"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	"go.opentelemetry.io/otel/sdk/metric/aggregator/histogram"
+	controller "go.opentelemetry.io/otel/sdk/metric/controller/basic"
+	processor "go.opentelemetry.io/otel/sdk/metric/processor/basic"
+	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/unit"
+)
+
+// ResourceLabels contains additional resource attributes for the
+// OTLP metrics in the form name1, value1, name2, value2, ...
+// It must be set before the registry is created.
+var ResourceLabels []string
+
+// otlpShared is the pipeline which is shared by all OTLP registries
+// so that all metrics are exported over the same connection.
+var otlpShared struct {
+	sync.Mutex
+	p *otlpPipeline
+}
+
+// ShutdownOTLP exports the remaining metrics and stops the OTLP
+// exporter if it was started.
+func ShutdownOTLP(timeout time.Duration) {
+	otlpShared.Lock()
+	defer otlpShared.Unlock()
+	if otlpShared.p == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := otlpShared.p.ctrl.Stop(ctx); err != nil {
+		log.Print("[WARN] metrics: Error stopping OTLP exporter. ", err)
+	}
+	otlpShared.p = nil
+}
+
+// openTelemetryRegistry returns a registry which exports the metrics
+// via OTLP to a collector.
+func openTelemetryRegistry(cfg config.Metrics) (Registry, error) {
+	otlpShared.Lock()
+	defer otlpShared.Unlock()
+	if otlpShared.p == nil {
+		exporter, err := newOTLPExporter(cfg.OTLP)
+		if err != nil {
+			return nil, err
+		}
+		p, err := newOTLPPipeline(exporter, cfg.Interval)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.ctrl.Start(context.Background()); err != nil {
+			return nil, err
+		}
+		log.Printf("[INFO] Sending metrics via OTLP/%s to %s", cfg.OTLP.Protocol, cfg.OTLP.Addr)
+		otlpShared.p = p
+	}
+	return newOTLPRegistry(otlpShared.p), nil
+}
+
+// newOTLPExporter creates the OTLP exporter for the collector URL.
+// The 'http' scheme uses a plain text connection and 'https' uses TLS.
+func newOTLPExporter(cfg config.OTLP) (*otlp.Exporter, error) {
+	u, err := url.Parse(cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("metrics: invalid OTLP collector url %q", cfg.Addr)
+	}
+
+	var driver otlp.ProtocolDriver
+	switch cfg.Protocol {
+	case "grpc":
+		opts := []otlpgrpc.Option{otlpgrpc.WithEndpoint(u.Host), otlpgrpc.WithHeaders(cfg.Headers)}
+		if u.Scheme == "http" {
+			opts = append(opts, otlpgrpc.WithInsecure())
+		}
+		driver = otlpgrpc.NewDriver(opts...)
+	case "http":
+		opts := []otlphttp.Option{otlphttp.WithEndpoint(u.Host), otlphttp.WithHeaders(cfg.Headers)}
+		if u.Scheme == "http" {
+			opts = append(opts, otlphttp.WithInsecure())
+		}
+		if u.Path != "" && u.Path != "/" {
+			opts = append(opts, otlphttp.WithMetricsURLPath(u.Path))
+		}
+		driver = otlphttp.NewDriver(opts...)
+	default:
+		return nil, fmt.Errorf("metrics: invalid OTLP protocol %q", cfg.Protocol)
+	}
+
+	kind := export.CumulativeExportKindSelector()
+	if cfg.Temporality == "delta" {
+		kind = export.DeltaExportKindSelector()
+	}
+	return otlp.NewExporter(context.Background(), driver, otlp.WithMetricExportKindSelector(kind))
+}
+
+// otlpPipeline contains the controller which collects the metrics
+// and pushes them to the exporter and the instruments created so far.
+type otlpPipeline struct {
+	ctrl  *controller.Controller
+	meter metric.Meter
+
+	// instMu and gaugeMu are separate locks since the SDK calls
+	// observeGauges with its own lock held which it also acquires
+	// when an instrument is created.
+	instMu sync.Mutex
+	insts  map[string]interface{}
+
+	gaugeMu sync.Mutex
+	gauges  map[string]map[string]*otlpGauge // instrument name -> label values -> gauge
+}
+
+// newOTLPPipeline creates the pipeline which exports the metrics
+// every interval. The controller is not started.
+func newOTLPPipeline(exporter export.Exporter, interval time.Duration) (*otlpPipeline, error) {
+	host, err := hostname()
+	if err != nil {
+		return nil, err
+	}
+	if len(ResourceLabels)%2 != 0 {
+		return nil, fmt.Errorf("metrics: odd number of resource labels")
+	}
+	attrs := []label.KeyValue{
+		semconv.ServiceNameKey.String("fabio"),
+		semconv.HostNameKey.String(host),
+	}
+	for i := 0; i < len(ResourceLabels); i += 2 {
+		attrs = append(attrs, label.String(ResourceLabels[i], ResourceLabels[i+1]))
+	}
+
+	ctrl := controller.New(
+		processor.New(simple.NewWithHistogramDistribution(histogram.WithExplicitBoundaries(prometheus.DefBuckets)), exporter),
+		controller.WithPusher(exporter),
+		controller.WithCollectPeriod(interval),
+		controller.WithResource(resource.NewWithAttributes(attrs...)),
+	)
+	return &otlpPipeline{
+		ctrl:   ctrl,
+		meter:  ctrl.MeterProvider().Meter("github.com/fabiolb/fabio"),
+		insts:  map[string]interface{}{},
+		gauges: map[string]map[string]*otlpGauge{},
+	}, nil
+}
+
+// instrument returns the instrument with the given name or creates it
+// with newInst.
+func (p *otlpPipeline) instrument(name string, newInst func() (interface{}, error)) (interface{}, error) {
+	p.instMu.Lock()
+	defer p.instMu.Unlock()
+	if inst, ok := p.insts[name]; ok {
+		return inst, nil
+	}
+	inst, err := newInst()
+	if err != nil {
+		return nil, err
+	}
+	p.insts[name] = inst
+	return inst, nil
+}
+
+// observeGauges reports the current values of the gauges of an
+// instrument.
+func (p *otlpPipeline) observeGauges(name string, result metric.Float64ObserverResult) {
+	p.gaugeMu.Lock()
+	defer p.gaugeMu.Unlock()
+	for _, g := range p.gauges[name] {
+		result.Observe(g.value(), g.labels...)
+	}
+}
+
+// otlpSeries is a single metric of an instrument.
+type otlpSeries struct {
+	metric interface{}
+	unbind func()
+}
+
+// otlpRegistry implements the LabeledRegistry interface
+// using the OpenTelemetry metrics SDK.
+type otlpRegistry struct {
+	p *otlpPipeline
+
+	mu     sync.Mutex
+	series map[string]map[string]*otlpSeries // flat name -> label values -> series
+}
+
+func newOTLPRegistry(p *otlpPipeline) *otlpRegistry {
+	return &otlpRegistry{p: p, series: map[string]map[string]*otlpSeries{}}
+}
+
+func (r *otlpRegistry) Names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.series))
+	for name := range r.series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *otlpRegistry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.series[name] {
+		s.unbind()
+	}
+	delete(r.series, name)
+}
+
+func (r *otlpRegistry) UnregisterAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, series := range r.series {
+		for _, s := range series {
+			s.unbind()
+		}
+		delete(r.series, name)
+	}
+}
+
+func (r *otlpRegistry) GetCounter(name string) Counter {
+	return r.GetLabeledCounter(name, name)
+}
+
+func (r *otlpRegistry) GetTimer(name string) Timer {
+	return r.GetLabeledTimer(name, name)
+}
+
+func (r *otlpRegistry) GetGauge(name string) Gauge {
+	return r.GetLabeledGauge(name, name)
+}
+
+func (r *otlpRegistry) GetLabeledCounter(flat, name string, labels ...string) Counter {
+	m := r.get(flat, otlpName(name), labels, func(instName string, kvs []label.KeyValue) (*otlpSeries, error) {
+		inst, err := r.p.instrument(instName, func() (interface{}, error) {
+			return r.p.meter.NewInt64Counter(instName, metric.WithDescription("counter "+name))
+		})
+		if err != nil {
+			return nil, err
+		}
+		c, ok := inst.(metric.Int64Counter)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a counter", instName)
+		}
+		b := c.Bind(kvs...)
+		return &otlpSeries{metric: &otlpCounter{b}, unbind: b.Unbind}, nil
+	})
+	if c, ok := m.(Counter); ok {
+		return c
+	}
+	return noopCounter
+}
+
+func (r *otlpRegistry) GetLabeledTimer(flat, name string, labels ...string) Timer {
+	m := r.get(flat, otlpName(name), labels, func(instName string, kvs []label.KeyValue) (*otlpSeries, error) {
+		inst, err := r.p.instrument(instName, func() (interface{}, error) {
+			return r.p.meter.NewFloat64ValueRecorder(instName, metric.WithDescription("timer "+name), metric.WithUnit(unit.Unit("s")))
+		})
+		if err != nil {
+			return nil, err
+		}
+		vr, ok := inst.(metric.Float64ValueRecorder)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a timer", instName)
+		}
+		b := vr.Bind(kvs...)
+		return &otlpSeries{metric: &otlpTimer{b, gm.NewTimer()}, unbind: b.Unbind}, nil
+	})
+	if t, ok := m.(Timer); ok {
+		return t
+	}
+	return noopTimer
+}
+
+func (r *otlpRegistry) GetLabeledGauge(flat, name string, labels ...string) Gauge {
+	m := r.get(flat, otlpName(name), labels, func(instName string, kvs []label.KeyValue) (*otlpSeries, error) {
+		inst, err := r.p.instrument(instName, func() (interface{}, error) {
+			return r.p.meter.NewFloat64ValueObserver(instName, func(_ context.Context, result metric.Float64ObserverResult) {
+				r.p.observeGauges(instName, result)
+			}, metric.WithDescription("gauge "+name))
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := inst.(metric.Float64ValueObserver); !ok {
+			return nil, fmt.Errorf("%s is not a gauge", instName)
+		}
+
+		key := labelKey(kvs)
+		g := &otlpGauge{labels: kvs}
+		r.p.gaugeMu.Lock()
+		if r.p.gauges[instName] == nil {
+			r.p.gauges[instName] = map[string]*otlpGauge{}
+		}
+		r.p.gauges[instName][key] = g
+		r.p.gaugeMu.Unlock()
+
+		unbind := func() {
+			r.p.gaugeMu.Lock()
+			defer r.p.gaugeMu.Unlock()
+			if r.p.gauges[instName][key] == g {
+				delete(r.p.gauges[instName], key)
+			}
+		}
+		return &otlpSeries{metric: g, unbind: unbind}, nil
+	})
+	if g, ok := m.(Gauge); ok {
+		return g
+	}
+	return noopGauge
+}
+
+// get returns the metric with the labels registered as 'flat' or
+// creates it with newSeries. It returns nil if the metric cannot be
+// created.
+func (r *otlpRegistry) get(flat, instName string, labels []string,
+	newSeries func(instName string, kvs []label.KeyValue) (*otlpSeries, error)) interface{} {
+
+	if len(labels)%2 != 0 {
+		log.Printf("[ERROR] metrics: Odd number of labels for %s", instName)
+		return nil
+	}
+	kvs := make([]label.KeyValue, 0, len(labels)/2)
+	for i := 0; i < len(labels); i += 2 {
+		kvs = append(kvs, label.String(labels[i], labels[i+1]))
+	}
+	key := labelKey(kvs)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if s := r.series[flat][key]; s != nil {
+		return s.metric
+	}
+
+	s, err := newSeries(instName, kvs)
+	if err != nil {
+		log.Printf("[ERROR] metrics: Cannot register %s. %s", instName, err)
+		return nil
+	}
+	if r.series[flat] == nil {
+		r.series[flat] = map[string]*otlpSeries{}
+	}
+	r.series[flat][key] = s
+	return s.metric
+}
+
+// labelKey returns a unique key for the label names and values.
+func labelKey(kvs []label.KeyValue) string {
+	var b strings.Builder
+	for _, kv := range kvs {
+		b.WriteString(string(kv.Key))
+		b.WriteByte('\xff')
+		b.WriteString(kv.Value.Emit())
+		b.WriteByte('\xff')
+	}
+	return b.String()
+}
+
+// otlpName returns the instrument name for name with all invalid
+// characters replaced by underscores.
+func otlpName(name string) string {
+	b := []byte(promNamespace + "." + name)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '.', c == '-':
+		default:
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+type otlpCounter struct {
+	c metric.BoundInt64Counter
+}
+
+func (c *otlpCounter) Inc(n int64) {
+	c.c.Add(context.Background(), n)
+}
+
+// otlpGauge stores the last value which is reported by the
+// observer callback of the instrument.
+type otlpGauge struct {
+	labels []label.KeyValue
+
+	mu sync.Mutex
+	v  float64
+}
+
+func (g *otlpGauge) Update(v float64) {
+	g.mu.Lock()
+	g.v = v
+	g.mu.Unlock()
+}
+
+func (g *otlpGauge) value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.v
+}
+
+// otlpTimer records the durations in seconds. It also keeps a
+// go-metrics timer for the rates and percentiles in the admin UI.
+type otlpTimer struct {
+	r metric.BoundFloat64ValueRecorder
+	t gm.Timer
+}
+
+func (t *otlpTimer) Percentile(nth float64) float64 {
+	return t.t.Percentile(nth)
+}
+
+func (t *otlpTimer) Rate1() float64 {
+	return t.t.Rate1()
+}
+
+func (t *otlpTimer) Update(d time.Duration) {
+	t.r.Record(context.Background(), d.Seconds())
+	t.t.Update(d)
+}
+
+func (t *otlpTimer) UpdateSince(start time.Time) {
+	t.Update(time.Since(start))
+}
@@ -0,0 +1,221 @@
+package metrics
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	"go.opentelemetry.io/otel/sdk/export/metric/aggregation"
+)
+
+// otlpRecorder is an exporter which records the values
+// of the last export.
+type otlpRecorder struct {
+	kind     export.ExportKindSelector
+	values   map[string]float64
+	resource string
+}
+
+func (r *otlpRecorder) ExportKindFor(desc *metric.Descriptor, kind aggregation.Kind) export.ExportKind {
+	return r.kind.ExportKindFor(desc, kind)
+}
+
+func (r *otlpRecorder) Export(ctx context.Context, cs export.CheckpointSet) error {
+	r.values = map[string]float64{}
+	return cs.ForEach(r, func(rec export.Record) error {
+		r.resource = rec.Resource().Encoded(label.DefaultEncoder())
+		desc := rec.Descriptor()
+		key := desc.Name() + "{" + rec.Labels().Encoded(label.DefaultEncoder()) + "}"
+		switch agg := rec.Aggregation().(type) {
+		case aggregation.Histogram:
+			n, err := agg.Count()
+			r.values[key] = float64(n)
+			return err
+		case aggregation.Sum:
+			n, err := agg.Sum()
+			r.values[key] = n.CoerceToFloat64(desc.NumberKind())
+			return err
+		case aggregation.LastValue:
+			n, _, err := agg.LastValue()
+			r.values[key] = n.CoerceToFloat64(desc.NumberKind())
+			return err
+		}
+		return nil
+	})
+}
+
+func TestOTLPName(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"tcp.conn", "fabio.tcp.conn"},
+		{"route_rx_bytes", "fabio.route_rx_bytes"},
+		{"svc.a-b./foo.1.2.3.4:80", "fabio.svc.a-b._foo.1.2.3.4_80"},
+	}
+	for _, tt := range tests {
+		if got, want := otlpName(tt.name), tt.want; got != want {
+			t.Errorf("got %q want %q", got, want)
+		}
+	}
+}
+
+func TestOTLPRegistry(t *testing.T) {
+	tests := []struct {
+		desc        string
+		kind        export.ExportKindSelector
+		first, last map[string]float64
+	}{
+		{
+			desc: "cumulative",
+			kind: export.CumulativeExportKindSelector(),
+			first: map[string]float64{
+				"fabio.test_rx_bytes{service=svc,target=1.2.3.4:80}": 5,
+				"fabio.test_duration{code=200}":                      1,
+				"fabio.test.gauge{}":                                 1.5,
+			},
+			last: map[string]float64{
+				"fabio.test_rx_bytes{service=svc,target=1.2.3.4:80}": 12,
+				"fabio.test.gauge{}": 2.5,
+			},
+		},
+		{
+			desc: "delta",
+			kind: export.DeltaExportKindSelector(),
+			first: map[string]float64{
+				"fabio.test_rx_bytes{service=svc,target=1.2.3.4:80}": 5,
+				"fabio.test_duration{code=200}":                      1,
+				"fabio.test.gauge{}":                                 1.5,
+			},
+			last: map[string]float64{
+				"fabio.test_rx_bytes{service=svc,target=1.2.3.4:80}": 7,
+				"fabio.test.gauge{}": 2.5,
+			},
+		},
+	}
+
+	defer func(l []string) { ResourceLabels = l }(ResourceLabels)
+	ResourceLabels = []string{"service.version", "1.2.3"}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			rec := &otlpRecorder{kind: tt.kind}
+			p, err := newOTLPPipeline(rec, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := newOTLPRegistry(p)
+			collect := func() map[string]float64 {
+				if err := p.ctrl.Start(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+				if err := p.ctrl.Stop(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+				return rec.values
+			}
+
+			labels := []string{"service", "svc", "target", "1.2.3.4:80"}
+			LabeledCounter(r, "svc.rx", "test_rx_bytes", labels...).Inc(3)
+			LabeledCounter(r, "svc.rx", "test_rx_bytes", labels...).Inc(2)
+			LabeledTimer(r, "http.status.200", "test_duration", "code", "200").Update(500 * time.Millisecond)
+			r.GetGauge("test.gauge").Update(1.5)
+
+			if got, want := r.Names(), []string{"http.status.200", "svc.rx", "test.gauge"}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got names %v want %v", got, want)
+			}
+			if got, want := collect(), tt.first; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %v want %v", got, want)
+			}
+			if got, want := rec.resource, "host.name="; !strings.Contains(got, want) {
+				t.Fatalf("got resource %q want %q", got, want)
+			}
+			if got, want := rec.resource, "service.version=1.2.3"; !strings.Contains(got, want) {
+				t.Fatalf("got resource %q want %q", got, want)
+			}
+
+			LabeledCounter(r, "svc.rx", "test_rx_bytes", labels...).Inc(7)
+			r.GetGauge("test.gauge").Update(2.5)
+			if got, want := collect(), tt.last; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %v want %v", got, want)
+			}
+
+			r.Unregister("test.gauge")
+			if _, ok := collect()["fabio.test.gauge{}"]; ok {
+				t.Fatal("unregistered gauge was exported")
+			}
+			if got, want := r.Names(), []string{"http.status.200", "svc.rx"}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got names %v want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestOTLPRegistry_HTTP(t *testing.T) {
+	// the server is a stand-in for an OTLP/HTTP receiver
+	var mu sync.Mutex
+	var path, contentType, apiKey string
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		path, contentType, apiKey = r.URL.Path, r.Header.Get("Content-Type"), r.Header.Get("Api-Key")
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	exporter, err := newOTLPExporter(config.OTLP{
+		Addr:        srv.URL,
+		Protocol:    "http",
+		Headers:     map[string]string{"api-key": "secret"},
+		Temporality: "delta",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := newOTLPPipeline(exporter, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ctrl.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	newOTLPRegistry(p).GetCounter("requests").Inc(1)
+	if err := p.ctrl.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got, want := path, "/v1/metrics"; got != want {
+		t.Fatalf("got path %q want %q", got, want)
+	}
+	if got, want := contentType, "application/x-protobuf"; got != want {
+		t.Fatalf("got content type %q want %q", got, want)
+	}
+	if got, want := apiKey, "secret"; got != want {
+		t.Fatalf("got api-key %q want %q", got, want)
+	}
+	for _, want := range []string{"fabio.requests", "host.name", "service.name"} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("%q not found in request", want)
+		}
+	}
+}
+
+func TestNewOTLPExporter_InvalidURL(t *testing.T) {
+	for _, addr := range []string{"localhost:4317", "ftp://localhost:4317", "http://"} {
+		if _, err := newOTLPExporter(config.OTLP{Addr: addr, Protocol: "grpc"}); err == nil {
+			t.Errorf("%s: got nil want error", addr)
+		}
+	}
+}
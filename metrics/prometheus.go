@@ -0,0 +1,265 @@
+package metrics
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	gm "github.com/rcrowley/go-metrics"
+)
+
+// promNamespace is the prefix of all metric names.
+const promNamespace = "fabio"
+
+// promMetrics contains the collectors of all Prometheus registries
+// since they are exposed on the same endpoint.
+var promMetrics = struct {
+	sync.Mutex
+	reg  *prometheus.Registry
+	vecs map[string]*promVec
+}{
+	reg:  newPromRegistry(),
+	vecs: map[string]*promVec{},
+}
+
+func newPromRegistry() *prometheus.Registry {
+	r := prometheus.NewRegistry()
+	r.MustRegister(prometheus.NewGoCollector())
+	r.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	return r
+}
+
+// PrometheusHandler returns the handler for the Prometheus endpoint.
+func PrometheusHandler() http.Handler {
+	return promhttp.HandlerFor(promMetrics.reg, promhttp.HandlerOpts{})
+}
+
+// promVec is a registered metric vector.
+type promVec struct {
+	labels []string
+	vec    interface {
+		prometheus.Collector
+		DeleteLabelValues(lvs ...string) bool
+	}
+}
+
+// promSeries is a single metric of a vector.
+type promSeries struct {
+	vec    *promVec
+	values []string
+	metric interface{}
+}
+
+// promRegistry implements the LabeledRegistry interface
+// using the github.com/prometheus/client_golang library.
+type promRegistry struct {
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]map[string]*promSeries // flat name -> label values -> series
+}
+
+// prometheusRegistry returns a registry which exposes the metrics on
+// the Prometheus endpoint.
+func prometheusRegistry(cfg config.Prometheus) (Registry, error) {
+	buckets := cfg.Buckets
+	if len(buckets) == 0 {
+		buckets = prometheus.DefBuckets
+	}
+	return &promRegistry{buckets: buckets, series: map[string]map[string]*promSeries{}}, nil
+}
+
+func (p *promRegistry) Names() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	names := make([]string, 0, len(p.series))
+	for name := range p.series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *promRegistry) Unregister(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, s := range p.series[name] {
+		s.vec.vec.DeleteLabelValues(s.values...)
+	}
+	delete(p.series, name)
+}
+
+func (p *promRegistry) UnregisterAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for name, series := range p.series {
+		for _, s := range series {
+			s.vec.vec.DeleteLabelValues(s.values...)
+		}
+		delete(p.series, name)
+	}
+}
+
+func (p *promRegistry) GetCounter(name string) Counter {
+	return p.GetLabeledCounter(name, name)
+}
+
+func (p *promRegistry) GetTimer(name string) Timer {
+	return p.GetLabeledTimer(name, name)
+}
+
+func (p *promRegistry) GetGauge(name string) Gauge {
+	return p.GetLabeledGauge(name, name)
+}
+
+func (p *promRegistry) GetLabeledCounter(flat, name string, labels ...string) Counter {
+	m := p.get(flat, promName(name, "_total"), labels, func(fqName string, names []string) promVec {
+		return promVec{vec: prometheus.NewCounterVec(prometheus.CounterOpts{Name: fqName, Help: "counter " + name}, names)}
+	}, func(v *promVec, values []string) interface{} {
+		return &promCounter{v.vec.(*prometheus.CounterVec).WithLabelValues(values...)}
+	})
+	if c, ok := m.(Counter); ok {
+		return c
+	}
+	return noopCounter
+}
+
+func (p *promRegistry) GetLabeledTimer(flat, name string, labels ...string) Timer {
+	m := p.get(flat, promName(name, "_seconds"), labels, func(fqName string, names []string) promVec {
+		return promVec{vec: prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: fqName, Help: "timer " + name, Buckets: p.buckets}, names)}
+	}, func(v *promVec, values []string) interface{} {
+		return &promTimer{v.vec.(*prometheus.HistogramVec).WithLabelValues(values...), gm.NewTimer()}
+	})
+	if t, ok := m.(Timer); ok {
+		return t
+	}
+	return noopTimer
+}
+
+func (p *promRegistry) GetLabeledGauge(flat, name string, labels ...string) Gauge {
+	m := p.get(flat, promName(name, ""), labels, func(fqName string, names []string) promVec {
+		return promVec{vec: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: fqName, Help: "gauge " + name}, names)}
+	}, func(v *promVec, values []string) interface{} {
+		return &promGauge{v.vec.(*prometheus.GaugeVec).WithLabelValues(values...)}
+	})
+	if g, ok := m.(Gauge); ok {
+		return g
+	}
+	return noopGauge
+}
+
+// get returns the metric with the label values registered as 'flat'
+// or creates it. The vector for fqName is created with newVec if it
+// does not exist and the metric is created with newMetric. It returns
+// nil if the metric cannot be created.
+func (p *promRegistry) get(flat, fqName string, labels []string,
+	newVec func(fqName string, names []string) promVec,
+	newMetric func(v *promVec, values []string) interface{}) interface{} {
+
+	if len(labels)%2 != 0 {
+		log.Printf("[ERROR] metrics: Odd number of labels for %s", fqName)
+		return nil
+	}
+	var names, values []string
+	for i := 0; i < len(labels); i += 2 {
+		names = append(names, labels[i])
+		values = append(values, labels[i+1])
+	}
+	key := strings.Join(values, "\xff")
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if s := p.series[flat][key]; s != nil {
+		return s.metric
+	}
+
+	promMetrics.Lock()
+	defer promMetrics.Unlock()
+	v := promMetrics.vecs[fqName]
+	if v == nil {
+		nv := newVec(fqName, names)
+		nv.labels = names
+		if err := promMetrics.reg.Register(nv.vec); err != nil {
+			log.Printf("[ERROR] metrics: Cannot register %s. %s", fqName, err)
+			return nil
+		}
+		v = &nv
+		promMetrics.vecs[fqName] = v
+	}
+	if strings.Join(v.labels, ",") != strings.Join(names, ",") {
+		log.Printf("[ERROR] metrics: Labels %v of %s do not match %v", names, fqName, v.labels)
+		return nil
+	}
+
+	s := &promSeries{vec: v, values: values, metric: newMetric(v, values)}
+	if p.series[flat] == nil {
+		p.series[flat] = map[string]*promSeries{}
+	}
+	p.series[flat][key] = s
+	return s.metric
+}
+
+// promName returns the fully qualified metric name for name with
+// all invalid characters replaced by underscores.
+func promName(name, suffix string) string {
+	b := []byte(promNamespace + "_" + name)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_':
+		default:
+			b[i] = '_'
+		}
+	}
+	s := string(b)
+	if !strings.HasSuffix(s, suffix) {
+		s += suffix
+	}
+	return s
+}
+
+type promCounter struct {
+	c prometheus.Counter
+}
+
+func (c *promCounter) Inc(n int64) {
+	c.c.Add(float64(n))
+}
+
+type promGauge struct {
+	g prometheus.Gauge
+}
+
+func (g *promGauge) Update(v float64) {
+	g.g.Set(v)
+}
+
+// promTimer records the durations in a histogram. It also keeps a
+// go-metrics timer for the rates and percentiles in the admin UI.
+type promTimer struct {
+	h prometheus.Observer
+	t gm.Timer
+}
+
+func (t *promTimer) Percentile(nth float64) float64 {
+	return t.t.Percentile(nth)
+}
+
+func (t *promTimer) Rate1() float64 {
+	return t.t.Rate1()
+}
+
+func (t *promTimer) Update(d time.Duration) {
+	t.h.Observe(d.Seconds())
+	t.t.Update(d)
+}
+
+func (t *promTimer) UpdateSince(start time.Time) {
+	t.Update(time.Since(start))
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+func TestPromName(t *testing.T) {
+	tests := []struct {
+		name, suffix, want string
+	}{
+		{"tcp.conn", "_total", "fabio_tcp_conn_total"},
+		{"route_rx_bytes_total", "_total", "fabio_route_rx_bytes_total"},
+		{"cert.a-b:c", "", "fabio_cert_a_b_c"},
+		{"requests", "_seconds", "fabio_requests_seconds"},
+	}
+	for _, tt := range tests {
+		if got, want := promName(tt.name, tt.suffix), tt.want; got != want {
+			t.Errorf("got %q want %q", got, want)
+		}
+	}
+}
+
+func TestPrometheusRegistry(t *testing.T) {
+	r, err := prometheusRegistry(config.Prometheus{Buckets: []float64{0.1, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	labels := []string{"service", "svc", "host", "example.com", "path", "/", "target", "1.2.3.4:80"}
+	LabeledCounter(r, "svc.rx", "test_rx_bytes", labels...).Inc(3)
+	LabeledCounter(r, "svc.rx", "test_rx_bytes", labels...).Inc(2)
+	LabeledTimer(r, "http.status.200", "test_response_duration", "code", "200").Update(500 * time.Millisecond)
+	r.GetGauge("test.gauge").Update(1.5)
+
+	// labels must match the first registration
+	LabeledCounter(r, "other.rx", "test_rx_bytes", "service", "svc").Inc(1)
+
+	if got, want := r.Names(), []string{"http.status.200", "svc.rx", "test.gauge"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got names %v want %v", got, want)
+	}
+
+	scrape := func() string {
+		rec := httptest.NewRecorder()
+		PrometheusHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		b, _ := ioutil.ReadAll(rec.Body)
+		return string(b)
+	}
+
+	body := scrape()
+	for _, want := range []string{
+		`fabio_test_rx_bytes_total{host="example.com",path="/",service="svc",target="1.2.3.4:80"} 5`,
+		`fabio_test_response_duration_seconds_bucket{code="200",le="0.1"} 0`,
+		`fabio_test_response_duration_seconds_bucket{code="200",le="1"} 1`,
+		`fabio_test_response_duration_seconds_count{code="200"} 1`,
+		`fabio_test_gauge 1.5`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metric %q not found in\n%s", want, body)
+		}
+	}
+
+	r.Unregister("svc.rx")
+	if body := scrape(); strings.Contains(body, "fabio_test_rx_bytes_total{") {
+		t.Errorf("unregistered metric found in\n%s", body)
+	}
+	if got, want := r.Names(), []string{"http.status.200", "test.gauge"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got names %v want %v", got, want)
+	}
+}
@@ -29,6 +29,11 @@ type Registry interface {
 	// If the metric does not exist yet it should be created
 	// otherwise the existing metric should be returned.
 	GetTimer(name string) Timer
+
+	// GetGauge returns a gauge metric for the given name.
+	// If the metric does not exist yet it should be created
+	// otherwise the existing metric should be returned.
+	GetGauge(name string) Gauge
 }
 
 // Counter defines a metric for counting events.
@@ -37,6 +42,12 @@ type Counter interface {
 	Inc(n int64)
 }
 
+// Gauge defines a metric for recording an instantaneous value.
+type Gauge interface {
+	// Update sets the gauge to 'v'.
+	Update(v float64)
+}
+
 // Timer defines a metric for counting and timing durations for events.
 type Timer interface {
 	// Percentile returns the nth percentile of the duration.
@@ -13,14 +13,19 @@ import (
 	"time"
 
 	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/logger"
 	"github.com/fabiolb/fabio/metrics"
 	"github.com/fabiolb/fabio/route"
+	"github.com/fabiolb/fabio/trace"
 	grpc_proxy "github.com/mwitkow/grpc-proxy/proxy"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/stats"
 	"google.golang.org/grpc/status"
 )
@@ -43,9 +48,9 @@ func (s *gRPCServer) Serve(lis net.Listener) error {
 	return s.server.Serve(lis)
 }
 
-func GetGRPCDirector(tlscfg *tls.Config) func(ctx context.Context, fullMethodName string) (context.Context, *grpc.ClientConn, error) {
+func GetGRPCDirector(tlscfg *tls.Config, upstream *UpstreamTLS) func(ctx context.Context, fullMethodName string) (context.Context, *grpc.ClientConn, error) {
 
-	connectionPool := newGrpcConnectionPool(tlscfg)
+	connectionPool := newGrpcConnectionPool(tlscfg, upstream)
 
 	return func(ctx context.Context, fullMethodName string) (context.Context, *grpc.ClientConn, error) {
 		md, ok := metadata.FromIncomingContext(ctx)
@@ -55,7 +60,9 @@ func GetGRPCDirector(tlscfg *tls.Config) func(ctx context.Context, fullMethodNam
 		}
 
 		outCtx, _ := context.WithCancel(ctx)
-		outCtx = metadata.NewOutgoingContext(outCtx, md.Copy())
+		md = md.Copy()
+		trace.InjectMetadata(ctx, md)
+		outCtx = metadata.NewOutgoingContext(outCtx, md)
 
 		target, _ := ctx.Value(targetKey{}).(*route.Target)
 
@@ -75,6 +82,9 @@ type GrpcProxyInterceptor struct {
 	Config       *config.Config
 	StatsHandler *GrpcStatsHandler
 	GlobCache    *route.GlobCache
+
+	// Logger is the access logger for the calls.
+	Logger logger.Logger
 }
 
 type targetKey struct{}
@@ -88,10 +98,24 @@ func (p proxyStream) Context() context.Context {
 	return p.ctx
 }
 
-func (g GrpcProxyInterceptor) Stream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+func (g GrpcProxyInterceptor) Stream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
 	ctx := stream.Context()
+	start := time.Now()
 
-	target, err := g.lookup(ctx, info.FullMethod)
+	var target *route.Target
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx, span := trace.StartGRPCSpan(ctx, md, info.FullMethod)
+	defer func() {
+		s, _ := status.FromError(err)
+		span.SetAttributes(label.Int("rpc.grpc.status_code", int(s.Code())))
+		if err != nil {
+			span.SetStatus(otelcodes.Error, s.Message())
+		}
+		span.End()
+		g.log(ctx, info.FullMethod, target, s.Code(), start)
+	}()
+
+	target, err = g.lookup(ctx, info.FullMethod)
 
 	if err != nil {
 		log.Println("[ERROR] grpc: error looking up route", err)
@@ -104,6 +128,8 @@ func (g GrpcProxyInterceptor) Stream(srv interface{}, stream grpc.ServerStream,
 		return status.Error(codes.NotFound, "no route found")
 	}
 
+	span.SetAttributes(targetAttributes(target)...)
+
 	ctx = context.WithValue(ctx, targetKey{}, target)
 
 	proxyStream := proxyStream{
@@ -111,18 +137,76 @@ func (g GrpcProxyInterceptor) Stream(srv interface{}, stream grpc.ServerStream,
 		ctx:          ctx,
 	}
 
-	start := time.Now()
+	upstreamStart := time.Now()
 
 	err = handler(srv, proxyStream)
 
 	end := time.Now()
-	dur := end.Sub(start)
+	dur := end.Sub(upstreamStart)
 
 	target.Timer.Update(dur)
 
+	s, _ := status.FromError(err)
+	grpcTimer(target, info.FullMethod, s.Code()).Update(dur)
+
 	return err
 }
 
+// grpcOtherMethod is the method name of the timer for calls
+// of methods which are not implemented by the upstream.
+const grpcOtherMethod = "other"
+
+// grpcTimer returns the timer for calls of the method with the
+// status code to the target. The name is derived from the route
+// metric name of the target, e.g.
+// <target>.grpc.helloworld_greeter_sayhello.ok
+//
+// The method is chosen by the client. To bound the number of timers
+// calls which the upstream does not implement are recorded for the
+// method 'other'.
+func grpcTimer(t *route.Target, method string, code codes.Code) metrics.Timer {
+	if code == codes.Unimplemented {
+		method = grpcOtherMethod
+	}
+	m := metrics.Clean(strings.Replace(strings.TrimPrefix(method, "/"), "/", ".", -1))
+	c := strings.ToLower(code.String())
+	labels := append(append([]string{}, t.MetricLabels...), "method", method, "code", c)
+	return metrics.LabeledTimer(metrics.DefaultRegistry, t.TimerName+".grpc."+m+"."+c, "route_grpc_duration", labels...)
+}
+
+// log writes the access log event for a gRPC call.
+func (g GrpcProxyInterceptor) log(ctx context.Context, method string, t *route.Target, code codes.Code, start time.Time) {
+	if g.Logger == nil {
+		return
+	}
+	e := &logger.Event{
+		Start:      start,
+		End:        time.Now(),
+		Proto:      "grpc",
+		GRPCMethod: method,
+		GRPCStatus: code.String(),
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if p.Addr != nil {
+			e.RemoteAddr = p.Addr.String()
+		}
+		if ti, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			e.Proto = "grpcs"
+			e.ServerName = ti.State.ServerName
+		}
+	}
+	if t != nil {
+		e.UpstreamService = t.Service
+		e.Route = t.Route
+		e.TargetWeight = t.Weight
+		e.SampleRules = t.AccessLog
+		if t.URL != nil {
+			e.UpstreamAddr = t.URL.Host
+		}
+	}
+	g.Logger.Log(e)
+}
+
 func (g GrpcProxyInterceptor) lookup(ctx context.Context, fullMethodName string) (*route.Target, error) {
 	pick := route.Picker[g.Config.Proxy.Strategy]
 	match := route.Matcher[g.Config.Proxy.Matcher]
@@ -186,7 +270,8 @@ func (h *GrpcStatsHandler) HandleRPC(ctx context.Context, rpc stats.RPCStats) {
 	h.Request.Update(dur)
 
 	s, _ := status.FromError(rpcStats.Error)
-	metrics.DefaultRegistry.GetTimer(fmt.Sprintf("grpc.status.%s", strings.ToLower(s.Code().String())))
+	code := strings.ToLower(s.Code().String())
+	metrics.LabeledTimer(metrics.DefaultRegistry, "grpc.status."+code, "grpc_response_duration", "code", code).Update(dur)
 }
 
 // HandleConn processes the Conn stats.
@@ -198,19 +283,29 @@ func (h *GrpcStatsHandler) HandleConn(ctx context.Context, conn stats.ConnStats)
 	}
 }
 
+// grpcCloseGrace is the time after which a replaced connection is
+// closed so that the calls which are still using it can complete.
+var grpcCloseGrace = time.Minute
+
 type grpcConnectionPool struct {
 	connections     map[*route.Target]*grpc.ClientConn
+	generations     map[*route.Target]uint64
 	lock            sync.RWMutex
 	cleanupInterval time.Duration
+	closeGrace      time.Duration
 	tlscfg          *tls.Config
+	upstream        *UpstreamTLS
 }
 
-func newGrpcConnectionPool(tlscfg *tls.Config) *grpcConnectionPool {
+func newGrpcConnectionPool(tlscfg *tls.Config, upstream *UpstreamTLS) *grpcConnectionPool {
 	cp := &grpcConnectionPool{
 		connections:     make(map[*route.Target]*grpc.ClientConn),
+		generations:     make(map[*route.Target]uint64),
 		lock:            sync.RWMutex{},
 		cleanupInterval: time.Second * 5,
+		closeGrace:      grpcCloseGrace,
 		tlscfg:          tlscfg,
+		upstream:        upstream,
 	}
 
 	go cp.cleanup()
@@ -220,22 +315,46 @@ func newGrpcConnectionPool(tlscfg *tls.Config) *grpcConnectionPool {
 
 func (p *grpcConnectionPool) Get(ctx context.Context, target *route.Target) (*grpc.ClientConn, error) {
 	p.lock.RLock()
-	conn := p.connections[target]
+	conn := p.current(target)
 	p.lock.RUnlock()
 
-	if conn != nil && conn.GetState() != connectivity.Shutdown {
+	if conn != nil {
 		return conn, nil
 	}
 
 	return p.newConnection(ctx, target)
 }
 
+// current returns the connection to the target unless it is shut
+// down or its client certificate or CA bundle has been updated.
+// p.lock must be held.
+func (p *grpcConnectionPool) current(target *route.Target) *grpc.ClientConn {
+	conn := p.connections[target]
+	if conn == nil || conn.GetState() == connectivity.Shutdown {
+		return nil
+	}
+	if p.upstream != nil && UsesUpstreamTLS(target) && p.upstream.Generation(target) != p.generations[target] {
+		return nil
+	}
+	return conn
+}
+
 func (p *grpcConnectionPool) newConnection(ctx context.Context, target *route.Target) (*grpc.ClientConn, error) {
 	opts := []grpc.DialOption{
 		grpc.WithDefaultCallOptions(grpc.CallCustomCodec(grpc_proxy.Codec())),
 	}
 
-	if target.URL.Scheme == "grpcs" && p.tlscfg != nil {
+	var gen uint64
+	switch {
+	case p.upstream != nil && UsesUpstreamTLS(target):
+		tlscfg, g, err := p.upstream.TLSConfig(target)
+		if err != nil {
+			return nil, err
+		}
+		gen = g
+		tlscfg.ServerName = target.Opts["grpcservername"]
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlscfg)))
+	case target.URL.Scheme == "grpcs" && p.tlscfg != nil:
 		opts = append(opts, grpc.WithTransportCredentials(
 			credentials.NewTLS(&tls.Config{
 				ClientCAs:          p.tlscfg.ClientCAs,
@@ -245,23 +364,31 @@ func (p *grpcConnectionPool) newConnection(ctx context.Context, target *route.Ta
 				// then you will need to override the servername
 				ServerName: target.Opts["grpcservername"],
 			})))
-	} else {
+	default:
 		opts = append(opts, grpc.WithInsecure())
 	}
 
 	conn, err := grpc.DialContext(ctx, target.URL.Host, opts...)
-
-	if err == nil {
-		p.Set(target, conn)
+	if err != nil {
+		return nil, err
 	}
 
-	return conn, err
-}
-
-func (p *grpcConnectionPool) Set(target *route.Target, conn *grpc.ClientConn) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
+
+	// another call has connected in the meantime
+	if cur := p.current(target); cur != nil {
+		conn.Close()
+		return cur, nil
+	}
+
+	// the calls on the replaced connection are not aborted
+	if old := p.connections[target]; old != nil {
+		time.AfterFunc(p.closeGrace, func() { old.Close() })
+	}
 	p.connections[target] = conn
+	p.generations[target] = gen
+	return conn, nil
 }
 
 func (p *grpcConnectionPool) cleanup() {
@@ -271,6 +398,7 @@ func (p *grpcConnectionPool) cleanup() {
 		for target, cs := range p.connections {
 			if cs.GetState() == connectivity.Shutdown {
 				delete(p.connections, target)
+				delete(p.generations, target)
 				continue
 			}
 
@@ -278,6 +406,7 @@ func (p *grpcConnectionPool) cleanup() {
 				log.Println("[DEBUG] grpc: cleaning up connection to", target.URL.Host)
 				cs.Close()
 				delete(p.connections, target)
+				delete(p.generations, target)
 			}
 		}
 		p.lock.Unlock()
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/metrics"
+	"github.com/fabiolb/fabio/route"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// timerNames records the names of the requested timers.
+type timerNames struct {
+	metrics.NoopRegistry
+	names []string
+}
+
+func (r *timerNames) GetTimer(name string) metrics.Timer {
+	r.names = append(r.names, name)
+	return metrics.NoopTimer{}
+}
+
+func TestGRPCTimer(t *testing.T) {
+	r := &timerNames{}
+	defer func(old metrics.Registry) { metrics.DefaultRegistry = old }(metrics.DefaultRegistry)
+	metrics.DefaultRegistry = r
+
+	tg := &route.Target{TimerName: "svc-a.example_com./foo.1_2_3_4_5000"}
+	grpcTimer(tg, "/helloworld.Greeter/SayHello", codes.NotFound)
+	grpcTimer(tg, "/random.Service/Method123", codes.Unimplemented)
+
+	want := []string{
+		"svc-a.example_com./foo.1_2_3_4_5000.grpc.helloworld_greeter_sayhello.notfound",
+		"svc-a.example_com./foo.1_2_3_4_5000.grpc.other.unimplemented",
+	}
+	if !reflect.DeepEqual(r.names, want) {
+		t.Fatalf("got %v want %v", r.names, want)
+	}
+}
+
+func TestGrpcConnectionPoolConcurrentGet(t *testing.T) {
+	p := &grpcConnectionPool{
+		connections: map[*route.Target]*grpc.ClientConn{},
+		generations: map[*route.Target]uint64{},
+		closeGrace:  time.Minute,
+	}
+	tg := &route.Target{URL: &url.URL{Scheme: "grpc", Host: "127.0.0.1:1"}}
+
+	conns := make([]*grpc.ClientConn, 10)
+	var wg sync.WaitGroup
+	for i := range conns {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn, err := p.Get(context.Background(), tg)
+			if err != nil {
+				t.Error(err)
+			}
+			conns[i] = conn
+		}(i)
+	}
+	wg.Wait()
+
+	for i, conn := range conns {
+		if conn != p.connections[tg] {
+			t.Fatalf("%d: got a connection which is not in the pool", i)
+		}
+	}
+	p.connections[tg].Close()
+}
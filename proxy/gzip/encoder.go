@@ -0,0 +1,114 @@
+package gzip
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	encodingBrotli   = "br"
+	encodingZstd     = "zstd"
+	encodingIdentity = "identity"
+)
+
+// Encodings contains the supported content encodings
+// in the default order of preference.
+var Encodings = []string{encodingBrotli, encodingZstd, encodingGzip}
+
+// encoder is a compressing writer which can be reused
+// for another response after it has been closed.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// newEncoder creates an encoder for the encoding with the compression
+// level. A level of 0 selects the default level of the encoding. Levels
+// outside of the valid range of the encoding are clamped.
+func newEncoder(encoding string, level int) (encoder, error) {
+	switch encoding {
+	case encodingGzip:
+		if level == 0 {
+			return gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		}
+		return gzip.NewWriterLevel(nil, clamp(level, gzip.BestSpeed, gzip.BestCompression))
+
+	case encodingBrotli:
+		if level == 0 {
+			level = brotli.DefaultCompression
+		}
+		return brotli.NewWriterLevel(nil, clamp(level, brotli.BestSpeed, brotli.BestCompression)), nil
+
+	case encodingZstd:
+		opts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+		if level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		return zstd.NewWriter(nil, opts...)
+
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// pools contains the encoder pools per encoding and level.
+var (
+	poolsMu sync.Mutex
+	pools   = map[poolKey]*sync.Pool{}
+)
+
+type poolKey struct {
+	encoding string
+	level    int
+}
+
+func encoderPool(encoding string, level int) *sync.Pool {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+	k := poolKey{encoding, level}
+	p := pools[k]
+	if p == nil {
+		p = &sync.Pool{}
+		pools[k] = p
+	}
+	return p
+}
+
+// getEncoder returns an encoder for the encoding and level
+// from the pool which writes to w.
+func getEncoder(encoding string, level int, w io.Writer) (encoder, error) {
+	if e, ok := encoderPool(encoding, level).Get().(encoder); ok {
+		e.Reset(w)
+		return e, nil
+	}
+	e, err := newEncoder(encoding, level)
+	if err != nil {
+		return nil, err
+	}
+	e.Reset(w)
+	return e, nil
+}
+
+// putEncoder closes the encoder and returns it to the pool.
+func putEncoder(encoding string, level int, e encoder) error {
+	err := e.Close()
+	e.Reset(nil)
+	encoderPool(encoding, level).Put(e)
+	return err
+}
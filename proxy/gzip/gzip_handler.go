@@ -2,122 +2,301 @@
 
 // Package gzip provides an HTTP handler which compresses responses
 // if the client supports this, the response is compressable and
-// not already compressed.
+// not already compressed. The encoding is negotiated with the
+// Accept-Encoding header of the client and can be one of brotli,
+// zstd or gzip.
 //
 // Based on https://github.com/smancke/handler/gzip
 package gzip
 
 import (
 	"bufio"
-	"compress/gzip"
 	"errors"
 	"io"
+	"log"
 	"net"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
-	"sync"
 )
 
 const (
 	headerVary            = "Vary"
 	headerAccept          = "Accept"
 	headerAcceptEncoding  = "Accept-Encoding"
+	headerCacheControl    = "Cache-Control"
 	headerContentEncoding = "Content-Encoding"
 	headerContentType     = "Content-Type"
 	headerContentLength   = "Content-Length"
+	headerETag            = "ETag"
+	headerIfNoneMatch     = "If-None-Match"
+	headerRange           = "Range"
 	encodingGzip          = "gzip"
 )
 
 var blacklistedAcceptContentTypes = []string{"text/event-stream"}
 
-var gzipWriterPool = sync.Pool{
-	New: func() interface{} { return gzip.NewWriter(nil) },
+// DefaultContentTypes matches the content types which are compressed
+// if compression is enabled for a route but no content types are
+// configured.
+var DefaultContentTypes = regexp.MustCompile(`^(text/.*|application/(javascript|json|font-woff|xml)|.*\+(json|xml))(;.*)?$`)
+
+// Config configures the compression of responses.
+type Config struct {
+	// ContentTypes matches the content types of the responses
+	// which are compressed. If nil DefaultContentTypes is used.
+	ContentTypes *regexp.Regexp
+
+	// Encodings contains the supported encodings in the order of
+	// preference for encodings with the same q-value. If empty
+	// Encodings is used.
+	Encodings []string
+
+	// MinSize is the minimum size of the response body in bytes.
+	// Smaller responses are sent uncompressed.
+	MinSize int
+
+	// Level is the compression level. If 0 the default
+	// level of the encoding is used.
+	Level int
 }
 
 // NewGzipHandler wraps an existing handler to transparently gzip the response
 // body if the client supports it (via the Accept-Encoding header) and the
 // response Content-Type matches the contentTypes expression.
 func NewGzipHandler(h http.Handler, contentTypes *regexp.Regexp) http.Handler {
+	return NewHandler(h, Config{ContentTypes: contentTypes, Encodings: []string{encodingGzip}})
+}
+
+// NewHandler wraps an existing handler to transparently compress the
+// response body with the encoding which is preferred by the client
+// and supported by cfg. Range requests are not compressed since the
+// ranges refer to the uncompressed body.
+func NewHandler(h http.Handler, cfg Config) http.Handler {
+	if cfg.ContentTypes == nil {
+		cfg.ContentTypes = DefaultContentTypes
+	}
+	if len(cfg.Encodings) == 0 {
+		cfg.Encodings = Encodings
+	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add(headerVary, headerAcceptEncoding)
 
-		if acceptsGzip(r) {
-			gzWriter := NewGzipResponseWriter(w, contentTypes)
-			defer gzWriter.Close()
-			h.ServeHTTP(gzWriter, r)
-		} else {
+		var encoding string
+		if acceptsCompression(r) && r.Header.Get(headerRange) == "" {
+			encoding = negotiate(r.Header.Get(headerAcceptEncoding), cfg.Encodings)
+		}
+		if encoding == "" {
 			h.ServeHTTP(w, r)
+			return
 		}
+
+		cw := NewResponseWriter(w, cfg, encoding)
+		cw.ifNoneMatch = r.Header.Get(headerIfNoneMatch)
+		defer cw.Close()
+		h.ServeHTTP(cw, r)
 	})
 }
 
-type GzipResponseWriter struct {
-	writer       io.Writer
-	gzipWriter   *gzip.Writer
-	contentTypes *regexp.Regexp
+// ResponseWriter compresses the response body with an encoder from a
+// pool if the response is compressable. The body is buffered until it
+// reaches the minimum size unless the Content-Length header is set.
+type ResponseWriter struct {
 	http.ResponseWriter
+	cfg      Config
+	encoding string
+
+	// code is the status code of the response
+	// or zero if the header was not written.
+	code int
+
+	// ifNoneMatch is the If-None-Match header of the request.
+	ifNoneMatch string
+
+	// writer is either the encoder or the underlying response
+	// writer. It is nil while the body is buffered.
+	writer  io.Writer
+	encoder encoder
+	buf     []byte
 }
 
-func NewGzipResponseWriter(w http.ResponseWriter, contentTypes *regexp.Regexp) *GzipResponseWriter {
-	return &GzipResponseWriter{ResponseWriter: w, contentTypes: contentTypes}
+// NewResponseWriter creates a response writer which
+// compresses the response body with the encoding.
+func NewResponseWriter(w http.ResponseWriter, cfg Config, encoding string) *ResponseWriter {
+	return &ResponseWriter{ResponseWriter: w, cfg: cfg, encoding: encoding}
 }
 
-func (grw *GzipResponseWriter) WriteHeader(code int) {
-	if grw.writer == nil {
-		if isCompressable(grw.Header(), grw.contentTypes) {
-			grw.Header().Del(headerContentLength)
-			grw.Header().Set(headerContentEncoding, encodingGzip)
-			grw.gzipWriter = gzipWriterPool.Get().(*gzip.Writer)
-			grw.gzipWriter.Reset(grw.ResponseWriter)
+func (cw *ResponseWriter) WriteHeader(code int) {
+	if cw.code != 0 {
+		return
+	}
+	cw.code = code
 
-			grw.writer = grw.gzipWriter
-		} else {
-			grw.writer = grw.ResponseWriter
+	h := cw.Header()
+	switch {
+	case code == http.StatusNotModified:
+		// the ETag must match the one of the compressed response
+		// if the client has revalidated the compressed response.
+		if hasWeakETag(cw.ifNoneMatch, h.Get(headerETag)) {
+			weakenETag(h)
+		}
+		cw.writeIdentity()
+	case !isCompressable(code, h, cw.cfg.ContentTypes):
+		cw.writeIdentity()
+	case cw.cfg.MinSize <= 0:
+		cw.writeEncoded()
+	default:
+		n, err := strconv.Atoi(h.Get(headerContentLength))
+		switch {
+		case err != nil:
+			// wait until MinSize bytes have been written
+		case n < cw.cfg.MinSize:
+			cw.writeIdentity()
+		default:
+			cw.writeEncoded()
 		}
 	}
-	grw.ResponseWriter.WriteHeader(code)
 }
 
-func (grw *GzipResponseWriter) Write(b []byte) (int, error) {
-	if grw.writer == nil {
-		if _, ok := grw.Header()[headerContentType]; !ok {
+func (cw *ResponseWriter) Write(b []byte) (int, error) {
+	if cw.code == 0 {
+		if _, ok := cw.Header()[headerContentType]; !ok {
 			// Set content-type if not present. Otherwise golang would make application/gzip out of that.
-			grw.Header().Set(headerContentType, http.DetectContentType(b))
+			cw.Header().Set(headerContentType, http.DetectContentType(b))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.writer != nil {
+		return cw.writer.Write(b)
+	}
+
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) >= cw.cfg.MinSize {
+		if err := cw.writeEncoded(); err != nil {
+			return 0, err
 		}
-		grw.WriteHeader(http.StatusOK)
 	}
-	return grw.writer.Write(b)
+	return len(b), nil
 }
 
-func (grw *GzipResponseWriter) Close() {
-	if grw.gzipWriter != nil {
-		grw.gzipWriter.Close()
-		gzipWriterPool.Put(grw.gzipWriter)
+// Flush writes the compressed data to the client. A buffered body
+// is compressed since the response is streamed.
+func (cw *ResponseWriter) Flush() {
+	if cw.code != 0 && cw.writer == nil {
+		cw.writeEncoded()
+	}
+	if cw.encoder != nil {
+		cw.encoder.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
 	}
 }
 
-func (grw *GzipResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
-	if hj, ok := grw.ResponseWriter.(http.Hijacker); ok {
+// Close writes a buffered body uncompressed and completes the
+// compressed body. The encoder is returned to the pool.
+func (cw *ResponseWriter) Close() {
+	if cw.code != 0 && cw.writer == nil {
+		cw.Header().Set(headerContentLength, strconv.Itoa(len(cw.buf)))
+		cw.writeIdentity()
+	}
+	if cw.encoder != nil {
+		if err := putEncoder(cw.encoding, cw.cfg.Level, cw.encoder); err != nil {
+			log.Printf("[ERROR] Cannot complete %s encoded response. %s", cw.encoding, err)
+		}
+		cw.encoder = nil
+	}
+}
+
+func (cw *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := cw.ResponseWriter.(http.Hijacker); ok {
 		return hj.Hijack()
 	}
 	return nil, nil, errors.New("not a Hijacker")
 }
 
-func isCompressable(header http.Header, contentTypes *regexp.Regexp) bool {
+// writeIdentity writes the header and the buffered body uncompressed.
+func (cw *ResponseWriter) writeIdentity() error {
+	cw.writer = cw.ResponseWriter
+	cw.ResponseWriter.WriteHeader(cw.code)
+	return cw.writeBuffer()
+}
+
+// writeEncoded writes the header and the buffered body compressed.
+func (cw *ResponseWriter) writeEncoded() error {
+	e, err := getEncoder(cw.encoding, cw.cfg.Level, cw.ResponseWriter)
+	if err != nil {
+		log.Printf("[ERROR] Cannot create %s encoder. %s", cw.encoding, err)
+		return cw.writeIdentity()
+	}
+
+	h := cw.Header()
+	h.Del(headerContentLength)
+	h.Set(headerContentEncoding, cw.encoding)
+	weakenETag(h)
+
+	cw.encoder, cw.writer = e, e
+	cw.ResponseWriter.WriteHeader(cw.code)
+	return cw.writeBuffer()
+}
+
+func (cw *ResponseWriter) writeBuffer() error {
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	b := cw.buf
+	cw.buf = nil
+	_, err := cw.writer.Write(b)
+	return err
+}
+
+func isCompressable(code int, header http.Header, contentTypes *regexp.Regexp) bool {
+	// responses without a body and partial responses
+	// must not be compressed
+	switch {
+	case code < 200, code == http.StatusNoContent, code == http.StatusPartialContent:
+		return false
+	}
 	// don't compress if it is already encoded
 	if header.Get(headerContentEncoding) != "" {
 		return false
 	}
+	if strings.Contains(header.Get(headerCacheControl), "no-transform") {
+		return false
+	}
 	return contentTypes.MatchString(header.Get(headerContentType))
 }
 
-func acceptsGzip(r *http.Request) bool {
+// weakenETag turns a strong ETag into a weak one since the compressed
+// response is not byte-for-byte identical to the uncompressed one.
+func weakenETag(header http.Header) {
+	etag := header.Get(headerETag)
+	if etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set(headerETag, "W/"+etag)
+	}
+}
+
+// hasWeakETag returns true if the If-None-Match header contains
+// the weak form of the strong etag.
+func hasWeakETag(ifNoneMatch, etag string) bool {
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	for _, s := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimSpace(s) == "W/"+etag {
+			return true
+		}
+	}
+	return false
+}
+
+func acceptsCompression(r *http.Request) bool {
 	accept := r.Header.Get(headerAccept)
 	for _, contentType := range blacklistedAcceptContentTypes {
 		if strings.Contains(accept, contentType) {
 			return false
 		}
 	}
-	return strings.Contains(r.Header.Get(headerAcceptEncoding), encodingGzip)
+	return r.Header.Get(headerAcceptEncoding) != ""
 }
@@ -4,14 +4,18 @@ package gzip
 import (
 	"bytes"
 	"compress/gzip"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"regexp"
 	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/andybalholm/brotli"
 	"github.com/fabiolb/fabio/assert"
+	"github.com/klauspost/compress/zstd"
 )
 
 var contentTypes = regexp.MustCompile(`^(text/.*|application/(javascript|json|font-woff|xml)|.*\+(json|xml))(;.*)?$`)
@@ -132,3 +136,168 @@ func test_already_compressed_handler() http.Handler {
 		gzWriter.Close()
 	})
 }
+
+func Test_Handler_Negotiate(t *testing.T) {
+	server := httptest.NewServer(NewHandler(test_text_handler(), Config{ContentTypes: contentTypes}))
+	defer server.Close()
+
+	tests := []struct {
+		accept string
+		want   string
+		decode func(io.Reader) (io.Reader, error)
+	}{
+		{"gzip", "gzip", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{"gzip, br", "br", func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil }},
+		{"gzip;q=0.5, zstd", "zstd", func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) }},
+	}
+
+	for _, tt := range tests {
+		tt := tt // capture loop var
+		t.Run(tt.accept, func(t *testing.T) {
+			assertEqual := assert.Equal(t)
+
+			r, err := http.NewRequest("GET", server.URL, nil)
+			assertEqual(err, nil)
+			r.Header.Set("Accept-Encoding", tt.accept)
+
+			resp, err := http.DefaultClient.Do(r)
+			assertEqual(err, nil)
+			defer resp.Body.Close()
+
+			assertEqual(resp.Header.Get("Content-Encoding"), tt.want)
+			assertEqual(resp.Header.Get("Vary"), "Accept-Encoding")
+
+			reader, err := tt.decode(resp.Body)
+			assertEqual(err, nil)
+			bytes, err := ioutil.ReadAll(reader)
+			assertEqual(err, nil)
+			assertEqual(string(bytes), "Hello World")
+		})
+	}
+}
+
+func Test_Handler_MinSize(t *testing.T) {
+	body := strings.Repeat("a", 100)
+	tests := []struct {
+		desc          string
+		contentLength bool
+		minSize       int
+		want          string
+	}{
+		{"smaller with content length", true, 101, ""},
+		{"smaller without content length", false, 101, ""},
+		{"equal with content length", true, 100, "gzip"},
+		{"equal without content length", false, 100, "gzip"},
+	}
+
+	for _, tt := range tests {
+		tt := tt // capture loop var
+		t.Run(tt.desc, func(t *testing.T) {
+			assertEqual := assert.Equal(t)
+
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				if tt.contentLength {
+					w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+				}
+				// write in two parts to test the buffering
+				w.Write([]byte(body[:50]))
+				w.Write([]byte(body[50:]))
+			})
+			cfg := Config{ContentTypes: contentTypes, MinSize: tt.minSize}
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			rec := httptest.NewRecorder()
+			NewHandler(h, cfg).ServeHTTP(rec, r)
+
+			assertEqual(rec.Header().Get("Content-Encoding"), tt.want)
+			if tt.want == "" {
+				assertEqual(rec.Header().Get("Content-Length"), "100")
+				assertEqual(rec.Body.String(), body)
+				return
+			}
+			assertEqual(rec.Header().Get("Content-Length"), "")
+			reader, err := gzip.NewReader(rec.Body)
+			assertEqual(err, nil)
+			bytes, err := ioutil.ReadAll(reader)
+			assertEqual(err, nil)
+			assertEqual(string(bytes), body)
+		})
+	}
+}
+
+func Test_Handler_ETag(t *testing.T) {
+	tests := []struct {
+		desc        string
+		code        int
+		etag        string
+		accept      string
+		ifNoneMatch string
+		want        string
+	}{
+		{"strong etag", 200, `"abc"`, "gzip", "", `W/"abc"`},
+		{"weak etag", 200, `W/"abc"`, "gzip", "", `W/"abc"`},
+		{"not compressed", 200, `"abc"`, "identity", "", `"abc"`},
+		{"not modified weak", 304, `"abc"`, "gzip", `"xyz", W/"abc"`, `W/"abc"`},
+		{"not modified strong", 304, `"abc"`, "gzip", `"abc"`, `"abc"`},
+	}
+
+	for _, tt := range tests {
+		tt := tt // capture loop var
+		t.Run(tt.desc, func(t *testing.T) {
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("ETag", tt.etag)
+				w.WriteHeader(tt.code)
+				if tt.code == 200 {
+					w.Write([]byte("Hello World"))
+				}
+			})
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Accept-Encoding", tt.accept)
+			if tt.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			rec := httptest.NewRecorder()
+			NewHandler(h, Config{ContentTypes: contentTypes}).ServeHTTP(rec, r)
+
+			assert.Equal(t)(rec.Header().Get("ETag"), tt.want)
+		})
+	}
+}
+
+func Test_Handler_Range(t *testing.T) {
+	tests := []struct {
+		desc string
+		rng  string
+		code int
+		want string
+	}{
+		{"range request", "bytes=0-4", 200, ""},
+		{"partial content", "", 206, ""},
+		{"full content", "", 200, "gzip"},
+	}
+
+	for _, tt := range tests {
+		tt := tt // capture loop var
+		t.Run(tt.desc, func(t *testing.T) {
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.WriteHeader(tt.code)
+				w.Write([]byte("Hello World"))
+			})
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			if tt.rng != "" {
+				r.Header.Set("Range", tt.rng)
+			}
+			rec := httptest.NewRecorder()
+			NewHandler(h, Config{ContentTypes: contentTypes}).ServeHTTP(rec, r)
+
+			assert.Equal(t)(rec.Header().Get("Content-Encoding"), tt.want)
+		})
+	}
+}
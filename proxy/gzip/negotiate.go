@@ -0,0 +1,61 @@
+package gzip
+
+import (
+	"strconv"
+	"strings"
+)
+
+// negotiate returns the encoding from encodings which is preferred by
+// the client according to the q-values of the Accept-Encoding header.
+// Encodings with the same q-value are selected in the order of the
+// encodings list. An encoding which is not listed in the header is
+// acceptable if the header contains a '*' with a positive q-value.
+// negotiate returns an empty string if none of the encodings are
+// acceptable.
+func negotiate(acceptEncoding string, encodings []string) string {
+	qvalues := parseAcceptEncoding(acceptEncoding)
+
+	var best string
+	var bestQ float64
+	for _, enc := range encodings {
+		q, ok := qvalues[enc]
+		if !ok {
+			q = qvalues["*"]
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// parseAcceptEncoding returns the q-values of the codings in the
+// Accept-Encoding header. Codings with an invalid q-value are
+// considered not acceptable.
+func parseAcceptEncoding(s string) map[string]float64 {
+	qvalues := map[string]float64{}
+	for _, part := range strings.Split(s, ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		if coding == "" {
+			continue
+		}
+		if coding == "x-gzip" {
+			coding = encodingGzip
+		}
+		q := 1.0
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if !strings.HasPrefix(p, "q=") && !strings.HasPrefix(p, "Q=") {
+				continue
+			}
+			v, err := strconv.ParseFloat(p[2:], 64)
+			if err != nil || v < 0 || v > 1 {
+				v = 0
+			}
+			q = v
+		}
+		qvalues[coding] = q
+	}
+	return qvalues
+}
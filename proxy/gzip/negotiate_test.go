@@ -0,0 +1,38 @@
+package gzip
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	all := []string{"br", "zstd", "gzip"}
+	tests := []struct {
+		accept    string
+		encodings []string
+		want      string
+	}{
+		{"", all, ""},
+		{"gzip", all, "gzip"},
+		{"x-gzip", all, "gzip"},
+		{"gzip, br", all, "br"},
+		{"gzip, br", []string{"gzip", "br"}, "gzip"},
+		{"GZIP, Zstd", all, "zstd"},
+		{"gzip;q=1.0, br;q=0.5", all, "gzip"},
+		{"gzip;q=0.5, br;q=0.8, zstd;q=0.9", all, "zstd"},
+		{"br;q=0, gzip", all, "gzip"},
+		{"*", all, "br"},
+		{"*;q=0.5, gzip", all, "gzip"},
+		{"*, br;q=0", all, "zstd"},
+		{"gzip;q=0", all, ""},
+		{"gzip;q=foo", all, ""},
+		{"deflate, identity", all, ""},
+		{"br", []string{"gzip"}, ""},
+	}
+
+	for _, tt := range tests {
+		tt := tt // capture loop var
+		t.Run(tt.accept, func(t *testing.T) {
+			if got, want := negotiate(tt.accept, tt.encodings), tt.want; got != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/fabiolb/fabio/route"
+	"golang.org/x/net/http2"
+)
+
+// Upstream protocols of HTTP targets.
+const (
+	protoHTTP1 = ""
+	protoH2    = "h2"
+	protoH2C   = "h2c"
+)
+
+// NewH2Transport configures the transport to speak HTTP/2 to TLS
+// upstreams which support it. Upstreams which do not negotiate h2
+// via ALPN are served with HTTP/1.1.
+func NewH2Transport(tr *http.Transport) (*http.Transport, error) {
+	// the TLS config is modified and may be shared
+	if tr.TLSClientConfig != nil {
+		tr.TLSClientConfig = tr.TLSClientConfig.Clone()
+	}
+	if err := http2.ConfigureTransport(tr); err != nil {
+		return nil, err
+	}
+	return tr, nil
+}
+
+// NewH2CTransport creates a transport which speaks HTTP/2 without
+// TLS (h2c) to upstreams with prior knowledge.
+func NewH2CTransport(dial func(network, addr string) (net.Conn, error)) http.RoundTripper {
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dial(network, addr)
+		},
+	}
+}
+
+// upstreamProto returns the protocol for the upstream connection of
+// the target and the scheme of the upstream url. The 'proto=h2' and
+// 'proto=h2c' options and the 'grpcs' and 'grpc' schemes select
+// HTTP/2 with and without TLS.
+func upstreamProto(t *route.Target) (proto, scheme string) {
+	scheme = t.URL.Scheme
+	switch {
+	case t.Opts["proto"] == protoH2 || scheme == "grpcs":
+		return protoH2, "https"
+	case t.Opts["proto"] == protoH2C || scheme == "grpc":
+		return protoH2C, "http"
+	}
+	return protoHTTP1, scheme
+}
+
+// isGRPC returns true if the request is a gRPC call.
+func isGRPC(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
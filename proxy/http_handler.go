@@ -55,6 +55,13 @@ func httpProxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
 		statusCode = StatusClientClosedRequest
 	}
 
+	// the request was aborted by the limits of the route
+	// and the reason is recorded in the access log
+	if code := limitsStatus(r); code != 0 {
+		w.WriteHeader(code)
+		return
+	}
+
 	w.WriteHeader(statusCode)
 	// Theres nothing we can do if the client closes the connection and logging the "context canceled" errors will just add noise to the error log
 	// Note: The access_log will still log the 499 response status codes
@@ -1,10 +1,15 @@
 package proxy
 
 import (
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
 	"errors"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/fabiolb/fabio/config"
@@ -33,6 +38,7 @@ func addResponseHeaders(w http.ResponseWriter, r *http.Request, cfg config.Proxy
 // * add X-Real-Ip, if not present
 // * ClientIPHeader != "": Set header with that name to <remote ip>
 // * TLS connection: Set header with name from `cfg.TLSHeader` to `cfg.TLSHeaderValue`
+// * Verified client cert: Set header with name from `cfg.ClientCertHeader.Name` to the cert identity
 //
 func addHeaders(r *http.Request, cfg config.Proxy, stripPath string) error {
 	remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
@@ -121,9 +127,55 @@ func addHeaders(r *http.Request, cfg config.Proxy, stripPath string) error {
 		}
 	}
 
+	// never forward a client certificate header sent by the client
+	if cfg.ClientCertHeader.Name != "" {
+		r.Header.Del(cfg.ClientCertHeader.Name)
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.PeerCertificates) > 0 {
+			r.Header.Set(cfg.ClientCertHeader.Name, clientCertHeader(r.TLS.PeerCertificates[0], cfg.ClientCertHeader.Fields))
+		}
+	}
+
 	return nil
 }
 
+// clientCertHeader returns the identity of the client certificate in
+// the format of the X-Forwarded-Client-Cert header, e.g.
+//
+//   Hash=<sha256>;Subject="CN=foo,O=bar";URI=spiffe://foo;DNS=foo.com
+//
+func clientCertHeader(cert *x509.Certificate, fields []string) string {
+	var kv []string
+	for _, f := range fields {
+		switch f {
+		case "Hash":
+			sum := sha256.Sum256(cert.Raw)
+			kv = append(kv, "Hash="+hex.EncodeToString(sum[:]))
+		case "Cert":
+			b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+			kv = append(kv, "Cert="+quoteClientCertValue(url.QueryEscape(string(b))))
+		case "Subject":
+			kv = append(kv, "Subject="+quoteClientCertValue(cert.Subject.String()))
+		case "URI":
+			for _, u := range cert.URIs {
+				kv = append(kv, "URI="+quoteClientCertValue(u.String()))
+			}
+		case "DNS":
+			for _, name := range cert.DNSNames {
+				kv = append(kv, "DNS="+quoteClientCertValue(name))
+			}
+		}
+	}
+	return strings.Join(kv, ";")
+}
+
+// quoteClientCertValue quotes values which contain separators.
+func quoteClientCertValue(s string) string {
+	if !strings.ContainsAny(s, ",;=\"") {
+		return s
+	}
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
 var tlsver = map[uint16]string{
 	tls.VersionSSL30: "ssl30",
 	tls.VersionTLS10: "tls10",
@@ -2,9 +2,12 @@ package proxy
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 
 	"github.com/fabiolb/fabio/config"
@@ -358,6 +361,38 @@ func TestAddHeaders(t *testing.T) {
 			},
 			"",
 		},
+
+		{"set client cert header for verified cert",
+			&http.Request{RemoteAddr: "1.2.3.4:5555", TLS: &tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{clientCert},
+				VerifiedChains:   [][]*x509.Certificate{{clientCert}},
+			}},
+			config.Proxy{ClientCertHeader: config.ClientCertHeader{Name: "X-Forwarded-Client-Cert", Fields: []string{"Subject", "URI", "DNS"}}},
+			"",
+			http.Header{
+				"Forwarded":               []string{"for=1.2.3.4; proto=https"},
+				"X-Forwarded-Client-Cert": []string{`Subject="CN=foo,O=bar";URI=spiffe://example.com/foo;DNS=foo.example.com;DNS=bar.example.com`},
+				"X-Forwarded-Proto":       []string{"https"},
+				"X-Forwarded-Port":        []string{"443"},
+				"X-Real-Ip":               []string{"1.2.3.4"},
+			},
+			"",
+		},
+
+		{"remove client cert header for unverified cert",
+			&http.Request{RemoteAddr: "1.2.3.4:5555", Header: http.Header{"X-Forwarded-Client-Cert": {"Subject=spoofed"}}, TLS: &tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{clientCert},
+			}},
+			config.Proxy{ClientCertHeader: config.ClientCertHeader{Name: "X-Forwarded-Client-Cert", Fields: []string{"Subject"}}},
+			"",
+			http.Header{
+				"Forwarded":         []string{"for=1.2.3.4; proto=https"},
+				"X-Forwarded-Proto": []string{"https"},
+				"X-Forwarded-Port":  []string{"443"},
+				"X-Real-Ip":         []string{"1.2.3.4"},
+			},
+			"",
+		},
 	}
 
 	for i, tt := range tests {
@@ -387,6 +422,13 @@ func TestAddHeaders(t *testing.T) {
 	}
 }
 
+var clientCert = &x509.Certificate{
+	Raw:      []byte("cert"),
+	Subject:  pkix.Name{CommonName: "foo", Organization: []string{"bar"}},
+	DNSNames: []string{"foo.example.com", "bar.example.com"},
+	URIs:     []*url.URL{{Scheme: "spiffe", Host: "example.com", Path: "/foo"}},
+}
+
 func TestAddResponseHeaders(t *testing.T) {
 	tests := []struct {
 		desc string
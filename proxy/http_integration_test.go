@@ -3,28 +3,39 @@ package proxy
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/fabiolb/fabio/cache"
+	"github.com/fabiolb/fabio/cert"
 	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/inspect"
 	"github.com/fabiolb/fabio/logger"
+	"github.com/fabiolb/fabio/metrics"
 	"github.com/fabiolb/fabio/noroute"
 	"github.com/fabiolb/fabio/proxy/internal"
 	"github.com/fabiolb/fabio/route"
 	"github.com/pascaldekloe/goe/verify"
+	"golang.org/x/net/http2"
 )
 
 const (
@@ -88,6 +99,14 @@ func TestProxyRequestIDHeader(t *testing.T) {
 	if want := "f47ac10b-58cc-0372-8567-0e02b2c3d479"; got != want {
 		t.Errorf("got %v, but want %v", got, want)
 	}
+
+	req, _ = http.NewRequest("GET", proxy.URL, nil)
+	req.Header.Set("X-Request-Id", "client-id")
+	mustDo(req)
+
+	if want := "client-id"; got != want {
+		t.Errorf("got %v, but want %v", got, want)
+	}
 }
 
 func TestProxySTSHeader(t *testing.T) {
@@ -404,7 +423,9 @@ func testProxyLogOutput(t *testing.T, bodySize int, cfg config.Proxy) {
 		Lookup: func(r *http.Request) *route.Target {
 			return &route.Target{
 				Service: "svc-a",
+				Route:   "example.com/foo",
 				URL:     mustParse(server.URL),
+				Weight:  0.5,
 			}
 		},
 		Logger: l,
@@ -434,13 +455,22 @@ func testProxyLogOutput(t *testing.T, bodySize int, cfg config.Proxy) {
 	upstreamHost, upstreamPort, _ := net.SplitHostPort(upstreamURL.Host)
 	remoteHost, remotePort, _ := net.SplitHostPort(remoteAddr)
 	want := []string{
+		"bytes_received:0",
+		"bytes_sent:" + strconv.Itoa(bodySize),
+		"cache_status:",
+		"close_reason:",
+		"grpc_method:",
+		"grpc_status:",
 		"header.X-Foo:bar",
+		"proto:http",
 		"remote_addr:" + remoteAddr,
 		"remote_host:" + remoteHost,
 		"remote_port:" + remotePort,
 		"request:GET /foo?x=y HTTP/1.1",
 		"request_args:x=y",
+		"request_body_size:0",
 		"request_host:example.com",
+		"request_id:",
 		"request_method:GET",
 		"request_proto:HTTP/1.1",
 		"request_scheme:http",
@@ -448,23 +478,32 @@ func testProxyLogOutput(t *testing.T, bodySize int, cfg config.Proxy) {
 		"request_url:http://example.com/foo?x=y",
 		"response_body_size:" + strconv.Itoa(bodySize),
 		"response_status:200",
-		"response_time_ms:1.111",
-		"response_time_ns:1.111111111",
-		"response_time_us:1.111111",
-		"time_common:01/Jan/2016:00:00:01 +0000",
-		"time_rfc3339:2016-01-01T00:00:01Z",
-		"time_rfc3339_ms:2016-01-01T00:00:01.123Z",
-		"time_rfc3339_ns:2016-01-01T00:00:01.123456789Z",
-		"time_rfc3339_us:2016-01-01T00:00:01.123456Z",
-		"time_unix_ms:1451606401123",
-		"time_unix_ns:1451606401123456789",
-		"time_unix_us:1451606401123456",
+		"response_time_ms:2.222",
+		"response_time_ns:2.222222222",
+		"response_time_us:2.222222",
+		"route:example.com/foo",
+		"target_weight:0.5",
+		"time_common:01/Jan/2016:00:00:02 +0000",
+		"time_rfc3339:2016-01-01T00:00:02Z",
+		"time_rfc3339_ms:2016-01-01T00:00:02.234Z",
+		"time_rfc3339_ns:2016-01-01T00:00:02.234567900Z",
+		"time_rfc3339_us:2016-01-01T00:00:02.234567Z",
+		"time_unix_ms:1451606402234",
+		"time_unix_ns:1451606402234567900",
+		"time_unix_us:1451606402234567",
+		"tls_cipher:",
+		"tls_client_subject:",
+		"tls_sni:",
+		"tls_version:",
 		"upstream_addr:" + upstreamURL.Host,
 		"upstream_host:" + upstreamHost,
 		"upstream_port:" + upstreamPort,
 		"upstream_request_scheme:" + upstreamURL.Scheme,
 		"upstream_request_uri:/foo?x=y",
 		"upstream_request_url:" + upstreamURL.String() + "/foo?x=y",
+		"upstream_response_time_ms:1.111",
+		"upstream_response_time_ns:1.111111111",
+		"upstream_response_time_us:1.111111",
 		"upstream_service:svc-a",
 	}
 
@@ -528,6 +567,151 @@ func TestProxyHTTPSUpstreamSkipVerify(t *testing.T) {
 	}
 }
 
+func TestProxyHTTPSUpstreamClientCert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fabio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFile := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			http.Error(w, "no client cert", http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, "OK")
+	}))
+	server.TLS = tlsServerConfig2()
+	server.TLS.ClientAuth = tls.RequireAnyClientCert
+	server.StartTLS()
+	defer server.Close()
+
+	upstream := &UpstreamTLS{
+		Sources: cert.NewClientSources(map[string]config.CertSource{
+			"client": {Name: "client", Type: "file", CertPath: writeFile("client.pem", internal.LocalhostCert), KeyPath: writeFile("client-key.pem", internal.LocalhostKey)},
+			"ca":     {Name: "ca", Type: "file", CertPath: writeFile("ca.pem", internal.LocalhostCert2), KeyPath: writeFile("ca-key.pem", internal.LocalhostKey2)},
+		}),
+		NewTransport: func(tlscfg *tls.Config) *http.Transport {
+			return &http.Transport{TLSClientConfig: tlscfg}
+		},
+	}
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config:      config.Proxy{},
+		Transport:   http.DefaultTransport,
+		UpstreamTLS: upstream,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString("route add srv / " + server.URL + ` opts "proto=https tlsclientcert=client tlsca=ca"`))
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	resp, body := mustGet(proxy.URL)
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got, want := string(body), "OK"; got != want {
+		t.Fatalf("got body %q want %q", got, want)
+	}
+}
+
+func TestProxyHTTP2Upstream(t *testing.T) {
+	// grpcHandler responds like a gRPC server
+	// and records the protocol of the request
+	var proto int32
+	grpcHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.StoreInt32(&proto, int32(r.ProtoMajor))
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write([]byte("pong"))
+		w.Header().Set("Grpc-Status", "0")
+	})
+
+	// h2 upstream
+	h2Server := httptest.NewUnstartedServer(grpcHandler)
+	h2Server.EnableHTTP2 = true
+	h2Server.StartTLS()
+	defer h2Server.Close()
+
+	// h2c upstream
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go (&http2.Server{}).ServeConn(conn, &http2.ServeConnOpts{Handler: grpcHandler})
+		}
+	}()
+
+	h2Transport, err := NewH2Transport(&http.Transport{TLSClientConfig: tlsInsecureConfig()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc string
+		tgt  *route.Target
+	}{
+		{"h2", &route.Target{URL: mustParse(h2Server.URL), Opts: map[string]string{"proto": "h2"}, TLSSkipVerify: true}},
+		{"h2c", &route.Target{URL: mustParse("http://" + ln.Addr().String()), Opts: map[string]string{"proto": "h2c"}}},
+		{"grpc scheme", &route.Target{URL: mustParse("grpc://" + ln.Addr().String())}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			atomic.StoreInt32(&proto, 0)
+			proxy := httptest.NewUnstartedServer(&HTTPProxy{
+				InsecureH2Transport: h2Transport,
+				H2CTransport:        NewH2CTransport(net.Dial),
+				Lookup:              func(r *http.Request) *route.Target { return tt.tgt },
+			})
+			proxy.EnableHTTP2 = true
+			proxy.StartTLS()
+			defer proxy.Close()
+
+			req, _ := http.NewRequest("POST", proxy.URL+"/svc/Ping", strings.NewReader("ping"))
+			req.Header.Set("Content-Type", "application/grpc")
+			req.Header.Set("TE", "trailers")
+			resp, err := proxy.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := resp.ProtoMajor, 2; got != want {
+				t.Fatalf("got client protocol HTTP/%d want HTTP/%d", got, want)
+			}
+			if got, want := int(atomic.LoadInt32(&proto)), 2; got != want {
+				t.Fatalf("got upstream protocol HTTP/%d want HTTP/%d", got, want)
+			}
+			if got, want := string(body), "pong"; got != want {
+				t.Fatalf("got body %q want %q", got, want)
+			}
+			if got, want := resp.Trailer.Get("Grpc-Status"), "0"; got != want {
+				t.Fatalf("got trailer Grpc-Status %q want %q", got, want)
+			}
+		})
+	}
+}
+
 func TestProxyGzipHandler(t *testing.T) {
 	tests := []struct {
 		desc            string
@@ -613,6 +797,192 @@ func TestProxyGzipHandler(t *testing.T) {
 	}
 }
 
+func TestProxyCache(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write(plainContent)
+	}))
+	defer server.Close()
+
+	var b bytes.Buffer
+	l, err := logger.New(&b, "$request_uri $cache_status")
+	if err != nil {
+		t.Fatal("logger.New: ", err)
+	}
+
+	tgt := &route.Target{URL: mustParse(server.URL), Cache: true, CacheTTL: time.Minute}
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config: config.Proxy{
+			GZIPContentTypes: regexp.MustCompile("^text/plain(;.*)?$"),
+		},
+		Transport: http.DefaultTransport,
+		Lookup:    func(r *http.Request) *route.Target { return tgt },
+		Logger:    l,
+		Cache:     cache.New(1<<20, 1<<20),
+	})
+	defer proxy.Close()
+
+	get := func(acceptEncoding string) (*http.Response, []byte) {
+		req, _ := http.NewRequest("GET", proxy.URL+"/a", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		return mustDo(req)
+	}
+
+	for i, want := range []string{cache.Miss, cache.Hit} {
+		resp, body := get("")
+		if got := resp.Header.Get("X-Cache"); got != want {
+			t.Fatalf("request %d: got X-Cache %q want %q", i+1, got, want)
+		}
+		if got, want := body, plainContent; !bytes.Equal(got, want) {
+			t.Fatalf("request %d: got body %q want %q", i+1, got, want)
+		}
+	}
+
+	// the cached response is compressed for the client
+	resp, body := get("gzip")
+	if got, want := resp.Header.Get("Content-Encoding"), "gzip"; got != want {
+		t.Fatalf("got content-encoding %q want %q", got, want)
+	}
+	if got, want := body, gzipContent; !bytes.Equal(got, want) {
+		t.Fatalf("got body %q want %q", got, want)
+	}
+
+	if got, want := atomic.LoadInt32(&calls), int32(1); got != want {
+		t.Fatalf("got %d upstream calls want %d", got, want)
+	}
+	if got, want := b.String(), "/a MISS\n/a HIT\n/a HIT\n"; got != want {
+		t.Fatalf("got access log %q want %q", got, want)
+	}
+}
+
+func TestProxyLimits(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/upload":
+			ioutil.ReadAll(r.Body)
+		case "/slow":
+			time.Sleep(time.Second)
+		case "/stream":
+			// send data in shorter intervals than the idle timeout
+			for i := 0; i < 4; i++ {
+				w.Write([]byte("."))
+				w.(http.Flusher).Flush()
+				time.Sleep(100 * time.Millisecond)
+			}
+		case "/stall":
+			w.Write([]byte("."))
+			w.(http.Flusher).Flush()
+			time.Sleep(time.Second)
+		}
+		w.Write([]byte("OK"))
+	}))
+	defer server.Close()
+
+	// the access log is written before the aborted
+	// connection is closed by the proxy
+	var b syncBuffer
+	l, err := logger.New(&b, "$request_uri $response_status $close_reason")
+	if err != nil {
+		t.Fatal("logger.New: ", err)
+	}
+
+	tgt := &route.Target{
+		URL:         mustParse(server.URL),
+		MaxBody:     8,
+		Timeout:     500 * time.Millisecond,
+		IdleTimeout: 250 * time.Millisecond,
+	}
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup:    func(r *http.Request) *route.Target { return tgt },
+		Logger:    l,
+	})
+	defer proxy.Close()
+
+	tests := []struct {
+		desc   string
+		path   string
+		body   io.Reader
+		status int
+		log    string
+	}{
+		{"small body", "/upload", strings.NewReader("12345678"), 200, "/upload 200 \n"},
+		{"content length too large", "/upload", strings.NewReader("123456789"), 413, "/upload 413 request body too large\n"},
+		{"chunked body too large", "/upload", ioutil.NopCloser(strings.NewReader("123456789")), 413, "/upload 413 request body too large\n"},
+		{"active stream", "/stream", nil, 200, "/stream 200 \n"},
+		{"idle timeout", "/slow", nil, 504, "/slow 504 upstream idle timeout\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			b.Reset()
+			req, _ := http.NewRequest("POST", proxy.URL+tt.path, tt.body)
+			resp, _ := mustDo(req)
+			if got, want := resp.StatusCode, tt.status; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := b.String(), tt.log; got != want {
+				t.Fatalf("got access log %q want %q", got, want)
+			}
+		})
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		b.Reset()
+		tgt.IdleTimeout = 0
+		defer func() { tgt.IdleTimeout = 250 * time.Millisecond }()
+		req, _ := http.NewRequest("GET", proxy.URL+"/slow", nil)
+		resp, _ := mustDo(req)
+		if got, want := resp.StatusCode, 504; got != want {
+			t.Fatalf("got status %d want %d", got, want)
+		}
+		if got, want := b.String(), "/slow 504 upstream timeout\n"; got != want {
+			t.Fatalf("got access log %q want %q", got, want)
+		}
+	})
+
+	t.Run("idle timeout after response header", func(t *testing.T) {
+		b.Reset()
+		resp, err := http.Get(proxy.URL + "/stall")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(resp.Body); err == nil {
+			t.Fatal("got complete response want aborted response")
+		}
+		resp.Body.Close()
+		if got, want := b.String(), "/stall 200 upstream idle timeout\n"; got != want {
+			t.Fatalf("got access log %q want %q", got, want)
+		}
+	})
+}
+
+// syncBuffer is a bytes.Buffer which is safe for concurrent use.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.b.Reset()
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
 var plainContent = []byte("Hello World")
 var gzipContent = compress(plainContent)
 
@@ -744,3 +1114,98 @@ func BenchmarkProxyLogger(b *testing.B) {
 		proxy.ServeHTTP(httptest.NewRecorder(), req)
 	}
 }
+
+// labeledTimers records the names and labels of the requested timers.
+type labeledTimers struct {
+	metrics.NoopRegistry
+	timers map[string][]string
+}
+
+func (r *labeledTimers) GetLabeledCounter(flat, name string, labels ...string) metrics.Counter {
+	return metrics.NoopCounter{}
+}
+
+func (r *labeledTimers) GetLabeledGauge(flat, name string, labels ...string) metrics.Gauge {
+	return metrics.NoopGauge{}
+}
+
+func (r *labeledTimers) GetLabeledTimer(flat, name string, labels ...string) metrics.Timer {
+	r.timers[flat] = append([]string{name}, labels...)
+	return metrics.NoopTimer{}
+}
+
+func TestRouteStatusTimer(t *testing.T) {
+	r := &labeledTimers{timers: map[string][]string{}}
+	defer func(old metrics.Registry) { metrics.DefaultRegistry = old }(metrics.DefaultRegistry)
+	metrics.DefaultRegistry = r
+
+	tg := &route.Target{
+		TimerName:    "svc-a.example_com./foo.1_2_3_4_5000",
+		MetricLabels: metrics.TargetLabels("svc-a", "example.com", "/foo", mustParse("http://1.2.3.4:5000/")),
+	}
+	req := httptest.NewRequest("GET", "http://example.com/foo", nil)
+	req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9999}))
+	routeStatusTimer(tg, 502, req)
+
+	got := r.timers["svc-a.example_com./foo.1_2_3_4_5000.status.502"]
+	want := []string{"route_response_duration", "service", "svc-a", "host", "example.com", "path", "/foo", "target", "1.2.3.4:5000", "code", "502", "listener", "127.0.0.1:9999"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestProxyInspectRequest(t *testing.T) {
+	c := inspect.Default.Watch()
+	defer c.Close()
+
+	tests := []struct {
+		desc   string
+		target *route.Target
+		status int
+	}{
+		{"no route", nil, http.StatusNotFound},
+		{
+			desc: "access denied",
+			target: func() *route.Target {
+				tgt := &route.Target{
+					URL:  mustParse("http://1.2.3.4:5000/"),
+					Opts: map[string]string{"deny": "ip:192.0.2.0/24"},
+				}
+				tgt.ProcessAccessRules()
+				return tgt
+			}(),
+			status: http.StatusForbidden,
+		},
+		{
+			desc: "redirect",
+			target: &route.Target{
+				URL:          mustParse("http://1.2.3.4:5000/"),
+				RedirectCode: http.StatusMovedPermanently,
+				RedirectURL:  mustParse("https://example.com/"),
+			},
+			status: http.StatusMovedPermanently,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			proxy := &HTTPProxy{
+				Lookup: func(*http.Request) *route.Target { return tt.target },
+			}
+			req := httptest.NewRequest("GET", "http://example.com/foo", nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+			reqs, _ := c.Next()
+			if len(reqs) != 1 {
+				t.Fatalf("got %d requests want 1", len(reqs))
+			}
+			if got, want := reqs[0].Status, tt.status; got != want {
+				t.Errorf("got status %d want %d", got, want)
+			}
+			if got, want := reqs[0].Host+reqs[0].Path, "example.com/foo"; got != want {
+				t.Errorf("got %q want %q", got, want)
+			}
+		})
+	}
+}
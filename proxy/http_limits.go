@@ -0,0 +1,188 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// Reasons for aborting a request which are recorded in the access log.
+const (
+	reasonBodyTooLarge = "request body too large"
+	reasonTimeout      = "upstream timeout"
+	reasonIdleTimeout  = "upstream idle timeout"
+)
+
+var errBodyTooLarge = errors.New(reasonBodyTooLarge)
+
+type limitsKey struct{}
+
+// limits enforces the maximum request body size and the total and
+// idle timeouts of a route for a single request. When a limit is
+// exceeded the request to the upstream is aborted and the reason is
+// recorded. The error handler of the proxy then responds with 413
+// Request Entity Too Large or 504 Gateway Timeout.
+type limits struct {
+	maxBody     int64
+	timeout     time.Duration
+	idleTimeout time.Duration
+	next        http.Handler
+
+	// idle is the idle timer which is reset on every data
+	// transfer. It is nil if there is no idle timeout.
+	idle *idleTimer
+
+	mu      sync.Mutex
+	aborted string
+	done    bool
+	cancel  context.CancelFunc
+	timers  []*time.Timer
+}
+
+// newLimits returns the limits handler for the target
+// or nil if the target has no limits.
+func newLimits(t *route.Target, next http.Handler) *limits {
+	if t.MaxBody <= 0 && t.Timeout <= 0 && t.IdleTimeout <= 0 {
+		return nil
+	}
+	return &limits{maxBody: t.MaxBody, timeout: t.Timeout, idleTimeout: t.IdleTimeout, next: next}
+}
+
+func (l *limits) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if l.maxBody > 0 && r.ContentLength > l.maxBody {
+		l.abort(reasonBodyTooLarge)
+		http.Error(w, reasonBodyTooLarge, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer l.stop()
+	l.mu.Lock()
+	l.cancel = cancel
+	if l.timeout > 0 {
+		l.timers = append(l.timers, time.AfterFunc(l.timeout, func() { l.abort(reasonTimeout) }))
+	}
+	l.idle = newIdleTimer(l.idleTimeout, func() { l.abort(reasonIdleTimeout) })
+	l.mu.Unlock()
+
+	r = r.WithContext(context.WithValue(ctx, limitsKey{}, l))
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = &limitedBody{r: r.Body, l: l}
+	}
+	l.next.ServeHTTP(&limitedWriter{w: w, l: l}, r)
+}
+
+// reason returns the reason why the request was aborted or an
+// empty string. It is safe to call on a nil handler.
+func (l *limits) reason() string {
+	if l == nil {
+		return ""
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.aborted
+}
+
+// status returns the status code for the response
+// of an aborted request or 0.
+func (l *limits) status() int {
+	switch l.reason() {
+	case reasonBodyTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case reasonTimeout, reasonIdleTimeout:
+		return http.StatusGatewayTimeout
+	}
+	return 0
+}
+
+// abort records the first reason and cancels the upstream request.
+func (l *limits) abort(reason string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.done || l.aborted != "" {
+		return
+	}
+	l.aborted = reason
+	if l.cancel != nil {
+		l.cancel()
+	}
+}
+
+// stop stops the timers when the request is complete.
+func (l *limits) stop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.done = true
+	for _, t := range l.timers {
+		t.Stop()
+	}
+	l.idle.stop()
+	if l.cancel != nil {
+		l.cancel()
+	}
+}
+
+// limitedBody reads the request body up to the maximum size.
+type limitedBody struct {
+	r io.ReadCloser
+	l *limits
+	n int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.n += int64(n)
+	if b.l.maxBody > 0 && b.n > b.l.maxBody {
+		b.l.abort(reasonBodyTooLarge)
+		return 0, errBodyTooLarge
+	}
+	if n > 0 {
+		b.l.idle.touch()
+	}
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	return b.r.Close()
+}
+
+// limitedWriter records the data transfer of the response body.
+type limitedWriter struct {
+	w http.ResponseWriter
+	l *limits
+}
+
+func (lw *limitedWriter) Header() http.Header {
+	return lw.w.Header()
+}
+
+func (lw *limitedWriter) WriteHeader(code int) {
+	lw.l.idle.touch()
+	lw.w.WriteHeader(code)
+}
+
+func (lw *limitedWriter) Write(b []byte) (int, error) {
+	lw.l.idle.touch()
+	return lw.w.Write(b)
+}
+
+func (lw *limitedWriter) Flush() {
+	if fl, ok := lw.w.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+// limitsStatus returns the status code for a request which
+// was aborted by the limits of the route or 0.
+func limitsStatus(r *http.Request) int {
+	l, ok := r.Context().Value(limitsKey{}).(*limits)
+	if !ok {
+		return 0
+	}
+	return l.status()
+}
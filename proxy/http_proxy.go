@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"errors"
 	"io"
+	"log"
 	"net"
 	"net/http"
 	"net/url"
@@ -13,7 +14,9 @@ import (
 	"time"
 
 	"github.com/fabiolb/fabio/auth"
+	"github.com/fabiolb/fabio/cache"
 	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/inspect"
 	"github.com/fabiolb/fabio/logger"
 	"github.com/fabiolb/fabio/metrics"
 	"github.com/fabiolb/fabio/noroute"
@@ -21,6 +24,7 @@ import (
 	"github.com/fabiolb/fabio/route"
 	"github.com/fabiolb/fabio/trace"
 	"github.com/fabiolb/fabio/uuid"
+	"go.opentelemetry.io/otel/label"
 )
 
 // HTTPProxy is a dynamic reverse proxy for HTTP and HTTPS protocols.
@@ -41,6 +45,22 @@ type HTTPProxy struct {
 	// self-signed certs.
 	InsecureTransport http.RoundTripper
 
+	// H2Transport is the http connection pool for targets with the
+	// 'proto=h2' option which speaks HTTP/2 to the upstream.
+	H2Transport http.RoundTripper
+
+	// InsecureH2Transport is the HTTP/2 connection pool
+	// for targets which also have 'tlsskipverify' set.
+	InsecureH2Transport http.RoundTripper
+
+	// H2CTransport is the http connection pool for targets with the
+	// 'proto=h2c' option which speaks HTTP/2 without TLS to the upstream.
+	H2CTransport http.RoundTripper
+
+	// UpstreamTLS provides the transports for routes which use
+	// client certificates or CA bundles for the upstream connection.
+	UpstreamTLS *UpstreamTLS
+
 	// Lookup returns a target host for the given request.
 	// The proxy will panic if this value is nil.
 	Lookup func(*http.Request) *route.Target
@@ -55,7 +75,7 @@ type HTTPProxy struct {
 	// Logger is the access logger for the requests.
 	Logger logger.Logger
 
-	// TracerCfg is the Open Tracing configuration as provided during startup
+	// TracerCfg is the tracing configuration as provided during startup
 	TracerCfg config.Tracing
 
 	// UUID returns a unique id in uuid format.
@@ -64,6 +84,10 @@ type HTTPProxy struct {
 
 	// Auth schemes registered with the server
 	AuthSchemes map[string]auth.AuthScheme
+
+	// Cache is the response cache for the routes with the
+	// 'cache' option. If nil cache.Default is used.
+	Cache *cache.Cache
 }
 
 func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -71,25 +95,57 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		panic("no lookup function")
 	}
 
+	timeNow := p.Time
+	if timeNow == nil {
+		timeNow = time.Now
+	}
+	start := timeNow()
+
+	var requestID string
 	if p.Config.RequestID != "" {
-		id := p.UUID
-		if id == nil {
-			id = uuid.NewUUID
+		// keep the request id of the client so that
+		// it is forwarded and logged unchanged.
+		requestID = r.Header.Get(p.Config.RequestID)
+		if requestID == "" {
+			id := p.UUID
+			if id == nil {
+				id = uuid.NewUUID
+			}
+			requestID = id()
+			r.Header.Set(p.Config.RequestID, requestID)
 		}
-		r.Header.Set(p.Config.RequestID, id())
 	}
 
-	//Create Span
-	span := trace.CreateSpan(r, &p.TracerCfg)
-	defer span.Finish()
+	r, span := trace.StartSpan(r, &p.TracerCfg)
+	defer span.End()
+
+	// record the request for the inspector on every return path if
+	// somebody is watching. r.Host and r.URL.Path are modified for
+	// the upstream request.
+	var t *route.Target
+	if inspect.Default.Watching() {
+		iw := &responseWriter{w: w}
+		w = iw
+		method, host, path := r.Method, r.Host, r.URL.Path
+		defer func() {
+			status := iw.code
+			if status == 0 && iw.size > 0 {
+				status = http.StatusOK
+			}
+			if status > 0 {
+				inspectRequest(method, host, path, t, status, start, timeNow())
+			}
+		}()
+	}
 
-	t := p.Lookup(r)
+	t = p.Lookup(r)
 
 	if t == nil {
 		status := p.Config.NoRouteStatus
 		if status < 100 || status > 999 {
 			status = http.StatusNotFound
 		}
+		trace.SetHTTPStatus(span, status)
 		w.WriteHeader(status)
 		html := noroute.GetHTML()
 		if html != "" {
@@ -98,13 +154,25 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	span.SetAttributes(targetAttributes(t)...)
+
 	if t.AccessDeniedHTTP(r) {
 		http.Error(w, "access denied", http.StatusForbidden)
 		return
 	}
 
-	if !t.Authorized(r, w, p.AuthSchemes) {
-		http.Error(w, "authorization failed", http.StatusUnauthorized)
+	if !t.ClientCertAllowed(r) {
+		http.Error(w, "client certificate required", http.StatusForbidden)
+		return
+	}
+
+	// the auth scheme may have responded already, e.g. with a
+	// redirect to a login page
+	aw := &responseWriter{w: w}
+	if !t.Authorized(r, aw, p.AuthSchemes) {
+		if aw.code == 0 && aw.size == 0 {
+			http.Error(w, "authorization failed", http.StatusUnauthorized)
+		}
 		return
 	}
 
@@ -119,16 +187,19 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	if t.RedirectCode != 0 && t.RedirectURL != nil {
 		http.Redirect(w, r, t.RedirectURL.String(), t.RedirectCode)
+		trace.SetHTTPStatus(span, t.RedirectCode)
 		if t.Timer != nil {
 			t.Timer.Update(0)
 		}
-		metrics.DefaultRegistry.GetTimer(key(t.RedirectCode)).Update(0)
+		statusTimer(t.RedirectCode, r).Update(0)
+		routeStatusTimer(t, t.RedirectCode, r).Update(0)
 		return
 	}
 
 	// build the real target url that is passed to the proxy
+	proto, targetScheme := upstreamProto(t)
 	targetURL := &url.URL{
-		Scheme: t.URL.Scheme,
+		Scheme: targetScheme,
 		Host:   t.URL.Host,
 		Path:   r.URL.Path,
 	}
@@ -167,26 +238,60 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	//Add OpenTrace Headers to response
-	trace.InjectHeaders(span, r)
+	// propagate the trace context to the upstream
+	trace.InjectHeaders(r.Context(), r.Header)
 
 	upgrade, accept := r.Header.Get("Upgrade"), r.Header.Get("Accept")
+	grpc, ws := isGRPC(r), isWebSocket(r)
+
+	// websocket connections are upgraded from HTTP/1.1
+	if upgrade != "" {
+		proto = protoHTTP1
+	}
 
 	tr := p.Transport
 	if t.TLSSkipVerify {
 		tr = p.InsecureTransport
 	}
+	switch proto {
+	case protoH2:
+		tr = p.H2Transport
+		if t.TLSSkipVerify {
+			tr = p.InsecureH2Transport
+		}
+	case protoH2C:
+		tr = p.H2CTransport
+	}
+	if proto != protoHTTP1 && tr == nil {
+		http.Error(w, proto+" transport not configured", http.StatusBadGateway)
+		return
+	}
+	if UsesUpstreamTLS(t) && proto != protoH2C {
+		if p.UpstreamTLS == nil {
+			http.Error(w, "upstream TLS not configured", http.StatusBadGateway)
+			return
+		}
+		utr, err := p.UpstreamTLS.Transport(t, proto == protoH2)
+		if err != nil {
+			log.Printf("[ERROR] Cannot create upstream transport for %s. %s", t.URL, err)
+			http.Error(w, "cannot create upstream transport", http.StatusBadGateway)
+			return
+		}
+		tr = utr
+	}
 
 	var h http.Handler
 	switch {
-	case upgrade == "websocket" || upgrade == "Websocket":
+	case ws:
+		span.SetAttributes(label.Bool("fabio.websocket", true))
 		r.URL = targetURL
+		limits := newWSLimits(p.Config.WebSocket, t)
 		if targetURL.Scheme == "https" || targetURL.Scheme == "wss" {
 			h = newWSHandler(targetURL.Host, func(network, address string) (net.Conn, error) {
 				return tls.Dial(network, address, tr.(*http.Transport).TLSClientConfig)
-			})
+			}, t, limits)
 		} else {
-			h = newWSHandler(targetURL.Host, net.Dial)
+			h = newWSHandler(targetURL.Host, net.Dial, t, limits)
 		}
 
 	case accept == "text/event-stream":
@@ -194,24 +299,50 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		// must be > 0s to be effective
 		h = newHTTPProxy(targetURL, tr, p.Config.FlushInterval)
 
+	case grpc:
+		// gRPC streams are flushed immediately
+		span.SetAttributes(label.Bool("fabio.grpc", true))
+		h = newHTTPProxy(targetURL, tr, -1)
+
 	default:
 		h = newHTTPProxy(targetURL, tr, p.Config.GlobalFlushInterval)
 	}
 
-	if p.Config.GZIPContentTypes != nil {
-		h = gzip.NewGzipHandler(h, p.Config.GZIPContentTypes)
+	// streaming responses are not cached
+	var ch *cache.Handler
+	if t.Cache && upgrade == "" && accept != "text/event-stream" {
+		ch = &cache.Handler{Cache: p.Cache, URL: requestURL, TTL: t.CacheTTL, Next: h, Time: p.Time, Authenticated: t.AuthScheme != ""}
+		h = ch
 	}
 
-	timeNow := p.Time
-	if timeNow == nil {
-		timeNow = time.Now
+	// gRPC messages are compressed by the gRPC protocol
+	if cfg, ok := compressConfig(p.Config, t); ok && !grpc {
+		h = gzip.NewHandler(h, cfg)
 	}
 
-	start := timeNow()
+	// websocket connections are long-lived and not limited
+	var lim *limits
+	if upgrade == "" {
+		if lim = newLimits(t, h); lim != nil {
+			h = lim
+		}
+	}
+
+	var body *countingReader
+	if r.Body != nil && r.Body != http.NoBody {
+		body = &countingReader{r: r.Body}
+		r.Body = body
+	}
+
+	upstreamStart := timeNow()
 	rw := &responseWriter{w: w}
-	h.ServeHTTP(rw, r)
+	if !serveAbortable(h, rw, r) {
+		// abort the response to the client after the access log
+		// has been written. See http.ErrAbortHandler
+		defer panic(http.ErrAbortHandler)
+	}
 	end := timeNow()
-	dur := end.Sub(start)
+	dur := end.Sub(upstreamStart)
 
 	if p.Requests != nil {
 		p.Requests.Update(dur)
@@ -223,10 +354,21 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	metrics.DefaultRegistry.GetTimer(key(rw.code)).Update(dur)
+	trace.SetHTTPStatus(span, rw.code)
+	statusTimer(rw.code, r).Update(dur)
+	routeStatusTimer(t, rw.code, r).Update(dur)
 
+	var cacheStatus string
+	if ch != nil {
+		cacheStatus = ch.Status
+		cacheCounter(t, cacheStatus).Inc(1)
+	}
 	// write access log
 	if p.Logger != nil {
+		proto := "http"
+		if r.TLS != nil {
+			proto = "https"
+		}
 		p.Logger.Log(&logger.Event{
 			Start:   start,
 			End:     end,
@@ -239,16 +381,159 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			UpstreamAddr:    targetURL.Host,
 			UpstreamService: t.Service,
 			UpstreamURL:     targetURL,
+			UpstreamTime:    dur,
+			RequestID:       requestID,
+			RequestBodySize: body.count(),
+			Route:           t.Route,
+			TargetWeight:    t.Weight,
+			Proto:           proto,
+			BytesReceived:   body.count(),
+			BytesSent:       int64(rw.size),
+			SampleRules:     t.AccessLog,
+			CacheStatus:     cacheStatus,
+			CloseReason:     lim.reason(),
 		})
 	}
 }
 
+// serveAbortable calls the handler and returns false if the handler
+// aborted the response with http.ErrAbortHandler, e.g. when the
+// upstream request was canceled while copying the response body.
+func serveAbortable(h http.Handler, w http.ResponseWriter, r *http.Request) (ok bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			if err != http.ErrAbortHandler {
+				panic(err)
+			}
+			ok = false
+		}
+	}()
+	h.ServeHTTP(w, r)
+	return true
+}
+
+// compressConfig returns the compression config for the responses of
+// the target and whether they should be compressed. Compression is
+// enabled globally by setting the content types and the options of
+// the target can enable or disable it and override the encodings
+// and the level.
+func compressConfig(cfg config.Proxy, t *route.Target) (gzip.Config, bool) {
+	c := gzip.Config{
+		ContentTypes: cfg.GZIPContentTypes,
+		Encodings:    cfg.Compress.Encodings,
+		MinSize:      cfg.Compress.MinSize,
+		Level:        cfg.Compress.Level,
+	}
+	enabled := cfg.GZIPContentTypes != nil
+	if t.Compress != nil {
+		enabled = *t.Compress
+	}
+	if len(t.CompressEncodings) > 0 {
+		c.Encodings = t.CompressEncodings
+	}
+	if t.CompressLevel != 0 {
+		c.Level = t.CompressLevel
+	}
+	return c, enabled
+}
+
+// cacheCounter returns the counter for the requests to the target
+// with the cache status, e.g. <target>.cache.hit
+func cacheCounter(t *route.Target, status string) metrics.Counter {
+	s := strings.ToLower(status)
+	labels := append(append([]string{}, t.MetricLabels...), "status", s)
+	return metrics.LabeledCounter(metrics.DefaultRegistry, t.TimerName+".cache."+s, "route_cache_requests", labels...)
+}
+
+// inspectRequest records the request for the request inspector
+// if somebody is watching. t is nil if there was no route.
+func inspectRequest(method, host, path string, t *route.Target, status int, start, end time.Time) {
+	if !inspect.Default.Watching() {
+		return
+	}
+	req := inspect.Request{
+		Time:     start,
+		Method:   method,
+		Host:     host,
+		Path:     path,
+		Status:   status,
+		Duration: float64(end.Sub(start)) / float64(time.Millisecond),
+	}
+	if t != nil {
+		req.Route = t.Route
+		req.Service = t.Service
+		if t.URL != nil {
+			req.Target = t.URL.Host
+		}
+	}
+	inspect.Default.Add(req)
+}
+
 func key(code int) string {
 	b := []byte("http.status.")
 	b = strconv.AppendInt(b, int64(code), 10)
 	return string(b)
 }
 
+// statusTimer returns the timer for responses with the status code.
+// Registries which support labels also get the address of the
+// listener which accepted the request.
+func statusTimer(code int, r *http.Request) metrics.Timer {
+	return metrics.LabeledTimer(metrics.DefaultRegistry, key(code), "http_response_duration", "code", strconv.Itoa(code), "listener", listenerAddr(r))
+}
+
+// routeStatusTimer returns the timer for responses of the target with
+// the status code, e.g. <target>.status.200. Registries which support
+// labels get the route labels, the status code and the listener.
+func routeStatusTimer(t *route.Target, code int, r *http.Request) metrics.Timer {
+	c := strconv.Itoa(code)
+	labels := append(append([]string{}, t.MetricLabels...), "code", c, "listener", listenerAddr(r))
+	return metrics.LabeledTimer(metrics.DefaultRegistry, t.TimerName+".status."+c, "route_response_duration", labels...)
+}
+
+// listenerAddr returns the address of the listener
+// which accepted the request.
+func listenerAddr(r *http.Request) string {
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		return addr.String()
+	}
+	return ""
+}
+
+// targetAttributes returns the span attributes for the route target.
+func targetAttributes(t *route.Target) []label.KeyValue {
+	attrs := []label.KeyValue{label.String("fabio.target.url", t.URL.String())}
+	for i := 0; i+1 < len(t.MetricLabels); i += 2 {
+		attrs = append(attrs, label.String("fabio.route."+t.MetricLabels[i], t.MetricLabels[i+1]))
+	}
+	return attrs
+}
+
+// countingReader counts the bytes read from the request body.
+type countingReader struct {
+	r io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) Close() error {
+	return c.r.Close()
+}
+
+// count returns the number of bytes read so far.
+// It is safe to call on a nil reader.
+func (c *countingReader) count() int64 {
+	if c == nil {
+		return 0
+	}
+	return c.n
+}
+
 // responseWriter wraps an http.ResponseWriter to capture the status code and
 // the size of the response. It also implements http.Hijacker to forward
 // hijacking the connection to the wrapped writer if supported.
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// idleTimer calls a function when there was no activity during the
+// idle timeout. The function is called at most once.
+type idleTimer struct {
+	timeout time.Duration
+	onIdle  func()
+
+	// last is the time of the last activity in unix nanoseconds.
+	last int64
+
+	mu      sync.Mutex
+	stopped bool
+	timer   *time.Timer
+}
+
+// newIdleTimer starts an idle timer which calls f when there was no
+// activity during the timeout. It returns nil if the timeout is not
+// positive.
+func newIdleTimer(timeout time.Duration, f func()) *idleTimer {
+	if timeout <= 0 {
+		return nil
+	}
+	t := &idleTimer{timeout: timeout, onIdle: f}
+	t.touch()
+	t.mu.Lock()
+	t.timer = time.AfterFunc(timeout, t.check)
+	t.mu.Unlock()
+	return t
+}
+
+// touch records an activity. It is safe to call on a nil timer.
+func (t *idleTimer) touch() {
+	if t == nil {
+		return
+	}
+	atomic.StoreInt64(&t.last, time.Now().UnixNano())
+}
+
+// check calls the idle function if there was no activity during the
+// timeout. Otherwise, it checks again when the timeout after the last
+// activity expires.
+func (t *idleTimer) check() {
+	idle := time.Since(time.Unix(0, atomic.LoadInt64(&t.last)))
+	t.mu.Lock()
+	if t.stopped {
+		t.mu.Unlock()
+		return
+	}
+	if idle < t.timeout {
+		t.timer = time.AfterFunc(t.timeout-idle, t.check)
+		t.mu.Unlock()
+		return
+	}
+	t.stopped = true
+	t.mu.Unlock()
+	t.onIdle()
+}
+
+// stop stops the timer. It is safe to call on a nil timer.
+func (t *idleTimer) stop() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopped = true
+	t.timer.Stop()
+}
@@ -57,6 +57,16 @@ func Shutdown(timeout time.Duration) {
 			srv.Shutdown(ctx)
 		}(srv)
 	}
+
+	// websocket connections are hijacked and
+	// not closed by the HTTP servers
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		shutdownWS(ctx)
+	}()
 	wg.Wait()
 }
 
@@ -0,0 +1,73 @@
+package tcp
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/fabiolb/fabio/logger"
+	"github.com/fabiolb/fabio/route"
+)
+
+// Close reasons for the access log.
+const (
+	reasonNoServerName  = "no server name"
+	reasonHandshake     = "tls handshake failed"
+	reasonNoRoute       = "no route"
+	reasonAccessDenied  = "access denied"
+	reasonDialFailed    = "upstream connect failed"
+	reasonProxyProto    = "proxy protocol failed"
+	reasonUpstreamTLS   = "upstream tls failed"
+	reasonClientClosed  = logger.CloseReasonClientClosed
+	reasonUpstreamClose = logger.CloseReasonUpstreamClosed
+)
+
+// connLog collects the values of the access log event
+// of a single connection.
+type connLog struct {
+	e      logger.Event
+	reason string
+
+	// received and sent count the bytes received from and sent to the
+	// client. They are updated by the copy go routines and must be
+	// accessed atomically.
+	received, sent int64
+}
+
+func newConnLog(proto string, in net.Conn) *connLog {
+	return &connLog{
+		e: logger.Event{
+			Start:      time.Now(),
+			Proto:      proto,
+			RemoteAddr: in.RemoteAddr().String(),
+		},
+	}
+}
+
+// target records the upstream target of the connection.
+func (c *connLog) target(t *route.Target) {
+	c.e.UpstreamService = t.Service
+	c.e.Route = t.Route
+	c.e.TargetWeight = t.Weight
+	c.e.SampleRules = t.AccessLog
+	if t.URL != nil {
+		c.e.UpstreamAddr = t.URL.Host
+	}
+}
+
+// log writes the access log event. If no close reason was
+// recorded then err is used as reason.
+func (c *connLog) log(l logger.Logger, err error) {
+	if l == nil {
+		return
+	}
+	reason := c.reason
+	if reason == "" && err != nil {
+		reason = err.Error()
+	}
+	c.e.End = time.Now()
+	c.e.CloseReason = reason
+	c.e.BytesReceived = atomic.LoadInt64(&c.received)
+	c.e.BytesSent = atomic.LoadInt64(&c.sent)
+	l.Log(&c.e)
+}
@@ -0,0 +1,123 @@
+package tcp
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/logger"
+	"github.com/fabiolb/fabio/route"
+)
+
+// eventLogger records the logged events.
+type eventLogger chan *logger.Event
+
+func (l eventLogger) Log(e *logger.Event) { l <- e }
+
+func (l eventLogger) wait(t *testing.T) *logger.Event {
+	t.Helper()
+	select {
+	case e := <-l:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for log event")
+		return nil
+	}
+}
+
+func TestProxyAccessLog(t *testing.T) {
+	// upstream server which echos a single line and closes the connection
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		line, _, _ := bufio.NewReader(c).ReadLine()
+		c.Write(append(line, " echo"...))
+	}()
+
+	t.Run("upstream closed", func(t *testing.T) {
+		events := make(eventLogger, 1)
+		p := &Proxy{
+			Lookup: func(string) *route.Target {
+				return &route.Target{
+					Service: "svc-a",
+					Route:   ":1234",
+					Weight:  1,
+					URL:     &url.URL{Host: l.Addr().String()},
+				}
+			},
+			Logger: events,
+		}
+		in, c := net.Pipe()
+		go p.ServeTCP(in)
+
+		if _, err := c.Write([]byte("foo\n")); err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(b), "foo echo"; got != want {
+			t.Fatalf("got %q want %q", got, want)
+		}
+
+		e := events.wait(t)
+		if got, want := e.Proto, "tcp"; got != want {
+			t.Errorf("got proto %q want %q", got, want)
+		}
+		if got, want := e.RemoteAddr, "pipe"; got != want {
+			t.Errorf("got remote addr %q want %q", got, want)
+		}
+		if got, want := e.UpstreamAddr, l.Addr().String(); got != want {
+			t.Errorf("got upstream addr %q want %q", got, want)
+		}
+		if got, want := e.UpstreamService, "svc-a"; got != want {
+			t.Errorf("got upstream service %q want %q", got, want)
+		}
+		if got, want := e.Route, ":1234"; got != want {
+			t.Errorf("got route %q want %q", got, want)
+		}
+		if got, want := e.BytesReceived, int64(4); got != want {
+			t.Errorf("got %d bytes received want %d", got, want)
+		}
+		if got, want := e.BytesSent, int64(8); got != want {
+			t.Errorf("got %d bytes sent want %d", got, want)
+		}
+		if got, want := e.CloseReason, reasonUpstreamClose; got != want {
+			t.Errorf("got close reason %q want %q", got, want)
+		}
+		if e.End.Before(e.Start) {
+			t.Errorf("end %v before start %v", e.End, e.Start)
+		}
+	})
+
+	t.Run("no route", func(t *testing.T) {
+		events := make(eventLogger, 1)
+		p := &Proxy{
+			Lookup: func(string) *route.Target { return nil },
+			Logger: events,
+		}
+		in, c := net.Pipe()
+		defer c.Close()
+		go p.ServeTCP(in)
+
+		e := events.wait(t)
+		if got, want := e.CloseReason, reasonNoRoute; got != want {
+			t.Errorf("got close reason %q want %q", got, want)
+		}
+		if got, want := e.UpstreamAddr, ""; got != want {
+			t.Errorf("got upstream addr %q want %q", got, want)
+		}
+	})
+}
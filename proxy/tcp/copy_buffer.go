@@ -2,13 +2,44 @@ package tcp
 
 import (
 	"io"
+	"sync/atomic"
 
 	"github.com/fabiolb/fabio/metrics"
 )
 
+// pipe copies data between the client connection in and the upstream
+// connection out until either side closes the connection or an error
+// occurs. Then both connections are closed and pipe waits for the
+// other direction to finish so that all transferred bytes are counted.
+// rx counts the traffic from the upstream server (in <- out) and tx
+// the traffic to the upstream server (out <- in). The close reason
+// and the number of transferred bytes are recorded in cl.
+func pipe(in, out io.ReadWriteCloser, rx, tx metrics.Counter, cl *connLog) error {
+	type result struct {
+		reason string
+		err    error
+	}
+	resc := make(chan result, 2)
+	cp := func(dst io.Writer, src io.Reader, c metrics.Counter, n *int64, reason string) {
+		resc <- result{reason, copyBuffer(dst, src, c, n)}
+	}
+
+	go cp(in, out, rx, &cl.sent, reasonUpstreamClose)
+	go cp(out, in, tx, &cl.received, reasonClientClosed)
+	res := <-resc
+	in.Close()
+	out.Close()
+	<-resc
+	if res.err == nil {
+		cl.reason = res.reason
+	}
+	return res.err
+}
+
 // copyBuffer is an adapted version of io.copyBuffer which updates a
-// counter instead of returning the total bytes written.
-func copyBuffer(dst io.Writer, src io.Reader, c metrics.Counter) (err error) {
+// counter instead of returning the total bytes written. If n is not
+// nil the total is also added to n atomically.
+func copyBuffer(dst io.Writer, src io.Reader, c metrics.Counter, n *int64) (err error) {
 	buf := make([]byte, 32*1024)
 	for {
 		nr, er := src.Read(buf)
@@ -18,6 +49,9 @@ func copyBuffer(dst io.Writer, src io.Reader, c metrics.Counter) (err error) {
 				if c != nil {
 					c.Inc(int64(nw))
 				}
+				if n != nil {
+					atomic.AddInt64(n, int64(nw))
+				}
 			}
 			if ew != nil {
 				err = ew
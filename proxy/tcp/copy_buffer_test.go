@@ -0,0 +1,58 @@
+package tcp
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPipe(t *testing.T) {
+	client, in := net.Pipe()
+	out, server := net.Pipe()
+
+	// upstream server which reads a request, sends a
+	// response and closes the connection
+	go func() {
+		buf := make([]byte, 3)
+		if _, err := server.Read(buf); err != nil {
+			return
+		}
+		server.Write([]byte("hello"))
+		server.Close()
+	}()
+
+	cl := &connLog{}
+	errc := make(chan error, 1)
+	go func() { errc <- pipe(in, out, nil, nil, cl) }()
+
+	if _, err := client.Write([]byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	// the client connection is closed when the upstream closes
+	b, err := ioutil.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "hello"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for pipe")
+	}
+	if got, want := cl.received, int64(3); got != want {
+		t.Errorf("got %d bytes received want %d", got, want)
+	}
+	if got, want := cl.sent, int64(5); got != want {
+		t.Errorf("got %d bytes sent want %d", got, want)
+	}
+	if got, want := cl.reason, reasonUpstreamClose; got != want {
+		t.Errorf("got close reason %q want %q", got, want)
+	}
+}
@@ -0,0 +1,20 @@
+package tcp
+
+import (
+	"time"
+
+	"github.com/fabiolb/fabio/metrics"
+	"github.com/fabiolb/fabio/route"
+)
+
+// trackConn increments the active connections gauge of the target and
+// returns a function which decrements it and records the duration of
+// the connection since start when the connection is closed.
+func trackConn(t *route.Target, start time.Time) func() {
+	done := metrics.TrackActive(metrics.DefaultRegistry, t.TimerName+".conn.active", "route_active_connections", t.MetricLabels...)
+	d := metrics.LabeledTimer(metrics.DefaultRegistry, t.TimerName+".conn.duration", "route_connection_duration", t.MetricLabels...)
+	return func() {
+		done()
+		d.UpdateSince(start)
+	}
+}
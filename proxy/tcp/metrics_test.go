@@ -0,0 +1,53 @@
+package tcp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/metrics"
+	"github.com/fabiolb/fabio/route"
+)
+
+// recordingRegistry records the gauge values and timer names.
+type recordingRegistry struct {
+	metrics.NoopRegistry
+	gauges map[string][]float64
+	timers []string
+}
+
+func (r *recordingRegistry) GetGauge(name string) metrics.Gauge {
+	return gaugeFunc(func(v float64) { r.gauges[name] = append(r.gauges[name], v) })
+}
+
+func (r *recordingRegistry) GetTimer(name string) metrics.Timer {
+	r.timers = append(r.timers, name)
+	return metrics.NoopTimer{}
+}
+
+type gaugeFunc func(v float64)
+
+func (f gaugeFunc) Update(v float64) { f(v) }
+
+func TestTrackConn(t *testing.T) {
+	r := &recordingRegistry{gauges: map[string][]float64{}}
+	defer func(old metrics.Registry) { metrics.DefaultRegistry = old }(metrics.DefaultRegistry)
+	metrics.DefaultRegistry = r
+
+	// the second target has the same name since it
+	// is the same target after a table update.
+	t1 := &route.Target{TimerName: "svc-a.:1234"}
+	t2 := &route.Target{TimerName: "svc-a.:1234"}
+
+	done1 := trackConn(t1, time.Now())
+	done2 := trackConn(t2, time.Now())
+	done1()
+	done2()
+
+	if got, want := r.gauges["svc-a.:1234.conn.active"], []float64{1, 2, 1, 0}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got active %v want %v", got, want)
+	}
+	if got, want := r.timers, []string{"svc-a.:1234.conn.duration", "svc-a.:1234.conn.duration"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got timers %v want %v", got, want)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net"
 	"time"
 
+	"github.com/fabiolb/fabio/logger"
 	"github.com/fabiolb/fabio/metrics"
 	"github.com/fabiolb/fabio/route"
 )
@@ -33,11 +34,17 @@ type SNIProxy struct {
 
 	// Noroute counts the failed Lookup() calls.
 	Noroute metrics.Counter
+
+	// Logger is the access logger for the connections.
+	Logger logger.Logger
 }
 
-func (p *SNIProxy) ServeTCP(in net.Conn) error {
+func (p *SNIProxy) ServeTCP(in net.Conn) (err error) {
 	defer in.Close()
 
+	cl := newConnLog("tcp+sni", in)
+	defer func() { cl.log(p.Logger, err) }()
+
 	if p.Conn != nil {
 		p.Conn.Inc(1)
 	}
@@ -49,6 +56,7 @@ func (p *SNIProxy) ServeTCP(in net.Conn) error {
 		if p.ConnFail != nil {
 			p.ConnFail.Inc(1)
 		}
+		cl.reason = reasonHandshake
 		return err
 	}
 
@@ -58,6 +66,7 @@ func (p *SNIProxy) ServeTCP(in net.Conn) error {
 		if p.ConnFail != nil {
 			p.ConnFail.Inc(1)
 		}
+		cl.reason = reasonHandshake
 		return err
 	}
 
@@ -68,17 +77,20 @@ func (p *SNIProxy) ServeTCP(in net.Conn) error {
 		if p.ConnFail != nil {
 			p.ConnFail.Inc(1)
 		}
+		cl.reason = reasonHandshake
 		return err
 	}
 
 	// readServerName wants only the handshake message so ignore the first
 	// 5 bytes which is the TLS record header
 	host, ok := readServerName(data[5:])
+	cl.e.ServerName = host
 	if !ok {
 		log.Print("[DEBUG] tcp+sni: TLS handshake failed (unable to parse client hello)")
 		if p.ConnFail != nil {
 			p.ConnFail.Inc(1)
 		}
+		cl.reason = reasonHandshake
 		return nil
 	}
 
@@ -87,6 +99,7 @@ func (p *SNIProxy) ServeTCP(in net.Conn) error {
 		if p.ConnFail != nil {
 			p.ConnFail.Inc(1)
 		}
+		cl.reason = reasonNoServerName
 		return nil
 	}
 
@@ -95,11 +108,14 @@ func (p *SNIProxy) ServeTCP(in net.Conn) error {
 		if p.Noroute != nil {
 			p.Noroute.Inc(1)
 		}
+		cl.reason = reasonNoRoute
 		return nil
 	}
+	cl.target(t)
 	addr := t.URL.Host
 
 	if t.AccessDeniedTCP(in) {
+		cl.reason = reasonAccessDenied
 		return nil
 	}
 
@@ -109,6 +125,7 @@ func (p *SNIProxy) ServeTCP(in net.Conn) error {
 		if p.ConnFail != nil {
 			p.ConnFail.Inc(1)
 		}
+		cl.reason = reasonDialFailed
 		return err
 	}
 	defer out.Close()
@@ -121,6 +138,7 @@ func (p *SNIProxy) ServeTCP(in net.Conn) error {
 			if p.ConnFail != nil {
 				p.ConnFail.Inc(1)
 			}
+			cl.reason = reasonProxyProto
 			return err
 		}
 	}
@@ -134,23 +152,19 @@ func (p *SNIProxy) ServeTCP(in net.Conn) error {
 		}
 		return err
 	}
-
-	errc := make(chan error, 2)
-	cp := func(dst io.Writer, src io.Reader, c metrics.Counter) {
-		errc <- copyBuffer(dst, src, c)
-	}
+	cl.received = int64(n)
 
 	// rx measures the traffic to the upstream server (in <- out)
 	// tx measures the traffic from the upstream server (out <- in)
-	rx := metrics.DefaultRegistry.GetCounter(t.TimerName + ".rx")
-	tx := metrics.DefaultRegistry.GetCounter(t.TimerName + ".tx")
+	rx := metrics.LabeledCounter(metrics.DefaultRegistry, t.TimerName+".rx", "route_rx_bytes", t.MetricLabels...)
+	tx := metrics.LabeledCounter(metrics.DefaultRegistry, t.TimerName+".tx", "route_tx_bytes", t.MetricLabels...)
 
 	// we've received the ClientHello already
 	rx.Inc(int64(n))
 
-	go cp(in, out, rx)
-	go cp(out, in, tx)
-	err = <-errc
+	defer trackConn(t, cl.e.Start)()
+
+	err = pipe(in, out, rx, tx, cl)
 	if err != nil && err != io.EOF {
 		log.Print("[WARN]: tcp+sni:  ", err)
 		return err
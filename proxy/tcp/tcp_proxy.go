@@ -1,11 +1,13 @@
 package tcp
 
 import (
+	"crypto/tls"
 	"io"
 	"log"
 	"net"
 	"time"
 
+	"github.com/fabiolb/fabio/logger"
 	"github.com/fabiolb/fabio/metrics"
 	"github.com/fabiolb/fabio/route"
 )
@@ -28,11 +30,22 @@ type Proxy struct {
 
 	// Noroute counts the failed Lookup() calls.
 	Noroute metrics.Counter
+
+	// Logger is the access logger for the connections.
+	Logger logger.Logger
+
+	// TLSConfig returns the TLS configuration for the upstream
+	// connection of the target. The upstream connection is not
+	// encrypted if TLSConfig is nil or returns nil.
+	TLSConfig func(t *route.Target) (*tls.Config, error)
 }
 
-func (p *Proxy) ServeTCP(in net.Conn) error {
+func (p *Proxy) ServeTCP(in net.Conn) (err error) {
 	defer in.Close()
 
+	cl := newConnLog("tcp", in)
+	defer func() { cl.log(p.Logger, err) }()
+
 	if p.Conn != nil {
 		p.Conn.Inc(1)
 	}
@@ -44,11 +57,14 @@ func (p *Proxy) ServeTCP(in net.Conn) error {
 		if p.Noroute != nil {
 			p.Noroute.Inc(1)
 		}
+		cl.reason = reasonNoRoute
 		return nil
 	}
+	cl.target(t)
 	addr := t.URL.Host
 
 	if t.AccessDeniedTCP(in) {
+		cl.reason = reasonAccessDenied
 		return nil
 	}
 
@@ -58,6 +74,7 @@ func (p *Proxy) ServeTCP(in net.Conn) error {
 		if p.ConnFail != nil {
 			p.ConnFail.Inc(1)
 		}
+		cl.reason = reasonDialFailed
 		return err
 	}
 	defer out.Close()
@@ -70,23 +87,29 @@ func (p *Proxy) ServeTCP(in net.Conn) error {
 			if p.ConnFail != nil {
 				p.ConnFail.Inc(1)
 			}
+			cl.reason = reasonProxyProto
 			return err
 		}
 	}
 
-	errc := make(chan error, 2)
-	cp := func(dst io.Writer, src io.Reader, c metrics.Counter) {
-		errc <- copyBuffer(dst, src, c)
+	out, err = upstreamTLS(out, t, p.TLSConfig, p.DialTimeout)
+	if err != nil {
+		log.Print("[WARN] tcp: TLS handshake with upstream failed. ", err)
+		if p.ConnFail != nil {
+			p.ConnFail.Inc(1)
+		}
+		cl.reason = reasonUpstreamTLS
+		return err
 	}
 
 	// rx measures the traffic to the upstream server (in <- out)
 	// tx measures the traffic from the upstream server (out <- in)
-	rx := metrics.DefaultRegistry.GetCounter(t.TimerName + ".rx")
-	tx := metrics.DefaultRegistry.GetCounter(t.TimerName + ".tx")
+	rx := metrics.LabeledCounter(metrics.DefaultRegistry, t.TimerName+".rx", "route_rx_bytes", t.MetricLabels...)
+	tx := metrics.LabeledCounter(metrics.DefaultRegistry, t.TimerName+".tx", "route_tx_bytes", t.MetricLabels...)
+
+	defer trackConn(t, cl.e.Start)()
 
-	go cp(in, out, rx)
-	go cp(out, in, tx)
-	err = <-errc
+	err = pipe(in, out, rx, tx, cl)
 	if err != nil && err != io.EOF {
 		log.Print("[WARN]: tcp:  ", err)
 		return err
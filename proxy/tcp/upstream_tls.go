@@ -0,0 +1,39 @@
+package tcp
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// upstreamTLS performs the TLS handshake on the upstream connection
+// if tlsConfig returns a configuration for the target. Otherwise, the
+// connection is returned unchanged.
+func upstreamTLS(out net.Conn, t *route.Target, tlsConfig func(*route.Target) (*tls.Config, error), timeout time.Duration) (net.Conn, error) {
+	if tlsConfig == nil {
+		return out, nil
+	}
+	cfg, err := tlsConfig(t)
+	if err != nil || cfg == nil {
+		return out, err
+	}
+	if cfg.ServerName == "" {
+		host, _, err := net.SplitHostPort(t.URL.Host)
+		if err != nil {
+			host = t.URL.Host
+		}
+		cfg.ServerName = host
+	}
+
+	conn := tls.Client(out, cfg)
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	if err := conn.Handshake(); err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
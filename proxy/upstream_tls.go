@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/fabiolb/fabio/cert"
+	"github.com/fabiolb/fabio/route"
+)
+
+// UpstreamTLS provides the TLS configurations and the transports for
+// upstream connections of routes with the 'tlsclientcert' or 'tlsca'
+// option. Transports are cached per combination of client certificate
+// source, CA source, 'tlsskipverify' and HTTP/2 and are replaced when
+// one of the sources is updated.
+type UpstreamTLS struct {
+	// Sources provides the certificates from the named cert sources.
+	Sources *cert.ClientSources
+
+	// NewTransport creates a transport with the given TLS configuration.
+	NewTransport func(*tls.Config) *http.Transport
+
+	mu         sync.Mutex
+	transports map[upstreamKey]*upstreamTransport
+}
+
+type upstreamKey struct {
+	cert, ca   string
+	skipVerify bool
+	h2         bool
+}
+
+type upstreamTransport struct {
+	gen uint64
+	tr  *http.Transport
+}
+
+// UsesUpstreamTLS returns true if the target requires a client
+// certificate or a custom CA bundle for the upstream connection.
+func UsesUpstreamTLS(t *route.Target) bool {
+	return t.TLSClientCert != "" || t.TLSCA != ""
+}
+
+// TLSConfig returns the TLS configuration for the upstream connection
+// of the target and its generation.
+func (u *UpstreamTLS) TLSConfig(t *route.Target) (*tls.Config, uint64, error) {
+	return u.Sources.TLSConfig(t.TLSClientCert, t.TLSCA, t.TLSSkipVerify)
+}
+
+// Generation returns the current generation of the TLS configuration
+// of the target.
+func (u *UpstreamTLS) Generation(t *route.Target) uint64 {
+	return u.Sources.Generation(t.TLSClientCert, t.TLSCA)
+}
+
+// Transport returns the cached transport for the target and creates
+// a new one if the sources have been updated since. If h2 is true the
+// transport speaks HTTP/2 to upstreams which support it.
+func (u *UpstreamTLS) Transport(t *route.Target, h2 bool) (*http.Transport, error) {
+	key := upstreamKey{t.TLSClientCert, t.TLSCA, t.TLSSkipVerify, h2}
+	gen := u.Generation(t)
+
+	u.mu.Lock()
+	ut := u.transports[key]
+	u.mu.Unlock()
+	if ut != nil && ut.gen == gen {
+		return ut.tr, nil
+	}
+
+	// TLSConfig may wait for a source to load. Do not block
+	// the transports of the other sources in the meantime.
+	tlscfg, gen, err := u.TLSConfig(t)
+	if err != nil {
+		return nil, err
+	}
+	tr := u.NewTransport(tlscfg)
+	if h2 {
+		if tr, err = NewH2Transport(tr); err != nil {
+			return nil, err
+		}
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	// another request has created a transport in the meantime
+	ut = u.transports[key]
+	if ut != nil && ut.gen >= gen {
+		tr.CloseIdleConnections()
+		return ut.tr, nil
+	}
+	if ut != nil {
+		log.Printf("[INFO] Updating upstream transport for client cert %q and CA %q", t.TLSClientCert, t.TLSCA)
+		ut.tr.CloseIdleConnections()
+	}
+	if u.transports == nil {
+		u.transports = make(map[upstreamKey]*upstreamTransport)
+	}
+	ut = &upstreamTransport{gen: gen, tr: tr}
+	u.transports[key] = ut
+	return ut.tr, nil
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"io"
+	"math/rand"
+)
+
+// WebSocket opcodes and close codes from RFC 6455.
+const (
+	wsOpClose = 0x8
+
+	wsCloseGoingAway     = 1001
+	wsCloseMessageTooBig = 1009
+)
+
+// wsFrameHeader is the header of a WebSocket frame.
+type wsFrameHeader struct {
+	// raw contains the header bytes as received.
+	raw []byte
+
+	fin    bool
+	opcode byte
+	length int64
+}
+
+// isControl returns true for close, ping and pong frames.
+func (h wsFrameHeader) isControl() bool {
+	return h.opcode&0x8 != 0
+}
+
+// readWSFrameHeader reads the header of the next frame including
+// the masking key. The payload is not read.
+func readWSFrameHeader(r io.Reader) (wsFrameHeader, error) {
+	var h wsFrameHeader
+	b := make([]byte, 2, 14)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return h, err
+	}
+	h.fin = b[0]&0x80 != 0
+	h.opcode = b[0] & 0x0f
+	masked := b[1]&0x80 != 0
+
+	var ext int
+	switch n := b[1] & 0x7f; n {
+	case 126:
+		ext = 2
+	case 127:
+		ext = 8
+	default:
+		h.length = int64(n)
+	}
+	if masked {
+		ext += 4
+	}
+	if ext > 0 {
+		b = b[:2+ext]
+		if _, err := io.ReadFull(r, b[2:]); err != nil {
+			return h, err
+		}
+	}
+	switch b[1] & 0x7f {
+	case 126:
+		h.length = int64(binary.BigEndian.Uint16(b[2:4]))
+	case 127:
+		h.length = int64(binary.BigEndian.Uint64(b[2:10]) & (1<<63 - 1))
+	}
+	h.raw = b
+	return h, nil
+}
+
+// wsCloseFrame returns a close frame with the status code and
+// reason. Frames which are sent to the server must be masked.
+func wsCloseFrame(code int, reason string, masked bool) []byte {
+	// control frames have at most 125 bytes of payload
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+
+	b := []byte{0x80 | wsOpClose, byte(len(payload))}
+	if !masked {
+		return append(b, payload...)
+	}
+	b[1] |= 0x80
+	var key [4]byte
+	binary.BigEndian.PutUint32(key[:], rand.Uint32())
+	b = append(b, key[:]...)
+	for i := range payload {
+		payload[i] ^= key[i%4]
+	}
+	return append(b, payload...)
+}
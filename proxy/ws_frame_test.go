@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestReadWSFrameHeader(t *testing.T) {
+	long := make([]byte, 10)
+	long[0], long[1] = 0x82, 127
+	binary.BigEndian.PutUint64(long[2:], 70000)
+
+	tests := []struct {
+		desc string
+		in   []byte
+		h    wsFrameHeader
+	}{
+		{"text", []byte{0x81, 0x05}, wsFrameHeader{fin: true, opcode: 0x1, length: 5}},
+		{"continuation", []byte{0x00, 0x03}, wsFrameHeader{opcode: 0x0, length: 3}},
+		{"masked", []byte{0x81, 0x85, 1, 2, 3, 4}, wsFrameHeader{fin: true, opcode: 0x1, length: 5}},
+		{"16 bit length", []byte{0x82, 126, 0x01, 0x00}, wsFrameHeader{fin: true, opcode: 0x2, length: 256}},
+		{"64 bit length", long, wsFrameHeader{fin: true, opcode: 0x2, length: 70000}},
+		{"close", []byte{0x88, 0x00}, wsFrameHeader{fin: true, opcode: wsOpClose}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			h, err := readWSFrameHeader(bytes.NewReader(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.h.raw = tt.in
+			if got, want := h, tt.h; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %+v want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestWSCloseFrame(t *testing.T) {
+	for _, masked := range []bool{false, true} {
+		b := wsCloseFrame(wsCloseGoingAway, "bye", masked)
+		r := bytes.NewReader(b)
+		h, err := readWSFrameHeader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := h.opcode, byte(wsOpClose); got != want {
+			t.Fatalf("got opcode %d want %d", got, want)
+		}
+		payload := make([]byte, h.length)
+		r.Read(payload)
+		if masked {
+			key := h.raw[len(h.raw)-4:]
+			for i := range payload {
+				payload[i] ^= key[i%4]
+			}
+		}
+		if got, want := int(binary.BigEndian.Uint16(payload)), wsCloseGoingAway; got != want {
+			t.Fatalf("got code %d want %d", got, want)
+		}
+		if got, want := string(payload[2:]), "bye"; got != want {
+			t.Fatalf("got reason %q want %q", got, want)
+		}
+	}
+}
@@ -1,27 +1,84 @@
 package proxy
 
 import (
-	"bytes"
+	"bufio"
+	"errors"
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/fabiolb/fabio/config"
 	"github.com/fabiolb/fabio/metrics"
+	"github.com/fabiolb/fabio/route"
 )
 
 // conn measures the number of open web socket connections
 var conn = metrics.DefaultRegistry.GetCounter("ws.conn")
 
+// wsCloseTimeout is the time to wait for the peers to close the
+// connection after a close frame was sent.
+var wsCloseTimeout = 5 * time.Second
+
+var errWSFrameTooLarge = errors.New("frame too large")
+
 type dialFunc func(network, address string) (net.Conn, error)
 
+// wsLimits contains the limits of a websocket connection.
+// A zero value disables the limit.
+type wsLimits struct {
+	idleTimeout  time.Duration
+	maxLifetime  time.Duration
+	maxFrameSize int64
+}
+
+// newWSLimits returns the limits for the websocket connections of
+// the target. The route options override the proxy configuration.
+func newWSLimits(cfg config.WebSocket, t *route.Target) wsLimits {
+	l := wsLimits{
+		idleTimeout:  cfg.IdleTimeout,
+		maxLifetime:  cfg.MaxLifetime,
+		maxFrameSize: int64(cfg.MaxFrameSize),
+	}
+	if t.WSIdleTimeout > 0 {
+		l.idleTimeout = t.WSIdleTimeout
+	}
+	if t.WSMaxLifetime > 0 {
+		l.maxLifetime = t.WSMaxLifetime
+	}
+	if t.WSMaxFrameSize > 0 {
+		l.maxFrameSize = t.WSMaxFrameSize
+	}
+	return l
+}
+
+// isWebSocket returns true if the request is a websocket upgrade
+// request. Header values are compared case-insensitive since
+// clients send 'Upgrade', 'upgrade' or 'keep-alive, Upgrade'.
+func isWebSocket(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, v := range r.Header["Connection"] {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // newWSHandler returns an HTTP handler which forwards data between
 // an incoming and outgoing websocket connection. It checks whether
-// the handshake was completed successfully before forwarding data
-// between the client and server.
-func newWSHandler(host string, dial dialFunc) http.Handler {
+// the handshake was completed successfully before forwarding the
+// frames between the client and server. The frames are counted in
+// the metrics of the target and checked against the limits.
+func newWSHandler(host string, dial dialFunc, t *route.Target, limits wsLimits) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		conn.Inc(1)
 		defer func() { conn.Inc(-1) }()
@@ -32,7 +89,7 @@ func newWSHandler(host string, dial dialFunc) http.Handler {
 			return
 		}
 
-		in, _, err := hj.Hijack()
+		in, brw, err := hj.Hijack()
 		if err != nil {
 			log.Printf("[ERROR] Hijack error for %s. %s", r.URL, err)
 			http.Error(w, "hijack error", http.StatusInternalServerError)
@@ -40,10 +97,14 @@ func newWSHandler(host string, dial dialFunc) http.Handler {
 		}
 		defer in.Close()
 
+		// the read and write timeouts of the listener do not
+		// apply to websocket connections. See wsLimits.
+		in.SetDeadline(time.Time{})
+
 		out, err := dial("tcp", host)
 		if err != nil {
 			log.Printf("[ERROR] WS error for %s. %s", r.URL, err)
-			http.Error(w, "error contacting backend server", http.StatusInternalServerError)
+			wsError(in, http.StatusBadGateway, "error contacting backend server")
 			return
 		}
 		defer out.Close()
@@ -51,55 +112,278 @@ func newWSHandler(host string, dial dialFunc) http.Handler {
 		err = r.Write(out)
 		if err != nil {
 			log.Printf("[ERROR] Error copying request for %s. %s", r.URL, err)
-			http.Error(w, "error copying request", http.StatusInternalServerError)
+			wsError(in, http.StatusBadGateway, "error copying request")
 			return
 		}
 
 		// read the initial response to check whether we get an HTTP/1.1 101 ... response
 		// to determine whether the handshake worked.
-		b := make([]byte, 1024)
 		if err := out.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
 			log.Printf("[ERROR] Error setting read timeout for %s: %s", r.URL, err)
-			http.Error(w, "error setting read timeout", http.StatusInternalServerError)
+			wsError(in, http.StatusInternalServerError, "error setting read timeout")
 			return
 		}
 
-		n, err := out.Read(b)
+		rec := &recordingReader{r: out, on: true}
+		br := bufio.NewReader(rec)
+		resp, err := http.ReadResponse(br, r)
 		if err != nil {
 			log.Printf("[ERROR] Error reading handshake for %s: %s", r.URL, err)
-			http.Error(w, "error reading handshake", http.StatusInternalServerError)
+			wsError(in, http.StatusBadGateway, "error reading handshake")
 			return
 		}
 
-		b = b[:n]
-		if m, err := in.Write(b); err != nil || n != m {
-			log.Printf("[ERROR] Error sending handshake for %s: %s", r.URL, err)
-			http.Error(w, "error sending handshake", http.StatusInternalServerError)
+		// https://tools.ietf.org/html/rfc6455#section-1.3
+		// The websocket server must respond with HTTP/1.1 101 on successful handshake
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			log.Printf("[INFO] Websocket upgrade failed for %s: %s", r.URL, resp.Status)
+			resp.Write(in)
 			return
 		}
 
-		// https://tools.ietf.org/html/rfc6455#section-1.3
-		// The websocket server must respond with HTTP/1.1 101 on successful handshake
-		if !bytes.HasPrefix(b, []byte("HTTP/1.1 101")) {
-			firstLine := strings.SplitN(string(b), "\n", 1)
-			log.Printf("[INFO] Websocket upgrade failed for %s: %s", r.URL, firstLine)
-			http.Error(w, "websocket upgrade failed", http.StatusInternalServerError)
+		// forward the handshake response as received. Data
+		// which follows the handshake is still in the buffer.
+		hdr := rec.stop(br.Buffered())
+		if _, err := in.Write(hdr); err != nil {
+			log.Printf("[ERROR] Error sending handshake for %s: %s", r.URL, err)
 			return
 		}
 
 		out.SetReadDeadline(time.Time{})
 
-		errc := make(chan error, 2)
-		cp := func(dst io.Writer, src io.Reader) {
-			_, err := io.Copy(dst, src)
-			errc <- err
+		c := &wsConn{
+			in:       in,
+			out:      out,
+			limits:   limits,
+			metrics:  newWSMetrics(t),
+			toClient: &wsWriter{w: in},
+			toServer: &wsWriter{w: out},
 		}
-
-		go cp(out, in)
-		go cp(in, out)
-		err = <-errc
-		if err != nil && err != io.EOF {
+		err = c.serve(brw.Reader, br)
+		if err != nil && err != io.EOF && !c.isClosing() {
 			log.Printf("[INFO] WS error for %s. %s", r.URL, err)
 		}
 	})
 }
+
+// wsError writes an error response to the hijacked connection.
+func wsError(c net.Conn, code int, msg string) {
+	resp := &http.Response{
+		StatusCode:    code,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"text/plain; charset=utf-8"}},
+		Body:          ioutil.NopCloser(strings.NewReader(msg + "\n")),
+		ContentLength: int64(len(msg) + 1),
+		Close:         true,
+	}
+	resp.Write(c)
+}
+
+// recordingReader records the data read from r until stop is called.
+type recordingReader struct {
+	r  io.Reader
+	on bool
+	b  []byte
+}
+
+func (rr *recordingReader) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	if rr.on {
+		rr.b = append(rr.b, p[:n]...)
+	}
+	return n, err
+}
+
+// stop stops recording and returns the recorded data
+// without the last n bytes which have not been consumed.
+func (rr *recordingReader) stop(n int) []byte {
+	rr.on = false
+	return rr.b[:len(rr.b)-n]
+}
+
+// wsConn forwards the frames of a websocket connection between
+// the client (in) and the server (out).
+type wsConn struct {
+	in, out  net.Conn
+	limits   wsLimits
+	metrics  *wsMetrics
+	toClient *wsWriter
+	toServer *wsWriter
+
+	// idle is the idle timer which is reset on every received
+	// frame. It is nil if there is no idle timeout.
+	idle *idleTimer
+
+	mu      sync.Mutex
+	closing bool
+	done    bool
+	timers  []*time.Timer
+}
+
+// serve forwards the frames until one of the connections is closed.
+func (c *wsConn) serve(fromClient, fromServer io.Reader) error {
+	addWSConn(c)
+	defer removeWSConn(c)
+	defer c.metrics.track()()
+	defer c.stop()
+
+	c.mu.Lock()
+	if c.limits.maxLifetime > 0 {
+		c.timers = append(c.timers, time.AfterFunc(c.limits.maxLifetime, func() {
+			c.close(wsCloseGoingAway, "max lifetime exceeded")
+		}))
+	}
+	c.idle = newIdleTimer(c.limits.idleTimeout, func() {
+		c.close(wsCloseGoingAway, "idle timeout")
+	})
+	c.mu.Unlock()
+
+	errc := make(chan error, 2)
+	go func() { errc <- c.pump(fromClient, c.toServer, c.metrics.rx, c.metrics.rxMessages) }()
+	go func() { errc <- c.pump(fromServer, c.toClient, c.metrics.tx, c.metrics.txMessages) }()
+	return <-errc
+}
+
+// pump forwards the frames from src to dst and counts the bytes and
+// messages. Frames which exceed the maximum frame size close the
+// connection.
+func (c *wsConn) pump(src io.Reader, dst *wsWriter, bytes, messages metrics.Counter) error {
+	if c.idle != nil {
+		src = &activityReader{r: src, t: c.idle}
+	}
+	for {
+		h, err := readWSFrameHeader(src)
+		if err != nil {
+			return err
+		}
+		if c.limits.maxFrameSize > 0 && h.length > c.limits.maxFrameSize {
+			c.close(wsCloseMessageTooBig, errWSFrameTooLarge.Error())
+			return errWSFrameTooLarge
+		}
+		n, err := dst.writeFrame(h, src)
+		bytes.Inc(n)
+		if err != nil {
+			return err
+		}
+		if h.fin && !h.isControl() {
+			messages.Inc(1)
+		}
+	}
+}
+
+// close sends a close frame with the code and reason to both peers
+// and closes the connections if the peers do not complete the close
+// handshake within wsCloseTimeout.
+func (c *wsConn) close(code int, reason string) {
+	c.mu.Lock()
+	if c.closing || c.done {
+		c.mu.Unlock()
+		return
+	}
+	c.closing = true
+	c.timers = append(c.timers, time.AfterFunc(wsCloseTimeout, c.abort))
+	c.mu.Unlock()
+
+	c.toClient.sendClose(wsCloseFrame(code, reason, false))
+	c.toServer.sendClose(wsCloseFrame(code, reason, true))
+}
+
+// abort closes both connections.
+func (c *wsConn) abort() {
+	c.in.Close()
+	c.out.Close()
+}
+
+func (c *wsConn) isClosing() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closing
+}
+
+// stop stops the timers when the connection is closed.
+func (c *wsConn) stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.done = true
+	for _, t := range c.timers {
+		t.Stop()
+	}
+	c.idle.stop()
+}
+
+// activityReader resets the idle timer on every read.
+type activityReader struct {
+	r io.Reader
+	t *idleTimer
+}
+
+func (ar *activityReader) Read(p []byte) (int, error) {
+	n, err := ar.r.Read(p)
+	if n > 0 {
+		ar.t.touch()
+	}
+	return n, err
+}
+
+// wsWriter writes frames to one side of the connection. A close frame
+// which is sent while a frame is forwarded is delayed until the frame
+// is complete. Frames after a close frame are discarded.
+type wsWriter struct {
+	w io.Writer
+
+	mu      sync.Mutex
+	mid     bool
+	closed  bool
+	pending []byte
+}
+
+// writeFrame forwards the frame header and payload and returns
+// the number of bytes written.
+func (w *wsWriter) writeFrame(h wsFrameHeader, payload io.Reader) (int64, error) {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		_, err := io.CopyN(ioutil.Discard, payload, h.length)
+		return 0, err
+	}
+	w.mid = true
+	w.mu.Unlock()
+
+	n, err := w.w.Write(h.raw)
+	written := int64(n)
+	if err == nil {
+		var m int64
+		m, err = io.CopyN(w.w, payload, h.length)
+		written += m
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.mid = false
+	if h.opcode == wsOpClose {
+		w.closed = true
+	}
+	if w.pending != nil && !w.closed && err == nil {
+		_, err = w.w.Write(w.pending)
+		w.closed = true
+	}
+	w.pending = nil
+	return written, err
+}
+
+// sendClose sends the close frame unless the connection is already
+// closed.
+func (w *wsWriter) sendClose(frame []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	if w.mid {
+		w.pending = frame
+		return
+	}
+	w.w.Write(frame)
+	w.closed = true
+}
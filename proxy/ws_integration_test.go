@@ -1,13 +1,18 @@
 package proxy
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/binary"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/fabiolb/fabio/config"
 	"github.com/fabiolb/fabio/route"
@@ -120,3 +125,120 @@ func testWSEcho(t *testing.T, url string, hdr http.Header) {
 func wsEchoHandler(ws *websocket.Conn) {
 	io.Copy(ws, ws)
 }
+
+func TestProxyWSLimits(t *testing.T) {
+	wsServer := httptest.NewServer(websocket.Handler(wsEchoHandler))
+	defer wsServer.Close()
+
+	routes := "route add ws /idle " + wsServer.URL + "\n"
+	routes += "route add ws /frame " + wsServer.URL + ` opts "ws.maxframesize=10B"` + "\n"
+	routes += "route add ws /shutdown " + wsServer.URL + ` opts "ws.idletimeout=1m"` + "\n"
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config:    config.Proxy{WebSocket: config.WebSocket{IdleTimeout: 100 * time.Millisecond}},
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString(routes))
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+	addr := proxy.URL[len("http://"):]
+
+	t.Run("idle timeout", func(t *testing.T) {
+		c, br := dialWS(t, addr, "/idle")
+		defer c.Close()
+		code, reason := readWSClose(t, br)
+		if got, want := code, wsCloseGoingAway; got != want {
+			t.Fatalf("got code %d want %d", got, want)
+		}
+		if got, want := reason, "idle timeout"; got != want {
+			t.Fatalf("got reason %q want %q", got, want)
+		}
+	})
+
+	t.Run("max frame size", func(t *testing.T) {
+		c, br := dialWS(t, addr, "/frame")
+		defer c.Close()
+		frame := append([]byte{0x81, 0x80 | 20, 0, 0, 0, 0}, make([]byte, 20)...)
+		if _, err := c.Write(frame); err != nil {
+			t.Fatal(err)
+		}
+		code, _ := readWSClose(t, br)
+		if got, want := code, wsCloseMessageTooBig; got != want {
+			t.Fatalf("got code %d want %d", got, want)
+		}
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		c, br := dialWS(t, addr, "/shutdown")
+		defer c.Close()
+
+		// wait until the connection is registered
+		for i := 0; len(openWSConns()) == 0; i++ {
+			if i == 100 {
+				t.Fatal("websocket connection not registered")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		go shutdownWS(ctx)
+
+		code, reason := readWSClose(t, br)
+		if got, want := code, wsCloseGoingAway; got != want {
+			t.Fatalf("got code %d want %d", got, want)
+		}
+		if got, want := reason, "server shutdown"; got != want {
+			t.Fatalf("got reason %q want %q", got, want)
+		}
+	})
+}
+
+// dialWS opens a websocket connection with a raw handshake.
+func dialWS(t *testing.T, addr, path string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := "GET " + path + " HTTP/1.1\r\n" +
+		"Host: " + addr + "\r\n" +
+		"Upgrade: WebSocket\r\n" +
+		"Connection: keep-alive, upgrade\r\n" +
+		"Origin: http://localhost/\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := io.WriteString(c, req); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(c)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resp.StatusCode, http.StatusSwitchingProtocols; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return c, br
+}
+
+// readWSClose skips frames until it reads a close frame
+// and returns its status code and reason.
+func readWSClose(t *testing.T, r io.Reader) (int, string) {
+	t.Helper()
+	for {
+		h, err := readWSFrameHeader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload := make([]byte, h.length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			t.Fatal(err)
+		}
+		if h.opcode == wsOpClose {
+			return int(binary.BigEndian.Uint16(payload)), string(payload[2:])
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package proxy
+
+import (
+	"github.com/fabiolb/fabio/metrics"
+	"github.com/fabiolb/fabio/route"
+)
+
+// wsMetrics contains the websocket metrics of a route. rx counts the
+// data received from the client and tx the data sent to the client.
+type wsMetrics struct {
+	rx, tx                 metrics.Counter
+	rxMessages, txMessages metrics.Counter
+	name                   string
+	labels                 []string
+}
+
+func newWSMetrics(t *route.Target) *wsMetrics {
+	r, name, labels := metrics.DefaultRegistry, t.TimerName, t.MetricLabels
+	return &wsMetrics{
+		rx:         metrics.LabeledCounter(r, name+".ws.rx", "route_ws_rx_bytes", labels...),
+		tx:         metrics.LabeledCounter(r, name+".ws.tx", "route_ws_tx_bytes", labels...),
+		rxMessages: metrics.LabeledCounter(r, name+".ws.rx.messages", "route_ws_rx_messages", labels...),
+		txMessages: metrics.LabeledCounter(r, name+".ws.tx.messages", "route_ws_tx_messages", labels...),
+		name:       name,
+		labels:     labels,
+	}
+}
+
+// track increments the active connections gauge and returns
+// a function which decrements it when the connection is closed.
+func (m *wsMetrics) track() func() {
+	return metrics.TrackActive(metrics.DefaultRegistry, m.name+".ws.conn.active", "route_ws_active_connections", m.labels...)
+}
@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// wsConns contains the open websocket connections which are
+// closed on shutdown. Hijacked connections are not tracked by
+// the HTTP server.
+var (
+	wsConnsMu sync.Mutex
+	wsConns   = map[*wsConn]bool{}
+)
+
+func addWSConn(c *wsConn) {
+	wsConnsMu.Lock()
+	wsConns[c] = true
+	wsConnsMu.Unlock()
+}
+
+func removeWSConn(c *wsConn) {
+	wsConnsMu.Lock()
+	delete(wsConns, c)
+	wsConnsMu.Unlock()
+}
+
+func openWSConns() []*wsConn {
+	wsConnsMu.Lock()
+	defer wsConnsMu.Unlock()
+	conns := make([]*wsConn, 0, len(wsConns))
+	for c := range wsConns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// shutdownWS sends a close frame to both peers of all open websocket
+// connections and waits until they are closed. Connections which are
+// still open when the context is done are closed.
+func shutdownWS(ctx context.Context) {
+	for _, c := range openWSConns() {
+		c.close(wsCloseGoingAway, "server shutdown")
+	}
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		conns := openWSConns()
+		if len(conns) == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			for _, c := range conns {
+				c.abort()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
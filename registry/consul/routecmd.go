@@ -63,6 +63,14 @@ func (r routecmd) build() []string {
 				case o == "proto=https":
 					dst = "https://" + addr
 
+				case o == "proto=h2":
+					dst = "https://" + addr
+					ropts = append(ropts, o)
+
+				case o == "proto=h2c":
+					dst = "http://" + addr
+					ropts = append(ropts, o)
+
 				case o == "proto=grpcs":
 					dst = "grpcs://" + addr
 
@@ -43,6 +43,36 @@ func TestRouteCmd(t *testing.T) {
 				`route add svc-1 :1234 tcp://1.1.1.1:2222`,
 			},
 		},
+		{
+			name: "h2",
+			r: routecmd{
+				prefix: "p-",
+				svc: &api.CatalogService{
+					ServiceName:    "svc-1",
+					ServiceAddress: "1.1.1.1",
+					ServicePort:    2222,
+					ServiceTags:    []string{`p-foo/bar proto=h2`},
+				},
+			},
+			cfg: []string{
+				`route add svc-1 foo/bar https://1.1.1.1:2222 opts "proto=h2"`,
+			},
+		},
+		{
+			name: "h2c",
+			r: routecmd{
+				prefix: "p-",
+				svc: &api.CatalogService{
+					ServiceName:    "svc-1",
+					ServiceAddress: "1.1.1.1",
+					ServicePort:    2222,
+					ServiceTags:    []string{`p-foo/bar proto=h2c`},
+				},
+			},
+			cfg: []string{
+				`route add svc-1 foo/bar http://1.1.1.1:2222 opts "proto=h2c"`,
+			},
+		},
 	}
 
 	for _, c := range cases {
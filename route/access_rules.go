@@ -7,15 +7,55 @@ import (
 	"net"
 	"net/http"
 	"strings"
+
+	"github.com/fabiolb/fabio/metrics"
+	"github.com/gobwas/glob"
 )
 
 const (
-	ipAllowTag = "allow:ip"
-	ipDenyTag  = "deny:ip"
+	ipAllowTag      = "allow:ip"
+	ipDenyTag       = "deny:ip"
+	countryAllowTag = "allow:country"
+	countryDenyTag  = "deny:country"
+	headerAllowTag  = "allow:header"
+	headerDenyTag   = "deny:header"
 )
 
+// IPGroups contains the named networks which can be referenced
+// in access rules with 'ipgroup:<name>'.
+var IPGroups = map[string][]*net.IPNet{}
+
+// TrustedProxies contains the networks of the proxies in front of
+// fabio. If it is set only the client address, i.e. the last address
+// of the X-Forwarded-For header which is not a trusted proxy, is
+// validated. Otherwise, the remote address and all elements of the
+// X-Forwarded-For header are validated by the 'ip' rules and only the
+// remote address by the 'country' rules.
+var TrustedProxies []*net.IPNet
+
+// GeoIP resolves the country of an address for 'country' access
+// rules. Requests to routes with 'country' rules are denied if it
+// is nil.
+var GeoIP CountryLookup
+
+// headerRule matches the value of a request header.
+type headerRule struct {
+	name  string
+	value glob.Glob
+	raw   string
+}
+
 // AccessDeniedHTTP checks rules on the target for HTTP proxy routes.
 func (t *Target) AccessDeniedHTTP(r *http.Request) bool {
+	if t.accessDeniedHTTP(r) {
+		t.countDenied()
+		return true
+	}
+	return false
+}
+
+// accessDeniedHTTP checks the rules without updating the metrics.
+func (t *Target) accessDeniedHTTP(r *http.Request) bool {
 	// No rules ... skip checks
 	if len(t.accessRules) == 0 {
 		return false
@@ -33,13 +73,29 @@ func (t *Target) AccessDeniedHTTP(r *http.Request) bool {
 		log.Printf("[WARN] failed to parse remote address %s", host)
 	}
 
-	// check remote source and return if denied
-	if t.denyByIP(ip) {
+	xff := r.Header.Get("X-Forwarded-For")
+
+	// only validate the client address behind the trusted proxies
+	if len(TrustedProxies) > 0 {
+		if ip = clientIP(ip, xff); ip == nil {
+			log.Printf("[INFO] route rules denied access from unknown client to %s", t.URL.String())
+			return true
+		}
+		if t.denyByIP(ip) || t.denyByCountry(ip) || t.denyByHeader(r.Header) {
+			return true
+		}
+		return false
+	}
+
+	// check remote source and return if denied. The addresses of the
+	// X-Forwarded-For header are not checked against country rules
+	// since they contain the private addresses of load balancers.
+	if t.denyByIP(ip) || t.denyByCountry(ip) {
 		return true
 	}
 
 	// check xff source if present
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+	if xff != "" {
 		// Trusting XFF headers sent from clients is dangerous and generally
 		// bad practice.  Therefore, we cannot assume which if any of the elements
 		// is the actual client address.  To try and avoid the chance of spoofed
@@ -47,6 +103,7 @@ func (t *Target) AccessDeniedHTTP(r *http.Request) bool {
 		// Specifically AWS does not strip XFF from anonymous internet sources:
 		// https://docs.aws.amazon.com/elasticloadbalancing/latest/classic/x-forwarded-headers.html#x-forwarded-for
 		// See lengthy github discussion for more background: https://github.com/fabiolb/fabio/pull/449
+		// Configure proxy.access.trustedproxies to validate only the client address.
 		for _, xip := range strings.Split(xff, ",") {
 			xip = strings.TrimSpace(xip)
 			if xip == host {
@@ -62,6 +119,10 @@ func (t *Target) AccessDeniedHTTP(r *http.Request) bool {
 		}
 	}
 
+	if t.denyByHeader(r.Header) {
+		return true
+	}
+
 	// default allow
 	return false
 }
@@ -82,19 +143,57 @@ func (t *Target) AccessDeniedTCP(c net.Conn) bool {
 		return false
 	}
 	// check remote connection address
-	if t.denyByIP(addr.IP) {
+	if t.denyByIP(addr.IP) || t.denyByCountry(addr.IP) {
+		t.countDenied()
 		return true
 	}
 	// default allow
 	return false
 }
 
+// countDenied updates the counter of denied requests for the target.
+func (t *Target) countDenied() {
+	metrics.LabeledCounter(metrics.DefaultRegistry, t.TimerName+".denied", "route_denied", t.MetricLabels...).Inc(1)
+}
+
+// clientIP returns the address of the client which is the last
+// address of the X-Forwarded-For header which is not a trusted proxy.
+// If the remote address is not a trusted proxy it is the client.
+func clientIP(remote net.IP, xff string) net.IP {
+	ip := remote
+	if ip == nil || !trusted(ip) || xff == "" {
+		return ip
+	}
+	hops := strings.Split(xff, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		xip := strings.TrimSpace(hops[i])
+		if ip = net.ParseIP(xip); ip == nil {
+			log.Printf("[WARN] failed to parse xff address %s", xip)
+			return nil
+		}
+		if !trusted(ip) {
+			return ip
+		}
+	}
+	return ip
+}
+
+func trusted(ip net.IP) bool {
+	for _, n := range TrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 func (t *Target) denyByIP(ip net.IP) bool {
 	if ip == nil || len(t.accessRules) == 0 {
 		return false
 	}
 	// check allow (whitelist) first if it exists
 	if _, ok := t.accessRules[ipAllowTag]; ok {
+		allowed := false
 		var block *net.IPNet
 		for _, x := range t.accessRules[ipAllowTag] {
 			if block, ok = x.(*net.IPNet); !ok {
@@ -107,14 +206,17 @@ func (t *Target) denyByIP(ip net.IP) bool {
 			if block.Contains(ip) {
 				// debug logging
 				log.Printf("[DEBUG] allowing request from %s via %s", ip.String(), block.String())
-				// specific allow matched - allow this request
-				return false
+				// specific allow matched
+				allowed = true
+				break
 			}
 		}
-		// we checked all the blocks - deny this request
-		log.Printf("[INFO] route rules denied access from %s to %s",
-			ip.String(), t.URL.String())
-		return true
+		if !allowed {
+			// we checked all the blocks - deny this request
+			log.Printf("[INFO] route rules denied access from %s to %s",
+				ip.String(), t.URL.String())
+			return true
+		}
 	}
 
 	// still going - check deny (blacklist) if it exists
@@ -144,6 +246,79 @@ func (t *Target) denyByIP(ip net.IP) bool {
 	return false
 }
 
+// denyByCountry checks the country rules for the client address.
+func (t *Target) denyByCountry(ip net.IP) bool {
+	allow, hasAllow := t.accessRules[countryAllowTag]
+	deny, hasDeny := t.accessRules[countryDenyTag]
+	if !hasAllow && !hasDeny {
+		return false
+	}
+
+	if GeoIP == nil {
+		log.Printf("[ERROR] no geoip database for country rules of %s", t.Service)
+		return true
+	}
+	country, err := GeoIP.Country(ip)
+	if err != nil {
+		log.Printf("[ERROR] failed to lookup country of %s: %s", ip, err)
+		return true
+	}
+
+	contains := func(list []interface{}) bool {
+		for _, x := range list {
+			if x == country {
+				return true
+			}
+		}
+		return false
+	}
+
+	if hasAllow && !contains(allow) {
+		log.Printf("[INFO] route rules denied access from %s (country %q) to %s", ip, country, t.URL.String())
+		return true
+	}
+	if hasDeny && contains(deny) {
+		log.Printf("[INFO] route rules denied access from %s (country %q) to %s", ip, country, t.URL.String())
+		return true
+	}
+	return false
+}
+
+func (t *Target) denyByHeader(h http.Header) bool {
+	allow, hasAllow := t.accessRules[headerAllowTag]
+	deny, hasDeny := t.accessRules[headerDenyTag]
+
+	match := func(list []interface{}) (*headerRule, bool) {
+		for _, x := range list {
+			rule, ok := x.(*headerRule)
+			if !ok {
+				log.Printf("[ERROR] failed to assert header rule for %s", t.Service)
+				continue
+			}
+			for _, v := range h[rule.name] {
+				if rule.value.Match(v) {
+					return rule, true
+				}
+			}
+		}
+		return nil, false
+	}
+
+	if hasAllow {
+		if _, ok := match(allow); !ok {
+			log.Printf("[INFO] route rules denied access to %s since no header allow rule matched", t.URL.String())
+			return true
+		}
+	}
+	if hasDeny {
+		if rule, ok := match(deny); ok {
+			log.Printf("[INFO] route rules denied access to %s via header rule %s", t.URL.String(), rule.raw)
+			return true
+		}
+	}
+	return false
+}
+
 // ProcessAccessRules processes access rules from options specified on the target route
 func (t *Target) ProcessAccessRules() error {
 	if t.Opts["allow"] != "" && t.Opts["deny"] != "" {
@@ -164,7 +339,6 @@ func (t *Target) parseAccessRule(allowDeny string) error {
 	var accessTag string
 	var temps []string
 	var value string
-	var ip net.IP
 
 	// init rules if needed
 	if t.accessRules == nil {
@@ -179,27 +353,43 @@ func (t *Target) parseAccessRule(allowDeny string) error {
 
 		// form access type tag
 		accessTag = allowDeny + ":" + strings.ToLower(strings.TrimSpace(temps[0]))
+		value = strings.TrimSpace(temps[1])
 
-		// switch on formed access tag - currently only ip types are implemented
+		// switch on formed access tag
 		switch accessTag {
 		case ipAllowTag, ipDenyTag:
-			if value = strings.TrimSpace(temps[1]); !strings.Contains(value, "/") {
-				if ip = net.ParseIP(value); ip == nil {
-					return fmt.Errorf("failed to parse IP %s", value)
-				}
-				if ip.To4() != nil {
-					value = ip.String() + "/32"
-				} else {
-					value = ip.String() + "/128"
-				}
-			}
-			_, net, err := net.ParseCIDR(value)
+			n, err := ParseIPNet(value)
 			if err != nil {
-				return fmt.Errorf("failed to parse CIDR %s with error: %s",
-					c, err.Error())
+				return err
 			}
 			// add element to rule map
-			t.accessRules[accessTag] = append(t.accessRules[accessTag], net)
+			t.accessRules[accessTag] = append(t.accessRules[accessTag], n)
+		case "allow:ipgroup", "deny:ipgroup":
+			group, ok := IPGroups[value]
+			if !ok {
+				return fmt.Errorf("unknown ip group %s", value)
+			}
+			// ip groups are expanded into ip rules
+			accessTag = allowDeny + ":ip"
+			for _, n := range group {
+				t.accessRules[accessTag] = append(t.accessRules[accessTag], n)
+			}
+		case countryAllowTag, countryDenyTag:
+			if len(value) != 2 {
+				return fmt.Errorf("invalid country code %s", value)
+			}
+			t.accessRules[accessTag] = append(t.accessRules[accessTag], strings.ToUpper(value))
+		case headerAllowTag, headerDenyTag:
+			p := strings.SplitN(value, "=", 2)
+			if len(p) != 2 || p[0] == "" {
+				return fmt.Errorf("invalid header rule %s, expected <name>=<value>", value)
+			}
+			g, err := glob.Compile(p[1])
+			if err != nil {
+				return fmt.Errorf("invalid header rule %s: %s", value, err)
+			}
+			rule := &headerRule{name: http.CanonicalHeaderKey(p[0]), value: g, raw: value}
+			t.accessRules[accessTag] = append(t.accessRules[accessTag], rule)
 		default:
 			return fmt.Errorf("unknown access item type: %s", temps[0])
 		}
@@ -207,3 +397,24 @@ func (t *Target) parseAccessRule(allowDeny string) error {
 
 	return nil
 }
+
+// ParseIPNet parses a network in CIDR notation or a single address
+// which is treated as a /32 or /128 network.
+func ParseIPNet(value string) (*net.IPNet, error) {
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("failed to parse IP %s", value)
+		}
+		if ip.To4() != nil {
+			value = ip.String() + "/32"
+		} else {
+			value = ip.String() + "/128"
+		}
+	}
+	_, n, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CIDR %s with error: %s", value, err.Error())
+	}
+	return n, nil
+}
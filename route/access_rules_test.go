@@ -46,6 +46,25 @@ func TestAccessRules_parseAccessRule(t *testing.T) {
 			allowDeny: "ip:fe80::1",
 			fail:      false,
 		},
+		{
+			desc:      "unknown ip group",
+			allowDeny: "ipgroup:unknown",
+			fail:      true,
+		},
+		{
+			desc:      "valid country and header rules",
+			allowDeny: "country:DE,country:at,header:X-Tenant=acme-*",
+		},
+		{
+			desc:      "invalid country code",
+			allowDeny: "country:DEU",
+			fail:      true,
+		},
+		{
+			desc:      "header rule without value",
+			allowDeny: "header:X-Tenant",
+			fail:      true,
+		},
 	}
 
 	for i, tt := range tests {
@@ -231,3 +250,134 @@ func TestAccessRules_AccessDeniedHTTP(t *testing.T) {
 		})
 	}
 }
+
+type countryMap map[string]string
+
+func (m countryMap) Country(ip net.IP) (string, error) {
+	return m[ip.String()], nil
+}
+
+func TestAccessRules_AccessDeniedHTTP_rules(t *testing.T) {
+	defer func(groups map[string][]*net.IPNet, proxies []*net.IPNet, geoip CountryLookup) {
+		IPGroups, TrustedProxies, GeoIP = groups, proxies, geoip
+	}(IPGroups, TrustedProxies, GeoIP)
+
+	mustParseIPNet := func(s string) *net.IPNet {
+		n, err := ParseIPNet(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	IPGroups = map[string][]*net.IPNet{
+		"office": {mustParseIPNet("10.0.0.0/8"), mustParseIPNet("192.168.1.1")},
+	}
+	GeoIP = countryMap{"1.1.1.1": "DE", "2.2.2.2": "US"}
+
+	tests := []struct {
+		desc    string
+		opts    map[string]string
+		proxies []*net.IPNet
+		header  http.Header
+		remote  string
+		denied  bool
+	}{
+		{
+			desc:   "allowed by ip group",
+			opts:   map[string]string{"allow": "ipgroup:office"},
+			remote: "192.168.1.1:1234",
+		},
+		{
+			desc:   "denied by ip group",
+			opts:   map[string]string{"allow": "ipgroup:office"},
+			remote: "192.168.1.2:1234",
+			denied: true,
+		},
+		{
+			desc:   "allowed by country",
+			opts:   map[string]string{"allow": "country:de"},
+			remote: "1.1.1.1:1234",
+		},
+		{
+			desc:   "denied by country",
+			opts:   map[string]string{"deny": "country:US"},
+			remote: "2.2.2.2:1234",
+			denied: true,
+		},
+		{
+			desc:   "allowed by ip and header",
+			opts:   map[string]string{"allow": "ip:1.1.1.1,header:X-Tenant=acme-*"},
+			header: http.Header{"X-Tenant": {"acme-prod"}},
+			remote: "1.1.1.1:1234",
+		},
+		{
+			desc:   "denied by missing header",
+			opts:   map[string]string{"allow": "ip:1.1.1.1,header:X-Tenant=acme-*"},
+			remote: "1.1.1.1:1234",
+			denied: true,
+		},
+		{
+			desc:   "denied by header",
+			opts:   map[string]string{"deny": "header:User-Agent=*bot*"},
+			header: http.Header{"User-Agent": {"somebot/1.0"}},
+			remote: "1.1.1.1:1234",
+			denied: true,
+		},
+		{
+			desc:   "country rules ignore xff without trusted proxies",
+			opts:   map[string]string{"allow": "country:DE"},
+			header: http.Header{"X-Forwarded-For": {"10.0.0.2"}},
+			remote: "1.1.1.1:1234",
+		},
+		{
+			desc:   "ip rules check xff without trusted proxies",
+			opts:   map[string]string{"deny": "ip:10.0.0.0/8"},
+			header: http.Header{"X-Forwarded-For": {"10.0.0.2"}},
+			remote: "1.1.1.1:1234",
+			denied: true,
+		},
+		{
+			desc:    "trusted proxy validates client address only",
+			opts:    map[string]string{"allow": "country:DE"},
+			proxies: []*net.IPNet{mustParseIPNet("10.0.0.0/8")},
+			header:  http.Header{"X-Forwarded-For": {"2.2.2.2, 1.1.1.1, 10.0.0.2"}},
+			remote:  "10.0.0.1:1234",
+		},
+		{
+			desc:    "trusted proxy denies client address",
+			opts:    map[string]string{"allow": "country:DE"},
+			proxies: []*net.IPNet{mustParseIPNet("10.0.0.0/8")},
+			header:  http.Header{"X-Forwarded-For": {"1.1.1.1, 2.2.2.2"}},
+			remote:  "10.0.0.1:1234",
+			denied:  true,
+		},
+		{
+			desc:    "untrusted remote address ignores xff",
+			opts:    map[string]string{"allow": "country:DE"},
+			proxies: []*net.IPNet{mustParseIPNet("10.0.0.0/8")},
+			header:  http.Header{"X-Forwarded-For": {"1.1.1.1"}},
+			remote:  "2.2.2.2:1234",
+			denied:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt // capture loop var
+		t.Run(tt.desc, func(t *testing.T) {
+			TrustedProxies = tt.proxies
+			tgt := &Target{Opts: tt.opts, URL: mustParse("http://testing.test/")}
+			if err := tgt.ProcessAccessRules(); err != nil {
+				t.Fatalf("failed to process access rules: %s", err)
+			}
+			req, _ := http.NewRequest("GET", "http://example.com/", nil)
+			req.Header = tt.header
+			if req.Header == nil {
+				req.Header = http.Header{}
+			}
+			req.RemoteAddr = tt.remote
+			if got, want := tgt.AccessDeniedHTTP(req), tt.denied; got != want {
+				t.Fatalf("got denied %v want %v", got, want)
+			}
+		})
+	}
+}
@@ -19,5 +19,14 @@ func (t *Target) Authorized(r *http.Request, w http.ResponseWriter, authSchemes
 		return false
 	}
 
-	return scheme.Authorized(r, w)
+	if len(t.AuthClaims) == 0 {
+		return scheme.Authorized(r, w)
+	}
+
+	cs, ok := scheme.(auth.ClaimsAuthScheme)
+	if !ok {
+		log.Printf("[ERROR] auth scheme '%s' does not support claims\n", t.AuthScheme)
+		return false
+	}
+	return cs.AuthorizedClaims(r, w, t.AuthClaims)
 }
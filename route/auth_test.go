@@ -16,6 +16,18 @@ func (t *testAuth) Authorized(r *http.Request, w http.ResponseWriter) bool {
 	return t.ok
 }
 
+type testClaimsAuth struct {
+	claims map[string]string
+}
+
+func (t *testClaimsAuth) Authorized(r *http.Request, w http.ResponseWriter) bool {
+	return true
+}
+
+func (t *testClaimsAuth) AuthorizedClaims(r *http.Request, w http.ResponseWriter, claims map[string]string) bool {
+	return reflect.DeepEqual(t.claims, claims)
+}
+
 type responseWriter struct {
 	header  http.Header
 	code    int
@@ -39,6 +51,7 @@ func TestTarget_Authorized(t *testing.T) {
 	tests := []struct {
 		name        string
 		authScheme  string
+		authClaims  map[string]string
 		authSchemes map[string]auth.AuthScheme
 		out         bool
 	}{
@@ -66,12 +79,31 @@ func TestTarget_Authorized(t *testing.T) {
 			},
 			out: false,
 		},
+		{
+			name:       "passes claims to claims auth scheme",
+			authScheme: "myjwt",
+			authClaims: map[string]string{"role": "admin"},
+			authSchemes: map[string]auth.AuthScheme{
+				"myjwt": &testClaimsAuth{claims: map[string]string{"role": "admin"}},
+			},
+			out: true,
+		},
+		{
+			name:       "returns false when scheme does not support claims",
+			authScheme: "mybasic",
+			authClaims: map[string]string{"role": "admin"},
+			authSchemes: map[string]auth.AuthScheme{
+				"mybasic": &testAuth{ok: true},
+			},
+			out: false,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			target := &Target{
 				AuthScheme: tt.authScheme,
+				AuthClaims: tt.authClaims,
 			}
 
 			if got, want := target.Authorized(&http.Request{}, &responseWriter{}, tt.authSchemes), tt.out; !reflect.DeepEqual(got, want) {
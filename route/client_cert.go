@@ -0,0 +1,130 @@
+package route
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+// clientCertRules contains the client certificate requirements
+// of a target.
+type clientCertRules struct {
+	// subjects contains the patterns for the common name.
+	subjects []glob.Glob
+
+	// sans contains the patterns for the DNS, email, URI and IP
+	// subject alternative names.
+	sans []glob.Glob
+
+	// invalid denies all requests if the rules could not be parsed.
+	invalid bool
+}
+
+// processClientCertRules parses the 'clientcert', 'clientcert.san'
+// and 'clientcert.subject' options of the target. A SAN or subject
+// pattern implies 'clientcert=required'. Invalid options deny all
+// requests to the target.
+func (t *Target) processClientCertRules() error {
+	rules, err := parseClientCertRules(t.Opts)
+	if err != nil {
+		t.ClientCertRequired = true
+		t.clientCertRules = &clientCertRules{invalid: true}
+		return err
+	}
+	t.ClientCertRequired = t.Opts["clientcert"] == "required" || rules != nil
+	t.clientCertRules = rules
+	return nil
+}
+
+func parseClientCertRules(opts map[string]string) (*clientCertRules, error) {
+	switch v := opts["clientcert"]; v {
+	case "", "required":
+		// ok
+	default:
+		return nil, fmt.Errorf("invalid value %q for clientcert", v)
+	}
+
+	var rules clientCertRules
+	var err error
+	if rules.subjects, err = parseGlobs(opts["clientcert.subject"]); err != nil {
+		return nil, err
+	}
+	if rules.sans, err = parseGlobs(opts["clientcert.san"]); err != nil {
+		return nil, err
+	}
+	if len(rules.subjects) == 0 && len(rules.sans) == 0 {
+		return nil, nil
+	}
+	return &rules, nil
+}
+
+func parseGlobs(s string) ([]glob.Glob, error) {
+	var globs []glob.Glob
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		g, err := glob.Compile(p, '.')
+		if err != nil {
+			return nil, fmt.Errorf("invalid client cert pattern %q: %s", p, err)
+		}
+		globs = append(globs, g)
+	}
+	return globs, nil
+}
+
+// ClientCertAllowed returns true if the request satisfies the client
+// certificate requirements of the target. Only client certificates
+// which have been verified by the listener are considered.
+func (t *Target) ClientCertAllowed(r *http.Request) bool {
+	if !t.ClientCertRequired {
+		return true
+	}
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.PeerCertificates) == 0 {
+		return false
+	}
+	if t.clientCertRules == nil {
+		return true
+	}
+	return t.clientCertRules.match(r.TLS.PeerCertificates[0])
+}
+
+// match returns true if either no subject patterns are configured or
+// the common name matches one of them, and if either no SAN patterns
+// are configured or one of the SANs matches one of them.
+func (rules *clientCertRules) match(cert *x509.Certificate) bool {
+	if rules.invalid {
+		return false
+	}
+	if len(rules.subjects) > 0 && !matchAny(rules.subjects, cert.Subject.CommonName) {
+		return false
+	}
+	if len(rules.sans) == 0 {
+		return true
+	}
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, u := range cert.URIs {
+		sans = append(sans, u.String())
+	}
+	return matchAny(rules.sans, sans...)
+}
+
+func matchAny(globs []glob.Glob, values ...string) bool {
+	for _, v := range values {
+		for _, g := range globs {
+			if g.Match(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package route
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestTarget_ClientCertAllowed(t *testing.T) {
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "svc-a"},
+		DNSNames: []string{"svc-a.internal.example.com"},
+		URIs:     []*url.URL{{Scheme: "spiffe", Host: "example.com", Path: "/svc-a"}},
+	}
+	verified := &http.Request{TLS: &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert}},
+	}}
+	unverified := &http.Request{TLS: &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+	}}
+	plain := &http.Request{}
+
+	tests := []struct {
+		desc  string
+		opts  map[string]string
+		req   *http.Request
+		allow bool
+		fail  bool
+	}{
+		{"no options", nil, plain, true, false},
+		{"required without cert", map[string]string{"clientcert": "required"}, plain, false, false},
+		{"required with unverified cert", map[string]string{"clientcert": "required"}, unverified, false, false},
+		{"required with verified cert", map[string]string{"clientcert": "required"}, verified, true, false},
+		{"san match", map[string]string{"clientcert.san": "*.internal.example.com"}, verified, true, false},
+		{"san match uri", map[string]string{"clientcert.san": "foo,spiffe://example.com/*"}, verified, true, false},
+		{"san no match", map[string]string{"clientcert.san": "*.example.com"}, verified, false, false},
+		{"san without cert", map[string]string{"clientcert.san": "*.internal.example.com"}, plain, false, false},
+		{"subject match", map[string]string{"clientcert.subject": "svc-*"}, verified, true, false},
+		{"subject no match", map[string]string{"clientcert.subject": "svc-b"}, verified, false, false},
+		{"subject and san", map[string]string{"clientcert.subject": "svc-a", "clientcert.san": "*.example.org"}, verified, false, false},
+		{"invalid value", map[string]string{"clientcert": "yes"}, verified, false, true},
+		{"invalid pattern", map[string]string{"clientcert.san": "[a"}, verified, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			tgt := &Target{Opts: tt.opts}
+			err := tgt.processClientCertRules()
+			if got, want := err != nil, tt.fail; got != want {
+				t.Fatalf("got error %v want error %v", err, want)
+			}
+			if got, want := tgt.ClientCertAllowed(tt.req), tt.allow; got != want {
+				t.Fatalf("got %v want %v", got, want)
+			}
+		})
+	}
+}
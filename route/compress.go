@@ -0,0 +1,51 @@
+package route
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// compressEncodings contains the encodings
+// which are valid for the 'compress' option.
+var compressEncodings = map[string]bool{"br": true, "zstd": true, "gzip": true}
+
+// processCompressOpts parses the 'compress' and 'compress.level'
+// options of the target. 'compress' is either 'on', 'off' or a
+// comma separated list of encodings which enables compression
+// with these encodings in the order of preference.
+func (t *Target) processCompressOpts() error {
+	if v, ok := t.Opts["compress"]; ok {
+		on, encodings, err := parseCompress(v)
+		if err != nil {
+			return err
+		}
+		t.Compress, t.CompressEncodings = &on, encodings
+	}
+
+	if v, ok := t.Opts["compress.level"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid value %q for compress.level", v)
+		}
+		t.CompressLevel = n
+	}
+	return nil
+}
+
+func parseCompress(s string) (on bool, encodings []string, err error) {
+	switch s {
+	case "on", "true":
+		return true, nil, nil
+	case "off", "false":
+		return false, nil, nil
+	}
+	for _, enc := range strings.Split(s, ",") {
+		enc = strings.TrimSpace(enc)
+		if !compressEncodings[enc] {
+			return false, nil, fmt.Errorf("invalid encoding %q for compress", enc)
+		}
+		encodings = append(encodings, enc)
+	}
+	return true, encodings, nil
+}
@@ -0,0 +1,46 @@
+package route
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTarget_ProcessCompressOpts(t *testing.T) {
+	on, off := true, false
+	tests := []struct {
+		desc      string
+		opts      map[string]string
+		compress  *bool
+		encodings []string
+		level     int
+		fail      bool
+	}{
+		{"no options", nil, nil, nil, 0, false},
+		{"on", map[string]string{"compress": "on"}, &on, nil, 0, false},
+		{"off", map[string]string{"compress": "off"}, &off, nil, 0, false},
+		{"encodings", map[string]string{"compress": "gzip,br"}, &on, []string{"gzip", "br"}, 0, false},
+		{"level", map[string]string{"compress.level": "9"}, nil, nil, 9, false},
+		{"invalid encoding", map[string]string{"compress": "gzip,deflate"}, nil, nil, 0, true},
+		{"invalid level", map[string]string{"compress.level": "max"}, nil, nil, 0, true},
+	}
+
+	for _, tt := range tests {
+		tt := tt // capture loop var
+		t.Run(tt.desc, func(t *testing.T) {
+			target := &Target{Opts: tt.opts}
+			err := target.processCompressOpts()
+			if got, want := err != nil, tt.fail; got != want {
+				t.Fatalf("got error %v want error %v", err, want)
+			}
+			if got, want := target.Compress, tt.compress; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got compress %v want %v", got, want)
+			}
+			if got, want := target.CompressEncodings, tt.encodings; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got encodings %v want %v", got, want)
+			}
+			if got, want := target.CompressLevel, tt.level; got != want {
+				t.Fatalf("got level %d want %d", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package route
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+
+	"github.com/fabiolb/fabio/auth"
+)
+
+// Explanation describes how the routing table handles a request.
+type Explanation struct {
+	// Host and Path are the host and path of the request.
+	Host string `json:"host"`
+	Path string `json:"path"`
+
+	// Strategy and Matcher are the names of the picker
+	// and matcher which were used for the lookup.
+	Strategy string `json:"strategy"`
+	Matcher  string `json:"matcher"`
+
+	// Hosts contains the host patterns of the table which match the
+	// request host in the order in which they are considered. The
+	// routes without a host are considered last.
+	Hosts []string `json:"hosts"`
+
+	// Routes contains the candidate routes in the order in which
+	// they were considered.
+	Routes []RouteDecision `json:"routes"`
+
+	// Route is the matched route.
+	Route string `json:"route,omitempty"`
+
+	// Targets contains the targets of the matched route.
+	Targets []TargetInfo `json:"targets,omitempty"`
+
+	// Target is the target which would be picked.
+	Target *TargetInfo `json:"target,omitempty"`
+
+	// Redirect contains the status code and the url
+	// if the target redirects the request.
+	Redirect string `json:"redirect,omitempty"`
+
+	// Access, ClientCert and Auth contain the decisions of the access
+	// rules, the client certificate requirements and the auth scheme
+	// of the target.
+	Access     *Decision `json:"access,omitempty"`
+	ClientCert *Decision `json:"clientcert,omitempty"`
+	Auth       *Decision `json:"auth,omitempty"`
+}
+
+// RouteDecision describes whether a route matched the request.
+type RouteDecision struct {
+	Route  string `json:"route"`
+	Match  bool   `json:"match"`
+	Reason string `json:"reason"`
+}
+
+// TargetInfo describes a target of a route.
+type TargetInfo struct {
+	Service     string            `json:"service"`
+	URL         string            `json:"url"`
+	Tags        []string          `json:"tags,omitempty"`
+	Opts        map[string]string `json:"opts,omitempty"`
+	FixedWeight float64           `json:"fixed_weight"`
+	Weight      float64           `json:"weight"`
+
+	// Slots is the number of entries of the target in the
+	// weighted list from which the picker selects the target.
+	Slots int `json:"slots"`
+}
+
+// Decision describes whether a request is allowed.
+type Decision struct {
+	Allowed bool   `json:"allowed"`
+	Skipped bool   `json:"skipped,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+// Explain looks up the target for the request with the same logic as
+// Lookup and records which routes were considered and why, the target
+// which would be picked and the decisions of the access rules, the
+// client certificate requirements and the auth scheme. The auth scheme
+// is not evaluated but reported as unknown if authSchemes is not nil
+// and does not contain it. Explain does not advance the round-robin
+// picker and does not update the metrics.
+func (t Table) Explain(req *http.Request, strategy, matcher string, globCache *GlobCache, globDisabled bool, authSchemes map[string]auth.AuthScheme) (*Explanation, error) {
+	pick := Picker[strategy]
+	if pick == nil {
+		return nil, fmt.Errorf("route: invalid strategy %q", strategy)
+	}
+	if strategy == "rr" {
+		pick = rrPeek
+	}
+	match := Matcher[matcher]
+	if match == nil {
+		return nil, fmt.Errorf("route: invalid matcher %q", matcher)
+	}
+	if globCache == nil {
+		globCache = NewGlobCache(100)
+	}
+
+	ex := &Explanation{
+		Host:     req.Host,
+		Path:     req.URL.Path,
+		Strategy: strategy,
+		Matcher:  matcher,
+	}
+	target := t.find(req, "", pick, match, globCache, globDisabled, ex)
+	if target == nil {
+		return ex, nil
+	}
+
+	info := targetInfo(target, 0)
+	for _, ti := range ex.Targets {
+		if ti.Service == info.Service && ti.URL == info.URL {
+			info.Slots = ti.Slots
+		}
+	}
+	ex.Target = &info
+
+	if target.RedirectCode != 0 && target.RedirectURL != nil {
+		ex.Redirect = fmt.Sprintf("%d %s", target.RedirectCode, target.RedirectURL)
+	}
+
+	switch {
+	case len(target.accessRules) == 0:
+		ex.Access = &Decision{Allowed: true, Reason: "no access rules"}
+	case target.accessDeniedHTTP(req):
+		ex.Access = &Decision{Allowed: false, Reason: "denied by " + accessRuleOpts(target)}
+	default:
+		ex.Access = &Decision{Allowed: true, Reason: "allowed by " + accessRuleOpts(target)}
+	}
+
+	switch {
+	case !target.ClientCertRequired:
+		ex.ClientCert = &Decision{Allowed: true, Reason: "no client certificate required"}
+	case target.ClientCertAllowed(req):
+		ex.ClientCert = &Decision{Allowed: true, Reason: "client certificate accepted"}
+	default:
+		ex.ClientCert = &Decision{Allowed: false, Reason: "no matching verified client certificate"}
+	}
+
+	// auth schemes are not evaluated since they may consume rate
+	// limits, modify the request or send requests to other services.
+	switch {
+	case target.AuthScheme == "":
+		ex.Auth = &Decision{Allowed: true, Reason: "no auth scheme"}
+	case authSchemes != nil && authSchemes[target.AuthScheme] == nil:
+		ex.Auth = &Decision{Allowed: false, Reason: fmt.Sprintf("unknown auth scheme %q", target.AuthScheme)}
+	default:
+		ex.Auth = &Decision{Skipped: true, Reason: fmt.Sprintf("auth scheme %q not evaluated", target.AuthScheme)}
+	}
+
+	return ex, nil
+}
+
+// NewExplainRequest creates the request for Explain from an absolute
+// url, the request headers and the client address. The request has a
+// TLS connection state if the scheme of the url is https.
+func NewExplainRequest(rawurl string, header http.Header, remoteAddr string) (*http.Request, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("route: invalid url %q. %s", rawurl, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("route: missing host in url %q", rawurl)
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	if header == nil {
+		header = http.Header{}
+	}
+	if _, _, err := net.SplitHostPort(remoteAddr); err != nil {
+		remoteAddr = net.JoinHostPort(remoteAddr, "0")
+	}
+	req := &http.Request{
+		Method:     "GET",
+		URL:        &url.URL{Path: u.Path, RawPath: u.RawPath, RawQuery: u.RawQuery},
+		Host:       u.Host,
+		Header:     header,
+		RemoteAddr: remoteAddr,
+	}
+	if u.Scheme == "https" {
+		req.TLS = &tls.ConnectionState{ServerName: u.Hostname()}
+	}
+	return req, nil
+}
+
+// candidate records the decision for route r.
+func (ex *Explanation) candidate(r *Route, match bool, reason string) {
+	ex.Routes = append(ex.Routes, RouteDecision{Route: r.Host + r.Path, Match: match, Reason: reason})
+}
+
+// matched records the targets of the matched route r.
+func (ex *Explanation) matched(r *Route) {
+	slots := map[*Target]int{}
+	for _, t := range r.wTargets {
+		slots[t]++
+	}
+	ex.Route = r.Host + r.Path
+	ex.Targets = nil
+	for _, t := range r.Targets {
+		ex.Targets = append(ex.Targets, targetInfo(t, slots[t]))
+	}
+}
+
+// skip records that the last matched route was skipped.
+func (ex *Explanation) skip(reason string) {
+	if n := len(ex.Routes); n > 0 {
+		ex.Routes[n-1].Match = false
+		ex.Routes[n-1].Reason = reason
+	}
+	ex.Route, ex.Targets = "", nil
+}
+
+func targetInfo(t *Target, slots int) TargetInfo {
+	ti := TargetInfo{
+		Service:     t.Service,
+		Tags:        t.Tags,
+		Opts:        t.Opts,
+		FixedWeight: t.FixedWeight,
+		Weight:      t.Weight,
+		Slots:       slots,
+	}
+	if t.URL != nil {
+		ti.URL = t.URL.String()
+	}
+	return ti
+}
+
+// accessRuleOpts returns the allow and deny options of the target.
+func accessRuleOpts(t *Target) string {
+	if v, ok := t.Opts["allow"]; ok {
+		return "allow=" + v
+	}
+	return "deny=" + t.Opts["deny"]
+}
+
+// rrPeek returns the target which the round-robin picker
+// would pick next without advancing it.
+func rrPeek(r *Route) *Target {
+	return r.wTargets[atomic.LoadUint64(&r.total)%uint64(len(r.wTargets))]
+}
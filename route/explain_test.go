@@ -0,0 +1,121 @@
+package route
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/fabiolb/fabio/auth"
+)
+
+// headerAuth authorizes requests with the header 'X-Token: ok'.
+type headerAuth struct{}
+
+func (headerAuth) Authorized(r *http.Request, w http.ResponseWriter) bool {
+	return r.Header.Get("X-Token") == "ok"
+}
+
+func TestExplain(t *testing.T) {
+	s := `
+	route add svc-a a.com/foo http://127.0.0.1:3000/ weight 0.25
+	route add svc-a a.com/foo http://127.0.0.1:3001/
+	route add svc-b a.com/ http://127.0.0.1:3002/ opts "deny=ip:10.0.0.0/8"
+	route add svc-c /admin http://127.0.0.1:3003/ opts "auth=token"
+	`
+	tbl, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemes := map[string]auth.AuthScheme{"token": headerAuth{}}
+
+	explain := func(url string, header http.Header, remote string, schemes map[string]auth.AuthScheme) *Explanation {
+		t.Helper()
+		req, err := NewExplainRequest(url, header, remote)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ex, err := tbl.Explain(req, "rr", "prefix", nil, false, schemes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ex
+	}
+
+	t.Run("weighted targets", func(t *testing.T) {
+		ex := explain("http://a.com/foo/bar", nil, "1.2.3.4", nil)
+		if got, want := ex.Routes, []RouteDecision{{"a.com/foo", true, "path matches"}}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got routes %v want %v", got, want)
+		}
+		if got, want := ex.Route, "a.com/foo"; got != want {
+			t.Fatalf("got route %q want %q", got, want)
+		}
+		var slots []int
+		for _, ti := range ex.Targets {
+			slots = append(slots, ti.Slots)
+		}
+		if got, want := slots, []int{2500, 7500}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got slots %v want %v", got, want)
+		}
+
+		// explain does not advance the round-robin picker
+		ex2 := explain("http://a.com/foo/bar", nil, "1.2.3.4", nil)
+		if ex.Target.URL != ex2.Target.URL {
+			t.Fatalf("got targets %s and %s want the same", ex.Target.URL, ex2.Target.URL)
+		}
+	})
+
+	t.Run("access denied", func(t *testing.T) {
+		ex := explain("http://a.com/bar", nil, "10.1.2.3", nil)
+		if got, want := ex.Hosts, []string{"a.com"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got hosts %v want %v", got, want)
+		}
+		want := []RouteDecision{
+			{"a.com/foo", false, "path does not match"},
+			{"a.com/", true, "path matches"},
+		}
+		if got := ex.Routes; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got routes %v want %v", got, want)
+		}
+		if got, want := *ex.Access, (Decision{Allowed: false, Reason: "denied by deny=ip:10.0.0.0/8"}); got != want {
+			t.Fatalf("got access %v want %v", got, want)
+		}
+	})
+
+	t.Run("fallback and auth", func(t *testing.T) {
+		ex := explain("https://b.com/admin", http.Header{"X-Token": {"ok"}}, "1.2.3.4", schemes)
+		if got, want := ex.Route, "/admin"; got != want {
+			t.Fatalf("got route %q want %q", got, want)
+		}
+		if got, want := *ex.Auth, (Decision{Skipped: true, Reason: `auth scheme "token" not evaluated`}); got != want {
+			t.Fatalf("got auth %v want %v", got, want)
+		}
+
+		ex = explain("https://b.com/admin", nil, "1.2.3.4", map[string]auth.AuthScheme{})
+		if got, want := *ex.Auth, (Decision{Allowed: false, Reason: `unknown auth scheme "token"`}); got != want {
+			t.Fatalf("got auth %v want %v", got, want)
+		}
+
+		ex = explain("https://b.com/admin", nil, "1.2.3.4", nil)
+		if got, want := *ex.Auth, (Decision{Skipped: true, Reason: `auth scheme "token" not evaluated`}); got != want {
+			t.Fatalf("got auth %v want %v", got, want)
+		}
+	})
+
+	t.Run("no route", func(t *testing.T) {
+		ex := explain("http://b.com/foo", nil, "1.2.3.4", nil)
+		if ex.Target != nil || ex.Route != "" {
+			t.Fatalf("got target %v route %q want none", ex.Target, ex.Route)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		if _, err := NewExplainRequest("/foo", nil, ""); err == nil {
+			t.Fatal("got nil want error for missing host")
+		}
+		req, _ := NewExplainRequest("http://a.com/", nil, "")
+		if _, err := tbl.Explain(req, "foo", "prefix", nil, false, nil); err == nil {
+			t.Fatal("got nil want error for invalid strategy")
+		}
+	})
+}
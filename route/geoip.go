@@ -0,0 +1,46 @@
+package route
+
+import (
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// CountryLookup returns the ISO 3166-1 country code of an address.
+type CountryLookup interface {
+	Country(ip net.IP) (string, error)
+}
+
+// GeoIPDB is a CountryLookup backed by a MaxMind DB file in the
+// GeoIP2/GeoLite2 Country or City format.
+type GeoIPDB struct {
+	r *maxminddb.Reader
+}
+
+// OpenGeoIPDB opens the MaxMind DB file at path.
+func OpenGeoIPDB(path string) (*GeoIPDB, error) {
+	r, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &GeoIPDB{r}, nil
+}
+
+// Country returns the country code for the address or an empty
+// string if the address is not in the database.
+func (db *GeoIPDB) Country(ip net.IP) (string, error) {
+	var rec struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := db.r.Lookup(ip, &rec); err != nil {
+		return "", err
+	}
+	return rec.Country.ISOCode, nil
+}
+
+// Close closes the database file.
+func (db *GeoIPDB) Close() error {
+	return db.r.Close()
+}
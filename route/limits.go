@@ -0,0 +1,98 @@
+package route
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sizeUnits contains the multipliers of the units
+// which are valid for the 'maxbody' option.
+var sizeUnits = []struct {
+	suffix string
+	n      int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// processLimitOpts parses the 'maxbody', 'timeout' and 'idletimeout'
+// options of the target and the 'ws.idletimeout', 'ws.maxlifetime'
+// and 'ws.maxframesize' options for websocket connections. Sizes are
+// in bytes with an optional unit of B, KB, MB or GB, e.g. '10MB'.
+// Timeouts are durations, e.g. '30s'.
+func (t *Target) processLimitOpts() error {
+	if v, ok := t.Opts["maxbody"]; ok {
+		n, err := parseSize(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid value %q for maxbody", v)
+		}
+		t.MaxBody = n
+	}
+
+	if v, ok := t.Opts["timeout"]; ok {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid value %q for timeout", v)
+		}
+		t.Timeout = d
+	}
+
+	if v, ok := t.Opts["idletimeout"]; ok {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid value %q for idletimeout", v)
+		}
+		t.IdleTimeout = d
+	}
+
+	if v, ok := t.Opts["ws.idletimeout"]; ok {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid value %q for ws.idletimeout", v)
+		}
+		t.WSIdleTimeout = d
+	}
+
+	if v, ok := t.Opts["ws.maxlifetime"]; ok {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid value %q for ws.maxlifetime", v)
+		}
+		t.WSMaxLifetime = d
+	}
+
+	if v, ok := t.Opts["ws.maxframesize"]; ok {
+		n, err := parseSize(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid value %q for ws.maxframesize", v)
+		}
+		t.WSMaxFrameSize = n
+	}
+	return nil
+}
+
+// parseSize parses a size with an optional unit, e.g. '512', '64KB'
+// or '10MB'. The units are case insensitive and multiples of 1024.
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	mult := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mult = strings.TrimSpace(s[:len(s)-len(u.suffix)]), u.n
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n > math.MaxInt64/mult || n < math.MinInt64/mult {
+		return 0, fmt.Errorf("size %q out of range", s)
+	}
+	return n * mult, nil
+}
@@ -0,0 +1,88 @@
+package route
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTarget_ProcessLimitOpts(t *testing.T) {
+	tests := []struct {
+		desc        string
+		opts        map[string]string
+		maxBody     int64
+		timeout     time.Duration
+		idleTimeout time.Duration
+		fail        bool
+	}{
+		{"no options", nil, 0, 0, 0, false},
+		{"maxbody bytes", map[string]string{"maxbody": "512"}, 512, 0, 0, false},
+		{"maxbody unit", map[string]string{"maxbody": "10MB"}, 10 << 20, 0, 0, false},
+		{"maxbody lower case unit", map[string]string{"maxbody": "64kb"}, 64 << 10, 0, 0, false},
+		{"timeout", map[string]string{"timeout": "30s"}, 0, 30 * time.Second, 0, false},
+		{"idletimeout", map[string]string{"idletimeout": "5s"}, 0, 0, 5 * time.Second, false},
+		{"all", map[string]string{"maxbody": "1GB", "timeout": "1m", "idletimeout": "10s"}, 1 << 30, time.Minute, 10 * time.Second, false},
+		{"invalid maxbody", map[string]string{"maxbody": "10XB"}, 0, 0, 0, true},
+		{"zero maxbody", map[string]string{"maxbody": "0"}, 0, 0, 0, true},
+		{"overflow maxbody", map[string]string{"maxbody": "9007199254740992GB"}, 0, 0, 0, true},
+		{"negative overflow maxbody", map[string]string{"maxbody": "-9007199254740992GB"}, 0, 0, 0, true},
+		{"invalid timeout", map[string]string{"timeout": "30"}, 0, 0, 0, true},
+		{"negative idletimeout", map[string]string{"idletimeout": "-1s"}, 0, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		tt := tt // capture loop var
+		t.Run(tt.desc, func(t *testing.T) {
+			target := &Target{Opts: tt.opts}
+			err := target.processLimitOpts()
+			if got, want := err != nil, tt.fail; got != want {
+				t.Fatalf("got error %v want error %v", err, want)
+			}
+			if got, want := target.MaxBody, tt.maxBody; got != want {
+				t.Fatalf("got maxbody %d want %d", got, want)
+			}
+			if got, want := target.Timeout, tt.timeout; got != want {
+				t.Fatalf("got timeout %v want %v", got, want)
+			}
+			if got, want := target.IdleTimeout, tt.idleTimeout; got != want {
+				t.Fatalf("got idletimeout %v want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestTarget_ProcessLimitOpts_WebSocket(t *testing.T) {
+	tests := []struct {
+		desc         string
+		opts         map[string]string
+		idleTimeout  time.Duration
+		maxLifetime  time.Duration
+		maxFrameSize int64
+		fail         bool
+	}{
+		{"no options", nil, 0, 0, 0, false},
+		{"all", map[string]string{"ws.idletimeout": "5m", "ws.maxlifetime": "24h", "ws.maxframesize": "64KB"}, 5 * time.Minute, 24 * time.Hour, 64 << 10, false},
+		{"invalid idletimeout", map[string]string{"ws.idletimeout": "5"}, 0, 0, 0, true},
+		{"invalid maxlifetime", map[string]string{"ws.maxlifetime": "0s"}, 0, 0, 0, true},
+		{"invalid maxframesize", map[string]string{"ws.maxframesize": "-1"}, 0, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		tt := tt // capture loop var
+		t.Run(tt.desc, func(t *testing.T) {
+			target := &Target{Opts: tt.opts}
+			err := target.processLimitOpts()
+			if got, want := err != nil, tt.fail; got != want {
+				t.Fatalf("got error %v want error %v", err, want)
+			}
+			if got, want := target.WSIdleTimeout, tt.idleTimeout; got != want {
+				t.Fatalf("got ws.idletimeout %v want %v", got, want)
+			}
+			if got, want := target.WSMaxLifetime, tt.maxLifetime; got != want {
+				t.Fatalf("got ws.maxlifetime %v want %v", got, want)
+			}
+			if got, want := target.WSMaxFrameSize, tt.maxFrameSize; got != want {
+				t.Fatalf("got ws.maxframesize %d want %d", got, want)
+			}
+		})
+	}
+}
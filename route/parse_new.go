@@ -28,10 +28,18 @@ route add <svc> <src> <dst>[ weight <w>][ tags "<t1>,<t2>,..."][ opts "k1=v1 k2=
 	  strip=/path        : forward '/path/to/file' as '/to/file'
 	  proto=tcp          : upstream service is TCP, dst is ':port'
 	  proto=https        : upstream service is HTTPS
+	  proto=h2           : upstream service is HTTPS with HTTP/2
+	  proto=h2c          : upstream service is HTTP/2 without TLS
 	  tlsskipverify=true : disable TLS cert validation for HTTPS upstream
+	  tlsclientcert=cs   : present the client certificate from cert source 'cs' (defined in proxy.cs) to the upstream
+	  tlsca=cs           : verify the upstream certificate with the CA bundle from cert source 'cs' (defined in proxy.cs)
 	  host=name          : set the Host header to 'name'. If 'name == "dst"' then the 'Host' header will be set to the registered upstream host name
 	  register=name      : register fabio as new service 'name'. Useful for registering hostnames for host specific routes.
       auth=name          : name of the auth scheme to use (defined in proxy.auth)
+	  auth.claims.c=v    : require claim 'c' to have the value 'v' (comma separated alternatives)
+	  clientcert=required     : require a verified client certificate
+	  clientcert.san=pattern  : require a client certificate with a SAN matching the glob pattern
+	  clientcert.subject=pattern : require a client certificate with a common name matching the glob pattern
 
 route del <svc>[ <src>[ <dst>]]
   - Remove route matching svc, src and/or dst
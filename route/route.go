@@ -8,7 +8,9 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/fabiolb/fabio/logger"
 	"github.com/fabiolb/fabio/metrics"
 	"github.com/gobwas/glob"
 )
@@ -60,19 +62,25 @@ func (r *Route) addTarget(service string, targetURL *url.URL, fixedWeight float6
 		name = "unknown"
 	}
 
+	labels := metrics.TargetLabels(service, r.Host, r.Path, targetURL)
+
 	t := &Target{
-		Service:     service,
-		Tags:        tags,
-		Opts:        opts,
-		URL:         targetURL,
-		FixedWeight: fixedWeight,
-		Timer:       ServiceRegistry.GetTimer(name),
-		TimerName:   name,
+		Service:      service,
+		Route:        r.Host + r.Path,
+		Tags:         tags,
+		Opts:         opts,
+		URL:          targetURL,
+		FixedWeight:  fixedWeight,
+		Timer:        metrics.LabeledTimer(ServiceRegistry, name, "route_request_duration", labels...),
+		TimerName:    name,
+		MetricLabels: labels,
 	}
 
 	if opts != nil {
 		t.StripPath = opts["strip"]
 		t.TLSSkipVerify = opts["tlsskipverify"] == "true"
+		t.TLSClientCert = opts["tlsclientcert"]
+		t.TLSCA = opts["tlsca"]
 		t.Host = opts["host"]
 		t.ProxyProto = opts["pxyproto"] == "true"
 
@@ -86,12 +94,48 @@ func (r *Route) addTarget(service string, targetURL *url.URL, fixedWeight float6
 			}
 		}
 
+		if v, ok := opts["accesslog"]; ok {
+			t.AccessLog, err = logger.ParseSampleRules(v)
+			if err != nil {
+				log.Printf("[ERROR] invalid accesslog option: %s", err)
+			}
+		}
+
+		if v, ok := opts["cache"]; ok {
+			ttl, err := time.ParseDuration(v)
+			if err != nil || ttl < 0 {
+				log.Printf("[ERROR] invalid cache option: %q. Must be a duration", v)
+			} else {
+				t.Cache, t.CacheTTL = true, ttl
+			}
+		}
+
+		if err = t.processCompressOpts(); err != nil {
+			log.Printf("[ERROR] invalid compress option: %s", err)
+		}
+
+		if err = t.processLimitOpts(); err != nil {
+			log.Printf("[ERROR] invalid limit option: %s", err)
+		}
+
 		if err = t.ProcessAccessRules(); err != nil {
 			log.Printf("[ERROR] failed to process access rules: %s",
 				err.Error())
 		}
 
+		if err = t.processClientCertRules(); err != nil {
+			log.Printf("[ERROR] failed to process client cert rules: %s", err)
+		}
+
 		t.AuthScheme = opts["auth"]
+		for k, v := range opts {
+			if strings.HasPrefix(k, "auth.claims.") {
+				if t.AuthClaims == nil {
+					t.AuthClaims = map[string]string{}
+				}
+				t.AuthClaims[strings.TrimPrefix(k, "auth.claims.")] = v
+			}
+		}
 	}
 
 	r.Targets = append(r.Targets, t)
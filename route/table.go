@@ -408,6 +408,11 @@ func ReverseHostPort(s string) string {
 // and if none matches then it falls back to generic routes without
 // a host. This is useful for a catch-all '/' rule.
 func (t Table) Lookup(req *http.Request, trace string, pick picker, match matcher, globCache *GlobCache, globDisabled bool) (target *Target) {
+	return t.find(req, trace, pick, match, globCache, globDisabled, nil)
+}
+
+// find implements Lookup and records the decisions in ex if it is not nil.
+func (t Table) find(req *http.Request, trace string, pick picker, match matcher, globCache *GlobCache, globDisabled bool, ex *Explanation) (target *Target) {
 
 	var hosts []string
 	if trace != "" {
@@ -430,9 +435,12 @@ func (t Table) Lookup(req *http.Request, trace string, pick picker, match matche
 	if trace != "" {
 		log.Printf("[TRACE] %s Matching hosts: %v", trace, hosts)
 	}
+	if ex != nil {
+		ex.Hosts = append([]string{}, hosts...)
+	}
 	hosts = append(hosts, "")
 	for _, h := range hosts {
-		if target = t.lookup(h, req.URL.Path, trace, pick, match); target != nil {
+		if target = t.lookup(h, req.URL.Path, trace, pick, match, ex); target != nil {
 			if target.RedirectCode != 0 {
 				req.URL.Host = req.Host
 				target.BuildRedirectURL(req.URL) // build redirect url and cache in target
@@ -440,6 +448,9 @@ func (t Table) Lookup(req *http.Request, trace string, pick picker, match matche
 					target.RedirectURL.Host == req.Host &&
 					target.RedirectURL.Path == req.URL.Path {
 					log.Print("[INFO] Skipping redirect with same scheme, host and path")
+					if ex != nil {
+						ex.skip("redirect to the same scheme, host and path")
+					}
 					continue
 				}
 			}
@@ -455,17 +466,24 @@ func (t Table) Lookup(req *http.Request, trace string, pick picker, match matche
 }
 
 func (t Table) LookupHost(host string, pick picker) *Target {
-	return t.lookup(host, "/", "", pick, prefixMatcher)
+	return t.lookup(host, "/", "", pick, prefixMatcher, nil)
 }
 
-func (t Table) lookup(host, path, trace string, pick picker, match matcher) *Target {
+func (t Table) lookup(host, path, trace string, pick picker, match matcher, ex *Explanation) *Target {
 	host = strings.ToLower(host) // routes are always added lowercase
 	for _, r := range t[host] {
 		if match(path, r) {
 			n := len(r.Targets)
 			if n == 0 {
+				if ex != nil {
+					ex.candidate(r, false, "route has no targets")
+				}
 				return nil
 			}
+			if ex != nil {
+				ex.candidate(r, true, "path matches")
+				ex.matched(r)
+			}
 
 			var target *Target
 			if n == 1 {
@@ -481,6 +499,9 @@ func (t Table) lookup(host, path, trace string, pick picker, match matcher) *Tar
 		if trace != "" {
 			log.Printf("[TRACE] %s No match %s%s", trace, r.Host, r.Path)
 		}
+		if ex != nil {
+			ex.candidate(r, false, "path does not match")
+		}
 	}
 	return nil
 }
@@ -61,3 +61,8 @@ func (p *stubRegistry) GetTimer(name string) metrics.Timer {
 	p.names[name] = true
 	return metrics.NoopTimer{}
 }
+
+func (p *stubRegistry) GetGauge(name string) metrics.Gauge {
+	p.names[name] = true
+	return metrics.NoopGauge{}
+}
@@ -11,6 +11,9 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/logger"
 )
 
 const (
@@ -671,6 +674,73 @@ func TestTableLookup_656(t *testing.T) {
 	}
 }
 
+func TestTableLookup_AccessLog(t *testing.T) {
+	s := `
+	route add svc-a a.com/ http://127.0.0.1:3000/ opts "accesslog=5xx:1,*:0"
+	route add svc-b b.com/ http://127.0.0.1:3001/ opts "accesslog=foo"
+	route add svc-c c.com/ http://127.0.0.1:3002/
+	`
+
+	tbl, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host  string
+		rules logger.SampleRules
+	}{
+		{"a.com", logger.SampleRules{{Class: 5, Rate: 1}, {Rate: 0}}},
+		{"b.com", nil},
+		{"c.com", nil},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "http://"+tt.host+"/", nil)
+		target := tbl.Lookup(req, "", rrPicker, prefixMatcher, globCache, globDisabled)
+		if target == nil {
+			t.Fatalf("%s: no route match", tt.host)
+		}
+		if got, want := target.AccessLog, tt.rules; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v want %v", tt.host, got, want)
+		}
+	}
+}
+
+func TestTableLookup_Cache(t *testing.T) {
+	s := `
+	route add svc-a a.com/ http://127.0.0.1:3000/ opts "cache=60s"
+	route add svc-b b.com/ http://127.0.0.1:3001/ opts "cache=0s"
+	route add svc-c c.com/ http://127.0.0.1:3002/ opts "cache=foo"
+	route add svc-d d.com/ http://127.0.0.1:3003/
+	`
+
+	tbl, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host  string
+		cache bool
+		ttl   time.Duration
+	}{
+		{"a.com", true, time.Minute},
+		{"b.com", true, 0},
+		{"c.com", false, 0},
+		{"d.com", false, 0},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "http://"+tt.host+"/", nil)
+		target := tbl.Lookup(req, "", rrPicker, prefixMatcher, globCache, globDisabled)
+		if target == nil {
+			t.Fatalf("%s: no route match", tt.host)
+		}
+		if target.Cache != tt.cache || target.CacheTTL != tt.ttl {
+			t.Errorf("%s: got cache %v ttl %s want %v %s", tt.host, target.Cache, target.CacheTTL, tt.cache, tt.ttl)
+		}
+	}
+}
+
 func TestNewTableCustom(t *testing.T) {
 
 	var routes []RouteDef
@@ -3,7 +3,9 @@ package route
 import (
 	"net/url"
 	"strings"
+	"time"
 
+	"github.com/fabiolb/fabio/logger"
 	"github.com/fabiolb/fabio/metrics"
 )
 
@@ -11,6 +13,10 @@ type Target struct {
 	// Service is the name of the service the targetURL points to
 	Service string
 
+	// Route is the host and path of the route of the target,
+	// e.g. "example.com/foo".
+	Route string
+
 	// Tags are the list of tags for this target
 	Tags []string
 
@@ -25,6 +31,14 @@ type Target struct {
 	// TLS connections.
 	TLSSkipVerify bool
 
+	// TLSClientCert is the name of the cert source which provides
+	// the client certificate for upstream TLS connections.
+	TLSClientCert string
+
+	// TLSCA is the name of the cert source which provides the CA
+	// bundle for verifying the upstream server certificate.
+	TLSCA string
+
 	// Host signifies what the proxy will set the Host header to.
 	// The proxy does not modify the Host header by default.
 	// When Host is set to 'dst' the proxy will use the host name
@@ -55,14 +69,78 @@ type Target struct {
 	// TimerName is the name of the timer in the metrics registry
 	TimerName string
 
+	// MetricLabels contains the label names and values of the route
+	// metrics for metrics registries which support labels.
+	MetricLabels []string
+
 	// accessRules is map of access information for the target.
 	accessRules map[string][]interface{}
 
+	// ClientCertRequired requires a verified client certificate
+	// for requests to this target.
+	ClientCertRequired bool
+
+	// clientCertRules contains the subject and SAN patterns which
+	// the client certificate must match.
+	clientCertRules *clientCertRules
+
 	// name of the auth handler for this target
 	AuthScheme string
 
+	// AuthClaims contains the claims and their allowed values
+	// which the auth scheme must verify for this target.
+	AuthClaims map[string]string
+
 	// ProxyProto enables PROXY Protocol on upstream connection
 	ProxyProto bool
+
+	// AccessLog contains the sample rules of the access log for this
+	// target. If nil the default rules apply.
+	AccessLog logger.SampleRules
+
+	// Compress enables or disables the compression of the responses
+	// of this target. If nil the global configuration applies.
+	Compress *bool
+
+	// CompressEncodings contains the encodings for the responses of
+	// this target in the order of preference. If empty the global
+	// encodings apply.
+	CompressEncodings []string
+
+	// CompressLevel is the compression level for the responses of
+	// this target. If 0 the global level applies.
+	CompressLevel int
+
+	// Cache enables the response cache for this target.
+	Cache bool
+
+	// CacheTTL is the lifetime of the cached responses of this
+	// target without Cache-Control or Expires header.
+	CacheTTL time.Duration
+
+	// MaxBody is the maximum size of the request body in bytes.
+	// If 0 the size is not limited.
+	MaxBody int64
+
+	// Timeout is the maximum duration of the upstream request
+	// including the response body. If 0 there is no timeout.
+	Timeout time.Duration
+
+	// IdleTimeout is the maximum duration without data transfer
+	// between client and upstream. If 0 there is no timeout.
+	IdleTimeout time.Duration
+
+	// WSIdleTimeout is the maximum duration without frames on a
+	// websocket connection. If 0 the global timeout applies.
+	WSIdleTimeout time.Duration
+
+	// WSMaxLifetime is the maximum duration of a websocket
+	// connection. If 0 the global lifetime applies.
+	WSMaxLifetime time.Duration
+
+	// WSMaxFrameSize is the maximum payload size of a websocket
+	// frame in bytes. If 0 the global size applies.
+	WSMaxFrameSize int64
 }
 
 func (t *Target) BuildRedirectURL(requestURL *url.URL) {
@@ -1,111 +1,236 @@
+// Package trace provides OpenTelemetry tracing for the proxied requests.
+//
+// Spans are exported via OTLP over gRPC or HTTP, or to a Zipkin
+// collector. The trace context is propagated with the W3C
+// 'traceparent' and 'tracestate' headers and B3 headers are accepted
+// and forwarded as well.
 package trace
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
+	"net/url"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/fabiolb/fabio/config"
-	opentracing "github.com/opentracing/opentracing-go"
-	"github.com/opentracing/opentracing-go/ext"
-	zipkin "github.com/openzipkin-contrib/zipkin-go-opentracing"
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlphttp"
+	"go.opentelemetry.io/otel/exporters/trace/zipkin"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/propagation"
+	export "go.opentelemetry.io/otel/sdk/export/trace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
 )
 
-func InjectHeaders(span opentracing.Span, req *http.Request) {
-	// Inject span data into the request headers
-	opentracing.GlobalTracer().Inject(
-		span.Context(),
-		opentracing.HTTPHeaders,
-		opentracing.HTTPHeadersCarrier(req.Header),
-	)
-}
+// tracerName is the name of the instrumentation library.
+const tracerName = "github.com/fabiolb/fabio"
 
-func CreateCollector(collectorType, connectString, topic string) zipkin.Collector {
-	var collector zipkin.Collector
-	var err error
+// provider is the tracer provider created by InitializeTracer.
+var provider *sdktrace.TracerProvider
 
-	switch collectorType {
-	case "http":
-		collector, err = zipkin.NewHTTPCollector(connectString)
-	case "kafka":
-		// TODO set logger?
-		kafkaHosts := strings.Split(connectString, ",")
-		collector, err = zipkin.NewKafkaCollector(
-			kafkaHosts,
-			zipkin.KafkaTopic(topic),
-		)
-	default:
-		err = fmt.Errorf("Unknown collector type.")
+// propagator extracts the trace context from W3C and B3 headers and
+// injects both. W3C headers take precedence if both are present.
+var propagator = propagation.NewCompositeTextMapPropagator(
+	b3.B3{InjectEncoding: b3.B3MultipleHeader},
+	propagation.TraceContext{},
+	propagation.Baggage{},
+)
+
+// InitializeTracer initializes OpenTelemetry tracing if
+// Tracing.TracingEnabled is set in the config.
+func InitializeTracer(cfg *config.Tracing) error {
+	if !cfg.TracingEnabled {
+		return nil
 	}
 
+	log.Printf("[INFO] Tracing initializing - type: %s, connection string: %s, service name: %s, sampler: %s, samplerRate: %v",
+		cfg.CollectorType, cfg.ConnectString, cfg.ServiceName, cfg.Sampler, cfg.SamplerRate)
+
+	exporter, err := newExporter(cfg)
 	if err != nil {
-		log.Fatalf("Unable to create Zipkin %s collector: %v", collectorType, err)
+		return fmt.Errorf("trace: cannot create %s exporter. %s", cfg.CollectorType, err)
 	}
 
-	return collector
-}
+	sampler, err := newSampler(cfg.Sampler, cfg.SamplerRate)
+	if err != nil {
+		return err
+	}
 
-func CreateTracer(recorder zipkin.SpanRecorder, samplerRate float64, traceID128Bit bool) opentracing.Tracer {
-	tracer, err := zipkin.NewTracer(
-		recorder,
-		zipkin.WithSampler(zipkin.NewBoundarySampler(samplerRate, 1)),
-		zipkin.ClientServerSameSpan(false),
-		zipkin.TraceID128Bit(traceID128Bit),
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithConfig(sdktrace.Config{DefaultSampler: sampler}),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.ServiceNameKey.String(cfg.ServiceName))),
+		sdktrace.WithBatcher(exporter),
 	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+	return nil
+}
 
-	if err != nil {
-		log.Printf("Unable to create Zipkin tracer: %+v", err)
-		os.Exit(-1)
+// Shutdown exports the remaining spans and stops the exporter.
+func Shutdown(timeout time.Duration) {
+	if provider == nil {
+		return
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := provider.Shutdown(ctx); err != nil {
+		log.Print("[WARN] trace: Error shutting down tracer. ", err)
+	}
+}
 
-	return tracer
+// newExporter creates the span exporter for the collector type.
+func newExporter(cfg *config.Tracing) (export.SpanExporter, error) {
+	switch cfg.CollectorType {
+	case "otlp", "otlp-http":
+		u, err := url.Parse(cfg.ConnectString)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("invalid collector url %q", cfg.ConnectString)
+		}
+
+		var driver otlp.ProtocolDriver
+		if cfg.CollectorType == "otlp" {
+			opts := []otlpgrpc.Option{otlpgrpc.WithEndpoint(u.Host), otlpgrpc.WithHeaders(cfg.Headers)}
+			if u.Scheme == "http" {
+				opts = append(opts, otlpgrpc.WithInsecure())
+			}
+			driver = otlpgrpc.NewDriver(opts...)
+		} else {
+			opts := []otlphttp.Option{otlphttp.WithEndpoint(u.Host), otlphttp.WithHeaders(cfg.Headers)}
+			if u.Scheme == "http" {
+				opts = append(opts, otlphttp.WithInsecure())
+			}
+			if u.Path != "" && u.Path != "/" {
+				opts = append(opts, otlphttp.WithTracesURLPath(u.Path))
+			}
+			driver = otlphttp.NewDriver(opts...)
+		}
+		return otlp.NewExporter(context.Background(), driver)
+
+	case "http":
+		return zipkin.NewRawExporter(cfg.ConnectString, cfg.ServiceName)
+
+	default:
+		return nil, fmt.Errorf("unknown collector type %q", cfg.CollectorType)
+	}
 }
 
-func CreateSpan(r *http.Request, cfg *config.Tracing) opentracing.Span {
-	globalTracer := opentracing.GlobalTracer()
+// newSampler creates the sampler with the name as used by the
+// OTEL_TRACES_SAMPLER environment variable. A negative rate samples
+// all traces.
+func newSampler(name string, rate float64) (sdktrace.Sampler, error) {
+	if rate < 0 {
+		rate = 1
+	}
+	switch name {
+	case "always_on":
+		return sdktrace.AlwaysSample(), nil
+	case "always_off":
+		return sdktrace.NeverSample(), nil
+	case "traceidratio":
+		return sdktrace.TraceIDRatioBased(rate), nil
+	case "parentbased_always_on":
+		return sdktrace.ParentBased(sdktrace.AlwaysSample()), nil
+	case "parentbased_always_off":
+		return sdktrace.ParentBased(sdktrace.NeverSample()), nil
+	case "parentbased_traceidratio":
+		return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(rate)), nil
+	default:
+		return nil, fmt.Errorf("trace: unknown sampler %q", name)
+	}
+}
 
+// StartSpan starts a server span for the request which is a child of
+// the span from the request headers, if present. It returns the
+// request with the span in its context. The caller must end the span.
+func StartSpan(r *http.Request, cfg *config.Tracing) (*http.Request, trace.Span) {
 	name := cfg.ServiceName
 	if cfg.SpanName != "" {
 		name = spanName(cfg.SpanName, r)
 	}
 
-	// If headers contain trace data, create child span from parent; else, create root span
-	var span opentracing.Span
-	if globalTracer != nil {
-		spanCtx, err := globalTracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(r.Header))
-		if err != nil {
-			span = globalTracer.StartSpan(name)
-		} else {
-			span = globalTracer.StartSpan(name, ext.RPCServerOption(spanCtx))
-		}
-		ext.HTTPMethod.Set(span, r.Method)
-		ext.HTTPUrl.Set(span, r.URL.String())
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), headerCarrier(r.Header))
+	ctx, span := otel.Tracer(tracerName).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest(cfg.ServiceName, "", r)...),
+	)
+	return r.WithContext(ctx), span
+}
+
+// StartGRPCSpan starts a server span for a gRPC call which is a child
+// of the span from the request metadata, if present. The caller must
+// end the span.
+func StartGRPCSpan(ctx context.Context, md metadata.MD, fullMethod string) (context.Context, trace.Span) {
+	name := strings.TrimPrefix(fullMethod, "/")
+	attrs := []label.KeyValue{semconv.RPCSystemKey.String("grpc")}
+	if p := strings.SplitN(name, "/", 2); len(p) == 2 {
+		attrs = append(attrs, semconv.RPCServiceKey.String(p[0]), semconv.RPCMethodKey.String(p[1]))
 	}
 
-	return span // caller must defer span.finish()
+	ctx = otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+	return otel.Tracer(tracerName).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attrs...),
+	)
 }
 
-// InitializeTracer initializes OpenTracing support if Tracing.TracingEnabled
-// is set in the config.
-func InitializeTracer(traceConfig *config.Tracing) {
-	if !traceConfig.TracingEnabled {
-		return
-	}
+// InjectHeaders adds the headers for the span in the context to the
+// upstream request headers.
+func InjectHeaders(ctx context.Context, h http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(h))
+}
+
+// InjectMetadata adds the metadata for the span in the context to the
+// upstream gRPC metadata.
+func InjectMetadata(ctx context.Context, md metadata.MD) {
+	otel.GetTextMapPropagator().Inject(ctx, metadataCarrier(md))
+}
+
+// SetHTTPStatus records the status code of the response on the span.
+func SetHTTPStatus(span trace.Span, code int) {
+	span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(code)...)
+	span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(code))
+}
+
+// headerCarrier adapts http.Header to the propagation.TextMapCarrier
+// interface.
+type headerCarrier http.Header
 
-	log.Printf("Tracing initializing - type: %s, connection string: %s, service name: %s, topic: %s, samplerRate: %v",
-		traceConfig.CollectorType, traceConfig.ConnectString, traceConfig.ServiceName, traceConfig.Topic, traceConfig.SamplerRate)
+func (c headerCarrier) Get(key string) string {
+	return http.Header(c).Get(key)
+}
+
+func (c headerCarrier) Set(key, value string) {
+	http.Header(c).Set(key, value)
+}
 
-	// Create a new Zipkin Collector, Recorder, and Tracer
-	collector := CreateCollector(traceConfig.CollectorType, traceConfig.ConnectString, traceConfig.Topic)
-	recorder := zipkin.NewRecorder(collector, false, traceConfig.SpanHost, traceConfig.ServiceName)
-	tracer := CreateTracer(recorder, traceConfig.SamplerRate, traceConfig.TraceID128Bit)
+// metadataCarrier adapts metadata.MD to the
+// propagation.TextMapCarrier interface.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if v := metadata.MD(c).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
 
-	// Set the Zipkin Tracer created above to the GlobalTracer
-	opentracing.SetGlobalTracer(tracer)
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
 }
 
 // spanName returns the rendered span name from the configured template.
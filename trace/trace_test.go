@@ -1,149 +1,178 @@
 package trace
 
 import (
+	"context"
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/fabiolb/fabio/config"
-	opentracing "github.com/opentracing/opentracing-go"
-	mocktracer "github.com/opentracing/opentracing-go/mocktracer"
-	zipkin "github.com/openzipkin-contrib/zipkin-go-opentracing"
-	zipkintypes "github.com/openzipkin-contrib/zipkin-go-opentracing/types"
+	"go.opentelemetry.io/otel"
+	export "go.opentelemetry.io/otel/sdk/export/trace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc/metadata"
 )
 
-const testServiceName = "TEST-SERVICE"
-
-func TestCreateCollectorHTTP(t *testing.T) {
-	if CreateCollector("http", "", "") == nil {
-		t.Error("CreateCollector returned nil value.")
-		t.FailNow()
-	}
-}
-
-func TestCreateSpanNoGlobalTracer(t *testing.T) {
-	opentracing.SetGlobalTracer(nil)
-	req, _ := http.NewRequest("GET", "http://example.com", nil)
+const (
+	testTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testSpanID  = "00f067aa0ba902b7"
+)
 
-	if CreateSpan(req, &config.Tracing{ServiceName: "fabiolb-test", SpanName: "{{.Method}} {{.Path}}"}) != nil {
-		t.Error("CreateSpan returned a non-nil result using a nil global tracer.")
-		t.Fail()
-	}
+// recorder is a span exporter which records the exported spans.
+type recorder struct {
+	spans []*export.SpanSnapshot
 }
 
-func TestCreateSpanWithNoParent(t *testing.T) {
-	tracer, _ := zipkin.NewTracer(nil)
-	opentracing.SetGlobalTracer(tracer)
-
-	req, _ := http.NewRequest("GET", "http://example.com", nil)
-	if CreateSpan(req, &config.Tracing{ServiceName: "fabiolb-test", SpanName: "{{.Method}} {{.Path}}"}) == nil {
-		t.Error("Received nil span while a global tracer was set.")
-		t.FailNow()
-	}
+func (r *recorder) ExportSpans(ctx context.Context, spans []*export.SpanSnapshot) error {
+	r.spans = append(r.spans, spans...)
+	return nil
 }
 
-func TestCreateSpanWithParent(t *testing.T) {
-	mt := mocktracer.New()
-	opentracing.SetGlobalTracer(mt)
-	globalTracer := opentracing.GlobalTracer()
-	parentSpan := globalTracer.StartSpan(testServiceName)
-
-	requestIn, _ := http.NewRequest("GET", "http://example.com", nil)
-	mt.Inject(parentSpan.Context(),
-		opentracing.HTTPHeaders,
-		opentracing.HTTPHeadersCarrier(requestIn.Header),
-	)
-
-	if CreateSpan(requestIn, &config.Tracing{ServiceName: "fabiolb-test", SpanName: "{{.Method}} {{.Path}}"}) == nil {
-		t.Error("Received a nil span while a global tracer was set.")
-		t.FailNow()
-	}
-}
+func (r *recorder) Shutdown(ctx context.Context) error { return nil }
 
-func TestInitializeTracer(t *testing.T) {
-	opentracing.SetGlobalTracer(nil)
-	InitializeTracer(&config.Tracing{TracingEnabled: true, CollectorType: "http"})
-	if opentracing.GlobalTracer() == nil {
-		t.Error("InitializeTracer set a nil tracer.")
-		t.FailNow()
-	}
+func setupTracer(t *testing.T, sampler sdktrace.Sampler) *recorder {
+	rec := &recorder{}
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithConfig(sdktrace.Config{DefaultSampler: sampler}),
+		sdktrace.WithSyncer(rec),
+	))
+	otel.SetTextMapPropagator(propagator)
+	return rec
 }
 
-func TestInitializeTracerWhileDisabled(t *testing.T) {
-	opentracing.SetGlobalTracer(nil)
-	InitializeTracer(&config.Tracing{TracingEnabled: false, CollectorType: "http"})
-	if opentracing.GlobalTracer() != nil {
-		t.Error("InitializeTracer set a tracer while tracing was disabled.")
-		t.FailNow()
+func TestStartSpan(t *testing.T) {
+	tests := []struct {
+		desc    string
+		header  http.Header
+		sampled bool
+		parent  bool
+	}{
+		{
+			desc:    "no parent",
+			header:  http.Header{},
+			sampled: false,
+		},
+		{
+			desc:    "w3c parent",
+			header:  http.Header{"Traceparent": {"00-" + testTraceID + "-" + testSpanID + "-01"}},
+			sampled: true,
+			parent:  true,
+		},
+		{
+			desc:    "w3c parent not sampled",
+			header:  http.Header{"Traceparent": {"00-" + testTraceID + "-" + testSpanID + "-00"}},
+			sampled: false,
+			parent:  true,
+		},
+		{
+			desc:    "b3 parent",
+			header:  http.Header{"X-B3-Traceid": {testTraceID}, "X-B3-Spanid": {testSpanID}, "X-B3-Sampled": {"1"}},
+			sampled: true,
+			parent:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			rec := setupTracer(t, sdktrace.ParentBased(sdktrace.NeverSample()))
+
+			req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+			req.Header = tt.header
+			req, span := StartSpan(req, &config.Tracing{ServiceName: "fabiolb-test", SpanName: "{{.Method}} {{.Path}}"})
+
+			sc := span.SpanContext()
+			if got, want := sc.TraceID.String() == testTraceID, tt.parent; got != want {
+				t.Fatalf("got trace id %s, parent %v want %v", sc.TraceID, got, want)
+			}
+			if got, want := sc.IsSampled(), tt.sampled; got != want {
+				t.Fatalf("got sampled %v want %v", got, want)
+			}
+
+			upstream := http.Header{}
+			InjectHeaders(req.Context(), upstream)
+			if tt.parent {
+				want := "00-" + testTraceID + "-" + sc.SpanID.String()
+				if got := upstream.Get("Traceparent"); !strings.HasPrefix(got, want) {
+					t.Fatalf("got traceparent %q want prefix %q", got, want)
+				}
+				if got, want := upstream.Get("X-B3-Traceid"), testTraceID; got != want {
+					t.Fatalf("got X-B3-Traceid %q want %q", got, want)
+				}
+			}
+
+			SetHTTPStatus(span, 502)
+			span.End()
+
+			if !tt.sampled {
+				if len(rec.spans) != 0 {
+					t.Fatalf("got %d spans want 0", len(rec.spans))
+				}
+				return
+			}
+			if len(rec.spans) != 1 {
+				t.Fatalf("got %d spans want 1", len(rec.spans))
+			}
+			if got, want := rec.spans[0].Name, "GET /foo"; got != want {
+				t.Fatalf("got span name %q want %q", got, want)
+			}
+		})
 	}
 }
 
-func TestInjectHeaders(t *testing.T) {
-	mt := mocktracer.New()
-	opentracing.SetGlobalTracer(mt)
-	globalTracer := opentracing.GlobalTracer()
-	span := globalTracer.StartSpan(testServiceName)
+func TestStartGRPCSpan(t *testing.T) {
+	rec := setupTracer(t, sdktrace.ParentBased(sdktrace.NeverSample()))
 
-	req, _ := http.NewRequest("GET", "http://example.com", nil)
-	InjectHeaders(span, req)
+	md := metadata.Pairs("traceparent", "00-"+testTraceID+"-"+testSpanID+"-01")
+	ctx, span := StartGRPCSpan(context.Background(), md, "/pkg.Service/Method")
 
-	if req.Header.Get("Mockpfx-Ids-Traceid") == "" {
-		t.Error("Inject did not set the Traceid in the request.")
-		t.Fail()
+	out := md.Copy()
+	InjectMetadata(ctx, out)
+	want := "00-" + testTraceID + "-" + span.SpanContext().SpanID.String() + "-01"
+	if got := out.Get("traceparent"); len(got) != 1 || got[0] != want {
+		t.Fatalf("got traceparent %v want %q", got, want)
 	}
-	if req.Header.Get("Mockpfx-Ids-Spanid") == "" {
-		t.Error("Inject did not set the Spanid in the request.")
-		t.Fail()
-	}
-}
-
-func TestInjectHeadersWithParentSpan(t *testing.T) {
-	parentSpanId := uint64(12345)
-	parentSpanContext := zipkin.SpanContext{
-		SpanID:  parentSpanId,
-		TraceID: zipkintypes.TraceID{High: uint64(1234), Low: uint64(4321)},
-	}
-
-	tracer, _ := zipkin.NewTracer(nil)
-	opentracing.SetGlobalTracer(tracer)
-	globalTracer := opentracing.GlobalTracer()
-	childSpan := globalTracer.StartSpan(testServiceName+"-CHILD", opentracing.ChildOf(parentSpanContext))
-
-	req, _ := http.NewRequest("GET", "http://example.com", nil)
-	InjectHeaders(childSpan, req)
+	span.End()
 
-	if req.Header.Get("X-B3-Traceid") == "" {
-		t.Error("Inject did not set the Traceid in the request.")
-		t.Fail()
+	if len(rec.spans) != 1 {
+		t.Fatalf("got %d spans want 1", len(rec.spans))
 	}
-	if req.Header.Get("X-B3-Spanid") == "" {
-		t.Error("Inject did not set the Spanid in the request.")
-		t.Fail()
-	}
-	if req.Header.Get("X-B3-Parentspanid") != "0000000000003039" {
-		t.Error("Inject did not set the correct Parentspanid in the request.")
-		t.Fail()
-	}
-	if req.Header.Get("x-B3-Traceid") != "00000000000004d200000000000010e1" {
-		t.Error("Inject did not reuse the Traceid from the parent span")
-		t.Fail()
+	if got, want := rec.spans[0].Name, "pkg.Service/Method"; got != want {
+		t.Fatalf("got span name %q want %q", got, want)
 	}
 }
 
-func TestSpanName(t *testing.T) {
-	req, _ := http.NewRequest("GET", "http://example.com/demo", nil)
-
-	if spanName("{{.Method}} {{.Host}} {{.Path}}", req) != "GET example.com /demo" {
-		t.Error("spanName did not properly render the supported template")
-		t.Fail()
+func TestNewSampler(t *testing.T) {
+	for _, name := range []string{"always_on", "always_off", "traceidratio", "parentbased_always_on", "parentbased_always_off", "parentbased_traceidratio"} {
+		if _, err := newSampler(name, 0.5); err != nil {
+			t.Errorf("%s: got %v want nil", name, err)
+		}
 	}
-
-	if spanName("{{.Invalid", req) != "{{.Invalid" {
-		t.Error("spanName did not return the unrendered string of the invalid template")
-		t.Fail()
+	if _, err := newSampler("foo", 0.5); err == nil {
+		t.Error("got nil want error")
 	}
+}
 
-	if spanName("{{.Unsupported}}", req) != "{{.Unsupported}}" {
-		t.Error("spanName did not return the unrendered string of the unsupported template")
-		t.Fail()
+func TestInitializeTracer(t *testing.T) {
+	for _, typ := range []string{"otlp", "otlp-http", "http"} {
+		t.Run(typ, func(t *testing.T) {
+			cfg := &config.Tracing{
+				TracingEnabled: true,
+				CollectorType:  typ,
+				ConnectString:  "http://127.0.0.1:1/api/v2/spans",
+				ServiceName:    "fabiolb-test",
+				Sampler:        "parentbased_traceidratio",
+				SamplerRate:    1,
+			}
+			if err := InitializeTracer(cfg); err != nil {
+				t.Fatalf("got %v want nil", err)
+			}
+			Shutdown(100 * time.Millisecond)
+		})
+	}
+
+	err := InitializeTracer(&config.Tracing{TracingEnabled: true, CollectorType: "otlp", ConnectString: "localhost:4317", Sampler: "always_on"})
+	if err == nil {
+		t.Fatal("got nil want error for url without scheme")
 	}
 }